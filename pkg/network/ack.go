@@ -0,0 +1,210 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAckTimeout 重发次数用完之后仍未收到确认
+	ErrAckTimeout = errors.New("ack timeout")
+
+	// ErrTooManyUnacked 等待确认的消息数量达到 Config.AckWindow
+	ErrTooManyUnacked = errors.New("too many unacked messages")
+)
+
+// AckSNMin 需要确认的消息使用 [AckSNMin, RequestSNMin) 之间的 SN，见 Session.SendReliable
+// 与 Session.Request 使用的 SN 不重叠，对方收到的消息不会被误认为请求的响应
+const AckSNMin = uint16(0x4000)
+
+// PendingAcks 已经发出、等待对方确认的消息，用于 Session.SendReliable
+// 超时未确认的消息重新发送，重发次数用完之后回调 ErrAckTimeout
+type PendingAcks struct {
+	mutex sync.Mutex
+
+	// waiters SN 对应的等待者
+	waiters map[uint16]*ackWaiter
+
+	// nextSN 下一个尝试分配的 SN
+	nextSN uint16
+
+	// closed 会话已关闭，不再发出新的消息
+	closed bool
+}
+
+// ackWaiter 等待一个消息的确认
+type ackWaiter struct {
+	// timer 确认超时的定时器
+	timer *time.Timer
+
+	// retries 剩余的重发次数
+	retries int
+
+	// callback 收到确认、超时或者会话关闭时回调
+	callback func(err error)
+}
+
+// Send 为消息分配 SN，使用 send 发送之后等待确认，不会阻塞
+// 超过 config.AckTimeout 未确认时再次调用 send 重发，最多重发 config.AckRetries 次
+// callback 只会被调用一次：收到确认时 err 为 nil，重发次数用完为 ErrAckTimeout，会话关闭为 ErrStopSend
+// 等待确认的消息达到 config.AckWindow 时返回 ErrTooManyUnacked，send 失败时返回其错误，这两种情况不会回调
+func (p *PendingAcks) Send(config *Config, send func(sn uint16) error, callback func(err error)) error {
+	sn, waiter, err := p.register(config.AckWindow, config.AckRetries, callback)
+	if err != nil {
+		return err
+	}
+
+	if err := send(sn); err != nil {
+		p.remove(sn, waiter)
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// 发送期间已经收到确认或者会话已经关闭
+	if p.waiters[sn] != waiter {
+		return nil
+	}
+
+	waiter.timer = time.AfterFunc(config.AckTimeout, func() {
+		p.expire(config.AckTimeout, sn, waiter, send)
+	})
+
+	return nil
+}
+
+// Ack 收到对方对 sn 的确认，返回是否有等待该确认的消息
+func (p *PendingAcks) Ack(sn uint16) bool {
+	p.mutex.Lock()
+	waiter, ok := p.waiters[sn]
+	if ok {
+		delete(p.waiters, sn)
+		if waiter.timer != nil {
+			waiter.timer.Stop()
+		}
+	}
+	p.mutex.Unlock()
+
+	if ok {
+		waiter.callback(nil)
+	}
+
+	return ok
+}
+
+// Len 等待确认的消息数量
+func (p *PendingAcks) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.waiters)
+}
+
+// Close 会话关闭，所有等待中的消息回调 ErrStopSend，之后发送返回 ErrStopSend
+func (p *PendingAcks) Close() {
+	p.mutex.Lock()
+	p.closed = true
+	waiters := make([]*ackWaiter, 0, len(p.waiters))
+	for sn, waiter := range p.waiters {
+		if waiter.timer != nil {
+			waiter.timer.Stop()
+		}
+		waiters = append(waiters, waiter)
+		delete(p.waiters, sn)
+	}
+	p.mutex.Unlock()
+
+	for _, waiter := range waiters {
+		waiter.callback(ErrStopSend)
+	}
+}
+
+// expire 确认超时，还有重发次数时重发并重新计时，否则回调 ErrAckTimeout
+func (p *PendingAcks) expire(timeout time.Duration, sn uint16, waiter *ackWaiter, send func(sn uint16) error) {
+	p.mutex.Lock()
+	if p.waiters[sn] != waiter {
+		p.mutex.Unlock()
+		return
+	}
+
+	if waiter.retries <= 0 {
+		delete(p.waiters, sn)
+		p.mutex.Unlock()
+		waiter.callback(ErrAckTimeout)
+		return
+	}
+	waiter.retries--
+	p.mutex.Unlock()
+
+	if err := send(sn); err != nil {
+		if p.remove(sn, waiter) {
+			waiter.callback(err)
+		}
+		return
+	}
+
+	p.mutex.Lock()
+	if p.waiters[sn] == waiter {
+		waiter.timer.Reset(timeout)
+	}
+	p.mutex.Unlock()
+}
+
+// register 分配一个未被占用的 SN
+func (p *PendingAcks) register(window, retries int, callback func(err error)) (uint16, *ackWaiter, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return 0, nil, ErrStopSend
+	}
+
+	if p.waiters == nil {
+		p.waiters = make(map[uint16]*ackWaiter)
+	}
+
+	if window > 0 && len(p.waiters) >= window {
+		return 0, nil, ErrTooManyUnacked
+	}
+
+	if callback == nil {
+		callback = func(error) {}
+	}
+
+	for i := 0; i < int(RequestSNMin-AckSNMin); i++ {
+		sn := p.nextSN
+		if sn < AckSNMin || sn >= RequestSNMin {
+			sn = AckSNMin
+		}
+		p.nextSN = sn + 1
+
+		if _, ok := p.waiters[sn]; ok {
+			continue
+		}
+
+		waiter := &ackWaiter{retries: retries, callback: callback}
+		p.waiters[sn] = waiter
+		return sn, waiter, nil
+	}
+
+	return 0, nil, ErrTooManyUnacked
+}
+
+// remove 移除仍在等待的等待者，返回是否移除
+func (p *PendingAcks) remove(sn uint16, waiter *ackWaiter) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.waiters[sn] != waiter {
+		return false
+	}
+
+	delete(p.waiters, sn)
+	if waiter.timer != nil {
+		waiter.timer.Stop()
+	}
+
+	return true
+}
@@ -0,0 +1,89 @@
+package network_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestPendingAcks(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.AckTimeout = 20 * time.Millisecond
+	config.AckRetries = 2
+	config.AckWindow = 2
+
+	var acks zeronetwork.PendingAcks
+
+	// 收到确认
+	var sent uint16
+	results := make(chan error, 4)
+	if err := acks.Send(config, func(sn uint16) error {
+		sent = sn
+		return nil
+	}, func(err error) { results <- err }); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if sent < zeronetwork.AckSNMin || sent >= zeronetwork.RequestSNMin {
+		t.Fatalf("unexpected sn: %d", sent)
+	}
+	if !acks.Ack(sent) || acks.Ack(sent) {
+		t.Fatal("unexpected ack result")
+	}
+	if err := <-results; err != nil {
+		t.Fatalf("unexpected ack err: %s", err.Error())
+	}
+
+	// 未收到确认，重发 AckRetries 次之后回调 ErrAckTimeout
+	var sends int32
+	if err := acks.Send(config, func(uint16) error {
+		atomic.AddInt32(&sends, 1)
+		return nil
+	}, func(err error) { results <- err }); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	select {
+	case err := <-results:
+		if !errors.Is(err, zeronetwork.ErrAckTimeout) {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for ack timeout")
+	}
+	if n := atomic.LoadInt32(&sends); n != 1+int32(config.AckRetries) {
+		t.Fatalf("unexpected sends: %d", n)
+	}
+
+	// 发送失败不会回调，也不占用窗口
+	expected := errors.New("send failed")
+	if err := acks.Send(config, func(uint16) error { return expected }, func(err error) { results <- err }); err != expected {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// 等待确认的消息数量达到上限
+	config.AckTimeout = time.Hour
+	for i := 0; i < config.AckWindow; i++ {
+		if err := acks.Send(config, func(uint16) error { return nil }, func(err error) { results <- err }); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+	if err := acks.Send(config, func(uint16) error { return nil }, nil); !errors.Is(err, zeronetwork.ErrTooManyUnacked) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// 关闭时回调 ErrStopSend
+	acks.Close()
+	for i := 0; i < config.AckWindow; i++ {
+		if err := <-results; !errors.Is(err, zeronetwork.ErrStopSend) {
+			t.Fatalf("unexpected err after close: %v", err)
+		}
+	}
+	if acks.Len() != 0 {
+		t.Fatalf("unexpected len: %d", acks.Len())
+	}
+	if err := acks.Send(config, func(uint16) error { return nil }, nil); !errors.Is(err, zeronetwork.ErrStopSend) {
+		t.Fatalf("unexpected err after close: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ErrMemoryPressure 内存占用超过上限，拒绝新的连接，见 MemoryAdmission
+var ErrMemoryPressure = errors.New("memory pressure")
+
+// MemoryAdmission 按照堆内存占用决定是否接受新连接的 AdmissionFunc，HeapAlloc 超过 maxHeapBytes 时返回 ErrMemoryPressure
+// runtime.ReadMemStats 会短暂暂停所有 goroutine，interval 内复用上一次读取的结果，interval <= 0 时每次都读取
+func MemoryAdmission(maxHeapBytes uint64, interval time.Duration) AdmissionFunc {
+	var (
+		mutex  sync.Mutex
+		readAt time.Time
+		heap   uint64
+	)
+
+	return func() error {
+		mutex.Lock()
+		if interval <= 0 || time.Since(readAt) >= interval {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			heap = stats.HeapAlloc
+			readAt = time.Now()
+		}
+		current := heap
+		mutex.Unlock()
+
+		if current > maxHeapBytes {
+			return fmt.Errorf("%w: heap %d bytes, limit %d bytes", ErrMemoryPressure, current, maxHeapBytes)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,22 @@
+package network_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestMemoryAdmission(t *testing.T) {
+	if err := zeronetwork.MemoryAdmission(1, 0)(); !errors.Is(err, zeronetwork.ErrMemoryPressure) {
+		t.Fatalf("unexpected err under pressure: %v", err)
+	}
+
+	check := zeronetwork.MemoryAdmission(^uint64(0), time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := check(); err != nil {
+			t.Fatalf("unexpected err without pressure: %s", err.Error())
+		}
+	}
+}
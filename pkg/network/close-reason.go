@@ -0,0 +1,79 @@
+package network
+
+import (
+	"errors"
+	"io"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+// CloseReason 会话关闭的原因，见 Session.CloseReason
+type CloseReason int32
+
+const (
+	// CloseReasonNone 会话尚未关闭
+	CloseReasonNone CloseReason = iota
+
+	// CloseReasonLocal 本端主动关闭，比如调用 Close、关闭服务、秘钥协商超时
+	CloseReasonLocal
+
+	// CloseReasonRemoteClosed 对方正常关闭连接
+	// 比如 tcp 读取到 io.EOF，websocket 收到 CloseNormalClosure、CloseGoingAway 关闭帧
+	CloseReasonRemoteClosed
+
+	// CloseReasonReadError 读取或者解包失败
+	// 比如连接被重置、读超时、websocket 未发送关闭帧就断开连接
+	CloseReasonReadError
+
+	// CloseReasonMessageTooBig 收到的消息超过 Config.MaxMessageSize
+	// 比如 websocket 消息超过读取上限，此时已经回复 CloseMessageTooBig 关闭帧
+	CloseReasonMessageTooBig
+
+	// CloseReasonKicked 被服务端踢下线，见 SessionManager.Kick
+	CloseReasonKicked
+
+	// CloseReasonZeroLimit 特殊协议消息超过限制，见 Config.ZeroLimitKick
+	CloseReasonZeroLimit
+
+	// CloseReasonSendQueueFull 发送队列已满，见 Config.SendOverflowPolicy
+	CloseReasonSendQueueFull
+)
+
+// String 打印原因
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonNone:
+		return "none"
+	case CloseReasonLocal:
+		return "local"
+	case CloseReasonRemoteClosed:
+		return "remote closed"
+	case CloseReasonReadError:
+		return "read error"
+	case CloseReasonMessageTooBig:
+		return "message too big"
+	case CloseReasonKicked:
+		return "kicked"
+	case CloseReasonZeroLimit:
+		return "zero message limit"
+	case CloseReasonSendQueueFull:
+		return "send queue full"
+	}
+
+	return "unknown"
+}
+
+// ReadCloseReason 根据读取失败的错误判断关闭原因，三种连接的 recvLoop 共用
+// io.EOF 与 websocket 的 CloseNormalClosure、CloseGoingAway、CloseNoStatusReceived 关闭帧表示对方正常关闭连接
+// websocket.ErrReadLimit 表示消息超过 Config.MaxMessageSize，其余均为读取失败
+func ReadCloseReason(err error) CloseReason {
+	if errors.Is(err, io.EOF) || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		return CloseReasonRemoteClosed
+	}
+
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return CloseReasonMessageTooBig
+	}
+
+	return CloseReasonReadError
+}
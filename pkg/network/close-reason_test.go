@@ -0,0 +1,49 @@
+package network_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	websocket "github.com/gorilla/websocket"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestReadErrorClassification(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		graceful bool
+		reason   zeronetwork.CloseReason
+	}{
+		{"eof", io.EOF, true, zeronetwork.CloseReasonRemoteClosed},
+		{"wrapped eof", fmt.Errorf("unpack: %w", io.EOF), true, zeronetwork.CloseReasonRemoteClosed},
+		{"unexpected eof", io.ErrUnexpectedEOF, true, zeronetwork.CloseReasonReadError},
+		{"closed", fmt.Errorf("read: %w", net.ErrClosed), true, zeronetwork.CloseReasonReadError},
+		{"closed pipe", io.ErrClosedPipe, true, zeronetwork.CloseReasonReadError},
+		{"timeout", &net.OpError{Op: "write", Err: os.ErrDeadlineExceeded}, true, zeronetwork.CloseReasonReadError},
+		{"reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true, zeronetwork.CloseReasonReadError},
+		{"ws normal", &websocket.CloseError{Code: websocket.CloseNormalClosure}, true, zeronetwork.CloseReasonRemoteClosed},
+		{"ws going away", &websocket.CloseError{Code: websocket.CloseGoingAway}, true, zeronetwork.CloseReasonRemoteClosed},
+		{"ws abnormal", &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, true, zeronetwork.CloseReasonReadError},
+		{"ws read limit", websocket.ErrReadLimit, false, zeronetwork.CloseReasonMessageTooBig},
+		{"unpack", errors.New("verify checksum failed"), false, zeronetwork.CloseReasonReadError},
+	}
+
+	for _, test := range tests {
+		if got := zeronetwork.IsEOFOrReadError(test.err); got != test.graceful {
+			t.Errorf("%s: unexpected IsEOFOrReadError: %v", test.name, got)
+		}
+		if got := zeronetwork.ReadCloseReason(test.err); got != test.reason {
+			t.Errorf("%s: unexpected ReadCloseReason: %s", test.name, got)
+		}
+	}
+
+	if zeronetwork.IsEOFOrReadError(nil) {
+		t.Fatal("nil is classified as read error")
+	}
+}
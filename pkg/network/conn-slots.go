@@ -0,0 +1,30 @@
+package network
+
+import "sync/atomic"
+
+// ConnSlots 为尚未加入 SessionManager 的连接预留名额，用于 Config.MaxConnNum
+// 并发接收连接时，检查连接数量与会话加入 SessionManager 之间存在间隔，只检查 SessionManager.Len 会超出上限
+type ConnSlots struct {
+	// pending 已经占用名额，尚未加入 SessionManager 的连接数量
+	pending int32
+}
+
+// Acquire 占用一个名额，SessionManager 中的会话数量与已预留的名额之和达到 max 时返回 false
+// max <= 0 表示不限制，此时仍然计数。占用成功之后，会话加入 SessionManager 或者连接被拒绝时需要调用 Release
+func (c *ConnSlots) Acquire(sessionManager SessionManager, max int) bool {
+	for {
+		pending := atomic.LoadInt32(&c.pending)
+		if max > 0 && sessionManager.Len()+int(pending) >= max {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&c.pending, pending, pending+1) {
+			return true
+		}
+	}
+}
+
+// Release 归还预留的名额
+func (c *ConnSlots) Release() {
+	atomic.AddInt32(&c.pending, -1)
+}
@@ -0,0 +1,37 @@
+package network
+
+import "errors"
+
+var (
+	// ErrUnsupportedContentType 负载的内容类型不支持该操作，比如 Bind 时类型未知
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// ContentType 负载的内容类型，接收方据此选择解码方式，见 Message.Bind
+// 仅在封包解包工具启用时才会随消息传输，见 datapack.WithLTDContentType
+type ContentType uint8
+
+const (
+	// ContentTypeRaw 原始字节，未指定类型的消息均为该类型
+	ContentTypeRaw ContentType = iota
+
+	// ContentTypeJSON 使用 encoding/json 编码
+	ContentTypeJSON
+
+	// ContentTypeProtobuf 使用 protobuf 编码
+	ContentTypeProtobuf
+)
+
+// String 打印类型
+func (c ContentType) String() string {
+	switch c {
+	case ContentTypeRaw:
+		return "raw"
+	case ContentTypeJSON:
+		return "json"
+	case ContentTypeProtobuf:
+		return "protobuf"
+	}
+
+	return "unknown"
+}
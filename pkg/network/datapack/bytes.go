@@ -0,0 +1,73 @@
+package datapack
+
+import (
+	"bytes"
+	"io"
+
+	zerocompress "github.com/zerogo-hub/zero-helper/compress"
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// PackOptions PackBytes 与 UnpackBytes 的选项，含义与 Config 中的同名配置一致
+type PackOptions struct {
+	// WhetherCompress 是否需要对消息负载进行压缩
+	WhetherCompress bool
+
+	// CompressThreshold 压缩的阈值，当消息负载长度不小于该值时才会压缩
+	CompressThreshold int
+
+	// Compress 压缩与解压器
+	Compress zerocompress.Compress
+
+	// Crypto 加密与解密工具，nil 表示不加密
+	Crypto zeronetwork.Crypto
+
+	// WhetherChecksum 是否使用校验值
+	WhetherChecksum bool
+
+	// ChecksumKey 校验秘钥
+	ChecksumKey []byte
+
+	// LTDOptions 封包解包工具的可选配置，比如 WithLTDVersion
+	LTDOptions []LTDOption
+}
+
+// ltd 根据选项创建封包解包工具
+func (o PackOptions) ltd() *ltd {
+	return NewLTD(
+		o.WhetherCompress,
+		o.CompressThreshold,
+		o.Compress,
+		o.Crypto != nil,
+		o.WhetherChecksum,
+		zerologger.NewSampleLogger(),
+		o.LTDOptions...,
+	).(*ltd)
+}
+
+// PackBytes 按照 opts 将消息封包为字节，与会话写入套接字的内容一致，用于协议的黄金测试
+func PackBytes(message zeronetwork.Message, opts PackOptions) ([]byte, error) {
+	return opts.ltd().Pack(message, opts.Crypto, opts.ChecksumKey)
+}
+
+// UnpackBytes 按照 opts 将字节解包为消息，p 中需要包含若干个完整的消息
+// 末尾存在不完整的消息时返回 io.ErrUnexpectedEOF
+func UnpackBytes(p []byte, opts PackOptions) ([]zeronetwork.Message, error) {
+	l := opts.ltd()
+	reader := bytes.NewReader(p)
+
+	messages := []zeronetwork.Message{}
+	for reader.Len() > 0 {
+		message, err := l.UnpackFrom(reader, opts.Crypto, opts.ChecksumKey)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,166 @@
+package datapack_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	zerozlib "github.com/zerogo-hub/zero-helper/compress/zlib"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
+)
+
+// goldenCases 各种选项组合下消息封包后的字节，修改协议时需要同步更新
+var goldenCases = []struct {
+	name     string
+	compress bool
+	crypto   bool
+	checksum bool
+	version  bool
+	flag     uint16
+	payload  string
+	golden   string
+}{
+	{name: "plain", payload: "zero-node", golden: "000d00000007000301027a65726f2d6e6f6465"},
+	{name: "empty payload", golden: "00040000000700030102"},
+	{name: "flag zero", flag: zeronetwork.FlagZero, payload: "token", golden: "00091000000700030102746f6b656e"},
+	{name: "checksum", checksum: true, payload: "zero-node", golden: "000d0100000739ef44f66fa3e6752da59842cfc89794000301027a65726f2d6e6f6465"},
+	{name: "compress", compress: true, payload: "zero-node", golden: "001f00010007789c000d00f2ff000301027a65726f2d6e6f6465000000ffff0300128d039a"},
+	{name: "crypto", crypto: true, payload: "zero-node", golden: "000d00100007846b415b872bd2f7249835faa5"},
+	{name: "compress crypto checksum", compress: true, crypto: true, checksum: true, payload: "zero-node", golden: "001f01110007a913496851423f935f57a8dc15bed10bfcf44054fdbc5f980af758e4a5e13c688c824cb58c31fb9513454dbdd734fd"},
+	{name: "version", version: true, checksum: true, payload: "zero-node", golden: "01000d01000007474288385a47b7f9d1546409ae5eb5ad000301027a65726f2d6e6f6465"},
+}
+
+func goldenOptions(t *testing.T, compress, crypto, checksum, version bool) zerodatapack.PackOptions {
+	opts := zerodatapack.PackOptions{}
+
+	if compress {
+		opts.WhetherCompress = true
+		opts.Compress = zerozlib.NewZlib()
+	}
+
+	if crypto {
+		c, err := zerorc4.New([]byte("0123456789abcdef"))
+		if err != nil {
+			t.Fatalf("new crypto failed: %s", err.Error())
+		}
+		opts.Crypto = c
+	}
+
+	if checksum {
+		opts.WhetherChecksum = true
+		opts.ChecksumKey = []byte("fedcba9876543210")
+	}
+
+	if version {
+		opts.LTDOptions = []zerodatapack.LTDOption{zerodatapack.WithLTDVersion(1)}
+	}
+
+	return opts
+}
+
+func TestPackBytesGolden(t *testing.T) {
+	for _, c := range goldenCases {
+		message := zerodatapack.NewLTDMessage(c.flag, 7, 3, 1, 2, []byte(c.payload))
+
+		p, err := zerodatapack.PackBytes(message, goldenOptions(t, c.compress, c.crypto, c.checksum, c.version))
+		if err != nil {
+			t.Fatalf("%s: pack failed: %s", c.name, err.Error())
+		}
+		if got := hex.EncodeToString(p); got != c.golden {
+			t.Fatalf("%s: unexpected bytes: %s, golden: %s", c.name, got, c.golden)
+		}
+
+		// 黄金数据可以解包为原始消息
+		golden, _ := hex.DecodeString(c.golden)
+		messages, err := zerodatapack.UnpackBytes(golden, goldenOptions(t, c.compress, c.crypto, c.checksum, c.version))
+		if err != nil {
+			t.Fatalf("%s: unpack failed: %s", c.name, err.Error())
+		}
+		if len(messages) != 1 {
+			t.Fatalf("%s: unexpected messages: %d", c.name, len(messages))
+		}
+
+		m := messages[0]
+		if m.SN() != 7 || m.Code() != 3 || m.ModuleID() != 1 || m.ActionID() != 2 || !bytes.Equal(m.Payload(), []byte(c.payload)) {
+			t.Fatalf("%s: unexpected message: %s", c.name, m.String())
+		}
+	}
+}
+
+func TestUnpackBytesTruncated(t *testing.T) {
+	opts := zerodatapack.PackOptions{}
+
+	var p []byte
+	for i := 0; i < 2; i++ {
+		frame, err := zerodatapack.PackBytes(zerodatapack.NewLTDMessage(0, uint16(i), 0, 1, 1, []byte("zero-node")), opts)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		p = append(p, frame...)
+	}
+
+	messages, err := zerodatapack.UnpackBytes(p, opts)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("unexpected messages: %d, err: %v", len(messages), err)
+	}
+
+	if _, err := zerodatapack.UnpackBytes(p[:len(p)-1], opts); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestUnpackBytesBodyTooShort(t *testing.T) {
+	compress := goldenOptions(t, true, false, false, false)
+	crypto := goldenOptions(t, false, true, false, false)
+
+	// shortFrame 构造消息头完整、消息体为 body 的帧，body 先压缩后加密，与封包顺序一致
+	shortFrame := func(body []byte, opts zerodatapack.PackOptions) []byte {
+		flag := uint16(0)
+		if opts.WhetherCompress {
+			compressed, err := opts.Compress.Compress(body)
+			if err != nil {
+				t.Fatalf("compress failed: %s", err.Error())
+			}
+			body = compressed
+			flag |= zeronetwork.FlagCompress
+		}
+		if opts.Crypto != nil {
+			encrypted, err := opts.Crypto.Encrypt(body)
+			if err != nil {
+				t.Fatalf("encrypt failed: %s", err.Error())
+			}
+			body = encrypted
+			flag |= zeronetwork.FlagEncrypt
+		}
+
+		frame := make([]byte, 6, 6+len(body))
+		binary.BigEndian.PutUint16(frame, uint16(len(body)))
+		binary.BigEndian.PutUint16(frame[2:], flag)
+		return append(frame, body...)
+	}
+
+	cases := []struct {
+		name string
+		body []byte
+		opts zerodatapack.PackOptions
+	}{
+		{name: "empty", body: []byte{}},
+		{name: "code only", body: []byte{0, 3}},
+		{name: "no action", body: []byte{0, 3, 1}},
+		{name: "compressed empty", body: []byte{}, opts: compress},
+		{name: "compressed no action", body: []byte{0, 3, 1}, opts: compress},
+		{name: "encrypted one byte", body: []byte{0}, opts: crypto},
+		{name: "encrypted no action", body: []byte{0, 3, 1}, opts: crypto},
+	}
+
+	for _, c := range cases {
+		if _, err := zerodatapack.UnpackBytes(shortFrame(c.body, c.opts), c.opts); !errors.Is(err, zerodatapack.ErrBodyTooShort) {
+			t.Fatalf("%s: unexpected err: %v", c.name, err)
+		}
+	}
+}
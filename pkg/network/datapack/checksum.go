@@ -0,0 +1,44 @@
+package datapack
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	zerocrypto "github.com/zerogo-hub/zero-helper/crypto"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+var (
+	// HMACMD5Checksummer 16 字节的 HMAC-MD5 校验值，使用协商得到的校验秘钥，默认使用
+	HMACMD5Checksummer zeronetwork.Checksummer = hmacMD5Checksummer{}
+
+	// CRC32Checksummer 4 字节的 CRC32 (IEEE) 校验值，忽略校验秘钥
+	// 只能发现传输中的数据损坏，无法防止篡改，适用于可信的内网链路
+	CRC32Checksummer zeronetwork.Checksummer = crc32Checksummer{}
+)
+
+// hmacMD5Checksummer 见 HMACMD5Checksummer
+type hmacMD5Checksummer struct{}
+
+// Size ..
+func (hmacMD5Checksummer) Size() int {
+	return ChecksumLength
+}
+
+// Sum ..
+func (hmacMD5Checksummer) Sum(data, key []byte) []byte {
+	return zerocrypto.HmacMd5ByteToByte(data, key)
+}
+
+// crc32Checksummer 见 CRC32Checksummer
+type crc32Checksummer struct{}
+
+// Size ..
+func (crc32Checksummer) Size() int {
+	return crc32.Size
+}
+
+// Sum ..
+func (crc32Checksummer) Sum(data, _ []byte) []byte {
+	return binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))
+}
@@ -0,0 +1,69 @@
+package datapack
+
+import (
+	"encoding/json"
+	"errors"
+
+	zerocodec "github.com/zerogo-hub/zero-helper/codec"
+	zeroprotobuf "github.com/zerogo-hub/zero-helper/codec/protobuf"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+var (
+	// ErrCodecNotSet 未设置编码与解码器
+	ErrCodecNotSet = errors.New("codec not set")
+)
+
+// protobufCodec Message.Bind 解码 protobuf 负载
+var protobufCodec = zeroprotobuf.New()
+
+// CodecContentType codec 对应的负载内容类型，根据 codec.Name() 判断，未知的编码为 ContentTypeRaw
+func CodecContentType(codec zerocodec.Codec) zeronetwork.ContentType {
+	switch codec.Name() {
+	case "json":
+		return zeronetwork.ContentTypeJSON
+	case "protobuf":
+		return zeronetwork.ContentTypeProtobuf
+	}
+
+	return zeronetwork.ContentTypeRaw
+}
+
+// NewCodecMessage 使用 codec 对 v 进行编码作为负载，创建一个消息
+func NewCodecMessage(codec zerocodec.Codec, sn uint16, module, action uint8, v interface{}) (zeronetwork.Message, error) {
+	if codec == nil {
+		return nil, ErrCodecNotSet
+	}
+
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	message := NewLTDMessage(0, sn, 0, module, action, payload)
+	message.SetContentType(CodecContentType(codec))
+	return message, nil
+}
+
+// RespondProto 创建 req 的响应消息，沿用 req 的 SN 与 module，v 使用 codec 编码后作为负载
+// eg: return zerodatapack.RespondProto(codec, message, ActionHelloSayResp, &protocol.Resp1{})
+func RespondProto(codec zerocodec.Codec, req zeronetwork.Message, action uint8, v interface{}) (zeronetwork.Message, error) {
+	return NewCodecMessage(codec, req.SN(), req.ModuleID(), action, v)
+}
+
+// NewJSONMessage 使用 encoding/json 对 v 进行编码作为负载，创建一个消息
+func NewJSONMessage(sn uint16, module, action uint8, v interface{}) (zeronetwork.Message, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	message := NewLTDMessage(0, sn, 0, module, action, payload)
+	message.SetContentType(zeronetwork.ContentTypeJSON)
+	return message, nil
+}
+
+// RespondJSON 创建 req 的响应消息，沿用 req 的 SN 与 module，v 使用 encoding/json 编码后作为负载
+func RespondJSON(req zeronetwork.Message, action uint8, v interface{}) (zeronetwork.Message, error) {
+	return NewJSONMessage(req.SN(), req.ModuleID(), action, v)
+}
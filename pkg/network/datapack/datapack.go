@@ -13,5 +13,8 @@ func DefaultDatapck(config *zeronetwork.Config) zeronetwork.Datapack {
 		config.WhetherCrypto,
 		config.WhetherChecksum,
 		config.Logger,
+		WithLTDPayloadPool(config.PayloadPoolMaxSize),
+		WithLTDChecksummer(config.Checksummer),
+		WithLTDMaxDecompressedSize(config.MaxDecompressedSize),
 	)
 }
@@ -3,15 +3,16 @@ package datapack
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"unsafe"
 
 	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
 	zerobytes "github.com/zerogo-hub/zero-helper/bytes"
 	zerocompress "github.com/zerogo-hub/zero-helper/compress"
-	zerocrypto "github.com/zerogo-hub/zero-helper/crypto"
 	zerologger "github.com/zerogo-hub/zero-helper/logger"
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 )
@@ -34,10 +35,26 @@ var (
 
 	// ErrDecompressPayload 解压负载失败
 	ErrDecompressPayload = errors.New("decompress payload failed")
+
+	// ErrUnsupportedVersion 协议版本不支持
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	// ErrBodyTooShort 消息体 (解密、解压之后) 短于 Code + Module + Action
+	ErrBodyTooShort = errors.New("message body too short")
 )
 
 const (
+	// ChecksumLength HMAC-MD5 校验值长度，使用其它 Checksummer 时消息头中的校验值长度见 Checksummer.Size
 	ChecksumLength = 16
+
+	// CorrelationIDLength 关联编号长度，见 WithLTDCorrelationID
+	CorrelationIDLength = 8
+
+	// ContentTypeLength 负载内容类型长度，见 WithLTDContentType
+	ContentTypeLength = 1
+
+	// ltdBodyFixedLen 消息体中固定字段的长度，Code (2) + Module (1) + Action (1)
+	ltdBodyFixedLen = 4
 )
 
 // ltdMessageHead 消息头
@@ -64,12 +81,21 @@ type ltdMessageBody struct {
 	Payload []byte
 }
 
-// HeadLen 消息头长度，6 字节或者 22 字节
-func ltdHeadLen(whetherChecksum bool) int {
-	length := int(unsafe.Sizeof(ltdMessageHead{}))
+// HeadLen 消息头长度，6 字节再加上 checksumSize 字节的校验值，默认的 HMAC-MD5 时为 22 字节
+// 启用版本号时再增加 1 字节，启用关联编号时再增加 8 字节，启用内容类型时再增加 1 字节
+func ltdHeadLen(checksumSize int, whetherVersion, whetherCorrelationID, whetherContentType bool) int {
+	length := int(unsafe.Sizeof(ltdMessageHead{})) - ChecksumLength + checksumSize
 
-	if !whetherChecksum {
-		length -= ChecksumLength
+	if whetherVersion {
+		length++
+	}
+
+	if whetherCorrelationID {
+		length += CorrelationIDLength
+	}
+
+	if whetherContentType {
+		length += ContentTypeLength
 	}
 
 	return length
@@ -85,6 +111,16 @@ type ltdMessage struct {
 
 	// sessionID 会话 id
 	sessionID zeronetwork.SessionID
+
+	// correlationID 关联编号，启用 WithLTDCorrelationID 时位于消息头中 SN 之后
+	// 不放在 ltdMessageHead 中，避免影响 ltdHeadLen 的计算
+	correlationID uint64
+
+	// contentType 负载的内容类型，启用 WithLTDContentType 时位于消息头中关联编号之后
+	contentType zeronetwork.ContentType
+
+	// payloadBuffer 解包时从缓冲池中获取的负载内存，Release 时归还，见 WithLTDPayloadPool
+	payloadBuffer *[]byte
 }
 
 // NewLTDMessage 创建一个消息
@@ -94,6 +130,9 @@ func NewLTDMessage(flag, sn, code uint16, module, action uint8, payload []byte)
 	m.head.Len = uint16(4 + len(payload))
 	m.head.Flag = flag
 	m.head.SN = sn
+	m.correlationID = 0
+	m.contentType = zeronetwork.ContentTypeRaw
+	m.payloadBuffer = nil
 
 	m.body.Code = code
 	m.body.Module = module
@@ -103,6 +142,28 @@ func NewLTDMessage(flag, sn, code uint16, module, action uint8, payload []byte)
 	return m
 }
 
+// CopyMessage 复制 message，标记改为 flag，负载为副本，不会随 message 一起释放
+func CopyMessage(message zeronetwork.Message, flag uint16) zeronetwork.Message {
+	m := NewLTDMessage(flag, message.SN(), message.Code(), message.ModuleID(), message.ActionID(), append([]byte(nil), message.Payload()...))
+	m.SetSessionID(message.SessionID())
+	m.SetCorrelationID(message.CorrelationID())
+	m.SetContentType(message.ContentType())
+	return m
+}
+
+// NewLTDRouteMessage 使用路由 ID 创建一个消息
+func NewLTDRouteMessage(flag, sn, code uint16, routeID zeronetwork.RouteID, payload []byte) zeronetwork.Message {
+	return NewLTDMessage(flag, sn, code, routeID.Module(), routeID.Action(), payload)
+}
+
+// Respond 创建 req 的响应消息，沿用 req 的 SN、关联编号与 module，错误码为 0
+// 客户端依赖 SN 将响应与请求对应起来
+func Respond(req zeronetwork.Message, action uint8, payload []byte) zeronetwork.Message {
+	resp := NewLTDMessage(0, req.SN(), 0, req.ModuleID(), action, payload)
+	resp.SetCorrelationID(req.CorrelationID())
+	return resp
+}
+
 // SessionID 会话 ID，每一个连接都有一个唯一的会话 ID
 func (m *ltdMessage) SessionID() zeronetwork.SessionID {
 	return m.sessionID
@@ -138,11 +199,58 @@ func (m *ltdMessage) SN() uint16 {
 	return m.head.SN
 }
 
+// SetSN 设置自增编号
+func (m *ltdMessage) SetSN(sn uint16) {
+	m.head.SN = sn
+}
+
+// CorrelationID 关联编号
+func (m *ltdMessage) CorrelationID() uint64 {
+	return m.correlationID
+}
+
+// SetCorrelationID 设置关联编号
+func (m *ltdMessage) SetCorrelationID(id uint64) {
+	m.correlationID = id
+}
+
+// ContentType 负载的内容类型
+func (m *ltdMessage) ContentType() zeronetwork.ContentType {
+	return m.contentType
+}
+
+// SetContentType 设置负载的内容类型
+func (m *ltdMessage) SetContentType(contentType zeronetwork.ContentType) {
+	m.contentType = contentType
+}
+
 // Payload 负载
 func (m *ltdMessage) Payload() []byte {
 	return m.body.Payload
 }
 
+// BindJSON 使用 encoding/json 将负载解码到 v 中
+func (m *ltdMessage) BindJSON(v interface{}) error {
+	return json.Unmarshal(m.body.Payload, v)
+}
+
+// Bind 根据 ContentType 将负载解码到 v 中
+func (m *ltdMessage) Bind(v interface{}) error {
+	switch m.contentType {
+	case zeronetwork.ContentTypeJSON:
+		return json.Unmarshal(m.body.Payload, v)
+	case zeronetwork.ContentTypeProtobuf:
+		return protobufCodec.Unmarshal(m.body.Payload, v)
+	case zeronetwork.ContentTypeRaw:
+		if p, ok := v.(*[]byte); ok {
+			*p = append((*p)[:0], m.body.Payload...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s, bind to %T", zeronetwork.ErrUnsupportedContentType, m.contentType, v)
+}
+
 // Checksum 校验值
 func (m *ltdMessage) Checksum() [ChecksumLength]byte {
 	return m.head.Checksum
@@ -153,18 +261,46 @@ func (m *ltdMessage) String() string {
 	return fmt.Sprintf("sn: %d, module: %d, action: %d", m.head.SN, m.body.Module, m.body.Action)
 }
 
-// Release 释放资源
+// Clone 复制消息，负载为副本，不使用缓冲池
+func (m *ltdMessage) Clone() zeronetwork.Message {
+	return CopyMessage(m, m.head.Flag)
+}
 
+// Release 释放资源
+// 解包得到的负载使用缓冲池时一并归还，之后不能再使用 Payload
 func (m *ltdMessage) Release() {
+	if m.payloadBuffer != nil {
+		putPayload(m.payloadBuffer)
+		m.payloadBuffer = nil
+		m.body.Payload = nil
+	}
+
 	messagePool.Put(m)
 }
 
 // ltd 按 Length-Type-Data 格式进行封包与解包
 // 封装出的消息结构见 ltdMessage
+// 处理顺序固定：消息体 (Code + Module + Action + Payload) 先压缩再加密，解包时先解密再解压
+// 校验值基于压缩与加密之后的完整消息计算，消息头中的 Len 为处理之后的消息体长度
 type ltd struct {
 	// headLen 消息头长度
 	headLen int
 
+	// whetherVersion 是否在消息头开头写入 1 字节的协议版本号
+	whetherVersion bool
+
+	// version 协议版本号，解包时版本号不一致的消息会被拒绝
+	version uint8
+
+	// lenIndex 消息体长度在消息头中的位置，启用版本号时为 1，否则为 0
+	lenIndex int
+
+	// whetherCorrelationID 是否在消息头中 SN 之后写入 8 字节的关联编号
+	whetherCorrelationID bool
+
+	// whetherContentType 是否在消息头中关联编号之后写入 1 字节的负载内容类型
+	whetherContentType bool
+
 	// whetherCompress 是否需要对消息负载 payload 进行压缩
 	whetherCompress bool
 
@@ -174,6 +310,12 @@ type ltd struct {
 	// compress 压缩与解压器，默认 zip
 	compress zerocompress.Compress
 
+	// maxDecompressedSize 解压后负载的最大长度，<= 0 表示不限制
+	maxDecompressedSize int
+
+	// payloadPoolMaxSize 解包时负载不超过该长度则从缓冲池中获取内存，<= 0 表示不使用缓冲池
+	payloadPoolMaxSize int
+
 	// whetherCrypto 是否需要对消息负载 payload 进行加密
 	whetherCrypto bool
 
@@ -183,11 +325,64 @@ type ltd struct {
 	// order 默认使用大端模式
 	order binary.ByteOrder
 
+	// checksummer 计算校验值，默认 HMACMD5Checksummer
+	checksummer zeronetwork.Checksummer
+
 	// logger 日志
 	logger zerologger.Logger
+}
+
+// LTDOption 封包解包工具的可选配置
+type LTDOption func(*ltd)
+
+// WithLTDVersion 在消息头开头写入 1 字节的协议版本号
+// 解包时版本号不一致的消息返回 ErrUnsupportedVersion，通信双方需要同时启用
+func WithLTDVersion(version uint8) LTDOption {
+	return func(l *ltd) {
+		l.whetherVersion = true
+		l.version = version
+	}
+}
+
+// WithLTDCorrelationID 在消息头中 SN 之后写入 8 字节的关联编号，见 Message.CorrelationID
+// 用于追踪服务端主动推送 (SN 为 0) 以及跨服务的调用，通信双方需要同时启用
+func WithLTDCorrelationID() LTDOption {
+	return func(l *ltd) {
+		l.whetherCorrelationID = true
+	}
+}
+
+// WithLTDPayloadPool 解包时负载不超过 maxSize 则从共享缓冲池中获取内存，消息 Release 时归还
+// 启用后 Payload 只在消息 Release 之前有效，需要在 Release 之后使用 (比如作为响应的负载) 时需要复制
+func WithLTDPayloadPool(maxSize int) LTDOption {
+	return func(l *ltd) {
+		l.payloadPoolMaxSize = maxSize
+	}
+}
+
+// WithLTDContentType 在消息头中关联编号之后写入 1 字节的负载内容类型，见 Message.ContentType
+// 接收方不需要事先约定即可使用 Message.Bind 解码负载，通信双方需要同时启用
+func WithLTDContentType() LTDOption {
+	return func(l *ltd) {
+		l.whetherContentType = true
+	}
+}
+
+// WithLTDChecksummer 开启校验值时使用的算法，nil 时仍然使用 HMACMD5Checksummer，通信双方需要一致
+// 消息头中校验值部分的长度随之变化，比如 CRC32Checksummer 为 4 字节
+func WithLTDChecksummer(checksummer zeronetwork.Checksummer) LTDOption {
+	return func(l *ltd) {
+		if checksummer != nil {
+			l.checksummer = checksummer
+		}
+	}
+}
 
-	// emptyChecksum 空检验值，用于计算
-	emptyChecksum [ChecksumLength]byte
+// WithLTDMaxDecompressedSize 解压后负载的最大长度，超过时解包失败，<= 0 表示不限制，见 Config.MaxDecompressedSize
+func WithLTDMaxDecompressedSize(maxDecompressedSize int) LTDOption {
+	return func(l *ltd) {
+		l.maxDecompressedSize = maxDecompressedSize
+	}
 }
 
 // NewLTD 创建一个封包解包工具
@@ -199,19 +394,34 @@ func NewLTD(
 	whetherCrypto bool,
 	whetherChecksum bool,
 	logger zerologger.Logger,
+	opts ...LTDOption,
 ) zeronetwork.Datapack {
-	return &ltd{
-		headLen:           ltdHeadLen(whetherChecksum),
+	l := &ltd{
 		whetherCompress:   whetherCompress,
 		compressThreshold: compressThreshold,
 		compress:          compress,
 		whetherCrypto:     whetherCrypto,
 		whetherChecksum:   whetherChecksum,
 		// 默认使用大端，zerobytes.ToUint16 也是大端模式
-		order:         binary.BigEndian,
-		logger:        logger,
-		emptyChecksum: [ChecksumLength]byte{},
+		order:       binary.BigEndian,
+		checksummer: HMACMD5Checksummer,
+		logger:      logger,
 	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	checksumSize := 0
+	if whetherChecksum {
+		checksumSize = l.checksummer.Size()
+	}
+	l.headLen = ltdHeadLen(checksumSize, l.whetherVersion, l.whetherCorrelationID, l.whetherContentType)
+	if l.whetherVersion {
+		l.lenIndex = 1
+	}
+
+	return l
 }
 
 // HeadLen 消息头长度
@@ -219,9 +429,32 @@ func (l *ltd) HeadLen() int {
 	return l.headLen
 }
 
+// WhetherCompress 封包时是否会压缩消息负载，需要同时开启压缩并设置压缩工具
+func (l *ltd) WhetherCompress() bool {
+	return l.whetherCompress && l.compress != nil
+}
+
+// checkVersion 校验消息头开头的协议版本号，未启用版本号时不校验
+func (l *ltd) checkVersion(head []byte) error {
+	if !l.whetherVersion {
+		return nil
+	}
+
+	if head[0] != l.version {
+		return fmt.Errorf("%w: %d, expected: %d", ErrUnsupportedVersion, head[0], l.version)
+	}
+
+	return nil
+}
+
 // Pack 封包
 func (l *ltd) Pack(message zeronetwork.Message, crypto zeronetwork.Crypto, checksumKey []byte) ([]byte, error) {
-	body, flag, err := l.packBody(message, crypto)
+	return l.PackCompress(message, crypto, checksumKey, true)
+}
+
+// PackCompress 封包，compress 为 false 时不压缩消息负载，用于按会话协商压缩，见 Config.CompressNegotiation
+func (l *ltd) PackCompress(message zeronetwork.Message, crypto zeronetwork.Crypto, checksumKey []byte, compress bool) ([]byte, error) {
+	body, flag, err := l.packBody(message, crypto, compress)
 	if err != nil {
 		return nil, err
 	}
@@ -231,83 +464,59 @@ func (l *ltd) Pack(message zeronetwork.Message, crypto zeronetwork.Crypto, check
 		flag |= zeronetwork.FlagChecksum
 	}
 
-	buffer := bufferPool.Get().(*bytes.Buffer)
-	defer bufferPool.Put(buffer)
-	buffer.Reset()
-
-	bodyLen := uint16(len(body))
+	// 直接按字节序写入，避免 binary.Write 的反射开销
+	// 未开启校验值时 headLen 不包含校验值，开启时校验值部分先保持为 0
+	allBytes := make([]byte, l.headLen+len(body))
 
-	// 消息体长度
-	if err := binary.Write(buffer, l.order, bodyLen); err != nil {
-		return nil, err
+	// 协议版本号
+	if l.whetherVersion {
+		allBytes[0] = l.version
 	}
+	// 消息体长度
+	l.order.PutUint16(allBytes[l.lenIndex:], uint16(len(body)))
 	// flag 标记
-	if err := binary.Write(buffer, l.order, flag); err != nil {
-		return nil, err
-	}
+	l.order.PutUint16(allBytes[l.lenIndex+2:], flag)
 	// SN 编号
-	if err := binary.Write(buffer, l.order, message.SN()); err != nil {
-		return nil, err
+	l.order.PutUint16(allBytes[l.lenIndex+4:], message.SN())
+	index := l.lenIndex + 6
+	// 关联编号
+	if l.whetherCorrelationID {
+		l.order.PutUint64(allBytes[index:], message.CorrelationID())
+		index += CorrelationIDLength
 	}
-
-	// 校验值
-	if l.whetherChecksum {
-		if err := binary.Write(buffer, l.order, l.emptyChecksum); err != nil {
-			return nil, err
-		}
+	// 负载内容类型
+	if l.whetherContentType {
+		allBytes[index] = uint8(message.ContentType())
 	}
 	// 负载
-	if len(body) > 0 {
-		if err := binary.Write(buffer, l.order, body); err != nil {
-			return nil, err
-		}
-	}
-
-	allBytes := buffer.Bytes()
+	copy(allBytes[l.headLen:], body)
 
 	// 计算校验值并填充
 	if l.whetherChecksum && (flag&zeronetwork.FlagZero == 0) {
-		calcChecksum := zerocrypto.HmacMd5ByteToByte(allBytes, checksumKey)
-		checksumStartIndex := l.HeadLen() - ChecksumLength
-		for i, v := range calcChecksum {
-			allBytes[checksumStartIndex+i] = v
-		}
+		copy(allBytes[l.headLen-l.checksummer.Size():l.headLen], l.checksummer.Sum(allBytes, checksumKey))
 	}
 
 	return allBytes, nil
 }
 
-func (l *ltd) packBody(message zeronetwork.Message, crypto zeronetwork.Crypto) ([]byte, uint16, error) {
-	buffer := bufferPool.Get().(*bytes.Buffer)
-	defer bufferPool.Put(buffer)
-	buffer.Reset()
+func (l *ltd) packBody(message zeronetwork.Message, crypto zeronetwork.Crypto, compress bool) ([]byte, uint16, error) {
+	payload := message.Payload()
+	body := make([]byte, 4+len(payload))
 
 	// 错误码
-	if err := binary.Write(buffer, l.order, message.Code()); err != nil {
-		return nil, 0, err
-	}
+	l.order.PutUint16(body[0:], message.Code())
 	// Module
-	if err := binary.Write(buffer, l.order, message.ModuleID()); err != nil {
-		return nil, 0, err
-	}
+	body[2] = message.ModuleID()
 	// Action
-	if err := binary.Write(buffer, l.order, message.ActionID()); err != nil {
-		return nil, 0, err
-	}
+	body[3] = message.ActionID()
 	// 负载
-	payload := message.Payload()
-	if len(payload) > 0 {
-		if err := binary.Write(buffer, l.order, payload); err != nil {
-			return nil, 0, err
-		}
-	}
+	copy(body[4:], payload)
 
 	var err error
-	body := buffer.Bytes()
 	flag := message.Flag()
 
 	// 压缩
-	if l.whetherCompress && l.compress != nil && len(body) >= l.compressThreshold {
+	if compress && l.whetherCompress && l.compress != nil && len(body) >= l.compressThreshold {
 		body, err = l.compress.Compress(body)
 		if err != nil {
 			l.logger.Errorf("compress failed, message: %s, err: %s", message.String(), err.Error())
@@ -332,151 +541,242 @@ func (l *ltd) packBody(message zeronetwork.Message, crypto zeronetwork.Crypto) (
 }
 
 // Unpack 解包
+// 遇到会改变秘钥的特殊协议消息时停止，之后的数据留在 buffer 中
 func (l *ltd) Unpack(buffer *zeroringbytes.RingBytes, crypto zeronetwork.Crypto, checksumKey []byte) ([]zeronetwork.Message, error) {
 	messages := []zeronetwork.Message{}
 
 	for {
-		bufferLen := buffer.Len()
-
-		if bufferLen < l.headLen {
-			// 内容连消息头都无法存放完，目前这不是一个完整的消息
-			break
-		}
-
-		// 取出消息体长度
-		p, err := buffer.Peek(2)
+		message, err := l.UnpackOne(buffer, crypto, checksumKey)
 		if err != nil {
-			return nil, ErrGetPayloadLen
+			return nil, err
 		}
-		bodyLen := int(zerobytes.ToUint16(p))
-		index := 2
-
-		// 判断是否满足至少一个消息
-		if bufferLen < l.headLen+bodyLen {
-			// 当前内容长度 < 消息头长度 + 负载长度
-			// 目前这不是一个完整的消息
+		if message == nil {
 			break
 		}
+		messages = append(messages, message)
 
-		// 取出所有内容
-		allBytes, err := buffer.Read(l.headLen + bodyLen)
-		if err != nil {
-			return nil, ErrGetAllBytes
+		// 之后的数据需要使用新的秘钥解包，留在 buffer 中等待下一次调用
+		if zeronetwork.RotatesCrypto(message) {
+			break
 		}
+	}
 
-		// ---------------------- 消息头 ----------------------
+	return messages, nil
+}
 
-		// flag 标记
-		p = allBytes[index : index+2]
-		flag := zerobytes.ToUint16(p)
-		index += 2
+// UnpackOne 解包一个完整的消息，buffer 中不足一个完整的消息时返回 nil
+func (l *ltd) UnpackOne(buffer *zeroringbytes.RingBytes, crypto zeronetwork.Crypto, checksumKey []byte) (zeronetwork.Message, error) {
+	bufferLen := buffer.Len()
 
-		// sn 自增编号
-		p = allBytes[index : index+2]
-		sn := zerobytes.ToUint16(p)
-		index += 2
+	if bufferLen < l.headLen {
+		// 内容连消息头都无法存放完，目前这不是一个完整的消息
+		return nil, nil
+	}
 
-		// checksum 校验值
-		if l.whetherChecksum {
-			// 发送端需要设置此标记
-			if flag&zeronetwork.FlagChecksum == 0 {
-				return nil, ErrNoChecksumFlag
-			}
+	// 取出消息体长度
+	p, err := buffer.Peek(l.lenIndex + 2)
+	if err != nil {
+		return nil, ErrGetPayloadLen
+	}
+	if err := l.checkVersion(p); err != nil {
+		return nil, err
+	}
+	bodyLen := int(zerobytes.ToUint16(p[l.lenIndex:]))
 
-			if flag&zeronetwork.FlagZero == 0 {
-				checksum := [ChecksumLength]byte{}
-				p = allBytes[index : index+ChecksumLength]
-				copy(checksum[:], p)
-				if !l.verifyChecksum(checksum, allBytes, checksumKey) {
-					return nil, ErrVerifyChecksum
-				}
-			}
+	// 判断是否满足至少一个消息
+	if bufferLen < l.headLen+bodyLen {
+		// 当前内容长度 < 消息头长度 + 负载长度
+		// 目前这不是一个完整的消息
+		return nil, nil
+	}
 
-			index += ChecksumLength
-		}
+	// 取出所有内容
+	allBytes, err := buffer.Read(l.headLen + bodyLen)
+	if err != nil {
+		return nil, ErrGetAllBytes
+	}
 
-		// ---------------------- 消息体(解密、解压) ----------------------
+	return l.unpackFrame(allBytes, crypto, checksumKey)
+}
 
-		bodyBytes := allBytes[index:]
+// bytesPeeker 可以查看而不读取数据，比如 bufio.Reader
+type bytesPeeker interface {
+	Peek(n int) ([]byte, error)
+}
 
-		// 解密
-		if flag&zeronetwork.FlagEncrypt != 0 && crypto != nil && (flag&zeronetwork.FlagZero == 0) {
-			bodyBytes, err = crypto.Decrypt(bodyBytes)
-			if err != nil {
-				l.logger.Errorf("decrypt failed, sn: %d, err: %s", sn, err.Error())
-				return nil, ErrDecryptPayload
+// UnpackFrom 从 reader 中读取一个完整的消息并解包
+// 先读取消息头，再根据消息头中的长度读取消息体，不需要经过 RingBytes 中转
+// 读取失败时返回 reader 的原始错误，比如 io.EOF
+func (l *ltd) UnpackFrom(reader io.Reader, crypto zeronetwork.Crypto, checksumKey []byte) (zeronetwork.Message, error) {
+	// offset allBytes 中已经读取的长度
+	offset := 0
+	var allBytes []byte
+
+	if peeker, ok := reader.(bytesPeeker); ok {
+		// 比如 bufio.Reader，先查看消息体长度，再一次性读取整个消息，只需要分配一次内存
+		p, err := peeker.Peek(l.lenIndex + 2)
+		if err != nil {
+			if err == io.EOF && len(p) > 0 {
+				err = io.ErrUnexpectedEOF
 			}
+			return nil, err
+		}
+		if err := l.checkVersion(p); err != nil {
+			return nil, err
+		}
+		allBytes = make([]byte, l.headLen+int(zerobytes.ToUint16(p[l.lenIndex:])))
+	} else {
+		head := make([]byte, l.headLen)
+		if _, err := io.ReadFull(reader, head); err != nil {
+			return nil, err
+		}
+		if err := l.checkVersion(head); err != nil {
+			return nil, err
 		}
+		allBytes = make([]byte, l.headLen+int(zerobytes.ToUint16(head[l.lenIndex:])))
+		offset = copy(allBytes, head)
+	}
 
-		// 解压
-		if flag&zeronetwork.FlagCompress != 0 && l.compress != nil {
-			bodyBytes, err = l.compress.Uncompress(bodyBytes)
-			if err != nil {
-				l.logger.Errorf("decompress failed, sn: %d, err: %s", sn, err.Error())
-				return nil, ErrDecompressPayload
-			}
+	if _, err := io.ReadFull(reader, allBytes[offset:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
 		}
+		return nil, err
+	}
 
-		index = 0
+	return l.unpackFrame(allBytes, crypto, checksumKey)
+}
 
-		// code 错误码
-		code := uint16(0)
-		index += 2
+// unpackFrame 解析一个完整的消息，allBytes 包含消息头与消息体
+func (l *ltd) unpackFrame(allBytes []byte, crypto zeronetwork.Crypto, checksumKey []byte) (zeronetwork.Message, error) {
+	var err error
+	index := l.lenIndex + 2
 
-		// module 功能模块
-		p = bodyBytes[index : index+1]
-		module := zerobytes.ToUint8(p)
-		index += 1
+	// ---------------------- 消息头 ----------------------
 
-		// action 功能细分
-		p = bodyBytes[index : index+1]
-		action := zerobytes.ToUint8(p)
-		index += 1
+	// flag 标记
+	p := allBytes[index : index+2]
+	flag := zerobytes.ToUint16(p)
+	index += 2
+
+	// sn 自增编号
+	p = allBytes[index : index+2]
+	sn := zerobytes.ToUint16(p)
+	index += 2
+
+	// correlationID 关联编号
+	var correlationID uint64
+	if l.whetherCorrelationID {
+		correlationID = l.order.Uint64(allBytes[index : index+CorrelationIDLength])
+		index += CorrelationIDLength
+	}
 
-		// payload 负载
-		var payload []byte
-		if bodyLen-4 > 0 {
-			payload = bodyBytes[index:]
+	// contentType 负载内容类型
+	contentType := zeronetwork.ContentTypeRaw
+	if l.whetherContentType {
+		contentType = zeronetwork.ContentType(allBytes[index])
+		index += ContentTypeLength
+	}
+
+	// checksum 校验值
+	if l.whetherChecksum {
+		// 发送端需要设置此标记
+		if flag&zeronetwork.FlagChecksum == 0 {
+			return nil, ErrNoChecksumFlag
 		}
 
-		// 组装一个消息
-		message := NewLTDMessage(flag, sn, code, module, action, payload)
-		messages = append(messages, message)
+		if flag&zeronetwork.FlagZero == 0 && !l.verifyChecksum(allBytes, checksumKey) {
+			return nil, ErrVerifyChecksum
+		}
+
+		index += l.checksummer.Size()
 	}
 
-	return messages, nil
-}
+	// ---------------------- 消息体(解密、解压) ----------------------
 
-func (l *ltd) verifyChecksum(checksum [ChecksumLength]byte, allBytes, checksumKey []byte) bool {
-	// 将填写检验值部分置 0
-	checksumStartIndex := l.HeadLen() - ChecksumLength
-	for i := checksumStartIndex; i < checksumStartIndex+ChecksumLength; i++ {
-		allBytes[i] = 0
+	bodyBytes := allBytes[index:]
+
+	// 解密
+	if flag&zeronetwork.FlagEncrypt != 0 && crypto != nil && (flag&zeronetwork.FlagZero == 0) {
+		bodyBytes, err = crypto.Decrypt(bodyBytes)
+		if err != nil {
+			l.logger.Errorf("decrypt failed, sn: %d, err: %s", sn, err.Error())
+			return nil, ErrDecryptPayload
+		}
 	}
-	calcChecksum := zerocrypto.HmacMd5ByteToByte(allBytes, checksumKey)
 
-	if len(calcChecksum) != len(checksum) {
-		return false
+	// 解压
+	if flag&zeronetwork.FlagCompress != 0 && l.compress != nil {
+		bodyBytes, err = uncompress(l.compress, bodyBytes, l.maxDecompressedSize)
+		if err != nil {
+			l.logger.Errorf("decompress failed, sn: %d, err: %s", sn, err.Error())
+			if err == ErrDecompressTooLarge {
+				return nil, err
+			}
+			return nil, ErrDecompressPayload
+		}
+	}
+
+	// 消息头中的长度来自对方，解密、解压之后的消息体也可能不足以包含固定的字段
+	if len(bodyBytes) < ltdBodyFixedLen {
+		return nil, ErrBodyTooShort
 	}
 
-	for i, v1 := range checksum {
-		if v1 != calcChecksum[i] {
-			return false
+	index = 0
+
+	// code 错误码
+	p = bodyBytes[index : index+2]
+	code := zerobytes.ToUint16(p)
+	index += 2
+
+	// module 功能模块
+	p = bodyBytes[index : index+1]
+	module := zerobytes.ToUint8(p)
+	index += 1
+
+	// action 功能细分
+	p = bodyBytes[index : index+1]
+	action := zerobytes.ToUint8(p)
+	index += 1
+
+	// payload 负载，复制一份由消息持有
+	// bodyBytes 可能指向 RingBytes 内部的缓冲区，继续读取之后会被覆盖
+	var payload []byte
+	var payloadBuffer *[]byte
+	if len(bodyBytes) > index {
+		size := len(bodyBytes) - index
+		if l.payloadPoolMaxSize > 0 && size <= l.payloadPoolMaxSize {
+			payloadBuffer = getPayload(size)
+			payload = *payloadBuffer
+		} else {
+			payload = make([]byte, size)
 		}
+		copy(payload, bodyBytes[index:])
 	}
 
-	return true
+	// 组装一个消息
+	message := NewLTDMessage(flag, sn, code, module, action, payload)
+	message.SetCorrelationID(correlationID)
+	message.SetContentType(contentType)
+	message.(*ltdMessage).payloadBuffer = payloadBuffer
+	return message, nil
+}
+
+// verifyChecksum 校验 allBytes 中的校验值，计算时校验值部分置 0，与封包时一致
+func (l *ltd) verifyChecksum(allBytes, checksumKey []byte) bool {
+	size := l.checksummer.Size()
+	checksum := make([]byte, size)
+	copy(checksum, allBytes[l.headLen-size:l.headLen])
+
+	// 将填写检验值部分置 0
+	clear(allBytes[l.headLen-size : l.headLen])
+
+	return bytes.Equal(checksum, l.checksummer.Sum(allBytes, checksumKey))
 }
 
-var bufferPool *sync.Pool
 var messagePool *sync.Pool
 
 func init() {
-	bufferPool = &sync.Pool{}
-	bufferPool.New = func() interface{} {
-		return &bytes.Buffer{}
-	}
-
 	messagePool = &sync.Pool{}
 	messagePool.New = func() interface{} {
 		return &ltdMessage{
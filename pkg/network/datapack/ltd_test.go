@@ -0,0 +1,728 @@
+package datapack_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
+	zeroprotobuf "github.com/zerogo-hub/zero-helper/codec/protobuf"
+	zerozlib "github.com/zerogo-hub/zero-helper/compress/zlib"
+	zerocrypto "github.com/zerogo-hub/zero-helper/crypto"
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	protocol "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp/example/protocol"
+	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
+)
+
+func packCompressed(t *testing.T, payload []byte) *zeroringbytes.RingBytes {
+	packer := zerodatapack.NewLTD(true, 0, zerozlib.NewZlib(), false, false, zerologger.NewSampleLogger())
+
+	message := zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload)
+	p, err := packer.Pack(message, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+
+	buffer := zeroringbytes.New(len(p) * 2)
+	if _, err := buffer.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	return buffer
+}
+
+func TestUnpackDecompressTooLarge(t *testing.T) {
+	// 1M 的 0 压缩后只有 1K 左右
+	buffer := packCompressed(t, make([]byte, 1024*1024))
+
+	unpacker := zerodatapack.NewLTD(true, 0, zerozlib.NewZlib(), false, false, zerologger.NewSampleLogger(), zerodatapack.WithLTDMaxDecompressedSize(64*1024))
+	_, err := unpacker.Unpack(buffer, nil, nil)
+	if err != zerodatapack.ErrDecompressTooLarge {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestUnpackDecompressWithinLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte("zero-node"), 1024)
+	buffer := packCompressed(t, payload)
+
+	unpacker := zerodatapack.NewLTD(true, 0, zerozlib.NewZlib(), false, false, zerologger.NewSampleLogger(), zerodatapack.WithLTDMaxDecompressedSize(64*1024))
+	messages, err := unpacker.Unpack(buffer, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack failed: %s", err.Error())
+	}
+
+	if len(messages) != 1 || !bytes.Equal(messages[0].Payload(), payload) {
+		t.Fatal("unexpected payload")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	req := zerodatapack.NewLTDMessage(0, 37, 5, 3, 1, []byte("req"))
+	resp := zerodatapack.Respond(req, 2, []byte("resp"))
+
+	if resp.SN() != req.SN() || resp.ModuleID() != req.ModuleID() {
+		t.Fatalf("unexpected response: %s", resp.String())
+	}
+	if resp.ActionID() != 2 || resp.Code() != 0 || string(resp.Payload()) != "resp" {
+		t.Fatalf("unexpected response: %s, code: %d", resp.String(), resp.Code())
+	}
+}
+
+// packWithBinaryWrite 使用 binary.Write 逐个字段封包，作为对照
+// 不包含压缩与加密
+func packWithBinaryWrite(message zeronetwork.Message, whetherChecksum bool, checksumKey []byte) []byte {
+	body := &bytes.Buffer{}
+	_ = binary.Write(body, binary.BigEndian, message.Code())
+	_ = binary.Write(body, binary.BigEndian, message.ModuleID())
+	_ = binary.Write(body, binary.BigEndian, message.ActionID())
+	if len(message.Payload()) > 0 {
+		_ = binary.Write(body, binary.BigEndian, message.Payload())
+	}
+
+	flag := message.Flag()
+	if whetherChecksum {
+		flag |= zeronetwork.FlagChecksum
+	}
+
+	buffer := &bytes.Buffer{}
+	_ = binary.Write(buffer, binary.BigEndian, uint16(body.Len()))
+	_ = binary.Write(buffer, binary.BigEndian, flag)
+	_ = binary.Write(buffer, binary.BigEndian, message.SN())
+	if whetherChecksum {
+		_ = binary.Write(buffer, binary.BigEndian, [zerodatapack.ChecksumLength]byte{})
+	}
+	_ = binary.Write(buffer, binary.BigEndian, body.Bytes())
+
+	allBytes := buffer.Bytes()
+	if whetherChecksum && (flag&zeronetwork.FlagZero == 0) {
+		copy(allBytes[6:], zerocrypto.HmacMd5ByteToByte(allBytes, checksumKey))
+	}
+
+	return allBytes
+}
+
+func TestPackMatchesBinaryWrite(t *testing.T) {
+	checksumKey := []byte("0123456789abcdef")
+
+	messages := []zeronetwork.Message{
+		zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil),
+		zerodatapack.NewLTDMessage(0, 0xfffe, 0x1234, 0xff, 0x80, []byte("zero-node")),
+		zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroExchangeKeyRequest, []byte("{}")),
+		zerodatapack.NewLTDMessage(0, 37, 5, 3, 1, bytes.Repeat([]byte{0xab}, 4096)),
+	}
+
+	for _, whetherChecksum := range []bool{false, true} {
+		packer := zerodatapack.NewLTD(false, 0, nil, false, whetherChecksum, zerologger.NewSampleLogger())
+
+		for _, message := range messages {
+			p, err := packer.Pack(message, nil, checksumKey)
+			if err != nil {
+				t.Fatalf("pack failed: %s", err.Error())
+			}
+
+			expected := packWithBinaryWrite(message, whetherChecksum, checksumKey)
+			if !bytes.Equal(p, expected) {
+				t.Fatalf("packed bytes mismatch, checksum: %t, message: %s\n got: %x\nwant: %x", whetherChecksum, message.String(), p, expected)
+			}
+		}
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	packer := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+	message := zerodatapack.NewLTDMessage(0, 37, 0, 3, 1, bytes.Repeat([]byte("zero-node"), 16))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := packer.Pack(message, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPackBinaryWrite(b *testing.B) {
+	message := zerodatapack.NewLTDMessage(0, 37, 0, 3, 1, bytes.Repeat([]byte("zero-node"), 16))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packWithBinaryWrite(message, false, nil)
+	}
+}
+
+// packFrames 将多个消息封包后拼接在一起
+func packFrames(t testing.TB, packer zeronetwork.Datapack, count int, checksumKey []byte) []byte {
+	stream := []byte{}
+	for i := 1; i <= count; i++ {
+		message := zerodatapack.NewLTDMessage(0, uint16(i), 0, 1, uint8(i), bytes.Repeat([]byte{byte(i)}, i*10))
+		p, err := packer.Pack(message, nil, checksumKey)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		stream = append(stream, p...)
+	}
+
+	return stream
+}
+
+func TestUnpackFromFragmented(t *testing.T) {
+	checksumKey := []byte("0123456789abcdef")
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"full":     func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+		"data err": iotest.DataErrReader,
+	}
+
+	for _, whetherChecksum := range []bool{false, true} {
+		datapack := zerodatapack.NewLTD(false, 0, nil, false, whetherChecksum, zerologger.NewSampleLogger())
+		stream := packFrames(t, datapack, 20, checksumKey)
+
+		for name, wrap := range readers {
+			reader := wrap(bytes.NewReader(stream))
+
+			for i := 1; i <= 20; i++ {
+				message, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, checksumKey)
+				if err != nil {
+					t.Fatalf("%s, checksum: %t, unpack %d failed: %s", name, whetherChecksum, i, err.Error())
+				}
+				if message.SN() != uint16(i) || message.ActionID() != uint8(i) || !bytes.Equal(message.Payload(), bytes.Repeat([]byte{byte(i)}, i*10)) {
+					t.Fatalf("%s, checksum: %t, unexpected message: %s", name, whetherChecksum, message.String())
+				}
+			}
+
+			if _, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, checksumKey); err != io.EOF {
+				t.Fatalf("%s, unexpected err: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestUnpackFromTruncated(t *testing.T) {
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+	stream := packFrames(t, datapack, 1, nil)
+
+	reader := bytes.NewReader(stream[:len(stream)-1])
+	if _, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, nil); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestUnpackFromBodyTooShort(t *testing.T) {
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+
+	// 消息头完整，消息体短于 Code + Module + Action，返回错误而不是 panic
+	for n := 0; n < 4; n++ {
+		frame := make([]byte, datapack.HeadLen()+n)
+		binary.BigEndian.PutUint16(frame, uint16(n))
+
+		if _, err := unpackBytes(datapack, frame, nil, nil); !errors.Is(err, zerodatapack.ErrBodyTooShort) {
+			t.Fatalf("body length %d, unexpected err: %v", n, err)
+		}
+		if _, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(bufio.NewReader(bytes.NewReader(frame)), nil, nil); !errors.Is(err, zerodatapack.ErrBodyTooShort) {
+			t.Fatalf("body length %d, unexpected err from bufio: %v", n, err)
+		}
+	}
+}
+
+// unpackBytes 从完整的封包数据中解出一个消息
+func unpackBytes(datapack zeronetwork.Datapack, p []byte, crypto zeronetwork.Crypto, checksumKey []byte) (zeronetwork.Message, error) {
+	return datapack.(zeronetwork.ReaderDatapack).UnpackFrom(bytes.NewReader(p), crypto, checksumKey)
+}
+
+func TestChecksumWithoutCrypto(t *testing.T) {
+	checksumKey := []byte("0123456789abcdef")
+	crypto, err := zerorc4.New(checksumKey)
+	if err != nil {
+		t.Fatalf("new rc4 failed: %s", err.Error())
+	}
+
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger())
+	payload := []byte("plaintext payload")
+	message := zerodatapack.NewLTDMessage(0, 37, 0, 3, 1, payload)
+
+	// 未开启加密时，即使传入了加解密工具，负载也保持明文，仍然计算校验值
+	for _, key := range [][]byte{checksumKey, nil} {
+		p, err := datapack.Pack(message, crypto, key)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		if !bytes.Contains(p, payload) {
+			t.Fatalf("payload is not plaintext: %x", p)
+		}
+
+		flag := binary.BigEndian.Uint16(p[2:])
+		if flag&zeronetwork.FlagChecksum == 0 || flag&zeronetwork.FlagEncrypt != 0 {
+			t.Fatalf("unexpected flag: %#x", flag)
+		}
+
+		unpacked, err := unpackBytes(datapack, p, crypto, key)
+		if err != nil {
+			t.Fatalf("unpack failed, key: %x, err: %s", key, err.Error())
+		}
+		if unpacked.SN() != 37 || !bytes.Equal(unpacked.Payload(), payload) {
+			t.Fatalf("unexpected message: %s", unpacked.String())
+		}
+	}
+
+	p, err := datapack.Pack(message, nil, checksumKey)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+
+	// 篡改消息头、负载或者使用不同的秘钥，均无法通过校验
+	tampers := map[string]func(p []byte) ([]byte, []byte){
+		"sn":      func(p []byte) ([]byte, []byte) { p[5] ^= 0x01; return p, checksumKey },
+		"payload": func(p []byte) ([]byte, []byte) { p[len(p)-1] ^= 0x01; return p, checksumKey },
+		"key":     func(p []byte) ([]byte, []byte) { return p, []byte("fedcba9876543210") },
+		"no key":  func(p []byte) ([]byte, []byte) { return p, nil },
+	}
+	for name, tamper := range tampers {
+		tampered, key := tamper(append([]byte(nil), p...))
+		if _, err := unpackBytes(datapack, tampered, nil, key); err != zerodatapack.ErrVerifyChecksum {
+			t.Errorf("%s: unexpected err: %v", name, err)
+		}
+	}
+
+	// 去掉校验标记
+	noFlag := append([]byte(nil), p...)
+	binary.BigEndian.PutUint16(noFlag[2:], binary.BigEndian.Uint16(noFlag[2:])&^zeronetwork.FlagChecksum)
+	if _, err := unpackBytes(datapack, noFlag, nil, checksumKey); err != zerodatapack.ErrNoChecksumFlag {
+		t.Errorf("unexpected err without checksum flag: %v", err)
+	}
+
+	// 特殊协议消息不计算校验值，秘钥协商完成之前双方的秘钥不同
+	exchange := zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroExchangeKeyRequest, []byte("{}"))
+	p, err = datapack.Pack(exchange, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	if _, err := unpackBytes(datapack, p, nil, checksumKey); err != nil {
+		t.Errorf("unpack flag zero message failed: %s", err.Error())
+	}
+}
+
+func TestChecksummer(t *testing.T) {
+	hmac := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger())
+	crc := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger(), zerodatapack.WithLTDChecksummer(zerodatapack.CRC32Checksummer))
+
+	if hmac.HeadLen() != 22 || crc.HeadLen() != 10 {
+		t.Fatalf("unexpected head length, hmac: %d, crc32: %d", hmac.HeadLen(), crc.HeadLen())
+	}
+
+	payload := []byte("crc32 payload")
+	message := zerodatapack.NewLTDMessage(0, 41, 0, 3, 1, payload)
+	p, err := crc.Pack(message, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	if len(p) != crc.HeadLen()+4+len(payload) {
+		t.Fatalf("unexpected packed length: %d", len(p))
+	}
+
+	unpacked, err := unpackBytes(crc, p, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack failed: %s", err.Error())
+	}
+	if unpacked.SN() != 41 || !bytes.Equal(unpacked.Payload(), payload) {
+		t.Fatalf("unexpected message: %s", unpacked.String())
+	}
+
+	// SN、校验值与消息体中任意一位翻转都能被发现，长度与标记见 TestChecksumWithoutCrypto
+	for i := 4 * 8; i < len(p)*8; i++ {
+		tampered := append([]byte(nil), p...)
+		tampered[i/8] ^= 1 << (i % 8)
+		if _, err := unpackBytes(crc, tampered, nil, nil); err != zerodatapack.ErrVerifyChecksum {
+			t.Fatalf("bit %d flipped, unexpected err: %v", i, err)
+		}
+	}
+
+	// 通信双方的算法不一致时无法解包
+	if _, err := unpackBytes(hmac, p, nil, nil); err == nil {
+		t.Fatal("unpack crc32 message with hmac-md5 succeeded")
+	}
+}
+
+func BenchmarkUnpackRingBytes(b *testing.B) {
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+	stream := packFrames(b, datapack, 32, nil)
+
+	buffer := make([]byte, 4096)
+	ringBytesBuffer := zeroringbytes.New(len(buffer) * 2)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		reader := bytes.NewReader(stream)
+		for {
+			n, err := reader.Read(buffer)
+			if err == io.EOF {
+				break
+			}
+			if err := ringBytesBuffer.WriteN(buffer, n); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := datapack.Unpack(ringBytesBuffer, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUnpackFrom(b *testing.B) {
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+	stream := packFrames(b, datapack, 32, nil)
+
+	reader := bytes.NewReader(stream)
+	bufReader := bufio.NewReaderSize(reader, 4096)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(stream)))
+	for i := 0; i < b.N; i++ {
+		reader.Reset(stream)
+		bufReader.Reset(reader)
+		for j := 0; j < 32; j++ {
+			if _, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(bufReader, nil, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestUnpackVersion(t *testing.T) {
+	v1 := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger(), zerodatapack.WithLTDVersion(1))
+	v2 := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger(), zerodatapack.WithLTDVersion(2))
+
+	if v1.HeadLen() != zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger()).HeadLen()+1 {
+		t.Fatalf("unexpected head length: %d", v1.HeadLen())
+	}
+
+	checksumKey := []byte("0123456789abcdef")
+
+	// v1 可以解析 v1 的消息
+	stream := packFrames(t, v1, 3, checksumKey)
+	buffer := zeroringbytes.New(len(stream) * 2)
+	if _, err := buffer.Write(stream); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	messages, err := v1.Unpack(buffer, nil, checksumKey)
+	if err != nil {
+		t.Fatalf("unpack failed: %s", err.Error())
+	}
+	if len(messages) != 3 {
+		t.Fatalf("unexpected messages: %d", len(messages))
+	}
+	for i, message := range messages {
+		if message.SN() != uint16(i+1) || !bytes.Equal(message.Payload(), bytes.Repeat([]byte{byte(i + 1)}, (i+1)*10)) {
+			t.Fatalf("unexpected message: %s", message.String())
+		}
+	}
+
+	// v1 拒绝 v2 的消息
+	stream = packFrames(t, v2, 1, checksumKey)
+	buffer.Reset()
+	if _, err := buffer.Write(stream); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	if _, err := v1.Unpack(buffer, nil, checksumKey); !errors.Is(err, zerodatapack.ErrUnsupportedVersion) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for _, reader := range []io.Reader{bytes.NewReader(stream), bufio.NewReader(bytes.NewReader(stream))} {
+		if _, err := v1.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, checksumKey); !errors.Is(err, zerodatapack.ErrUnsupportedVersion) {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+}
+
+func TestUnpackStopsAtRotation(t *testing.T) {
+	newCrypto := func() zeronetwork.Crypto {
+		c, err := zerorc4.New([]byte("0123456789abcdef"))
+		if err != nil {
+			t.Fatalf("new crypto failed: %s", err.Error())
+		}
+		return c
+	}
+
+	packer := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+
+	// 明文的秘钥交换响应之后，紧跟使用新秘钥加密的消息，两者在同一次读取中到达
+	rotation, err := packer.Pack(zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 1, 0, 0, zeronetwork.FlagZeroExchangeKeyResponse, []byte("key")), nil, nil)
+	if err != nil {
+		t.Fatalf("pack rotation failed: %s", err.Error())
+	}
+	encrypted, err := packer.Pack(zerodatapack.NewLTDMessage(0, 2, 0, 1, 1, []byte("zero-node")), newCrypto(), nil)
+	if err != nil {
+		t.Fatalf("pack encrypted failed: %s", err.Error())
+	}
+
+	buffer := zeroringbytes.New(1024)
+	if _, err := buffer.Write(append(rotation, encrypted...)); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	messages, err := packer.Unpack(buffer, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack rotation failed: %s", err.Error())
+	}
+	if len(messages) != 1 || !zeronetwork.RotatesCrypto(messages[0]) {
+		t.Fatalf("expected only the rotation message, got %d messages", len(messages))
+	}
+	if buffer.Len() != len(encrypted) {
+		t.Fatalf("expected %d bytes left, got %d", len(encrypted), buffer.Len())
+	}
+
+	messages, err = packer.Unpack(buffer, newCrypto(), nil)
+	if err != nil {
+		t.Fatalf("unpack encrypted failed: %s", err.Error())
+	}
+	if len(messages) != 1 || string(messages[0].Payload()) != "zero-node" {
+		t.Fatalf("unexpected messages after rotation: %v", messages)
+	}
+}
+
+func TestPackCompressThenEncrypt(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	encrypter, _ := zerorc4.New(key)
+	decrypter, _ := zerorc4.New(key)
+	compress := zerozlib.NewZlib()
+
+	packer := zerodatapack.NewLTD(true, 0, compress, true, false, zerologger.NewSampleLogger())
+	payload := bytes.Repeat([]byte("zero-node"), 32)
+
+	p, err := packer.Pack(zerodatapack.NewLTDMessage(0, 1, 7, 3, 5, payload), encrypter, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+
+	flag := binary.BigEndian.Uint16(p[2:])
+	if flag&zeronetwork.FlagCompress == 0 || flag&zeronetwork.FlagEncrypt == 0 {
+		t.Fatalf("unexpected flag: %x", flag)
+	}
+
+	// 按照规定的顺序手动还原：先解密，再解压，得到 Code + Module + Action + Payload
+	body, err := decrypter.Decrypt(p[packer.HeadLen():])
+	if err != nil {
+		t.Fatalf("decrypt failed: %s", err.Error())
+	}
+	body, err = compress.Uncompress(body)
+	if err != nil {
+		t.Fatalf("uncompress failed: %s", err.Error())
+	}
+
+	if binary.BigEndian.Uint16(body) != 7 || body[2] != 3 || body[3] != 5 || !bytes.Equal(body[4:], payload) {
+		t.Fatalf("unexpected body: %v", body[:4])
+	}
+}
+
+func TestPackCompressPerSession(t *testing.T) {
+	// 两个会话共用同一个封包工具，只有协商了压缩的会话才压缩
+	datapack := zerodatapack.NewLTD(true, 0, zerozlib.NewZlib(), false, false, zerologger.NewSampleLogger())
+	packer, ok := datapack.(zeronetwork.SessionCompressDatapack)
+	if !ok {
+		t.Fatal("ltd does not implement SessionCompressDatapack")
+	}
+	payload := bytes.Repeat([]byte("zero-node"), 32)
+
+	for _, compress := range []bool{true, false} {
+		p, err := packer.PackCompress(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload), nil, nil, compress)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+
+		flag := binary.BigEndian.Uint16(p[2:])
+		if (flag&zeronetwork.FlagCompress != 0) != compress {
+			t.Fatalf("unexpected flag: %x, compress: %v", flag, compress)
+		}
+		if compress == (len(p) >= datapack.HeadLen()+4+len(payload)) {
+			t.Fatalf("unexpected packed length: %d, compress: %v", len(p), compress)
+		}
+
+		// 解包按照消息头中的标记决定是否解压
+		message, err := unpackBytes(datapack, p, nil, nil)
+		if err != nil {
+			t.Fatalf("unpack failed: %s", err.Error())
+		}
+		if !bytes.Equal(message.Payload(), payload) {
+			t.Fatalf("unexpected payload, compress: %v", compress)
+		}
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	checksumKey := []byte("0123456789abcdef")
+
+	for _, whetherChecksum := range []bool{false, true} {
+		plain := zerodatapack.NewLTD(false, 0, nil, false, whetherChecksum, zerologger.NewSampleLogger())
+		datapack := zerodatapack.NewLTD(false, 0, nil, false, whetherChecksum, zerologger.NewSampleLogger(),
+			zerodatapack.WithLTDVersion(1), zerodatapack.WithLTDCorrelationID())
+
+		if datapack.HeadLen() != plain.HeadLen()+1+zerodatapack.CorrelationIDLength {
+			t.Fatalf("unexpected head length: %d", datapack.HeadLen())
+		}
+
+		// 服务端主动推送，SN 为 0
+		message := zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, []byte("push"))
+		message.SetCorrelationID(0x0102030405060708)
+
+		p, err := datapack.Pack(message, nil, checksumKey)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+
+		for _, reader := range []io.Reader{bytes.NewReader(p), bufio.NewReader(bytes.NewReader(p))} {
+			unpacked, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, checksumKey)
+			if err != nil {
+				t.Fatalf("unpack failed: %s", err.Error())
+			}
+			if unpacked.CorrelationID() != message.CorrelationID() || string(unpacked.Payload()) != "push" {
+				t.Fatalf("unexpected message: %s, correlation id: %x", unpacked.String(), unpacked.CorrelationID())
+			}
+			if resp := zerodatapack.Respond(unpacked, 3, nil); resp.CorrelationID() != message.CorrelationID() {
+				t.Fatalf("unexpected response correlation id: %x", resp.CorrelationID())
+			}
+		}
+
+		// 未启用时不会传输关联编号
+		p, err = plain.Pack(message, nil, checksumKey)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		if len(p) != plain.HeadLen()+4+len("push") {
+			t.Fatalf("unexpected frame length: %d", len(p))
+		}
+
+		unpacked, err := plain.(zeronetwork.ReaderDatapack).UnpackFrom(bytes.NewReader(p), nil, checksumKey)
+		if err != nil {
+			t.Fatalf("unpack failed: %s", err.Error())
+		}
+		if unpacked.CorrelationID() != 0 {
+			t.Fatalf("unexpected correlation id: %x", unpacked.CorrelationID())
+		}
+	}
+}
+
+func TestUnpackPayloadOwnership(t *testing.T) {
+	for _, poolSize := range []int{0, 1024} {
+		t.Run(fmt.Sprintf("pool-%d", poolSize), func(t *testing.T) {
+			datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger(), zerodatapack.WithLTDPayloadPool(poolSize))
+
+			pack := func(sn uint16, payload []byte) []byte {
+				p, err := datapack.Pack(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, payload), nil, nil)
+				if err != nil {
+					t.Fatalf("pack failed: %s", err.Error())
+				}
+				return p
+			}
+
+			first := bytes.Repeat([]byte("a"), 100)
+			p := pack(1, first)
+
+			// 只能容纳两个消息，继续读取时会覆盖之前的内容
+			buffer := zeroringbytes.New(len(p) * 2)
+			if _, err := buffer.Write(p); err != nil {
+				t.Fatalf("write failed: %s", err.Error())
+			}
+			message, err := datapack.(zeronetwork.SingleDatapack).UnpackOne(buffer, nil, nil)
+			if err != nil || message == nil {
+				t.Fatalf("unpack failed: %v", err)
+			}
+			defer message.Release()
+
+			// 处理 message 的同时继续读取后续消息
+			done := make(chan bool)
+			go func() {
+				for i := 0; i < 100; i++ {
+					if !bytes.Equal(message.Payload(), first) {
+						done <- false
+						return
+					}
+				}
+				done <- true
+			}()
+
+			for sn := uint16(2); sn < 20; sn++ {
+				if _, err := buffer.Write(pack(sn, bytes.Repeat([]byte("b"), 100))); err != nil {
+					t.Fatalf("write failed: %s", err.Error())
+				}
+				messages, err := datapack.Unpack(buffer, nil, nil)
+				if err != nil || len(messages) != 1 {
+					t.Fatalf("unpack failed: %v", err)
+				}
+				messages[0].Release()
+			}
+
+			if !<-done || !bytes.Equal(message.Payload(), first) {
+				t.Fatal("payload overwritten by subsequent reads")
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	checksumKey := []byte("0123456789abcdef")
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, true, zerologger.NewSampleLogger(),
+		zerodatapack.WithLTDCorrelationID(), zerodatapack.WithLTDContentType())
+
+	type hello struct {
+		Name string `json:"name"`
+	}
+	jsonMessage, err := zerodatapack.NewJSONMessage(1, 1, 1, &hello{Name: "zero"})
+	if err != nil {
+		t.Fatalf("new json message failed: %s", err.Error())
+	}
+	protoMessage, err := zerodatapack.NewCodecMessage(zeroprotobuf.New(), 2, 1, 2, &protocol.Req1{Name: "zero", Word: "hello"})
+	if err != nil {
+		t.Fatalf("new proto message failed: %s", err.Error())
+	}
+	rawMessage := zerodatapack.NewLTDMessage(0, 3, 0, 1, 3, []byte("raw"))
+
+	for _, message := range []zeronetwork.Message{jsonMessage, protoMessage, rawMessage} {
+		p, err := datapack.Pack(message, nil, checksumKey)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+
+		unpacked, err := datapack.(zeronetwork.ReaderDatapack).UnpackFrom(bytes.NewReader(p), nil, checksumKey)
+		if err != nil {
+			t.Fatalf("unpack failed: %s", err.Error())
+		}
+		if unpacked.ContentType() != message.ContentType() {
+			t.Fatalf("unexpected content type: %s, expected: %s", unpacked.ContentType(), message.ContentType())
+		}
+
+		// 不需要事先约定负载的编码方式
+		switch unpacked.ContentType() {
+		case zeronetwork.ContentTypeJSON:
+			v := &hello{}
+			if err := unpacked.Bind(v); err != nil || v.Name != "zero" {
+				t.Fatalf("bind json failed: %v, %+v", err, v)
+			}
+		case zeronetwork.ContentTypeProtobuf:
+			v := &protocol.Req1{}
+			if err := unpacked.Bind(v); err != nil || v.Name != "zero" || v.Word != "hello" {
+				t.Fatalf("bind protobuf failed: %v, %+v", err, v)
+			}
+			if err := unpacked.Bind(&hello{}); err == nil {
+				t.Fatal("expected error binding protobuf payload to a non-proto value")
+			}
+		case zeronetwork.ContentTypeRaw:
+			var v []byte
+			if err := unpacked.Bind(&v); err != nil || string(v) != "raw" {
+				t.Fatalf("bind raw failed: %v, %s", err, v)
+			}
+			if err := unpacked.Bind(&hello{}); !errors.Is(err, zeronetwork.ErrUnsupportedContentType) {
+				t.Fatalf("expected ErrUnsupportedContentType, got: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package datapack
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// minPayloadClass 缓冲池中最小的缓冲区长度为 1 << minPayloadClass
+const minPayloadClass = 6
+
+// payloadPools 解包负载使用的共享缓冲池，按 2 的幂区分长度，见 WithLTDPayloadPool
+var payloadPools [bits.UintSize]sync.Pool
+
+// payloadClass 容纳 size 字节所需的缓冲区等级，缓冲区长度为 1 << class
+func payloadClass(size int) int {
+	if size <= 1<<minPayloadClass {
+		return minPayloadClass
+	}
+
+	return bits.Len(uint(size - 1))
+}
+
+// getPayload 从缓冲池中获取长度为 size 的缓冲区，用完之后需要调用 putPayload 归还
+func getPayload(size int) *[]byte {
+	class := payloadClass(size)
+
+	if v := payloadPools[class].Get(); v != nil {
+		buffer := v.(*[]byte)
+		*buffer = (*buffer)[:size]
+		return buffer
+	}
+
+	buffer := make([]byte, size, 1<<class)
+	return &buffer
+}
+
+// putPayload 归还缓冲区
+func putPayload(buffer *[]byte) {
+	class := payloadClass(cap(*buffer))
+	if cap(*buffer) != 1<<class {
+		return
+	}
+
+	payloadPools[class].Put(buffer)
+}
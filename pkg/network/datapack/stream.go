@@ -0,0 +1,90 @@
+package datapack
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+var (
+	// ErrStreamInvalid 流式消息的负载无效
+	ErrStreamInvalid = errors.New("invalid stream chunk")
+
+	// ErrStreamSequence 流式消息的分块序号不连续
+	ErrStreamSequence = errors.New("stream chunk out of sequence")
+)
+
+const (
+	// StreamHeadLen 流式消息负载头长度: 分块序号(4 字节) + 是否最后一个分块(1 字节)
+	StreamHeadLen = 5
+
+	// StreamChunkSize 流式发送时每一个分块的长度，不包含负载头
+	StreamChunkSize = 32 * 1024
+)
+
+// NewStreamMessage 创建流式发送的一个分块，同一个流的所有分块使用相同的 module 与 action
+func NewStreamMessage(module, action uint8, seq uint32, last bool, data []byte) zeronetwork.Message {
+	payload := make([]byte, StreamHeadLen+len(data))
+	binary.BigEndian.PutUint32(payload, seq)
+	if last {
+		payload[4] = 1
+	}
+	copy(payload[StreamHeadLen:], data)
+
+	return NewLTDMessage(0, 0, 0, module, action, payload)
+}
+
+// ParseStream 解析流式消息的负载，返回分块序号、是否最后一个分块与分块数据
+func ParseStream(payload []byte) (uint32, bool, []byte, error) {
+	if len(payload) < StreamHeadLen || payload[4] > 1 {
+		return 0, false, nil, ErrStreamInvalid
+	}
+
+	return binary.BigEndian.Uint32(payload), payload[4] == 1, payload[StreamHeadLen:], nil
+}
+
+// StreamReassembler 接收方按顺序重组流式消息，写入 writer 中
+type StreamReassembler struct {
+	// writer 重组之后的数据
+	writer io.Writer
+
+	// next 下一个分块的序号
+	next uint32
+
+	// done 是否已经收到最后一个分块
+	done bool
+}
+
+// NewStreamReassembler 创建流式消息重组器，数据写入 writer
+func NewStreamReassembler(writer io.Writer) *StreamReassembler {
+	return &StreamReassembler{writer: writer}
+}
+
+// Write 写入一个分块，收到最后一个分块时返回 true
+// 分块需要按顺序到达，tcp、ws、kcp 都能保证同一个会话中消息的顺序
+func (r *StreamReassembler) Write(message zeronetwork.Message) (bool, error) {
+	seq, last, data, err := ParseStream(message.Payload())
+	if err != nil {
+		return false, err
+	}
+
+	if r.done || seq != r.next {
+		return false, ErrStreamSequence
+	}
+
+	if _, err := r.writer.Write(data); err != nil {
+		return false, err
+	}
+
+	r.next++
+	r.done = last
+
+	return last, nil
+}
+
+// Done 是否已经收到最后一个分块
+func (r *StreamReassembler) Done() bool {
+	return r.done
+}
@@ -0,0 +1,40 @@
+package datapack_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+func TestStreamReassemblerSequence(t *testing.T) {
+	var buffer bytes.Buffer
+	reassembler := zerodatapack.NewStreamReassembler(&buffer)
+
+	if _, err := reassembler.Write(zerodatapack.NewStreamMessage(1, 3, 1, false, []byte("b"))); !errors.Is(err, zerodatapack.ErrStreamSequence) {
+		t.Fatalf("expected ErrStreamSequence, got: %v", err)
+	}
+
+	for seq, chunk := range []string{"a", "b", "c"} {
+		last, err := reassembler.Write(zerodatapack.NewStreamMessage(1, 3, uint32(seq), seq == 2, []byte(chunk)))
+		if err != nil {
+			t.Fatalf("write chunk %d failed: %s", seq, err.Error())
+		}
+		if last != (seq == 2) {
+			t.Fatalf("unexpected last flag at chunk %d", seq)
+		}
+	}
+
+	if !reassembler.Done() || buffer.String() != "abc" {
+		t.Fatalf("unexpected reassembled data: %q", buffer.String())
+	}
+
+	if _, err := reassembler.Write(zerodatapack.NewStreamMessage(1, 3, 3, true, nil)); !errors.Is(err, zerodatapack.ErrStreamSequence) {
+		t.Fatalf("expected ErrStreamSequence after last chunk, got: %v", err)
+	}
+
+	if _, _, _, err := zerodatapack.ParseStream([]byte{0, 0}); !errors.Is(err, zerodatapack.ErrStreamInvalid) {
+		t.Fatalf("expected ErrStreamInvalid, got: %v", err)
+	}
+}
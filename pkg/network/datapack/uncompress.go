@@ -0,0 +1,74 @@
+package datapack
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+
+	zerocompress "github.com/zerogo-hub/zero-helper/compress"
+)
+
+var (
+	// ErrDecompressTooLarge 解压后的负载超出上限
+	ErrDecompressTooLarge = errors.New("decompressed payload too large")
+)
+
+// LimitUncompressor 支持限制解压后长度的解压器
+// 自定义的压缩与解压器实现该接口后，解包时会优先使用 UncompressLimit
+type LimitUncompressor interface {
+	// UncompressLimit 解压，解压后的长度超过 limit 时返回 ErrDecompressTooLarge
+	UncompressLimit(in []byte, limit int) ([]byte, error)
+}
+
+// uncompress 解压，解压后的长度不得超过 limit，limit <= 0 表示不限制
+// zlib、gzip、flate 使用流式解压，超出上限后立即终止，避免压缩炸弹耗尽内存
+// 其它解压器只能在解压完成之后检查长度
+func uncompress(compress zerocompress.Compress, in []byte, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return compress.Uncompress(in)
+	}
+
+	if l, ok := compress.(LimitUncompressor); ok {
+		return l.UncompressLimit(in, limit)
+	}
+
+	var reader io.ReadCloser
+	var err error
+
+	switch compress.Name() {
+	case "zlib":
+		reader, err = zlib.NewReader(bytes.NewReader(in))
+	case "gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(in))
+	case "flate":
+		reader = flate.NewReader(bytes.NewReader(in))
+	default:
+		out, err := compress.Uncompress(in)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > limit {
+			return nil, ErrDecompressTooLarge
+		}
+		return out, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// 多读取 1 个字节，用于判断是否超出上限
+	out, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrDecompressTooLarge
+	}
+
+	return out, nil
+}
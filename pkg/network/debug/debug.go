@@ -0,0 +1,180 @@
+// Package debug 提供 pprof 与 expvar 调试服务，生命周期可以与 Peer 绑定
+package debug
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// ShutdownTimeout 关闭调试服务时等待进行中请求的最长时间，超时后强制关闭
+// 比如 /debug/pprof/profile 默认采样 30 秒
+var ShutdownTimeout = 5 * time.Second
+
+// ErrServerStarted 调试服务已经启动
+var ErrServerStarted = errors.New("debug server already started")
+
+// Server pprof 与 expvar 调试服务
+// 使用独立的 ServeMux，不依赖 http.DefaultServeMux，路径如下:
+//
+//	/debug/pprof/
+//	/debug/vars
+type Server struct {
+	address string
+
+	server *http.Server
+
+	mutex sync.Mutex
+
+	// ln 监听器，启动后有效
+	ln net.Listener
+
+	// done 服务 goroutine 退出的信号
+	done chan struct{}
+}
+
+// NewServer 创建调试服务，address 比如 "localhost:6060"，需要调用 Start 开始监听
+func NewServer(address string) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return &Server{
+		address: address,
+		server:  &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+	}
+}
+
+// Start 开始监听并在新的 goroutine 中提供服务，不会阻塞
+func (s *Server) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ln != nil {
+		return ErrServerStarted
+	}
+
+	ln, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+
+	s.ln = ln
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		_ = s.server.Serve(ln)
+	}()
+
+	return nil
+}
+
+// Addr 监听地址，未启动时返回 nil
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ln == nil {
+		return nil
+	}
+
+	return s.ln.Addr()
+}
+
+// Close 关闭调试服务，最多等待 ShutdownTimeout，返回时服务 goroutine 已经退出
+// 未启动或者重复调用时直接返回
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.ln == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = s.server.Close()
+	}
+
+	<-s.done
+	s.ln = nil
+
+	return err
+}
+
+// Peer 绑定了调试服务的 Peer，Start 时启动调试服务，Close 或者 ListenSignal 返回时关闭调试服务
+type Peer struct {
+	zeronetwork.Peer
+
+	server *Server
+}
+
+// Wrap 为 peer 绑定监听 address 的调试服务，不影响 OnServerStart 与 OnServerClose
+// 使用方式:
+//
+//	p := debug.Wrap(zerotcp.NewServer().WithOption(...), "localhost:6060")
+//	if err := p.Start(); err != nil { ... }
+//	p.ListenSignal()
+func Wrap(peer zeronetwork.Peer, address string) *Peer {
+	return &Peer{
+		Peer:   peer,
+		server: NewServer(address),
+	}
+}
+
+// Start 先启动调试服务，再开启 peer，peer 开启失败时关闭调试服务
+func (p *Peer) Start() error {
+	if err := p.server.Start(); err != nil {
+		return err
+	}
+
+	if err := p.Peer.Start(); err != nil {
+		_ = p.server.Close()
+		return err
+	}
+
+	return nil
+}
+
+// Close 先关闭 peer，再关闭调试服务
+func (p *Peer) Close() error {
+	err := p.Peer.Close()
+
+	if serr := p.server.Close(); err == nil {
+		err = serr
+	}
+
+	return err
+}
+
+// ListenSignal 监听信号，peer 关闭后关闭调试服务
+func (p *Peer) ListenSignal() {
+	p.Peer.ListenSignal()
+	_ = p.server.Close()
+}
+
+// WithOption 设置配置，返回绑定了调试服务的 Peer
+func (p *Peer) WithOption(opts ...zeronetwork.Option) zeronetwork.Peer {
+	p.Peer.WithOption(opts...)
+	return p
+}
+
+// DebugServer 绑定的调试服务
+func (p *Peer) DebugServer() *Server {
+	return p.server
+}
@@ -0,0 +1,62 @@
+package debug_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	"github.com/zerogo-hub/zero-node/pkg/network/debug"
+	zerotcp "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp"
+)
+
+func TestWrap(t *testing.T) {
+	p := debug.Wrap(zerotcp.NewServer(), "127.0.0.1:0")
+	p.WithOption(zeronetwork.WithHost("127.0.0.1"), zeronetwork.WithPort(0))
+
+	if addr := p.DebugServer().Addr(); addr != nil {
+		t.Fatalf("addr before start: %s", addr)
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("start failed: %s", err.Error())
+	}
+
+	if err := p.DebugServer().Start(); err != debug.ErrServerStarted {
+		t.Errorf("start twice: %v", err)
+	}
+
+	addr := p.DebugServer().Addr().String()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("get %s failed: %s", path, err.Error())
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("get %s: %d", path, resp.StatusCode)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close failed: %s", err.Error())
+	}
+
+	if p.DebugServer().Addr() != nil {
+		t.Error("addr after close")
+	}
+
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("debug server still listening after close")
+	}
+
+	// 重复关闭
+	if err := p.DebugServer().Close(); err != nil {
+		t.Errorf("close twice: %s", err.Error())
+	}
+}
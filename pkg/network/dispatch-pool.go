@@ -0,0 +1,137 @@
+package network
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrDispatchPoolClosed 协程池已经关闭
+var ErrDispatchPoolClosed = errors.New("dispatch pool closed")
+
+// ShardFunc 为消息计算分片值，分片值相同的消息总是在同一个协程中按顺序处理
+type ShardFunc func(message Message) uint64
+
+// ShardBySessionID 按照会话 ID 分片，同一个会话的消息按顺序处理，不同会话的消息可以并发处理
+func ShardBySessionID(message Message) uint64 {
+	return message.SessionID()
+}
+
+// DispatchPool 多个会话共享的消息处理协程池，见 Config.DispatchPool
+// 每个协程处理固定分片的消息，同一个分片的消息严格按照提交的顺序处理，不同分片的消息并发处理
+// 可以按照会话之外的键分片，比如按照玩家实体，使不同连接中操作同一实体的消息串行执行
+// 协程池可以被多个服务共用，由创建者调用 Close 关闭
+type DispatchPool struct {
+	// shard 分片函数
+	shard ShardFunc
+
+	// tasks 每个协程的任务队列
+	tasks []chan func()
+
+	mutex sync.RWMutex
+
+	// closed 是否已经关闭
+	closed bool
+
+	// wg 等待所有协程退出
+	wg sync.WaitGroup
+}
+
+// dispatchResult 处理函数的返回结果
+type dispatchResult struct {
+	message Message
+	err     error
+	panic   interface{}
+}
+
+// NewDispatchPool 创建包含 workers 个协程的协程池，workers <= 0 时为 1，shard 为 nil 时使用 ShardBySessionID
+func NewDispatchPool(workers int, shard ShardFunc) *DispatchPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if shard == nil {
+		shard = ShardBySessionID
+	}
+
+	p := &DispatchPool{
+		shard: shard,
+		tasks: make([]chan func(), workers),
+	}
+
+	p.wg.Add(workers)
+	for i := range p.tasks {
+		p.tasks[i] = make(chan func())
+		go p.work(p.tasks[i])
+	}
+
+	return p
+}
+
+// Workers 协程数量
+func (p *DispatchPool) Workers() int {
+	return len(p.tasks)
+}
+
+// Worker message 所在分片对应的协程序号
+func (p *DispatchPool) Worker(message Message) int {
+	return int(p.shard(message) % uint64(len(p.tasks)))
+}
+
+// Handle 在 message 对应的协程中执行 handler，阻塞直到执行完毕并返回其结果
+// p 为 nil 时在当前协程中直接执行；handler 发生 panic 时在当前协程中重新 panic，由调用方恢复
+func (p *DispatchPool) Handle(message Message, handler HandlerFunc) (Message, error) {
+	if p == nil {
+		return handler(message)
+	}
+
+	done := make(chan dispatchResult, 1)
+	task := func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- dispatchResult{panic: r}
+			}
+		}()
+
+		responseMessage, err := handler(message)
+		done <- dispatchResult{message: responseMessage, err: err}
+	}
+
+	p.mutex.RLock()
+	if p.closed {
+		p.mutex.RUnlock()
+		return nil, ErrDispatchPoolClosed
+	}
+	p.tasks[p.Worker(message)] <- task
+	p.mutex.RUnlock()
+
+	result := <-done
+	if result.panic != nil {
+		panic(result.panic)
+	}
+
+	return result.message, result.err
+}
+
+// Close 关闭协程池，等待正在处理的消息处理完毕，之后提交的消息返回 ErrDispatchPoolClosed
+func (p *DispatchPool) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	for _, tasks := range p.tasks {
+		close(tasks)
+	}
+	p.mutex.Unlock()
+
+	p.wg.Wait()
+}
+
+// work 依次执行分配到该协程的任务
+func (p *DispatchPool) work(tasks chan func()) {
+	defer p.wg.Done()
+
+	for task := range tasks {
+		task()
+	}
+}
@@ -0,0 +1,119 @@
+package network_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+func newPoolMessage(sessionID zeronetwork.SessionID, sn uint16) zeronetwork.Message {
+	message := zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil)
+	message.SetSessionID(sessionID)
+	return message
+}
+
+func TestDispatchPool(t *testing.T) {
+	pool := zeronetwork.NewDispatchPool(4, nil)
+	defer pool.Close()
+
+	// 会话 1 的处理函数阻塞，直到会话 2 的处理函数开始执行，两个会话并发处理
+	started := make(chan struct{})
+	var mutex sync.Mutex
+	var order []uint16
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for sn := uint16(1); sn <= 100; sn++ {
+			_, _ = pool.Handle(newPoolMessage(1, sn), func(message zeronetwork.Message) (zeronetwork.Message, error) {
+				if message.SN() == 1 {
+					select {
+					case <-started:
+					case <-time.After(2 * time.Second):
+						t.Error("sessions are not handled concurrently")
+					}
+				}
+				mutex.Lock()
+				order = append(order, message.SN())
+				mutex.Unlock()
+				return nil, nil
+			})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = pool.Handle(newPoolMessage(2, 1), func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			close(started)
+			return nil, nil
+		})
+	}()
+	wg.Wait()
+
+	for i, sn := range order {
+		if sn != uint16(i+1) {
+			t.Fatalf("unexpected order at %d: %d", i, sn)
+		}
+	}
+
+	// 返回处理函数的结果
+	expected := errors.New("handler failed")
+	if _, err := pool.Handle(newPoolMessage(1, 1), func(zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, expected
+	}); err != expected {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// panic 在调用方重新抛出，协程池仍然可用
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("unexpected panic: %v", p)
+			}
+		}()
+		_, _ = pool.Handle(newPoolMessage(1, 1), func(zeronetwork.Message) (zeronetwork.Message, error) {
+			panic("boom")
+		})
+	}()
+	if _, err := pool.Handle(newPoolMessage(1, 1), func(zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("handle after panic failed: %s", err.Error())
+	}
+
+	pool.Close()
+	if _, err := pool.Handle(newPoolMessage(1, 1), func(zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}); !errors.Is(err, zeronetwork.ErrDispatchPoolClosed) {
+		t.Fatalf("unexpected err after close: %v", err)
+	}
+}
+
+func TestDispatchPoolShard(t *testing.T) {
+	// 按照负载中的实体 ID 分片，不同会话中同一实体的消息由同一个协程处理
+	pool := zeronetwork.NewDispatchPool(8, func(message zeronetwork.Message) uint64 {
+		return uint64(message.Payload()[0])
+	})
+	defer pool.Close()
+
+	for entity := byte(0); entity < 16; entity++ {
+		a := zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte{entity})
+		a.SetSessionID(1)
+		b := zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte{entity})
+		b.SetSessionID(2)
+		if pool.Worker(a) != pool.Worker(b) {
+			t.Fatalf("entity %d dispatched to different workers", entity)
+		}
+	}
+
+	var nilPool *zeronetwork.DispatchPool
+	if _, err := nilPool.Handle(newPoolMessage(1, 1), func(zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("nil pool handle failed: %s", err.Error())
+	}
+}
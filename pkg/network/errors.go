@@ -0,0 +1,80 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// 各个 peer(tcp、ws、kcp) 共用的错误，peer 中的同名错误是这里的别名
+// 可以使用 errors.Is 判断，与产生错误的 peer 无关
+var (
+	// ErrWriteNotAll 未能将信息全部写入
+	ErrWriteNotAll = errors.New("write not all")
+
+	// ErrStopSend 已关闭，不再收发消息
+	ErrStopSend = errors.New("stop send message")
+
+	// ErrWriteTimeout 写入超时，放入发送队列超时 3 秒，或者写入套接字超过 SendDeadline
+	ErrWriteTimeout = errors.New("write timeout")
+
+	// ErrPrivateKeyEmpty 秘钥协商时私钥为空，可能尚未发起秘钥协商请求
+	ErrPrivateKeyEmpty = errors.New("private key is empty, exchange key request may not be sent")
+
+	// ErrRandomValueEmpty 秘钥协商时随机值为空，可能尚未发起秘钥协商请求
+	ErrRandomValueEmpty = errors.New("random value is empty, exchange key request may not be sent")
+
+	// ErrMaxConnNum 超过连接数量上限，拒绝连接
+	ErrMaxConnNum = errors.New("max conn num")
+
+	// ErrMaxConnPerIP 超过同一个 IP 的连接数量上限，拒绝连接
+	ErrMaxConnPerIP = errors.New("max conn per ip")
+
+	// ErrRedirectInvalid 重定向通知中的地址无效
+	ErrRedirectInvalid = errors.New("invalid redirect address")
+
+	// ErrDatapackNotSwitchable 未开启 Config.DatapackSwitchable 时不能切换封包工具
+	ErrDatapackNotSwitchable = errors.New("datapack is not switchable")
+
+	// ErrDatapackSwitched 封包工具只能切换一次
+	ErrDatapackSwitched = errors.New("datapack already switched")
+
+	// ErrHeartBeatInvalid 心跳响应的负载无效
+	ErrHeartBeatInvalid = errors.New("invalid heartbeat")
+
+	// ErrHandlerTimeout 处理函数超过 Config.HandlerTimeout 仍未返回
+	ErrHandlerTimeout = errors.New("handler timeout")
+
+	// ErrAcceptRejected Config.OnAccept 拒绝了连接
+	ErrAcceptRejected = errors.New("rejected by accept filter")
+
+	// ErrShutdownInvalid 关闭通知的负载无效
+	ErrShutdownInvalid = errors.New("invalid shutdown notice")
+
+	// ErrAckInvalid 确认消息的负载无效
+	ErrAckInvalid = errors.New("invalid ack")
+
+	// ErrReadOnlySession 会话为 SessionModeReadOnly，不能发送消息
+	ErrReadOnlySession = errors.New("session is read only")
+
+	// ErrSessionRunning 会话的收发循环已经开始，比如 Session.SetMode 只能在 Config.OnHandshake 中调用
+	ErrSessionRunning = errors.New("session is running")
+
+	// ErrZeroActionNotAllowed 收到的特殊协议不应由这一端处理，比如服务端收到仅发给客户端的恢复结果
+	ErrZeroActionNotAllowed = errors.New("zero action not allowed on this side")
+)
+
+// WrapWriteError 写入套接字超时时，使用 ErrWriteTimeout 包装原始错误，其它错误原样返回
+func WrapWriteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrWriteTimeout, err)
+	}
+
+	return err
+}
@@ -0,0 +1,39 @@
+package network_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerokcp "github.com/zerogo-hub/zero-node/pkg/network/peer/kcp"
+	zerotcp "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp"
+	zerows "github.com/zerogo-hub/zero-node/pkg/network/peer/ws"
+)
+
+func TestPeerErrors(t *testing.T) {
+	peers := map[string][]error{
+		"tcp": {zerotcp.ErrWriteTimeout, zerotcp.ErrStopSend, zerotcp.ErrWriteNotAll},
+		"ws":  {zerows.ErrWriteTimeout, zerows.ErrStopSend, zerows.ErrWriteNotAll},
+		"kcp": {zerokcp.ErrWriteTimeout, zerokcp.ErrStopSend, zerokcp.ErrWriteNotAll},
+	}
+
+	for name, errs := range peers {
+		for i, target := range []error{zeronetwork.ErrWriteTimeout, zeronetwork.ErrStopSend, zeronetwork.ErrWriteNotAll} {
+			if !errors.Is(errs[i], target) {
+				t.Fatalf("%s: %v is not %v", name, errs[i], target)
+			}
+		}
+	}
+}
+
+func TestWrapWriteError(t *testing.T) {
+	err := zeronetwork.WrapWriteError(os.ErrDeadlineExceeded)
+	if !errors.Is(err, zeronetwork.ErrWriteTimeout) || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if err := zeronetwork.WrapWriteError(os.ErrClosed); err != os.ErrClosed {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
@@ -5,6 +5,9 @@ const (
 	// FlagCompress 负载 payload 被压缩
 	FlagCompress = uint16(0x0001)
 
+	// FlagAck 消息需要对方处理完毕之后回复确认，见 Session.SendReliable
+	FlagAck = uint16(0x0002)
+
 	// FlagEncrypt 负载 payload 被加密
 	FlagEncrypt = uint16(0x0010)
 
@@ -24,4 +27,41 @@ const (
 
 	// FlagZeroHeartBeat 心跳包
 	FlagZeroHeartBeat = uint8(3)
+
+	// FlagZeroResumeToken 服务端下发恢复令牌
+	FlagZeroResumeToken = uint8(4)
+
+	// FlagZeroResumeRequest 客户端重连后携带恢复令牌请求恢复会话
+	FlagZeroResumeRequest = uint8(5)
+
+	// FlagZeroResumeResponse 恢复会话的结果，成功时负载为原会话 ID
+	FlagZeroResumeResponse = uint8(6)
+
+	// FlagZeroRedirect 服务端通知客户端连接到新的地址，负载为 host:port，发送之后服务端关闭连接
+	FlagZeroRedirect = uint8(7)
+
+	// FlagZeroHeartBeatResponse 心跳响应，原样返回心跳包的负载，用于测量往返时间
+	FlagZeroHeartBeatResponse = uint8(8)
+
+	// FlagZeroShutdown 服务端即将关闭，负载为建议的重连间隔与关闭原因，发送之后服务端关闭连接
+	FlagZeroShutdown = uint8(9)
+
+	// FlagZeroAck 确认已经处理完毕带有 FlagAck 标记的消息，负载为该消息的 SN
+	FlagZeroAck = uint8(10)
 )
+
+// RotatesCrypto 是否为会改变秘钥的特殊协议消息，比如秘钥交换、恢复会话
+// 秘钥只在消息边界上切换：接收方需要等该消息处理完毕，再使用新的秘钥解包之后的消息
+// 这些消息本身不加密，是切换秘钥之前的最后一个明文消息
+func RotatesCrypto(message Message) bool {
+	if message.Flag()&FlagZero == 0 {
+		return false
+	}
+
+	switch message.ActionID() {
+	case FlagZeroExchangeKeyRequest, FlagZeroExchangeKeyResponse, FlagZeroResumeRequest:
+		return true
+	}
+
+	return false
+}
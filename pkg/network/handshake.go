@@ -0,0 +1,129 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrHandshakeNotReady 秘钥协商尚未完成，拒绝处理业务消息
+	ErrHandshakeNotReady = errors.New("handshake not ready")
+
+	// ErrHandshakeTimeout 超过 Config.HandshakeTimeout 仍未完成秘钥协商，关闭连接
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+)
+
+// HandshakeState 会话的秘钥协商状态
+// HandshakeInit -> HandshakeKeyExchanged -> HandshakeReady
+type HandshakeState int32
+
+const (
+	// HandshakeInit 尚未进行秘钥协商
+	HandshakeInit HandshakeState = iota
+
+	// HandshakeKeyExchanged 已生成秘钥，服务端尚未将协商结果发送给客户端
+	HandshakeKeyExchanged
+
+	// HandshakeReady 秘钥协商完成，可以处理业务消息
+	HandshakeReady
+)
+
+// WatchHandshake 开启加密并且设置了 Config.HandshakeTimeout 时，为服务端新建的会话开始计时
+// 超时仍未完成秘钥协商时，使用 ErrHandshakeTimeout 调用 onTimeout，之后关闭会话；会话提前关闭时停止计时
+func WatchHandshake(session Session, onTimeout func(reason error)) {
+	config := session.Config()
+	if !config.WhetherCrypto || config.HandshakeTimeout <= 0 {
+		return
+	}
+
+	timer := time.AfterFunc(config.HandshakeTimeout, func() {
+		if session.HandshakeState() == HandshakeReady {
+			return
+		}
+
+		if onTimeout != nil {
+			onTimeout(ErrHandshakeTimeout)
+		}
+		session.Close()
+	})
+	session.OnClose(func() {
+		timer.Stop()
+	})
+}
+
+// KeyExchangeSlots 限制同时进行的秘钥协商计算数量，用于 Config.MaxKeyExchanges，零值可以直接使用
+type KeyExchangeSlots struct {
+	mutex sync.Mutex
+
+	// active 正在进行的秘钥协商数量
+	active int
+
+	// released 有名额归还时关闭，唤醒所有等待者
+	released chan struct{}
+}
+
+// Acquire 占用一个名额，正在进行的数量达到 max 时等待其它协商完成，超过 timeout 仍未占用返回 false
+// max <= 0 表示不限制，此时仍然计数；timeout <= 0 表示一直等待。占用成功之后，协商计算完成时需要调用 Release
+func (k *KeyExchangeSlots) Acquire(max int, timeout time.Duration) bool {
+	var deadline <-chan time.Time
+
+	for {
+		k.mutex.Lock()
+		if max <= 0 || k.active < max {
+			k.active++
+			k.mutex.Unlock()
+			return true
+		}
+		if k.released == nil {
+			k.released = make(chan struct{})
+		}
+		released := k.released
+		k.mutex.Unlock()
+
+		if deadline == nil && timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case <-released:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// Release 归还名额
+func (k *KeyExchangeSlots) Release() {
+	k.mutex.Lock()
+	k.active--
+	if k.released != nil {
+		close(k.released)
+		k.released = nil
+	}
+	k.mutex.Unlock()
+}
+
+// Active 正在进行的秘钥协商数量
+func (k *KeyExchangeSlots) Active() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	return k.active
+}
+
+// String 打印状态
+func (h HandshakeState) String() string {
+	switch h {
+	case HandshakeInit:
+		return "init"
+	case HandshakeKeyExchanged:
+		return "key exchanged"
+	case HandshakeReady:
+		return "ready"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,63 @@
+package network_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestKeyExchangeSlots(t *testing.T) {
+	var slots zeronetwork.KeyExchangeSlots
+	var active, peak int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !slots.Acquire(3, 0) {
+				t.Error("acquire failed")
+				return
+			}
+			defer slots.Release()
+
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&active, -1)
+		}()
+	}
+	wg.Wait()
+
+	if peak > 3 {
+		t.Fatalf("concurrency exceeded: %d", peak)
+	}
+	if slots.Active() != 0 {
+		t.Fatalf("unexpected active: %d", slots.Active())
+	}
+
+	// 名额已满时等待超时
+	if !slots.Acquire(1, 0) {
+		t.Fatal("acquire failed")
+	}
+	start := time.Now()
+	if slots.Acquire(1, 50*time.Millisecond) {
+		t.Fatal("expected acquire timeout")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("returned too early: %s", elapsed)
+	}
+
+	// 不限制时仍然计数
+	if !slots.Acquire(0, 0) || slots.Active() != 2 {
+		t.Fatalf("unexpected active: %d", slots.Active())
+	}
+}
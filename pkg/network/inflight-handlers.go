@@ -0,0 +1,76 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// InflightHandlers 记录会话中正在执行的处理函数，用于 Config.CloseGracePeriod
+// 关闭会话时，先于 Close 开始的处理函数仍然可以发送响应，之后开始的处理函数的响应会被丢弃，零值可以直接使用
+type InflightHandlers struct {
+	mutex sync.Mutex
+
+	// count 先于 Stop 开始，尚未结束的处理函数数量
+	count int
+
+	// stopped 是否已经调用 Stop
+	stopped bool
+
+	// idle 调用 Stop 之后，所有处理函数结束时关闭
+	idle chan struct{}
+}
+
+// Begin 处理函数开始之前调用，返回 true 表示先于 Stop 开始，结束时需要调用 End
+func (h *InflightHandlers) Begin() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.stopped {
+		return false
+	}
+
+	h.count++
+	return true
+}
+
+// End 先于 Stop 开始的处理函数的响应放入发送队列之后调用
+func (h *InflightHandlers) End() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.count--
+	if h.count == 0 && h.idle != nil {
+		close(h.idle)
+		h.idle = nil
+	}
+}
+
+// Stop 之后开始的处理函数不再计入，等待已经开始的处理函数结束，最多等待 timeout
+// 全部结束时返回 true，timeout <= 0 时不等待
+func (h *InflightHandlers) Stop(timeout time.Duration) bool {
+	h.mutex.Lock()
+	h.stopped = true
+	if h.count == 0 {
+		h.mutex.Unlock()
+		return true
+	}
+	if timeout <= 0 {
+		h.mutex.Unlock()
+		return false
+	}
+	if h.idle == nil {
+		h.idle = make(chan struct{})
+	}
+	idle := h.idle
+	h.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// IPConnCounter 按远端 IP 统计活跃连接数量，用于 Config.MaxConnPerIP
+type IPConnCounter struct {
+	mutex sync.Mutex
+
+	// counts IP 对应的活跃连接数量
+	counts map[string]int
+}
+
+// NewIPConnCounter 创建一个连接计数器
+func NewIPConnCounter() *IPConnCounter {
+	return &IPConnCounter{counts: make(map[string]int)}
+}
+
+// Acquire 为远端地址对应的 IP 占用一个连接名额，已达到 max 时返回 false
+// max <= 0 表示不限制，此时仍然计数。占用成功的连接关闭时需要调用 Release
+func (c *IPConnCounter) Acquire(remoteAddress string, max int) bool {
+	ip := ConnIP(remoteAddress)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if max > 0 && c.counts[ip] >= max {
+		return false
+	}
+
+	c.counts[ip]++
+
+	return true
+}
+
+// Release 归还远端地址对应的 IP 占用的连接名额
+func (c *IPConnCounter) Release(remoteAddress string) {
+	ip := ConnIP(remoteAddress)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.counts[ip] <= 1 {
+		delete(c.counts, ip)
+		return
+	}
+
+	c.counts[ip]--
+}
+
+// Count IP 当前的活跃连接数量
+func (c *IPConnCounter) Count(ip string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.counts[ConnIP(ip)]
+}
+
+// ConnIP 从远端地址中取出 IP 并规范化
+// 去掉端口与 IPv6 的 zone，IPv4-mapped IPv6 地址(::ffff:127.0.0.1)转换为 IPv4
+func ConnIP(remoteAddress string) string {
+	host, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		// 不包含端口
+		host = remoteAddress
+	}
+
+	// IPv6 zone，如 fe80::1%eth0
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,44 @@
+package network_test
+
+import (
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestConnIP(t *testing.T) {
+	cases := map[string]string{
+		"127.0.0.1:8001":          "127.0.0.1",
+		"[::ffff:127.0.0.1]:8001": "127.0.0.1",
+		"[::1]:8001":              "::1",
+		"[fe80::1%eth0]:8001":     "fe80::1",
+		"[2001:DB8::1]:8001":      "2001:db8::1",
+		"10.0.0.1":                "10.0.0.1",
+	}
+
+	for address, ip := range cases {
+		if got := zeronetwork.ConnIP(address); got != ip {
+			t.Fatalf("address: %s, unexpected ip: %s", address, got)
+		}
+	}
+}
+
+func TestIPConnCounter(t *testing.T) {
+	counter := zeronetwork.NewIPConnCounter()
+
+	if !counter.Acquire("127.0.0.1:1", 2) || !counter.Acquire("[::ffff:127.0.0.1]:2", 2) {
+		t.Fatal("acquire failed")
+	}
+	// IPv4 与 IPv4-mapped IPv6 是同一个 IP
+	if counter.Acquire("127.0.0.1:3", 2) {
+		t.Fatal("acquire over max")
+	}
+	if !counter.Acquire("127.0.0.2:1", 2) {
+		t.Fatal("other ip affected")
+	}
+
+	counter.Release("127.0.0.1:1")
+	if counter.Count("127.0.0.1") != 1 || !counter.Acquire("127.0.0.1:3", 2) {
+		t.Fatal("release failed")
+	}
+}
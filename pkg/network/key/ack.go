@@ -0,0 +1,29 @@
+package key
+
+import (
+	"encoding/binary"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// Ack 创建确认消息，确认 SN 为 sn、带有 FlagAck 标记的消息已经处理完毕
+func Ack(sn uint16) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroAck
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, sn)
+
+	return zerodatapack.NewLTDMessage(flag, 0, code, module, action, payload)
+}
+
+// ParseAck 解析确认消息的负载，返回被确认消息的 SN
+func ParseAck(payload []byte) (uint16, error) {
+	if len(payload) != 2 {
+		return 0, zeronetwork.ErrAckInvalid
+	}
+
+	return binary.BigEndian.Uint16(payload), nil
+}
@@ -0,0 +1,41 @@
+package key
+
+import (
+	"encoding/binary"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// HeartBeat 创建心跳请求，负载为发送时间，由发送方定义，对方原样返回
+func HeartBeat(timestamp int64) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	sn := uint16(0)
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroHeartBeat
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(timestamp))
+
+	return zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
+}
+
+// HeartBeatResponse 创建心跳响应，原样返回心跳请求的负载
+func HeartBeatResponse(payload []byte) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	sn := uint16(0)
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroHeartBeatResponse
+
+	return zerodatapack.NewLTDMessage(flag, sn, code, module, action, append([]byte{}, payload...))
+}
+
+// ParseHeartBeat 解析心跳响应的负载，返回心跳请求的发送时间
+func ParseHeartBeat(payload []byte) (int64, error) {
+	if len(payload) != 8 {
+		return 0, zeronetwork.ErrHeartBeatInvalid
+	}
+
+	return int64(binary.BigEndian.Uint64(payload)), nil
+}
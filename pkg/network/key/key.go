@@ -5,7 +5,6 @@ import (
 	"errors"
 
 	zerojson "github.com/zerogo-hub/zero-helper/json"
-	zerorandom "github.com/zerogo-hub/zero-helper/random"
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
 	zeroecdh "github.com/zerogo-hub/zero-node/pkg/security/ecdh"
@@ -14,14 +13,21 @@ import (
 // ExchangeKeyRequest 创建秘钥协商，请求
 // return: 私钥，随机值，请求消息
 func ExchangeKeyRequest() ([]byte, []byte, zeronetwork.Message) {
+	return ExchangeKeyRequestWithCompress(false)
+}
+
+// ExchangeKeyRequestWithCompress 创建秘钥协商，请求中声明是否支持压缩，见 Config.CompressNegotiation
+// return: 私钥，随机值，请求消息
+func ExchangeKeyRequestWithCompress(compress bool) ([]byte, []byte, zeronetwork.Message) {
 	// 1. 生成公钥，私钥，随机数
 	publicKey, privateKey := zeroecdh.GenerateKeys()
-	randomValue := zerorandom.Bytes(32)
+	randomValue := zeroecdh.GenerateRandomValue(32)
 
 	// 2. 创建协商协议
 	request := &zeroecdh.ExchangeRequest{
 		PublicKey: hex.EncodeToString(publicKey),
 		R:         hex.EncodeToString(randomValue),
+		Compress:  compress,
 	}
 	payload, _ := zerojson.Marshal(request)
 
@@ -38,20 +44,29 @@ func ExchangeKeyRequest() ([]byte, []byte, zeronetwork.Message) {
 // ExchangeKeyResponse 响应秘钥协商
 // return: 服务端最终秘钥，响应消息，错误
 func ExchangeKeyResponse(requestBytes []byte) ([]byte, zeronetwork.Message, error) {
+	key, message, _, err := ExchangeKeyResponseWithCompress(requestBytes, false)
+	return key, message, err
+}
+
+// ExchangeKeyResponseWithCompress 响应秘钥协商，compress 为服务端是否支持压缩
+// 双方都支持时该会话压缩，结果写入响应告知客户端，见 Config.CompressNegotiation
+// return: 服务端最终秘钥，响应消息，该会话是否压缩，错误
+func ExchangeKeyResponseWithCompress(requestBytes []byte, compress bool) ([]byte, zeronetwork.Message, bool, error) {
 	// 1. 解析请求
 	if len(requestBytes) == 0 {
-		return nil, nil, errors.New("requestBytes is empty")
+		return nil, nil, false, errors.New("requestBytes is empty")
 	}
 	var request zeroecdh.ExchangeRequest
 	if err := zerojson.Unmarshal(requestBytes, &request); err != nil {
-		return nil, nil, err
+		return nil, nil, false, err
 	}
+	compress = compress && request.Compress
 	peerClientPublicKey, _ := hex.DecodeString(request.PublicKey)
 	peerClientRandomValue, _ := hex.DecodeString(request.R)
 
 	// 2. 生成公钥，私钥，随机数
 	publicKey, privateKey := zeroecdh.GenerateKeys()
-	randomValue := zerorandom.Bytes(32)
+	randomValue := zeroecdh.GenerateRandomValue(32)
 
 	// 3. 生成共享秘钥
 	serverSharedKey, _ := zeroecdh.GenerateShareKey(privateKey, peerClientPublicKey)
@@ -63,6 +78,7 @@ func ExchangeKeyResponse(requestBytes []byte) ([]byte, zeronetwork.Message, erro
 	response := &zeroecdh.ExchageResponse{
 		PublicKey: hex.EncodeToString(publicKey),
 		R:         hex.EncodeToString(randomValue),
+		Compress:  compress,
 	}
 
 	payload, _ := zerojson.Marshal(response)
@@ -74,19 +90,26 @@ func ExchangeKeyResponse(requestBytes []byte) ([]byte, zeronetwork.Message, erro
 	action := zeronetwork.FlagZeroExchangeKeyResponse
 	message := zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
 
-	return key, message, nil
+	return key, message, compress, nil
 }
 
 // ExchangeKeyParseResponse 解析秘钥协商的响应
 // return 客户端最终秘钥，错误
 func ExchangeKeyParseResponse(responseBytes, privateKey, randomValue []byte) ([]byte, error) {
+	key, _, err := ExchangeKeyParseResponseWithCompress(responseBytes, privateKey, randomValue)
+	return key, err
+}
+
+// ExchangeKeyParseResponseWithCompress 解析秘钥协商的响应，以及服务端决定该会话是否压缩
+// return 客户端最终秘钥，该会话是否压缩，错误
+func ExchangeKeyParseResponseWithCompress(responseBytes, privateKey, randomValue []byte) ([]byte, bool, error) {
 	// 1. 解析响应
 	if len(responseBytes) == 0 {
-		return nil, errors.New("responseBytes is empty")
+		return nil, false, errors.New("responseBytes is empty")
 	}
 	var response zeroecdh.ExchageResponse
 	if err := zerojson.Unmarshal(responseBytes, &response); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	peerServerPublicKey, _ := hex.DecodeString(response.PublicKey)
 	peerServerRandomValue, _ := hex.DecodeString(response.R)
@@ -97,5 +120,5 @@ func ExchangeKeyParseResponse(responseBytes, privateKey, randomValue []byte) ([]
 	// 3. 生成最终需要的秘钥
 	key := zeroecdh.BuildKey(clientSharedKey, peerServerRandomValue, randomValue)
 
-	return key, nil
+	return key, response.Compress, nil
 }
@@ -0,0 +1,36 @@
+package key
+
+import (
+	"net"
+	"strconv"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// Redirect 创建重定向通知，通知客户端连接到新的地址，负载格式为 host:port
+func Redirect(host string, port int) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	sn := uint16(0)
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroRedirect
+	payload := []byte(net.JoinHostPort(host, strconv.Itoa(port)))
+
+	return zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
+}
+
+// ParseRedirect 解析重定向通知的负载，返回新的地址
+func ParseRedirect(payload []byte) (string, int, error) {
+	host, p, err := net.SplitHostPort(string(payload))
+	if err != nil {
+		return "", 0, zeronetwork.ErrRedirectInvalid
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, zeronetwork.ErrRedirectInvalid
+	}
+
+	return host, port, nil
+}
@@ -0,0 +1,18 @@
+package key
+
+import (
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// ResumeRequest 创建恢复会话请求，token 为断线前服务端下发的恢复令牌
+// 开启加密时，客户端需要使用断线前的秘钥重新 SetCrypto，并在收到响应之后再发送业务消息
+func ResumeRequest(token string) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	sn := uint16(0)
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroResumeRequest
+
+	return zerodatapack.NewLTDMessage(flag, sn, code, module, action, []byte(token))
+}
@@ -0,0 +1,34 @@
+package key
+
+import (
+	"encoding/binary"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// Shutdown 创建关闭通知，负载为建议的重连间隔(毫秒，8 字节) + 关闭原因
+func Shutdown(reason string, retryAfter time.Duration) zeronetwork.Message {
+	flag := zeronetwork.FlagZero
+	sn := uint16(0)
+	code := uint16(0)
+	module := uint8(0)
+	action := zeronetwork.FlagZeroShutdown
+	payload := make([]byte, 8+len(reason))
+	binary.BigEndian.PutUint64(payload, uint64(retryAfter.Milliseconds()))
+	copy(payload[8:], reason)
+
+	return zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
+}
+
+// ParseShutdown 解析关闭通知的负载，返回关闭原因与建议的重连间隔
+func ParseShutdown(payload []byte) (string, time.Duration, error) {
+	if len(payload) < 8 {
+		return "", 0, zeronetwork.ErrShutdownInvalid
+	}
+
+	retryAfter := time.Duration(binary.BigEndian.Uint64(payload)) * time.Millisecond
+
+	return string(payload[8:]), retryAfter, nil
+}
@@ -0,0 +1,87 @@
+package network_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// formatVerb 匹配格式化动词，%% 不占用参数
+var formatVerb = regexp.MustCompile(`%[-+# 0]*(\*|[0-9]+)?(\.(\*|[0-9]+)?)?[a-zA-Z%]`)
+
+// printfMethods 以格式字符串作为第一个参数的日志方法
+var printfMethods = map[string]bool{
+	"Debugf": true, "Infof": true, "Warnf": true, "Errorf": true, "Fatalf": true,
+}
+
+// printMethods 不接受格式字符串的日志方法
+var printMethods = map[string]bool{
+	"Debug": true, "Info": true, "Warn": true, "Error": true, "Fatal": true,
+}
+
+// TestLogFormat 检查日志格式字符串中的动词数量与参数数量一致
+// 日志接口的方法不会被 go vet 的 printf 检查覆盖，所以在这里检查
+func TestLogFormat(t *testing.T) {
+	fset := token.NewFileSet()
+
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		ast.Inspect(file, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 || call.Ellipsis.IsValid() {
+				return true
+			}
+			selector, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			literal, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				return true
+			}
+			format, err := strconv.Unquote(literal.Value)
+			if err != nil {
+				return true
+			}
+
+			verbs := 0
+			for _, verb := range formatVerb.FindAllString(format, -1) {
+				if verb != "%%" {
+					verbs++
+				}
+			}
+
+			name := selector.Sel.Name
+			switch {
+			case printfMethods[name] && verbs != len(call.Args)-1:
+				t.Errorf("%s: %s has %d verbs but %d args", fset.Position(call.Pos()), name, verbs, len(call.Args)-1)
+			case printMethods[name] && verbs > 0:
+				t.Errorf("%s: %s called with format string, use %sf", fset.Position(call.Pos()), name, name)
+			}
+
+			return true
+		})
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk failed: %s", err.Error())
+	}
+}
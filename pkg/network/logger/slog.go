@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+)
+
+// LevelFatal slog 中没有 fatal 级别，使用比 error 更高的级别表示
+const LevelFatal = slog.LevelError + 4
+
+// slogLogger 使用 log/slog 输出日志，实现 zerologger.Logger 接口
+// 可以通过 zeronetwork.WithLogger 传入
+type slogLogger struct {
+	// logger 实际输出日志
+	logger *slog.Logger
+
+	// level 日志级别，见 zerologger.DEBUG 等
+	level int32
+
+	// able 是否开启日志
+	able atomic.Bool
+}
+
+// NewSlog 将 *slog.Logger 包装为 zerologger.Logger
+// logger 为 nil 时使用 slog.Default()
+func NewSlog(logger *slog.Logger) zerologger.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	l := &slogLogger{logger: logger, level: zerologger.DEBUG}
+	l.able.Store(true)
+
+	return l
+}
+
+// Debug ..
+func (l *slogLogger) Debug(v ...interface{}) {
+	l.log(zerologger.DEBUG, fmt.Sprint(v...))
+}
+
+// Debugf ..
+func (l *slogLogger) Debugf(format string, v ...interface{}) {
+	l.log(zerologger.DEBUG, fmt.Sprintf(format, v...))
+}
+
+// Info ..
+func (l *slogLogger) Info(v ...interface{}) {
+	l.log(zerologger.INFO, fmt.Sprint(v...))
+}
+
+// Infof ..
+func (l *slogLogger) Infof(format string, v ...interface{}) {
+	l.log(zerologger.INFO, fmt.Sprintf(format, v...))
+}
+
+// Warn ..
+func (l *slogLogger) Warn(v ...interface{}) {
+	l.log(zerologger.WARN, fmt.Sprint(v...))
+}
+
+// Warnf ..
+func (l *slogLogger) Warnf(format string, v ...interface{}) {
+	l.log(zerologger.WARN, fmt.Sprintf(format, v...))
+}
+
+// Error ..
+func (l *slogLogger) Error(v ...interface{}) {
+	l.log(zerologger.ERROR, fmt.Sprint(v...))
+}
+
+// Errorf ..
+func (l *slogLogger) Errorf(format string, v ...interface{}) {
+	l.log(zerologger.ERROR, fmt.Sprintf(format, v...))
+}
+
+// Fatal 最终调用 panic
+func (l *slogLogger) Fatal(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	l.log(zerologger.FATAL, message)
+	panic(message)
+}
+
+// Fatalf 最终调用 panic
+func (l *slogLogger) Fatalf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	l.log(zerologger.FATAL, message)
+	panic(message)
+}
+
+// SetPath 日志输出位置由 slog.Handler 决定，这里不做处理
+func (l *slogLogger) SetPath(path string) {}
+
+// SetLevel 设置日志响应级别
+func (l *slogLogger) SetLevel(level int) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// SetEnable 设置日志是否开启
+func (l *slogLogger) SetEnable(able bool) {
+	l.able.Store(able)
+}
+
+// SetConsoleEnable 日志输出位置由 slog.Handler 决定，这里不做处理
+func (l *slogLogger) SetConsoleEnable(able bool) {}
+
+// IsDebugAble ..
+func (l *slogLogger) IsDebugAble() bool {
+	return l.enabled(zerologger.DEBUG)
+}
+
+// IsInfoAble ..
+func (l *slogLogger) IsInfoAble() bool {
+	return l.enabled(zerologger.INFO)
+}
+
+// IsWarnAble ..
+func (l *slogLogger) IsWarnAble() bool {
+	return l.enabled(zerologger.WARN)
+}
+
+// enabled 该级别的日志是否会输出
+func (l *slogLogger) enabled(level int) bool {
+	if !l.able.Load() || level < int(atomic.LoadInt32(&l.level)) {
+		return false
+	}
+
+	return l.logger.Enabled(context.Background(), toSlogLevel(level))
+}
+
+func (l *slogLogger) log(level int, message string) {
+	if !l.enabled(level) {
+		return
+	}
+
+	// 跳过 runtime.Callers、log 以及 Infof 等，定位到实际调用处
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	record := slog.NewRecord(time.Now(), toSlogLevel(level), message, pcs[0])
+	_ = l.logger.Handler().Handle(context.Background(), record)
+}
+
+// toSlogLevel 将 zerologger 中的日志级别转为 slog 中的日志级别
+func toSlogLevel(level int) slog.Level {
+	switch level {
+	case zerologger.DEBUG:
+		return slog.LevelDebug
+	case zerologger.INFO:
+		return slog.LevelInfo
+	case zerologger.WARN:
+		return slog.LevelWarn
+	case zerologger.ERROR:
+		return slog.LevelError
+	}
+
+	return LevelFatal
+}
@@ -0,0 +1,102 @@
+package logger_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetworklogger "github.com/zerogo-hub/zero-node/pkg/network/logger"
+)
+
+// recordHandler 记录收到的日志
+type recordHandler struct {
+	level   slog.Level
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordHandler) Handle(_ context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *recordHandler) WithGroup(name string) slog.Handler { return h }
+
+func TestSlogLevels(t *testing.T) {
+	handler := &recordHandler{level: slog.LevelDebug}
+	logger := zeronetworklogger.NewSlog(slog.New(handler))
+
+	logger.Debugf("session: %d", 1)
+	logger.Infof("session: %d", 2)
+	logger.Warnf("session: %d", 3)
+	logger.Errorf("session: %d", 4)
+
+	expected := []struct {
+		level   slog.Level
+		message string
+	}{
+		{slog.LevelDebug, "session: 1"},
+		{slog.LevelInfo, "session: 2"},
+		{slog.LevelWarn, "session: 3"},
+		{slog.LevelError, "session: 4"},
+	}
+
+	if len(handler.records) != len(expected) {
+		t.Fatalf("unexpected records: %d", len(handler.records))
+	}
+
+	for i, e := range expected {
+		record := handler.records[i]
+		if record.Level != e.level || record.Message != e.message {
+			t.Errorf("unexpected record, level: %s, message: %s", record.Level, record.Message)
+		}
+	}
+}
+
+func TestSlogSetLevel(t *testing.T) {
+	handler := &recordHandler{level: slog.LevelDebug}
+	logger := zeronetworklogger.NewSlog(slog.New(handler))
+
+	if !logger.IsDebugAble() {
+		t.Fatal("debug should be able")
+	}
+
+	logger.SetLevel(zerologger.WARN)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+
+	if logger.IsDebugAble() || logger.IsInfoAble() || !logger.IsWarnAble() {
+		t.Fatal("unexpected able level")
+	}
+
+	if len(handler.records) != 1 || handler.records[0].Message != "warn" {
+		t.Fatalf("unexpected records: %d", len(handler.records))
+	}
+
+	logger.SetEnable(false)
+	logger.Error("error")
+	if len(handler.records) != 1 {
+		t.Fatal("logger should be disabled")
+	}
+}
+
+func TestSlogHandlerLevel(t *testing.T) {
+	handler := &recordHandler{level: slog.LevelInfo}
+	logger := zeronetworklogger.NewSlog(slog.New(handler))
+
+	if logger.IsDebugAble() {
+		t.Fatal("debug should not be able")
+	}
+
+	logger.Debug("debug")
+	if len(handler.records) != 0 {
+		t.Fatal("debug should not reach handler")
+	}
+}
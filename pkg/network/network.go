@@ -1,10 +1,13 @@
 package network
 
 import (
+	"context"
+	"io"
 	"net"
 	"time"
 
 	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
+	zerocodec "github.com/zerogo-hub/zero-helper/codec"
 	zerocompress "github.com/zerogo-hub/zero-helper/compress"
 	zerologger "github.com/zerogo-hub/zero-helper/logger"
 )
@@ -15,12 +18,53 @@ type SessionID = uint64
 // ConnFunc 与客户端连接相关的响应函数
 type ConnFunc func(session Session)
 
+// HandshakeFunc 连接开始收发消息之前的预热函数，返回错误时关闭连接
+type HandshakeFunc func(session Session) error
+
 // SendCallbackFunc 发送消息的回调函数
 type SendCallbackFunc func(session Session)
 
+// SendResultFunc 发送消息的回调函数，写入成功时 err 为 nil，写入失败时为具体错误
+type SendResultFunc func(session Session, err error)
+
+// SendCallbackResult 将 SendCallbackFunc 转换为 SendResultFunc，只在写入成功时回调
+func SendCallbackResult(callback SendCallbackFunc) SendResultFunc {
+	if callback == nil {
+		return nil
+	}
+
+	return func(session Session, err error) {
+		if err == nil {
+			callback(session)
+		}
+	}
+}
+
+// ConnRejectFunc 拒绝连接时的响应函数，reason 为拒绝的原因，如 ErrMaxConnNum、ErrMaxConnPerIP、ErrHandshakeTimeout
+type ConnRejectFunc func(remoteAddress string, reason error)
+
+// AcceptFunc 接受连接之后，创建会话之前的响应函数，返回 false 或者错误时拒绝该连接
+// conn 为 *PeekConn，可以查看数据而不消耗
+type AcceptFunc func(conn net.Conn) (accept bool, err error)
+
+// AdmissionFunc 创建会话之前检查资源压力，返回错误时拒绝该连接，见 Config.AdmissionCheck
+type AdmissionFunc func() error
+
 // CloseCallbackFunc 关闭会话后的回调函数
 type CloseCallbackFunc func(session Session)
 
+// ResumeCallbackFunc 恢复会话后的回调函数，oldSessionID 为新连接原本分配的 ID
+type ResumeCallbackFunc func(session Session, oldSessionID SessionID)
+
+// RedirectFunc 客户端收到重定向通知时的回调，host 与 port 为服务端通知的新地址
+type RedirectFunc func(host string, port int)
+
+// ShutdownFunc 客户端收到服务端关闭通知时的回调，retryAfter 为建议的重连间隔，0 表示未指定
+type ShutdownFunc func(reason string, retryAfter time.Duration)
+
+// UnknownMessageFunc 收到没有匹配路由的消息时触发，见 Config.OnUnknownMessage
+type UnknownMessageFunc func(session Session, message Message)
+
 // MessageHander 处理客户端消息
 type MessageHander func(message Message) (Message, error)
 
@@ -41,7 +85,11 @@ type Peer interface {
 	// SessionManager 会话管理器
 	SessionManager() SessionManager
 
-	// ListenSignal 监听信号
+	// Stats 所有会话的队列统计
+	Stats() PeerStats
+
+	// ListenSignal 阻塞监听 SIGINT 与 SIGTERM，收到信号时关闭服务，服务关闭时返回
+	// 服务不会自动注册信号处理，需要时显式调用
 	ListenSignal()
 
 	PeerOption
@@ -55,6 +103,12 @@ type PeerOption interface {
 	// SetMaxConnNum 连接数量上限，超过数量则拒绝连接
 	// 负数表示不限制
 	SetMaxConnNum(MaxConnNum int)
+	// SetMaxConnPerIP 同一个 IP 的连接数量上限，超过数量则拒绝连接，<= 0 表示不限制
+	SetMaxConnPerIP(maxConnPerIP int)
+	// SetAdmissionCheck 接受连接之后、创建会话之前调用，返回错误时拒绝该连接
+	SetAdmissionCheck(admissionCheck AdmissionFunc)
+	// SetAcceptGoroutines 同时在监听器上接收连接的 goroutine 数量，websocket 不受该配置影响，默认 1
+	SetAcceptGoroutines(acceptGoroutines int)
 	// SetNetwork 可选 "tcp", "tcp4", "tcp6"，仅在 tcp peer 下有效
 	SetNetwork(network string)
 	// SetHost 设置监听地址
@@ -63,6 +117,8 @@ type PeerOption interface {
 	// SetPort 设置监听端口
 	// 默认 8001
 	SetPort(port int)
+	// SetListener 设置外部创建的监听器，Start 时不再监听 Host 与 Port，仅在 tcp、ws 下有效
+	SetListener(ln net.Listener)
 	// SetLogger 设置日志
 	SetLogger(logger zerologger.Logger)
 	// SetLoggerLevel 设置日志级别
@@ -76,41 +132,159 @@ type PeerOption interface {
 	// SetCloseTimeout 关闭服务器的等待时间，超过该时间服务器直接关闭
 	// 默认 5 秒
 	SetCloseTimeout(closeTimeout time.Duration)
+	// SetStatsInterval 大于 0 时，服务每隔该时长输出一行统计日志
+	SetStatsInterval(statsInterval time.Duration)
+	// SetShutdownNotice 关闭服务器时，先向所有客户端发送关闭通知，再等待连接关闭
+	SetShutdownNotice(shutdownNotice bool)
+	// SetShutdownReason 关闭通知中携带的关闭原因，仅在开启 ShutdownNotice 时有效
+	SetShutdownReason(shutdownReason string)
+	// SetShutdownRetryAfter 关闭通知中携带的建议重连间隔，仅在开启 ShutdownNotice 时有效
+	SetShutdownRetryAfter(shutdownRetryAfter time.Duration)
 
 	// SetRecvBufferSize 在 session 中接收消息 buffer 大小，默认 8K(8 * 1024)
 	SetRecvBufferSize(recvBufferSize int)
+	// SetRingBufferSize 在 session 中存储待解包数据的环形缓冲区大小，<= 0 表示使用 RecvBufferSize 的 2 倍
+	SetRingBufferSize(ringBufferSize int)
+	// SetRingBufferLazy 收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 RingBufferSize
+	SetRingBufferLazy(ringBufferLazy bool)
+	// SetMaxMessageSize 单个完整消息的最大长度，未设置 RingBufferSize 时环形缓冲区按需扩容以容纳该长度的消息
+	SetMaxMessageSize(maxMessageSize int)
 	// SetRecvDeadline 通信超时时间，最终调用 conn.SetReadDeadline 进行设置
 	SetRecvDeadline(recvDeadLine time.Duration)
+	// SetRecvDeadlineMode 读超时的计算方式，仅在 tcp、kcp 下有效
+	// 默认 DeadlineFixed，每一轮读取前设置一次超时时间
+	// DeadlineSliding 每次读取到数据后都会刷新超时时间
+	SetRecvDeadlineMode(recvDeadlineMode DeadlineMode)
 	// SetRecvQueueSize 在 session 中接收到的消息队列大小，session 接收到消息后并非立即处理，而是丢到一个消息队列中，异步处理
 	// 默认 128 个，超过此值后会阻塞消息
 	SetRecvQueueSize(recvQueueSize int)
+	// SetRecvQueueMaxBytes 接收消息队列中缓存的消息负载字节数上限，超出时按照 RecvOverflowPolicy 处理，0 表示不限制
+	SetRecvQueueMaxBytes(recvQueueMaxBytes int)
+	// SetRecvOverflowPolicy 接收消息队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+	SetRecvOverflowPolicy(recvOverflowPolicy RecvOverflowPolicy)
 
 	// SetSendBufferSize 发送消息 buffer 大小，默认 8K(8 * 1024)
-	SetSendBufferSize(recvBufferSize int)
+	SetSendBufferSize(sendBufferSize int)
 	// SetSendDeadline SendDeadline
 	SetSendDeadline(recvDeadLine time.Duration)
 	// SetSendQueueSize 发送的消息队列大小，消息优先发送到 sesion 的消息队列，然后写入到套接字中
 	// 默认 128 个，超过此值后会阻塞消息
-	SetSendQueueSize(recvQueueSize int)
+	SetSendQueueSize(sendQueueSize int)
+	// SetSendQueueMaxSize 发送的消息队列可以增长到的消息数量上限，大于 SendQueueSize 时生效，达到上限后按照 SendOverflowPolicy 处理
+	SetSendQueueMaxSize(sendQueueMaxSize int)
+	// SetSendOverflowPolicy 发送消息队列已满时的处理策略，队列的上限见 SendQueueMaxSize
+	SetSendOverflowPolicy(sendOverflowPolicy SendOverflowPolicy)
+	// SetSendRateLimit 每个会话每秒最多发送的字节数，超出时延迟之后的写入，0 表示不限制
+	SetSendRateLimit(sendRateLimit int)
+	// SetLinger 关闭连接时调用 conn.SetLinger 设置的值，仅在 tcp 下有效，< 0 表示使用系统默认行为
+	SetLinger(linger int)
+	// SetHalfCloseTimeout 关闭连接时先关闭写方向，等待对方关闭连接的最长时间，仅在 tcp 下有效，0 表示直接关闭
+	SetHalfCloseTimeout(halfCloseTimeout time.Duration)
+	// SetNoDelay 新连接是否禁用 Nagle 算法 (TCP_NODELAY)，仅在 tcp 下有效，默认 true
+	SetNoDelay(noDelay bool)
+	// SetHandlerTimeout 处理函数的超时时间，超时之后不再等待，继续处理之后的消息
+	SetHandlerTimeout(handlerTimeout time.Duration)
+	// SetHandlerTimeoutCode 处理函数超时时返回给客户端的响应错误码，为 0 时不返回响应
+	SetHandlerTimeoutCode(handlerTimeoutCode uint16)
+	// SetSlowHandlerThreshold 处理函数的耗时超过该值时记录一条警告日志，0 表示不记录
+	SetSlowHandlerThreshold(slowHandlerThreshold time.Duration)
+	// SetCloseGracePeriod 关闭会话时等待已经开始的处理函数结束的最长时间，其响应仍然会被发送，0 表示不等待
+	SetCloseGracePeriod(closeGracePeriod time.Duration)
+	// SetAckTimeout SendReliable 发送的消息等待确认的时间，超时之后重发，默认 5 秒
+	SetAckTimeout(ackTimeout time.Duration)
+	// SetAckRetries SendReliable 发送的消息超时之后的重发次数，默认 2
+	SetAckRetries(ackRetries int)
+	// SetAckWindow 每个会话等待确认的消息数量上限，默认 64
+	SetAckWindow(ackWindow int)
+	// SetDispatchPool 多个会话共享的消息处理协程池，同一个分片的消息按顺序处理，nil 表示每个会话在自己的协程中处理
+	SetDispatchPool(dispatchPool *DispatchPool)
 
 	// SetOnConnected 客户端连接到来时触发，此时客户端已经可以开始收发消息
 	SetOnConnected(onConnected ConnFunc)
-	// SetOnConnClose 客户端连接关闭触发，此时客户端不可以再收发消息
+	// SetOnConnectedAsync 是否在新的 goroutine 中执行 OnConnected，此时消息可能先于 OnConnected 完成被处理
+	SetOnConnectedAsync(onConnectedAsync bool)
+	// SetSessionMode 会话的收发模式，默认 SessionModeDuplex，见 SessionMode
+	SetSessionMode(sessionMode SessionMode)
+	// SetOnHandshake 连接开始收发消息之前同步触发，先于 OnConnected，返回错误时关闭连接
+	SetOnHandshake(onHandshake HandshakeFunc)
+	// SetOnConnClose 客户端连接关闭触发，此时不再接收消息，但仍然可以发送消息，这些消息会在断开连接之前发送完毕
 	SetOnConnClose(onConnClose ConnFunc)
+	// SetOnConnReject 连接因超过 MaxConnNum、MaxConnPerIP，或者超过 HandshakeTimeout 仍未完成秘钥协商被拒绝时触发
+	SetOnConnReject(onConnReject ConnRejectFunc)
+	// SetOnAccept 接受连接之后，创建会话之前触发，返回 false 或者错误时关闭连接
+	SetOnAccept(onAccept AcceptFunc)
+	// SetProxyProtocol 连接开头是否为 PROXY protocol v1/v2 头部，仅在 tcp 下有效
+	SetProxyProtocol(proxyProtocol bool)
+	// SetOnUnknownMessage 收到没有匹配路由的消息时触发，之后丢弃该消息
+	SetOnUnknownMessage(onUnknownMessage UnknownMessageFunc)
+	// SetStrictRouting 收到没有匹配路由的消息时关闭会话，默认 false
+	SetStrictRouting(strictRouting bool)
+	// SetHandshakeKick 开启加密时，秘钥协商完成之前收到业务消息，是否断开连接
+	// 默认 false，仅丢弃该消息
+	SetHandshakeKick(handshakeKick bool)
+	// SetHandshakeTimeout 开启加密时，连接建立之后需要在该时间内完成秘钥协商，否则关闭连接，0 表示不限制
+	SetHandshakeTimeout(handshakeTimeout time.Duration)
+	// SetBroadcastReadyOnly SendAll 与 SendMany 跳过尚未完成秘钥协商的会话
+	SetBroadcastReadyOnly(broadcastReadyOnly bool)
+	// SetMaxKeyExchanges 服务端同时进行的秘钥协商计算数量上限，超出时排队等待，最多等待 HandshakeTimeout，0 表示不限制
+	SetMaxKeyExchanges(maxKeyExchanges int)
+	// SetZeroMessageRate 每个会话每秒最多处理的特殊协议消息数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+	SetZeroMessageRate(zeroMessageRate int)
+	// SetMaxExchangeKeyRequests 每个会话最多处理的秘钥协商请求数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+	SetMaxExchangeKeyRequests(maxExchangeKeyRequests int)
+	// SetZeroLimitKick 特殊协议消息超过 ZeroMessageRate 或者 MaxExchangeKeyRequests 时是否断开连接，默认只丢弃该消息
+	SetZeroLimitKick(zeroLimitKick bool)
+
+	// SetMinCryptoKeySize 秘钥协商得到的秘钥最小长度，不足时秘钥协商失败
+	SetMinCryptoKeySize(minCryptoKeySize int)
+
+	// SetResumeTTL 断线后保留会话状态的时长，<= 0 表示不开启会话恢复
+	SetResumeTTL(resumeTTL time.Duration)
+	// SetResumeBufferSize 断线后保留会话状态期间最多缓存的消息数量，恢复会话后重新发送，<= 0 表示不缓存
+	SetResumeBufferSize(resumeBufferSize int)
+	// SetResumeBufferBytes 断线后每个会话缓存的消息负载总长度上限，<= 0 表示只按数量限制
+	SetResumeBufferBytes(resumeBufferBytes int)
+
+	// SetResumeStore 存储断线后保留的会话状态
+	SetResumeStore(resumeStore ResumeStore)
+	// SetBufferFailPolicy 设置连接缓冲区(SetReadBuffer、SetWriteBuffer)失败时的处理策略
+	// 默认 BufferFailClose，关闭该连接
+	SetBufferFailPolicy(bufferFailPolicy BufferFailPolicy)
+	// SetSocketReadBuffer 套接字接收缓冲区大小 (SO_RCVBUF)，0 表示使用系统默认值
+	SetSocketReadBuffer(socketReadBuffer int)
+	// SetSocketWriteBuffer 套接字发送缓冲区大小 (SO_SNDBUF)，0 表示使用系统默认值
+	SetSocketWriteBuffer(socketWriteBuffer int)
 
 	// SetDatapack 封包与解包
 	SetDatapack(datapack Datapack)
+	// SetDatapackSwitchable 会话是否可能在握手之后切换封包工具，开启后切换之前逐个解包与处理消息
+	SetDatapackSwitchable(datapackSwitchable bool)
 
 	// SetWhetherCompress 是否需要对消息负载进行压缩
 	SetWhetherCompress(whetherCompress bool)
+	// SetCompressNegotiation 是否由每个会话在秘钥协商时决定是否压缩
+	SetCompressNegotiation(compressNegotiation bool)
 	// SetCompressThreshold 压缩的阈值，当消息负载长度不小于该值时才会压缩
 	SetCompressThreshold(compressThreshold int)
 	// SetCompress 设置压缩与解压器
 	SetCompress(compress zerocompress.Compress)
+	// SetMaxDecompressedSize 解压后负载的最大长度，超出则解包失败，<= 0 表示不限制
+	// 默认 1M
+	SetMaxDecompressedSize(maxDecompressedSize int)
+	// SetPayloadPoolMaxSize 解包时负载不超过该长度则从共享缓冲池中获取内存，消息 Release 时归还，0 表示不使用
+	SetPayloadPoolMaxSize(payloadPoolMaxSize int)
 	// SetWhetherCrypto 是否需要对消息负载进行加解密
 	SetWhetherCrypto(whetherCrypto bool)
 	// SetWhetherChecksum 是否启用校验值功能，默认 false
 	SetWhetherChecksum(whetherChecksum bool)
+	// SetChecksummer 开启 WhetherChecksum 时计算校验值的算法，默认 HMAC-MD5
+	SetChecksummer(checksummer Checksummer)
+	// SetCodec 编码与解码器，用于 Session.SendProto，默认 protobuf
+	SetCodec(codec zerocodec.Codec)
+	// SetRouter 使用外部创建的路由器，多个服务可以共用同一个路由器，见 peer/mux
+	SetRouter(router Router)
+	// SetSessionManager 使用外部创建的会话管理器，多个服务共用时会话 ID 不会重复，见 peer/mux
+	SetSessionManager(sessionManager SessionManager)
 }
 
 // Session 表示与客户端的一条连接，也称为会话
@@ -127,6 +301,46 @@ type Session interface {
 	// SendCallback 发送消息给客户端，发送成功之后响应回调函数
 	SendCallback(message Message, callback SendCallbackFunc) error
 
+	// SendResult 发送消息给客户端，写入套接字之后响应回调函数，写入失败时回调中携带错误
+	SendResult(message Message, callback SendResultFunc) error
+
+	// SendAndClose 发送消息之后以 reason 关闭会话，比如踢下线时先通知客户端原因
+	// 消息放入发送队列之后不再接受新的消息，最多等待 Config.CloseTimeout 直到该消息写入套接字，之后关闭会话
+	// 阻塞直到会话关闭，返回写入该消息的错误，会话已经停止发送时返回 ErrStopSend，此时不会关闭会话
+	SendAndClose(message Message, reason CloseReason) error
+
+	// TrySend 尝试将消息放入发送队列，不会阻塞，适合不能等待的实时循环
+	// 放入成功返回 (true, nil)；队列已满时返回 (false, nil)，消息未被发送，仍由调用方持有；会话已停止发送时返回 ErrStopSend
+	TrySend(message Message) (bool, error)
+
+	// SendNow 立即将消息写入套接字，不经过发送队列，阻塞直到写入完成
+	// 与发送队列共用同一把写锁，保证每一个消息完整写入，但可能先于队列中尚未发送的消息到达
+	SendNow(message Message) error
+
+	// SendProto 使用 Config.Codec 对 v 进行编码，封装成消息后发送给客户端
+	SendProto(module, action uint8, v interface{}) error
+
+	// SendJSON 使用 encoding/json 对 v 进行编码，封装成消息后发送给客户端，与 Config.Codec 无关
+	SendJSON(module, action uint8, v interface{}) error
+
+	// Request 发送请求并等待对方的响应，比如服务端请求客户端确认
+	// SN 由会话在 [RequestSNMin, 65535] 之间分配，对方需要原样返回 SN 与 module，比如使用 datapack.Respond 创建响应
+	// 对方主动发出的消息 SN 需要小于 RequestSNMin，客户端自动分配的 SN 满足这一点
+	// 响应交给调用方，不会派发给路由，超过 timeout 返回 ErrRequestTimeout，timeout <= 0 表示一直等待
+	// 等待期间会话关闭返回 ErrStopSend
+	Request(message Message, timeout time.Duration) (Message, error)
+
+	// SendReliable 发送需要对方确认的消息，用于需要确认对方已经处理完毕的重要消息
+	// SN 由会话在 [AckSNMin, RequestSNMin) 之间分配，对方处理成功之后自动回复确认
+	// 超过 Config.AckTimeout 未确认时重发，重发 Config.AckRetries 次之后仍未确认时回调 ErrAckTimeout
+	// 收到确认时回调中 err 为 nil，等待期间会话关闭时为 ErrStopSend，等待确认的消息超过 Config.AckWindow 时返回 ErrTooManyUnacked
+	SendReliable(message Message, callback SendResultFunc) error
+
+	// SendStream 将 reader 中的数据拆分为若干分块依次发送，同一个流的分块使用相同的 module 与 action
+	// 分块的负载格式见 datapack.NewStreamMessage，接收方可以使用 datapack.StreamReassembler 重组
+	// 已放入发送队列但尚未写入套接字的分块有数量上限，内存占用不会随 reader 的长度增长，阻塞直到全部写入
+	SendStream(module, action uint8, reader io.Reader) error
+
 	// ID 获取 sessionID，每一条连接都分配有一个唯一的 id
 	ID() SessionID
 
@@ -142,14 +356,69 @@ type Session interface {
 	// SetChecksumKey 设置校验秘钥
 	SetChecksumKey(checksumKey []byte)
 
+	// HandshakeState 秘钥协商状态
+	// 开启加密时，协商完成之前收到的业务消息会被拒绝
+	HandshakeState() HandshakeState
+
+	// Ready 是否可以收发业务消息，未开启加密时总是为 true，否则需要完成秘钥协商
+	Ready() bool
+
+	// Mode 会话的收发模式，见 Config.SessionMode
+	Mode() SessionMode
+
+	// SetMode 修改该会话的收发模式，只能在 Config.OnHandshake 中调用，收发循环开始之后返回 ErrSessionRunning
+	SetMode(mode SessionMode) error
+
+	// IsEncrypted 是否已经设置了加解密工具，秘钥协商完成或者调用 SetCrypto 之后为 true
+	// 发送敏感数据之前可以用来确认连接已经加密
+	IsEncrypted() bool
+
+	// IsCompressed 当前使用的封包工具是否会压缩消息负载，封包工具未实现 CompressDatapack 时为 false
+	IsCompressed() bool
+
+	// ResumeToken 恢复令牌，服务端为连接分配，客户端为收到的令牌，未开启时为空
+	ResumeToken() string
+
+	// Redirect 通知客户端连接到新的地址，通知发送完毕之后关闭连接，用于滚动部署
+	Redirect(host string, port int) error
+
+	// SetDatapack 握手之后切换该会话的封包工具，不影响其它会话，只能切换一次，需要开启 Config.DatapackSwitchable
+	// 在消息处理函数中调用：之后收到的消息使用新的封包工具解包
+	// 调用之前放入发送队列的消息仍然使用原来的封包工具，之后的消息 (包括处理函数返回的响应) 使用新的封包工具
+	SetDatapack(datapack Datapack) error
+
+	// RTT 心跳测量的往返时间，多次测量平滑之后的值，尚未测量时为 0
+	// 只有发送心跳的一方才会测量，比如开启心跳的客户端
+	RTT() time.Duration
+
+	// CloseReason 会话关闭的原因，尚未关闭时为 CloseReasonNone，在 Config.OnConnClose 中已经可以获取
+	CloseReason() CloseReason
+
+	// QueueStats 发送队列与接收队列当前的长度、容量与最高水位
+	QueueStats() QueueStats
+
 	// Config 配置
 	Config() *Config
 
 	// Get 获取自定义参数
+	// 会话关闭时不会清空参数，OnClose 注册的回调与 Config.OnConnClose 中仍然可以读取，用于清理 Set 保存的资源
 	Get(key string) interface{}
 
-	// Set 设置自定义参数，存储于此次会话中
+	// Set 设置自定义参数，存储于此次会话中，会话关闭之后仍然可以通过 Get 读取，见 Get
 	Set(key string, value interface{})
+
+	// SetWithTTL 设置自定义参数，超过 ttl 之后 Get 返回 nil，ttl <= 0 时与 Set 相同
+	// 适用于计数器、临时令牌等短期有效的值，读取时检查是否过期，不会为每一个参数启动定时器
+	// 设置了 ttl 的参数不会保留到会话恢复之后
+	SetWithTTL(key string, value interface{}, ttl time.Duration)
+
+	// OnClose 注册会话关闭时执行的回调，用于清理与该会话绑定的资源，比如定时器、订阅
+	// 多个回调按注册的相反顺序执行，只会执行一次，并且先于 Config.OnConnClose
+	OnClose(callback func())
+
+	// Closed 会话完全关闭之后关闭的通道：Close 执行完毕，连接已经关闭，收发循环与心跳均已退出
+	// Close 可能在收发循环中执行，返回时循环不一定已经退出，需要确认时等待该通道
+	Closed() <-chan struct{}
 }
 
 // Client 客户端，一般用来编写测试用例
@@ -162,6 +431,10 @@ type Client interface {
 
 	// Logger 日志
 	Logger() zerologger.Logger
+
+	// NextSN 下一个自动分配的 SN
+	// 发送 SN 为 0 的非特殊协议消息时自动分配，超过 65535 之后从 1 开始，0 保留给服务端主动推送的消息
+	NextSN() uint16
 }
 
 // SessionManager 会话管理器
@@ -172,17 +445,33 @@ type SessionManager interface {
 	// Add 添加 Session
 	Add(session Session)
 
-	// Del 移除 Session
+	// Del 移除 Session 并关闭，返回时收发循环可能尚未退出，需要确认时等待 Session.Closed
 	Del(sessionID SessionID)
 
+	// Rebind 恢复会话后，session 使用了新的 ID，将其从 oldSessionID 迁移过去，不会关闭会话
+	// 同时不再为 session.ID() 缓存消息，见 Park
+	Rebind(oldSessionID SessionID, session Session)
+
+	// Kick 发送 message 通知客户端之后将其踢下线，关闭原因为 CloseReasonKicked，见 Session.SendAndClose
+	Kick(sessionID SessionID, message Message) error
+
+	// Park 会话断线后保留状态期间，Send 发给 sessionID 的消息存入 buffer，等待恢复会话后重新发送
+	// 恢复会话 (Rebind) 或者 buffer 过期之后不再缓存，Send 返回 ErrSessionNotFound
+	Park(sessionID SessionID, buffer *ResumeBuffer)
+
 	// Get(sessionID SessionID) (Session, error)
 	Get(sessionID SessionID) (Session, error)
 
-	// Len 获取当前 Session 数量
+	// Len 获取当前 Session 数量，不需要遍历，可以在每次接受连接时调用
 	Len() int
 
+	// Range 只读遍历所有 Session，f 返回 false 时停止遍历
+	// 与 sync.Map.Range 一致，遍历过程中可以添加或者移除 Session，不保证能遍历到遍历过程中添加的 Session
+	Range(f func(session Session) bool)
+
 	// Close 当前所有连接停止接收客户端消息，不再接收服务端消息，当已接收的服务端消息发送完毕后，断开连接
-	Close()
+	// 超过 timeout 仍未关闭的连接会被强行关闭，返回被强行关闭的连接数量，timeout <= 0 表示一直等待
+	Close(timeout time.Duration) int
 
 	// Send 发送消息给客户端
 	Send(sessionID SessionID, message Message) error
@@ -190,8 +479,25 @@ type SessionManager interface {
 	// SendCallback  发送消息个客户端，发送之后进行回调
 	SendCallback(sessionID SessionID, message Message, callback SendCallbackFunc) error
 
-	// SendAll 给所有客户端发送消息
+	// SendResult 发送消息给客户端，写入套接字之后进行回调，写入失败时回调中携带错误
+	SendResult(sessionID SessionID, message Message, callback SendResultFunc) error
+
+	// SendReliable 发送需要客户端确认的消息，见 Session.SendReliable
+	SendReliable(sessionID SessionID, message Message, callback SendResultFunc) error
+
+	// Request 发送请求给客户端并等待响应，见 Session.Request
+	Request(sessionID SessionID, message Message, timeout time.Duration) (Message, error)
+
+	// SendAll 给所有客户端发送消息，开启 Config.BroadcastReadyOnly 时跳过尚未完成秘钥协商的会话
 	SendAll(message Message)
+
+	// SendMany 给 ids 中的客户端发送消息，重复的 ID 只发送一次，返回发送失败的 ID 及其错误，全部成功时为 nil
+	// 不存在的会话为 ErrSessionNotFound，开启 Config.BroadcastReadyOnly 时尚未完成秘钥协商的会话为 ErrHandshakeNotReady
+	// 每个会话发送 message 的副本，message 在发送之后释放
+	SendMany(ids []SessionID, message Message) map[SessionID]error
+
+	// RedirectAll 通知所有客户端连接到新的地址，通知发送完毕之后关闭连接，用于滚动部署
+	RedirectAll(host string, port int)
 }
 
 // Message 通讯消息
@@ -214,18 +520,45 @@ type Message interface {
 	// SN 自增编号
 	SN() uint16
 
+	// SetSN 设置自增编号，客户端发送 SN 为 0 的消息时自动分配
+	SetSN(sn uint16)
+
+	// CorrelationID 关联编号，用于追踪服务端主动推送以及跨服务的调用
+	// 仅在封包解包工具启用时才会随消息传输，否则为 0，见 datapack.WithLTDCorrelationID
+	CorrelationID() uint64
+
+	// SetCorrelationID 设置关联编号
+	SetCorrelationID(id uint64)
+
 	// Code 错误码
 	Code() uint16
 
 	// Payload 负载
 	Payload() []byte
 
+	// BindJSON 使用 encoding/json 将负载解码到 v 中
+	BindJSON(v interface{}) error
+
+	// ContentType 负载的内容类型
+	// 仅在封包解包工具启用时才会随消息传输，否则为 ContentTypeRaw，见 datapack.WithLTDContentType
+	ContentType() ContentType
+
+	// SetContentType 设置负载的内容类型
+	SetContentType(contentType ContentType)
+
+	// Bind 根据 ContentType 将负载解码到 v 中
+	// json 与 protobuf 分别解码，raw 时 v 需要为 *[]byte，得到负载的副本
+	Bind(v interface{}) error
+
 	// Checksum 校验值
 	Checksum() [16]byte
 
 	// String 打印消息
 	String() string
 
+	// Clone 复制消息，负载为副本，副本与原消息分别释放
+	Clone() Message
+
 	// Release 释放资源
 	Release()
 }
@@ -239,6 +572,15 @@ type Crypto interface {
 	Decrypt(in []byte) ([]byte, error)
 }
 
+// Checksummer 计算消息的校验值，见 Config.Checksummer
+type Checksummer interface {
+	// Size 校验值的字节数，决定消息头中校验值部分的长度
+	Size() int
+
+	// Sum 计算 data 的校验值，返回 Size 个字节，key 为校验秘钥，不需要秘钥的算法忽略该参数
+	Sum(data, key []byte) []byte
+}
+
 // Datapack 通讯数据封包与解包
 type Datapack interface {
 	// HeadLen 消息头长度
@@ -248,20 +590,75 @@ type Datapack interface {
 	Pack(message Message, crypto Crypto, checksumKey []byte) ([]byte, error)
 
 	// Unpack 解包
+	// 遇到会改变秘钥的特殊协议消息 (见 RotatesCrypto) 时需要停止，之后的数据留在 buffer 中，待秘钥更新后再次调用
 	Unpack(buffer *zeroringbytes.RingBytes, crypto Crypto, checksumKey []byte) ([]Message, error)
 }
 
+// ReaderDatapack 可以直接从 io.Reader 中读取完整消息的封包解包工具
+// tcp 会话优先使用，减少一次从 RingBytes 中转的拷贝
+type ReaderDatapack interface {
+	Datapack
+
+	// UnpackFrom 从 reader 中读取一个完整的消息并解包
+	UnpackFrom(reader io.Reader, crypto Crypto, checksumKey []byte) (Message, error)
+}
+
+// SingleDatapack 每次只从 buffer 中解包一个消息的封包解包工具
+// 开启 Config.DatapackSwitchable 时，切换之前使用，避免提前使用原来的封包工具解包之后的消息
+type SingleDatapack interface {
+	Datapack
+
+	// UnpackOne 解包一个完整的消息，buffer 中不足一个完整的消息时返回 nil
+	UnpackOne(buffer *zeroringbytes.RingBytes, crypto Crypto, checksumKey []byte) (Message, error)
+}
+
+// CompressDatapack 可以告知是否会压缩消息负载的封包解包工具，见 Session.IsCompressed
+type CompressDatapack interface {
+	Datapack
+
+	// WhetherCompress 封包时是否会压缩消息负载，超过压缩阈值的负载才会被压缩
+	WhetherCompress() bool
+}
+
+// SessionCompressDatapack 可以按会话决定是否压缩消息负载的封包解包工具，见 Config.CompressNegotiation
+// 多个会话共用同一个封包工具，解包时总是按照消息头中的 FlagCompress 解压
+type SessionCompressDatapack interface {
+	Datapack
+
+	// PackCompress 封包，compress 为 false 时不压缩消息负载，为 true 时与 Pack 一致
+	PackCompress(message Message, crypto Crypto, checksumKey []byte, compress bool) ([]byte, error)
+}
+
 // HandlerFunc 路由消息处理函数
+// 返回的响应消息不为 nil 时，即使同时返回了错误，也会发送给客户端，比如携带错误码的响应
+// 只有返回 ErrFatal 时才会断开连接
+// 处理函数返回之后 (返回了响应时在响应写入之后) 消息会被释放并复用，之后仍需要使用消息或者负载时先复制一份
 type HandlerFunc func(message Message) (Message, error)
 
+// ContextHandlerFunc 可以感知超时的路由消息处理函数，与 HandlerFunc 一致
+// 设置了 Config.HandlerTimeout 时，ctx 在超时后取消，处理函数应当尽快返回
+type ContextHandlerFunc func(ctx context.Context, message Message) (Message, error)
+
 // Router 消息处理路由器
 type Router interface {
 	// AddRouter 添加路由
 	AddRouter(module, action uint8, handle HandlerFunc) error
 
+	// AddRoute 使用路由 ID 添加路由
+	AddRoute(routeID RouteID, handle HandlerFunc) error
+
+	// AddContextRoute 使用路由 ID 添加可以感知超时的路由
+	AddContextRoute(routeID RouteID, handle ContextHandlerFunc) error
+
+	// AddRouters 批量添加路由，任意一个路由已存在或者处理函数为空时全部不添加
+	AddRouters(routes map[RouteID]HandlerFunc) error
+
 	// Handler 路由处理
 	Handler(message Message) (Message, error)
 
+	// HandlerContext 路由处理，ctx 传递给使用 AddContextRoute 注册的处理函数
+	HandlerContext(ctx context.Context, message Message) (Message, error)
+
 	// SetHandlerFunc 设置自定义路由处理函数
 	SetHandlerFunc(handler HandlerFunc)
 }
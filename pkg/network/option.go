@@ -1,12 +1,78 @@
 package network
 
 import (
+	"net"
 	"time"
 
+	zerocodec "github.com/zerogo-hub/zero-helper/codec"
+	zeroprotobuf "github.com/zerogo-hub/zero-helper/codec/protobuf"
 	zerocompress "github.com/zerogo-hub/zero-helper/compress"
 	zerologger "github.com/zerogo-hub/zero-helper/logger"
 )
 
+// BufferFailPolicy 设置连接缓冲区(SetReadBuffer、SetWriteBuffer)失败时的处理策略
+type BufferFailPolicy int
+
+const (
+	// BufferFailClose 关闭该连接，默认
+	BufferFailClose BufferFailPolicy = iota
+
+	// BufferFailIgnore 忽略错误，继续使用系统默认的缓冲区大小
+	BufferFailIgnore
+)
+
+// RecvOverflowPolicy 接收队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+type RecvOverflowPolicy int
+
+const (
+	// RecvOverflowBlock 暂停读取，直到队列中的消息被处理，与消息数量达到 RecvQueueSize 时一致，默认
+	RecvOverflowBlock RecvOverflowPolicy = iota
+
+	// RecvOverflowClose 关闭该连接
+	RecvOverflowClose
+)
+
+// SendOverflowPolicy 发送队列已满时的处理策略，队列的上限为 SendQueueSize 与 SendQueueMaxSize 中较大的一个
+type SendOverflowPolicy int
+
+const (
+	// SendOverflowBlock 等待队列中的消息被发送，最多等待 3 秒，超时返回 ErrWriteTimeout，默认
+	SendOverflowBlock SendOverflowPolicy = iota
+
+	// SendOverflowDrop 丢弃该消息，返回 ErrSendQueueFull，消息仍由调用方持有
+	SendOverflowDrop
+
+	// SendOverflowClose 丢弃该消息并关闭该连接，返回 ErrSendQueueFull
+	SendOverflowClose
+)
+
+// SessionMode 会话的收发模式，见 Config.SessionMode
+type SessionMode int
+
+const (
+	// SessionModeDuplex 收发消息，默认
+	SessionModeDuplex SessionMode = iota
+
+	// SessionModeReadOnly 只接收消息，发送返回 ErrReadOnlySession，处理函数的响应被丢弃，不发送心跳
+	SessionModeReadOnly
+
+	// SessionModeWriteOnly 只发送消息，不启动 recvLoop 与 dispatchLoop，不发送心跳
+	// 不读取套接字，对方断开连接只能在写入失败时发现，也无法进行秘钥协商
+	SessionModeWriteOnly
+)
+
+// DeadlineMode 读超时的计算方式
+type DeadlineMode int
+
+const (
+	// DeadlineFixed 固定超时，每一轮读取前设置一次超时时间，默认
+	DeadlineFixed DeadlineMode = iota
+
+	// DeadlineSliding 滑动超时，每次读取到数据后都会刷新超时时间
+	// 只要持续有数据到达就不会超时，避免慢速但持续传输的客户端被断开
+	DeadlineSliding
+)
+
 // Config 一些参数配置
 type Config struct {
 	// --------------------------- 服务 ---------------------------
@@ -15,6 +81,21 @@ type Config struct {
 	// 负数表示不限制
 	MaxConnNum int
 
+	// MaxConnPerIP 同一个 IP 的连接数量上限，超过数量则拒绝连接
+	// <= 0 表示不限制
+	// 默认 0
+	MaxConnPerIP int
+
+	// AdmissionCheck 接受连接之后、创建会话之前调用，返回错误时拒绝该连接，并以该错误触发 OnConnReject
+	// 用于按照内存等资源压力限制接入，比如 MemoryAdmission，在接受连接的 goroutine 中执行，需要尽快返回
+	// 默认 nil，不检查
+	AdmissionCheck AdmissionFunc
+
+	// AcceptGoroutines 同时在监听器上接收连接的 goroutine 数量，每个 goroutine 执行相同的准入检查并创建会话
+	// 连接突增时，设置套接字参数、创建会话等工作可以并行执行，websocket 由 http.Server 为每个连接单独开启 goroutine，不受该配置影响
+	// 默认 1
+	AcceptGoroutines int
+
 	// Network 可选 "tcp", "tcp4", "tcp6"
 	// 默认 tcp4
 	Network string
@@ -25,6 +106,11 @@ type Config struct {
 	// 默认 8001
 	Port int
 
+	// Listener 外部创建的监听器，设置后 Start 直接使用，不再监听 Host 与 Port
+	// 比如测试时使用 127.0.0.1:0，或者由 systemd socket activation 传入的监听器
+	// 关闭服务时会关闭该监听器，仅在 tcp、ws 下有效
+	Listener net.Listener
+
 	Logger zerologger.Logger
 	// LoggerLevel 日志级别
 	// 见 https://github.com/zerogo-hub/zero-helper/blob/main/logger/logger.go
@@ -40,19 +126,65 @@ type Config struct {
 	// 默认 5 秒
 	CloseTimeout time.Duration
 
+	// StatsInterval 大于 0 时，服务每隔该时长输出一行统计日志，包括会话数量、接收与拒绝连接的速率、收发字节的速率，见 Peer.Stats
+	// 默认 0，不输出
+	StatsInterval time.Duration
+
+	// ShutdownNotice 关闭服务器时，先向所有客户端发送关闭通知，再等待连接关闭
+	// 客户端可以据此区分计划内的关闭与异常断开，见 WithClientOnShutdown
+	// 默认 false
+	ShutdownNotice bool
+
+	// ShutdownReason 关闭通知中携带的关闭原因，仅在开启 ShutdownNotice 时有效
+	ShutdownReason string
+
+	// ShutdownRetryAfter 关闭通知中携带的建议重连间隔，仅在开启 ShutdownNotice 时有效
+	// 默认 0，表示未指定
+	ShutdownRetryAfter time.Duration
+
 	// --------------------------- 会话 ---------------------------
 
 	// RecvBufferSize 在 session 中接收消息 buffer 大小
 	// 默认 8K
 	RecvBufferSize int
 
+	// RingBufferSize 在 session 中存储待解包数据的环形缓冲区大小，仅在 tcp、kcp、ws 使用环形缓冲区解包时有效
+	// <= 0 表示使用 RecvBufferSize 的 2 倍
+	// 默认 0
+	RingBufferSize int
+
+	// RingBufferLazy 收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 RingBufferSize
+	// 大量空闲连接时可以节省内存
+	// 默认 false
+	RingBufferLazy bool
+
+	// MaxMessageSize 单个完整消息 (消息头 + 消息体) 的最大长度
+	// 未设置 RingBufferSize 时，环形缓冲区不足以存放一个完整消息会按需扩容，最大为 MaxMessageSize + RecvBufferSize
+	// 因此可以接收比 RecvBufferSize 更长的消息，扩容后的缓冲区在数据处理完毕之后恢复为原来的大小
+	// websocket 同时用于限制单个 websocket 消息的长度 (SetReadLimit)，超出时以 CloseReasonMessageTooBig 关闭会话
+	// 默认 128K
+	MaxMessageSize int
+
 	// RecvDeadline 通信超时时间，最终调用 conn.SetReadDeadline
 	RecvDeadline time.Duration
 
+	// RecvDeadlineMode 读超时的计算方式，仅在 tcp、kcp 下有效
+	// 默认 DeadlineFixed
+	RecvDeadlineMode DeadlineMode
+
 	// RecvQueueSize 每一个 session 的接收消息队列大小，session 接收到消息后并非立即处理，而是丢到一个消息队列中，异步处理
 	// 默认 128
 	RecvQueueSize int
 
+	// RecvQueueMaxBytes 每一个 session 的接收消息队列中缓存的消息负载字节数上限，与 RecvQueueSize 共同限制缓存消息占用的内存
+	// 超出时按照 RecvOverflowPolicy 处理，队列为空时单个消息不受限制
+	// 默认 0，表示不限制
+	RecvQueueMaxBytes int
+
+	// RecvOverflowPolicy 接收消息队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+	// 默认 RecvOverflowBlock
+	RecvOverflowPolicy RecvOverflowPolicy
+
 	// SendBufferSize 发送消息 buffer 大小
 	// 默认 8K
 	SendBufferSize int
@@ -64,25 +196,204 @@ type Config struct {
 	// 默认 128
 	SendQueueSize int
 
+	// SendQueueMaxSize 每一个 session 的发送消息队列可以增长到的消息数量上限
+	// 大于 SendQueueSize 时，队列已满之后的消息放入按需增长的溢出缓冲区，用于平滑短暂的突发流量，达到上限后按照 SendOverflowPolicy 处理
+	// 默认 0，表示不增长，队列大小固定为 SendQueueSize
+	SendQueueMaxSize int
+
+	// SendOverflowPolicy 发送消息队列已满时的处理策略，队列的上限见 SendQueueMaxSize
+	// 默认 SendOverflowBlock
+	SendOverflowPolicy SendOverflowPolicy
+
+	// SendRateLimit 每个会话每秒最多发送的字节数，超出时延迟之后的写入，只影响该会话的发送
+	// 基于令牌桶，允许 0.1 秒的突发流量，SendNow 写入的字节同样计入，但不会被延迟
+	// 默认 0，表示不限制
+	SendRateLimit int
+
+	// Linger 关闭连接时调用 conn.SetLinger 设置的值，仅在 tcp 下有效
+	// < 0 表示不设置，使用系统默认行为，在后台继续发送剩余的数据；0 表示丢弃未发送的数据；> 0 表示最多等待的秒数
+	// 默认 -1
+	Linger int
+
+	// HalfCloseTimeout 关闭连接时先关闭写方向 (CloseWrite)，等待对方关闭连接之后再关闭套接字，最多等待该时长，仅在 tcp 下有效
+	// 对方读取完剩余的数据之后才会关闭连接，避免直接关闭时接收缓冲区中尚有数据，发送 RST 导致最后的数据丢失
+	// 默认 0，表示直接关闭
+	HalfCloseTimeout time.Duration
+
+	// NoDelay 新连接是否禁用 Nagle 算法 (TCP_NODELAY)，仅在 tcp 下有效
+	// 对延迟不敏感的大批量传输可以关闭，减少数据包数量，也可以在连接建立之后通过会话的 SetNoDelay 单独设置
+	// 默认 true
+	NoDelay bool
+
+	// HandlerTimeout 处理函数的超时时间，超时之后不再等待该处理函数，继续处理之后的消息
+	// 处理函数可以通过 Router.AddContextRoute 注册，在 ctx 超时后尽快返回
+	// 默认 0，不限制
+	HandlerTimeout time.Duration
+
+	// HandlerTimeoutCode 处理函数超时时，返回给客户端的响应错误码，沿用请求的 SN、module 与 action
+	// 默认 0，不返回响应
+	HandlerTimeoutCode uint16
+
+	// SlowHandlerThreshold 处理函数的耗时超过该值时记录一条警告日志，包含 module、action、sn 与耗时
+	// 只记录慢的处理，不需要为每个消息记录日志
+	// 默认 0，不记录
+	SlowHandlerThreshold time.Duration
+
+	// CloseGracePeriod 关闭会话时，等待先于 Close 开始的处理函数结束的最长时间，这些处理函数返回的响应仍然会被发送
+	// Close 之后开始的处理函数的响应，以及其它地方新调用的 Send 仍然返回 ErrStopSend
+	// 处理函数中关闭自身所在的会话时，Close 会等待该处理函数直到超时，因此不宜过长
+	// 默认 0，表示不等待，关闭过程中返回的响应会被丢弃
+	CloseGracePeriod time.Duration
+
+	// AckTimeout 通过 Session.SendReliable 发送的消息等待确认的时间，超时之后重发，默认 5 秒
+	AckTimeout time.Duration
+
+	// AckRetries 等待确认超时之后的重发次数，用完之后回调 ErrAckTimeout，默认 2，0 表示不重发
+	// 接收方可能多次处理同一个消息，需要自行去重
+	AckRetries int
+
+	// AckWindow 每个会话等待确认的消息数量上限，达到上限时 SendReliable 返回 ErrTooManyUnacked，默认 64，0 表示不限制
+	AckWindow int
+
+	// DispatchPool 多个会话共享的消息处理协程池，设置之后路由处理函数在协程池中执行，见 NewDispatchPool
+	// 同一个分片 (默认为同一个会话) 的消息按顺序处理，不同分片的消息并发处理，协程池由创建者关闭
+	// 默认 nil，表示每个会话在自己的协程中处理消息
+	DispatchPool *DispatchPool
+
 	// OnConnected 客户端连接到来时触发，此时客户端已经可以开始收发消息
+	// 默认同步执行，返回之后会话才开始读取消息，见 OnConnectedAsync
 	OnConnected ConnFunc
 
-	// OnConnClose 客户端连接关闭触发，此时客户端不可以再收发消息
+	// OnConnectedAsync 是否在新的 goroutine 中执行 OnConnected，比如 OnConnected 中需要从数据库加载玩家数据
+	// 默认 false，同步执行，OnConnected 返回之后会话才开始读取消息，第一个消息一定在 OnConnected 完成之后处理
+	// 为 true 时会话立即开始收发消息，消息可能先于 OnConnected 完成被处理，处理函数需要自行等待所需的数据
+	OnConnectedAsync bool
+
+	// SessionMode 会话的收发模式，默认 SessionModeDuplex
+	// 只订阅推送的客户端可以使用 SessionModeReadOnly，只推送的服务端会话可以使用 SessionModeWriteOnly，不启动用不到的循环
+	// 单个会话可以在 OnHandshake 中通过 Session.SetMode 修改
+	SessionMode SessionMode
+
+	// OnHandshake 连接开始收发消息之前同步触发，先于 OnConnected，此时尚未读取任何消息
+	// 在这里使用 SendNow 发送的消息一定是第一个写入套接字的消息，返回错误时关闭连接
+	OnHandshake HandshakeFunc
+
+	// OnConnClose 客户端连接关闭触发，此时不再接收消息，但仍然可以发送消息，这些消息会在断开连接之前发送完毕
+	// 此时 Session.Get 仍然可以读取 Session.Set 保存的参数，用于清理会话绑定的资源
 	OnConnClose ConnFunc
 
+	// OnConnReject 连接因超过 MaxConnNum、MaxConnPerIP、未通过 AdmissionCheck，或者超过 HandshakeTimeout 仍未完成秘钥协商被拒绝时触发
+	OnConnReject ConnRejectFunc
+
+	// OnAccept 接受连接之后，创建会话之前触发，返回 false 或者错误时关闭连接，并触发 OnConnReject
+	// conn 支持 Peek，查看的数据不会被消耗，比如根据前几个字节或者 TLS SNI 选择处理方式
+	// 在新的 goroutine 中执行，不会阻塞接受其它连接，仅在 tcp、kcp 下有效
+	OnAccept AcceptFunc
+
+	// ProxyProtocol 连接开头是否为 PROXY protocol v1/v2 头部，位于 HAProxy、ELB 等代理之后时开启
+	// 接受连接之后先读取并去掉头部，会话的 RemoteAddr、MaxConnPerIP 与 OnAccept 均使用头部中的真实客户端地址
+	// 没有有效头部或者超过 ProxyHeaderTimeout 仍未读取完毕时，触发 OnConnReject 并关闭连接，仅在 tcp 下有效
+	// 默认 false
+	ProxyProtocol bool
+
+	// OnUnknownMessage 收到没有匹配路由、也没有通过 Router.SetHandlerFunc 设置默认处理的消息时触发
+	// 触发之后丢弃该消息，会话保持，除非开启了 StrictRouting；回调返回之后消息会被释放
+	OnUnknownMessage UnknownMessageFunc
+
+	// StrictRouting 收到没有匹配路由的消息时关闭会话
+	// 默认 false，只记录日志并丢弃该消息
+	StrictRouting bool
+
+	// BufferFailPolicy 设置连接缓冲区失败时的处理策略
+	// 默认 BufferFailClose
+	BufferFailPolicy BufferFailPolicy
+
+	// SocketReadBuffer 套接字接收缓冲区大小 (SO_RCVBUF)，与 RecvBufferSize 无关
+	// 在 accept 或者客户端连接建立之后设置到连接上，kcp 服务端的连接共用监听套接字，设置到监听器上
+	// 默认 0，表示不设置，使用系统默认值
+	SocketReadBuffer int
+
+	// SocketWriteBuffer 套接字发送缓冲区大小 (SO_SNDBUF)，与 SendBufferSize 无关
+	// 在 accept 或者客户端连接建立之后设置到连接上，kcp 服务端的连接共用监听套接字，设置到监听器上
+	// 默认 0，表示不设置，使用系统默认值
+	SocketWriteBuffer int
+
 	// --------------------------- 封包与解包 ---------------------------
 
 	// Datapack 封包与解包器
 	Datapack Datapack
 
+	// DatapackSwitchable 会话是否可能在握手之后调用 Session.SetDatapack 切换封包工具
+	// 开启后，切换之前接收方每解包一个消息，都会等待该消息处理完毕再继续解包，保证切换发生在消息边界上
+	// 默认 false
+	DatapackSwitchable bool
+
 	// WhetherCompress 是否需要对消息负载进行压缩
 	// 默认 false
 	WhetherCompress bool
 
+	// CompressNegotiation 是否由每个会话在秘钥协商时决定是否压缩，压缩与不压缩的客户端可以连接同一个服务
+	// 客户端在协商请求中声明支持压缩 (见 key.ExchangeKeyRequestWithCompress)，服务端同样开启 WhetherCompress 时该会话压缩，并在协商响应中告知客户端
+	// 开启后未协商压缩的会话不压缩，解包总是按照消息头中的 FlagCompress 解压，需要设置 Compress
+	// 默认 false，所有会话按照 WhetherCompress 压缩
+	CompressNegotiation bool
+
 	// WhetherCrypto 是否需要对消息负载进行加密
 	// 默认 false
 	WhetherCrypto bool
 
+	// HandshakeKick 开启加密时，秘钥协商完成之前收到业务消息，是否断开连接
+	// 默认 false，仅丢弃该消息
+	HandshakeKick bool
+
+	// HandshakeTimeout 开启加密时，连接建立之后需要在该时间内完成秘钥协商，否则触发 OnConnReject (原因为 ErrHandshakeTimeout) 并关闭连接
+	// 避免连接之后不进行秘钥协商的客户端一直占用连接名额，未开启加密时没有秘钥协商，空闲连接见 RecvDeadline
+	// 默认 0，表示不限制
+	HandshakeTimeout time.Duration
+
+	// BroadcastReadyOnly SendAll 与 SendMany 跳过尚未完成秘钥协商的会话，见 Session.Ready
+	// 开启加密时，协商完成之前发送的消息不会被加密，开启后广播消息只发送给已经加密的会话，SendMany 中被跳过的会话返回 ErrHandshakeNotReady
+	// 默认 false，发送给所有会话
+	BroadcastReadyOnly bool
+
+	// MaxKeyExchanges 服务端同时进行的秘钥协商计算 (ECDH) 数量上限，大量连接同时握手时限制 CPU 占用
+	// 超出上限的协商请求排队等待，最多等待 HandshakeTimeout，仍未轮到时触发 OnConnReject (原因为 ErrHandshakeTimeout) 并关闭连接
+	// 默认 0，表示不限制
+	MaxKeyExchanges int
+
+	// ZeroMessageRate 每个会话每秒最多处理的特殊协议消息 (FlagZero) 数量，比如秘钥协商、心跳，与路由消息分开限制
+	// 超出时按照 ZeroLimitKick 处理，默认 0 表示不限制
+	ZeroMessageRate int
+
+	// MaxExchangeKeyRequests 每个会话最多处理的秘钥协商请求数量，正常的客户端只需要一次，避免反复协商占用 CPU
+	// 超出时按照 ZeroLimitKick 处理，默认 0 表示不限制
+	MaxExchangeKeyRequests int
+
+	// ZeroLimitKick 特殊协议消息超过 ZeroMessageRate 或者 MaxExchangeKeyRequests 时是否断开连接，默认 false 只丢弃该消息
+	ZeroLimitKick bool
+
+	// MinCryptoKeySize 秘钥协商得到的秘钥最小长度，不足时秘钥协商失败
+	// 默认 16
+	MinCryptoKeySize int
+
+	// ResumeTTL 断线后保留会话状态的时长，客户端在此期间携带恢复令牌重连，可以恢复会话
+	// <= 0 表示不开启会话恢复
+	// 默认 0
+	ResumeTTL time.Duration
+
+	// ResumeBufferSize 断线后保留会话状态期间，通过 SessionManager.Send 发给该会话的消息最多缓存的数量
+	// 客户端恢复会话之后，缓存的消息排在恢复结果之后按顺序重新发送；超出数量时丢弃最早的消息，过期未恢复时全部丢弃
+	// 默认 0，表示不缓存
+	ResumeBufferSize int
+
+	// ResumeBufferBytes 每个会话缓存的消息负载总长度上限，超出时丢弃最早的消息，见 ResumeBufferSize
+	// 默认 0，表示只按数量限制
+	ResumeBufferBytes int
+
+	// ResumeStore 存储断线后保留的会话状态
+	// 开启会话恢复且未设置时，使用 NewResumeStore()
+	ResumeStore ResumeStore
+
 	// CompressThreshold 压缩的阈值，当消息负载长度超过该值时才会压缩
 	// 默认 0
 	CompressThreshold int
@@ -90,25 +401,56 @@ type Config struct {
 	// Compress 压缩与解压器
 	Compress zerocompress.Compress
 
-	// WhetherChecksum 是否启用校验值功能
+	// MaxDecompressedSize 解压后负载的最大长度，超出则解包失败，避免压缩炸弹耗尽内存
+	// <= 0 表示不限制
+	// 默认 1M
+	MaxDecompressedSize int
+
+	// PayloadPoolMaxSize 解包时负载不超过该长度则从共享缓冲池中获取内存，消息 Release 时归还，减少每个消息的内存分配
+	// 启用后 Payload 只在消息 Release 之前有效，需要在 Release 之后使用 (比如作为响应的负载) 时需要复制
+	// 默认 0，表示不使用缓冲池，每个消息单独分配内存，见 datapack.WithLTDPayloadPool
+	PayloadPoolMaxSize int
+
+	// WhetherChecksum 是否启用校验值功能，与 WhetherCrypto 相互独立，可以只校验不加密
+	// 校验秘钥来自秘钥协商，未协商时使用空秘钥，只能发现传输错误，无法防止篡改
+	// 秘钥协商、恢复会话等特殊协议消息(FlagZero)不计算校验值，此时双方的秘钥可能不同
 	WhetherChecksum bool
+
+	// Checksummer 开启 WhetherChecksum 时计算校验值的算法，通信双方需要一致
+	// 默认 nil，使用 16 字节的 HMAC-MD5，见 datapack.HMACMD5Checksummer；可信的内网链路可以使用 4 字节的 datapack.CRC32Checksummer，只用于发现数据损坏
+	Checksummer Checksummer
+
+	// Codec 编码与解码器，用于 Session.SendProto
+	// 默认 protobuf
+	Codec zerocodec.Codec
 }
 
 // DefaultConfig 默认值
 func DefaultConfig() *Config {
 	config := &Config{
-		MaxConnNum:      -1,
-		Network:         "tcp4",
-		Host:            "127.0.0.1",
-		Port:            8001,
-		Logger:          zerologger.NewSampleLogger(),
-		LoggerLevel:     zerologger.DEBUG,
-		RecvBufferSize:  8 * 1024,
-		RecvQueueSize:   128,
-		SendBufferSize:  8 * 1024,
-		SendQueueSize:   128,
-		CloseTimeout:    5 * time.Second,
-		WhetherChecksum: false,
+		MaxConnNum:       -1,
+		AcceptGoroutines: 1,
+		Network:          "tcp4",
+		Host:             "127.0.0.1",
+		Port:             8001,
+		Logger:           zerologger.NewSampleLogger(),
+		LoggerLevel:      zerologger.DEBUG,
+		RecvBufferSize:   8 * 1024,
+		RecvQueueSize:    128,
+		SendBufferSize:   8 * 1024,
+		SendQueueSize:    128,
+		CloseTimeout:     5 * time.Second,
+		AckTimeout:       5 * time.Second,
+		AckRetries:       2,
+		AckWindow:        64,
+		Linger:           -1,
+		NoDelay:          true,
+		WhetherChecksum:  false,
+
+		MaxMessageSize:      128 * 1024,
+		MaxDecompressedSize: 1024 * 1024,
+		MinCryptoKeySize:    16,
+		Codec:               zeroprotobuf.New(),
 	}
 
 	return config
@@ -125,6 +467,27 @@ func WithMaxConnNum(MaxConnNum int) Option {
 	}
 }
 
+// WithMaxConnPerIP 同一个 IP 的连接数量上限，超过数量则拒绝连接，<= 0 表示不限制
+func WithMaxConnPerIP(maxConnPerIP int) Option {
+	return func(p Peer) {
+		p.SetMaxConnPerIP(maxConnPerIP)
+	}
+}
+
+// WithAdmissionCheck 接受连接之后、创建会话之前调用，返回错误时拒绝该连接
+func WithAdmissionCheck(admissionCheck AdmissionFunc) Option {
+	return func(p Peer) {
+		p.SetAdmissionCheck(admissionCheck)
+	}
+}
+
+// WithAcceptGoroutines 同时在监听器上接收连接的 goroutine 数量，websocket 不受该配置影响，默认 1
+func WithAcceptGoroutines(acceptGoroutines int) Option {
+	return func(p Peer) {
+		p.SetAcceptGoroutines(acceptGoroutines)
+	}
+}
+
 // WithNetwork 可选 "tcp", "tcp4", "tcp6"
 func WithNetwork(network string) Option {
 	return func(p Peer) {
@@ -146,6 +509,13 @@ func WithPort(port int) Option {
 	}
 }
 
+// WithListener 设置外部创建的监听器，Start 时不再监听 Host 与 Port，仅在 tcp、ws 下有效
+func WithListener(ln net.Listener) Option {
+	return func(p Peer) {
+		p.SetListener(ln)
+	}
+}
+
 // WithLogger 设置日志
 func WithLogger(logger zerologger.Logger) Option {
 	return func(p Peer) {
@@ -185,6 +555,34 @@ func WithCloseTimeout(closeTimeout time.Duration) Option {
 	}
 }
 
+// WithStatsInterval 大于 0 时，服务每隔该时长输出一行统计日志
+func WithStatsInterval(statsInterval time.Duration) Option {
+	return func(p Peer) {
+		p.SetStatsInterval(statsInterval)
+	}
+}
+
+// WithShutdownNotice 关闭服务器时，先向所有客户端发送关闭通知，再等待连接关闭
+func WithShutdownNotice(shutdownNotice bool) Option {
+	return func(p Peer) {
+		p.SetShutdownNotice(shutdownNotice)
+	}
+}
+
+// WithShutdownReason 关闭通知中携带的关闭原因，仅在开启 ShutdownNotice 时有效
+func WithShutdownReason(shutdownReason string) Option {
+	return func(p Peer) {
+		p.SetShutdownReason(shutdownReason)
+	}
+}
+
+// WithShutdownRetryAfter 关闭通知中携带的建议重连间隔，仅在开启 ShutdownNotice 时有效
+func WithShutdownRetryAfter(shutdownRetryAfter time.Duration) Option {
+	return func(p Peer) {
+		p.SetShutdownRetryAfter(shutdownRetryAfter)
+	}
+}
+
 // WithRecvBufferSize 在 session 中接收消息 buffer 大小
 func WithRecvBufferSize(recvBufferSize int) Option {
 	return func(p Peer) {
@@ -192,6 +590,27 @@ func WithRecvBufferSize(recvBufferSize int) Option {
 	}
 }
 
+// WithRingBufferSize 在 session 中存储待解包数据的环形缓冲区大小，<= 0 表示使用 RecvBufferSize 的 2 倍
+func WithRingBufferSize(ringBufferSize int) Option {
+	return func(p Peer) {
+		p.SetRingBufferSize(ringBufferSize)
+	}
+}
+
+// WithRingBufferLazy 收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 RingBufferSize
+func WithRingBufferLazy(ringBufferLazy bool) Option {
+	return func(p Peer) {
+		p.SetRingBufferLazy(ringBufferLazy)
+	}
+}
+
+// WithMaxMessageSize 单个完整消息的最大长度，未设置 RingBufferSize 时环形缓冲区按需扩容以容纳该长度的消息
+func WithMaxMessageSize(maxMessageSize int) Option {
+	return func(p Peer) {
+		p.SetMaxMessageSize(maxMessageSize)
+	}
+}
+
 // WithRecvDeadLine 通信超时时间，最终调用 conn.SetReadDeadline
 func WithRecvDeadLine(recvDeadLine time.Duration) Option {
 	return func(p Peer) {
@@ -199,10 +618,31 @@ func WithRecvDeadLine(recvDeadLine time.Duration) Option {
 	}
 }
 
+// WithRecvDeadlineMode 读超时的计算方式，固定超时或者滑动超时
+func WithRecvDeadlineMode(recvDeadlineMode DeadlineMode) Option {
+	return func(p Peer) {
+		p.SetRecvDeadlineMode(recvDeadlineMode)
+	}
+}
+
 // WithRecvQueueSize 在 session 中接收到的消息队列大小，session 接收到消息后并非立即处理，而是丢到一个消息队列中，异步处理
 func WithRecvQueueSize(recvQueueSize int) Option {
 	return func(p Peer) {
-		p.SetRecvBufferSize(recvQueueSize)
+		p.SetRecvQueueSize(recvQueueSize)
+	}
+}
+
+// WithRecvQueueMaxBytes 接收消息队列中缓存的消息负载字节数上限，超出时按照 RecvOverflowPolicy 处理，0 表示不限制
+func WithRecvQueueMaxBytes(recvQueueMaxBytes int) Option {
+	return func(p Peer) {
+		p.SetRecvQueueMaxBytes(recvQueueMaxBytes)
+	}
+}
+
+// WithRecvOverflowPolicy 接收消息队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+func WithRecvOverflowPolicy(recvOverflowPolicy RecvOverflowPolicy) Option {
+	return func(p Peer) {
+		p.SetRecvOverflowPolicy(recvOverflowPolicy)
 	}
 }
 
@@ -227,6 +667,104 @@ func WithSendQueueSize(sendQueueSize int) Option {
 	}
 }
 
+// WithSendQueueMaxSize 发送的消息队列可以增长到的消息数量上限，大于 SendQueueSize 时生效，达到上限后按照 SendOverflowPolicy 处理
+func WithSendQueueMaxSize(sendQueueMaxSize int) Option {
+	return func(p Peer) {
+		p.SetSendQueueMaxSize(sendQueueMaxSize)
+	}
+}
+
+// WithSendOverflowPolicy 发送消息队列已满时的处理策略，队列的上限见 SendQueueMaxSize
+func WithSendOverflowPolicy(sendOverflowPolicy SendOverflowPolicy) Option {
+	return func(p Peer) {
+		p.SetSendOverflowPolicy(sendOverflowPolicy)
+	}
+}
+
+// WithSendRateLimit 每个会话每秒最多发送的字节数，超出时延迟之后的写入，0 表示不限制
+func WithSendRateLimit(sendRateLimit int) Option {
+	return func(p Peer) {
+		p.SetSendRateLimit(sendRateLimit)
+	}
+}
+
+// WithLinger 关闭连接时调用 conn.SetLinger 设置的值，仅在 tcp 下有效，< 0 表示使用系统默认行为
+func WithLinger(linger int) Option {
+	return func(p Peer) {
+		p.SetLinger(linger)
+	}
+}
+
+// WithHalfCloseTimeout 关闭连接时先关闭写方向，等待对方关闭连接的最长时间，仅在 tcp 下有效，0 表示直接关闭
+func WithHalfCloseTimeout(halfCloseTimeout time.Duration) Option {
+	return func(p Peer) {
+		p.SetHalfCloseTimeout(halfCloseTimeout)
+	}
+}
+
+// WithNoDelay 新连接是否禁用 Nagle 算法 (TCP_NODELAY)，仅在 tcp 下有效，默认 true
+func WithNoDelay(noDelay bool) Option {
+	return func(p Peer) {
+		p.SetNoDelay(noDelay)
+	}
+}
+
+// WithHandlerTimeout 处理函数的超时时间，超时之后不再等待，继续处理之后的消息
+func WithHandlerTimeout(handlerTimeout time.Duration) Option {
+	return func(p Peer) {
+		p.SetHandlerTimeout(handlerTimeout)
+	}
+}
+
+// WithHandlerTimeoutCode 处理函数超时时返回给客户端的响应错误码，为 0 时不返回响应
+func WithHandlerTimeoutCode(handlerTimeoutCode uint16) Option {
+	return func(p Peer) {
+		p.SetHandlerTimeoutCode(handlerTimeoutCode)
+	}
+}
+
+// WithSlowHandlerThreshold 处理函数的耗时超过该值时记录一条警告日志，0 表示不记录
+func WithSlowHandlerThreshold(slowHandlerThreshold time.Duration) Option {
+	return func(p Peer) {
+		p.SetSlowHandlerThreshold(slowHandlerThreshold)
+	}
+}
+
+// WithCloseGracePeriod 关闭会话时等待已经开始的处理函数结束的最长时间，其响应仍然会被发送，0 表示不等待
+func WithCloseGracePeriod(closeGracePeriod time.Duration) Option {
+	return func(p Peer) {
+		p.SetCloseGracePeriod(closeGracePeriod)
+	}
+}
+
+// WithAckTimeout SendReliable 发送的消息等待确认的时间，超时之后重发，默认 5 秒
+func WithAckTimeout(ackTimeout time.Duration) Option {
+	return func(p Peer) {
+		p.SetAckTimeout(ackTimeout)
+	}
+}
+
+// WithAckRetries SendReliable 发送的消息超时之后的重发次数，默认 2
+func WithAckRetries(ackRetries int) Option {
+	return func(p Peer) {
+		p.SetAckRetries(ackRetries)
+	}
+}
+
+// WithAckWindow 每个会话等待确认的消息数量上限，默认 64
+func WithAckWindow(ackWindow int) Option {
+	return func(p Peer) {
+		p.SetAckWindow(ackWindow)
+	}
+}
+
+// WithDispatchPool 多个会话共享的消息处理协程池，同一个分片的消息按顺序处理，nil 表示每个会话在自己的协程中处理
+func WithDispatchPool(dispatchPool *DispatchPool) Option {
+	return func(p Peer) {
+		p.SetDispatchPool(dispatchPool)
+	}
+}
+
 // WithOnConnected 客户端连接到来时触发，此时客户端已经可以开始收发消息
 func WithOnConnected(onConnected ConnFunc) Option {
 	return func(p Peer) {
@@ -234,13 +772,90 @@ func WithOnConnected(onConnected ConnFunc) Option {
 	}
 }
 
-// WithOnConnClose 客户端连接关闭触发，此时客户端不可以再收发消息
+// WithOnConnectedAsync 是否在新的 goroutine 中执行 OnConnected，此时消息可能先于 OnConnected 完成被处理
+func WithOnConnectedAsync(onConnectedAsync bool) Option {
+	return func(p Peer) {
+		p.SetOnConnectedAsync(onConnectedAsync)
+	}
+}
+
+// WithSessionMode 会话的收发模式，默认 SessionModeDuplex，见 SessionMode
+func WithSessionMode(sessionMode SessionMode) Option {
+	return func(p Peer) {
+		p.SetSessionMode(sessionMode)
+	}
+}
+
+// WithOnHandshake 连接开始收发消息之前同步触发，先于 OnConnected，返回错误时关闭连接
+func WithOnHandshake(onHandshake HandshakeFunc) Option {
+	return func(p Peer) {
+		p.SetOnHandshake(onHandshake)
+	}
+}
+
+// WithOnConnClose 客户端连接关闭触发，此时不再接收消息，但仍然可以发送消息，这些消息会在断开连接之前发送完毕
 func WithOnConnClose(onConnClose ConnFunc) Option {
 	return func(p Peer) {
 		p.SetOnConnClose(onConnClose)
 	}
 }
 
+// WithOnConnReject 连接因超过 MaxConnNum、MaxConnPerIP，或者超过 HandshakeTimeout 仍未完成秘钥协商被拒绝时触发
+func WithOnConnReject(onConnReject ConnRejectFunc) Option {
+	return func(p Peer) {
+		p.SetOnConnReject(onConnReject)
+	}
+}
+
+// WithOnAccept 接受连接之后，创建会话之前触发，返回 false 或者错误时关闭连接
+func WithOnAccept(onAccept AcceptFunc) Option {
+	return func(p Peer) {
+		p.SetOnAccept(onAccept)
+	}
+}
+
+// WithProxyProtocol 连接开头是否为 PROXY protocol v1/v2 头部，仅在 tcp 下有效
+func WithProxyProtocol(proxyProtocol bool) Option {
+	return func(p Peer) {
+		p.SetProxyProtocol(proxyProtocol)
+	}
+}
+
+// WithOnUnknownMessage 收到没有匹配路由的消息时触发，之后丢弃该消息
+func WithOnUnknownMessage(onUnknownMessage UnknownMessageFunc) Option {
+	return func(p Peer) {
+		p.SetOnUnknownMessage(onUnknownMessage)
+	}
+}
+
+// WithStrictRouting 收到没有匹配路由的消息时关闭会话，默认 false
+func WithStrictRouting(strictRouting bool) Option {
+	return func(p Peer) {
+		p.SetStrictRouting(strictRouting)
+	}
+}
+
+// WithBufferFailPolicy 设置连接缓冲区失败时的处理策略
+func WithBufferFailPolicy(bufferFailPolicy BufferFailPolicy) Option {
+	return func(p Peer) {
+		p.SetBufferFailPolicy(bufferFailPolicy)
+	}
+}
+
+// WithSocketReadBuffer 套接字接收缓冲区大小 (SO_RCVBUF)，0 表示使用系统默认值
+func WithSocketReadBuffer(socketReadBuffer int) Option {
+	return func(p Peer) {
+		p.SetSocketReadBuffer(socketReadBuffer)
+	}
+}
+
+// WithSocketWriteBuffer 套接字发送缓冲区大小 (SO_SNDBUF)，0 表示使用系统默认值
+func WithSocketWriteBuffer(socketWriteBuffer int) Option {
+	return func(p Peer) {
+		p.SetSocketWriteBuffer(socketWriteBuffer)
+	}
+}
+
 // WithDatapack 封包与解包
 func WithDatapack(datapack Datapack) Option {
 	return func(p Peer) {
@@ -248,6 +863,13 @@ func WithDatapack(datapack Datapack) Option {
 	}
 }
 
+// WithDatapackSwitchable 会话是否可能在握手之后切换封包工具，开启后切换之前逐个解包与处理消息
+func WithDatapackSwitchable(datapackSwitchable bool) Option {
+	return func(p Peer) {
+		p.SetDatapackSwitchable(datapackSwitchable)
+	}
+}
+
 // WithWhetherCompress 是否需要对消息负载进行压缩
 func WithWhetherCompress(whetherCompress bool) Option {
 	return func(p Peer) {
@@ -255,6 +877,13 @@ func WithWhetherCompress(whetherCompress bool) Option {
 	}
 }
 
+// WithCompressNegotiation 是否由每个会话在秘钥协商时决定是否压缩
+func WithCompressNegotiation(compressNegotiation bool) Option {
+	return func(p Peer) {
+		p.SetCompressNegotiation(compressNegotiation)
+	}
+}
+
 // WithWhetherCrypto 是否需要对消息负载进行加密
 func WithWhetherCrypto(whetherCrypto bool) Option {
 	return func(p Peer) {
@@ -262,6 +891,90 @@ func WithWhetherCrypto(whetherCrypto bool) Option {
 	}
 }
 
+// WithHandshakeKick 开启加密时，秘钥协商完成之前收到业务消息，是否断开连接
+func WithHandshakeKick(handshakeKick bool) Option {
+	return func(p Peer) {
+		p.SetHandshakeKick(handshakeKick)
+	}
+}
+
+// WithHandshakeTimeout 开启加密时，连接建立之后需要在该时间内完成秘钥协商，否则关闭连接，0 表示不限制
+func WithHandshakeTimeout(handshakeTimeout time.Duration) Option {
+	return func(p Peer) {
+		p.SetHandshakeTimeout(handshakeTimeout)
+	}
+}
+
+// WithBroadcastReadyOnly SendAll 与 SendMany 跳过尚未完成秘钥协商的会话
+func WithBroadcastReadyOnly(broadcastReadyOnly bool) Option {
+	return func(p Peer) {
+		p.SetBroadcastReadyOnly(broadcastReadyOnly)
+	}
+}
+
+// WithMaxKeyExchanges 服务端同时进行的秘钥协商计算数量上限，超出时排队等待，最多等待 HandshakeTimeout，0 表示不限制
+func WithMaxKeyExchanges(maxKeyExchanges int) Option {
+	return func(p Peer) {
+		p.SetMaxKeyExchanges(maxKeyExchanges)
+	}
+}
+
+// WithZeroMessageRate 每个会话每秒最多处理的特殊协议消息数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+func WithZeroMessageRate(zeroMessageRate int) Option {
+	return func(p Peer) {
+		p.SetZeroMessageRate(zeroMessageRate)
+	}
+}
+
+// WithMaxExchangeKeyRequests 每个会话最多处理的秘钥协商请求数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+func WithMaxExchangeKeyRequests(maxExchangeKeyRequests int) Option {
+	return func(p Peer) {
+		p.SetMaxExchangeKeyRequests(maxExchangeKeyRequests)
+	}
+}
+
+// WithZeroLimitKick 特殊协议消息超过 ZeroMessageRate 或者 MaxExchangeKeyRequests 时是否断开连接，默认只丢弃该消息
+func WithZeroLimitKick(zeroLimitKick bool) Option {
+	return func(p Peer) {
+		p.SetZeroLimitKick(zeroLimitKick)
+	}
+}
+
+// WithMinCryptoKeySize 秘钥协商得到的秘钥最小长度，不足时秘钥协商失败
+func WithMinCryptoKeySize(minCryptoKeySize int) Option {
+	return func(p Peer) {
+		p.SetMinCryptoKeySize(minCryptoKeySize)
+	}
+}
+
+// WithResumeTTL 断线后保留会话状态的时长，<= 0 表示不开启会话恢复
+func WithResumeTTL(resumeTTL time.Duration) Option {
+	return func(p Peer) {
+		p.SetResumeTTL(resumeTTL)
+	}
+}
+
+// WithResumeBufferSize 断线后保留会话状态期间最多缓存的消息数量，恢复会话后重新发送，<= 0 表示不缓存
+func WithResumeBufferSize(resumeBufferSize int) Option {
+	return func(p Peer) {
+		p.SetResumeBufferSize(resumeBufferSize)
+	}
+}
+
+// WithResumeBufferBytes 断线后每个会话缓存的消息负载总长度上限，<= 0 表示只按数量限制
+func WithResumeBufferBytes(resumeBufferBytes int) Option {
+	return func(p Peer) {
+		p.SetResumeBufferBytes(resumeBufferBytes)
+	}
+}
+
+// WithResumeStore 存储断线后保留的会话状态
+func WithResumeStore(resumeStore ResumeStore) Option {
+	return func(p Peer) {
+		p.SetResumeStore(resumeStore)
+	}
+}
+
 // WithCompressThreshold 压缩的阈值，当消息负载长度超过该值时才会压缩
 func WithCompressThreshold(compressThreshold int) Option {
 	return func(p Peer) {
@@ -276,9 +989,52 @@ func WithCompress(compress zerocompress.Compress) Option {
 	}
 }
 
+// WithMaxDecompressedSize 解压后负载的最大长度，超出则解包失败，<= 0 表示不限制
+func WithMaxDecompressedSize(maxDecompressedSize int) Option {
+	return func(p Peer) {
+		p.SetMaxDecompressedSize(maxDecompressedSize)
+	}
+}
+
+// WithPayloadPoolMaxSize 解包时负载不超过该长度则从共享缓冲池中获取内存，消息 Release 时归还，0 表示不使用
+func WithPayloadPoolMaxSize(payloadPoolMaxSize int) Option {
+	return func(p Peer) {
+		p.SetPayloadPoolMaxSize(payloadPoolMaxSize)
+	}
+}
+
 // WithWhetherChecksum 是否启用检验值功能
 func WithWhetherChecksum(whetherChecksum bool) Option {
 	return func(p Peer) {
 		p.SetWhetherChecksum(whetherChecksum)
 	}
 }
+
+// WithChecksummer 开启 WhetherChecksum 时计算校验值的算法，默认 HMAC-MD5
+func WithChecksummer(checksummer Checksummer) Option {
+	return func(p Peer) {
+		p.SetChecksummer(checksummer)
+	}
+}
+
+// WithCodec 编码与解码器，用于 Session.SendProto
+func WithCodec(codec zerocodec.Codec) Option {
+	return func(p Peer) {
+		p.SetCodec(codec)
+	}
+}
+
+// WithRouter 使用外部创建的路由器，多个服务可以共用同一个路由器
+func WithRouter(router Router) Option {
+	return func(p Peer) {
+		p.SetRouter(router)
+	}
+}
+
+// WithSessionManager 使用外部创建的会话管理器，多个服务共用时会话 ID 不会重复
+// 任意一个服务关闭时都会关闭其中所有的会话
+func WithSessionManager(sessionManager SessionManager) Option {
+	return func(p Peer) {
+		p.SetSessionManager(sessionManager)
+	}
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"bufio"
+	"net"
+)
+
+// PeekConn 可以查看而不消耗数据的连接，用于 Config.OnAccept
+// 查看过的数据仍然可以被之后的 Read 读取
+type PeekConn struct {
+	net.Conn
+
+	// reader 缓冲查看过的数据
+	reader *bufio.Reader
+
+	// remoteAddr PROXY protocol 头部中的真实客户端地址，为 nil 时使用连接的地址，见 ReadProxyHeader
+	remoteAddr net.Addr
+}
+
+// NewPeekConn 包装 conn，使其支持 Peek
+func NewPeekConn(conn net.Conn) *PeekConn {
+	return &PeekConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+// Peek 查看接下来的 n 个字节，不会消耗数据，数据不足时阻塞
+func (c *PeekConn) Peek(n int) ([]byte, error) {
+	return c.reader.Peek(n)
+}
+
+// Read 先读取查看过的数据，再从连接中读取
+func (c *PeekConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr 对端地址，解析了 PROXY protocol 头部时为真实的客户端地址
+func (c *PeekConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
@@ -0,0 +1,100 @@
+package network
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// PeerCounters 服务启动以来累计的连接数量与收发字节数，可以在多个 goroutine 中同时更新
+// 方法在 nil 上调用时不做任何事情，客户端的会话不需要统计
+type PeerCounters struct {
+	accepted uint64
+	rejected uint64
+	bytesIn  uint64
+	bytesOut uint64
+
+	messages     uint64
+	zeroMessages uint64
+	zeroLimited  uint64
+}
+
+// AddAccepted 创建了一个会话
+func (c *PeerCounters) AddAccepted() {
+	if c != nil {
+		atomic.AddUint64(&c.accepted, 1)
+	}
+}
+
+// AddRejected 拒绝了一个连接
+func (c *PeerCounters) AddRejected() {
+	if c != nil {
+		atomic.AddUint64(&c.rejected, 1)
+	}
+}
+
+// AddBytesIn 从连接读取了 n 个字节
+func (c *PeerCounters) AddBytesIn(n int) {
+	if c != nil && n > 0 {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+	}
+}
+
+// AddBytesOut 向连接写入了 n 个字节
+func (c *PeerCounters) AddBytesOut(n int) {
+	if c != nil && n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+	}
+}
+
+// AddMessage 处理了一个路由消息
+func (c *PeerCounters) AddMessage() {
+	if c != nil {
+		atomic.AddUint64(&c.messages, 1)
+	}
+}
+
+// AddZeroMessage 处理了一个特殊协议消息
+func (c *PeerCounters) AddZeroMessage() {
+	if c != nil {
+		atomic.AddUint64(&c.zeroMessages, 1)
+	}
+}
+
+// AddZeroLimited 拒绝了一个超过限制的特殊协议消息
+func (c *PeerCounters) AddZeroLimited() {
+	if c != nil {
+		atomic.AddUint64(&c.zeroLimited, 1)
+	}
+}
+
+// Fill 将累计值填入 stats
+func (c *PeerCounters) Fill(stats *PeerStats) {
+	stats.Accepted = atomic.LoadUint64(&c.accepted)
+	stats.Rejected = atomic.LoadUint64(&c.rejected)
+	stats.BytesIn = atomic.LoadUint64(&c.bytesIn)
+	stats.BytesOut = atomic.LoadUint64(&c.bytesOut)
+	stats.Messages = atomic.LoadUint64(&c.messages)
+	stats.ZeroMessages = atomic.LoadUint64(&c.zeroMessages)
+	stats.ZeroLimited = atomic.LoadUint64(&c.zeroLimited)
+}
+
+// CountReader 包装 reader，读取的字节数计入 BytesIn，用于直接从套接字读取消息的场景
+func (c *PeerCounters) CountReader(reader io.Reader) io.Reader {
+	if c == nil {
+		return reader
+	}
+
+	return &countReader{reader: reader, counters: c}
+}
+
+// countReader 见 PeerCounters.CountReader
+type countReader struct {
+	reader   io.Reader
+	counters *PeerCounters
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.counters.AddBytesIn(n)
+	return n, err
+}
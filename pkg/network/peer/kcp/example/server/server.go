@@ -115,12 +115,5 @@ func (s *server) reqSayHello(message zeronetwork.Message) (zeronetwork.Message,
 		return nil, err
 	}
 
-	return s.newMessage(message.SN(), ModuleHello, ActionHelloSayResp, res), nil
-}
-
-func (s *server) newMessage(sn uint16, module, action uint8, payload []byte) zeronetwork.Message {
-	flag := uint16(0)
-	code := uint16(0)
-	message := zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
-	return message
+	return zerodatapack.Respond(message, ActionHelloSayResp, res), nil
 }
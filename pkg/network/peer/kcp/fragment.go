@@ -0,0 +1,155 @@
+package kcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrFragmentTooMany 分片数量超过上限，需要调大分片长度
+	ErrFragmentTooMany = errors.New("too many fragments")
+
+	// ErrFragmentInvalid 分片头无效
+	ErrFragmentInvalid = errors.New("invalid fragment")
+)
+
+const (
+	// fragmentHeadLen 分片头长度: 编号(2 字节) + 分片序号(1 字节) + 分片数量(1 字节)
+	fragmentHeadLen = 4
+
+	// fragmentMaxCount 一个帧最多拆分的分片数量
+	fragmentMaxCount = 255
+)
+
+// fragmenter 应用层分片与重组，用于 kcp 非流模式
+// 封包后的帧超过分片长度时，拆分成多个带分片头的数据包发送，接收端收齐后重组为完整的帧再解包
+// 开启后所有数据包都带有分片头，通信双方需要同时开启
+type fragmenter struct {
+	// size 分片长度，不包含分片头
+	size int
+
+	// timeout 分片重组的超时时间，超时仍未收齐的分片会被丢弃
+	timeout time.Duration
+
+	// nextID 下一个帧的编号，仅在发送时使用
+	nextID uint16
+
+	// partials 尚未收齐的帧，仅在接收时使用
+	partials map[uint16]*fragmentPartial
+}
+
+// fragmentPartial 尚未收齐的帧
+type fragmentPartial struct {
+	// parts 已经收到的分片，按分片序号存放
+	parts [][]byte
+
+	// received 已经收到的分片数量
+	received int
+
+	// length 已经收到的分片总长度
+	length int
+
+	// deadline 超过该时间仍未收齐则丢弃
+	deadline time.Time
+}
+
+func newFragmenter(size int, timeout time.Duration) *fragmenter {
+	return &fragmenter{
+		size:     size,
+		timeout:  timeout,
+		partials: make(map[uint16]*fragmentPartial),
+	}
+}
+
+// split 将一个完整的帧拆分为若干分片，每一个分片都带有分片头
+func (f *fragmenter) split(p []byte) ([][]byte, error) {
+	count := (len(p) + f.size - 1) / f.size
+	if count == 0 {
+		count = 1
+	}
+	if count > fragmentMaxCount {
+		return nil, ErrFragmentTooMany
+	}
+
+	id := f.nextID
+	f.nextID++
+
+	fragments := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		start := index * f.size
+		end := start + f.size
+		if end > len(p) {
+			end = len(p)
+		}
+
+		fragment := make([]byte, fragmentHeadLen+end-start)
+		binary.BigEndian.PutUint16(fragment, id)
+		fragment[2] = uint8(index)
+		fragment[3] = uint8(count)
+		copy(fragment[fragmentHeadLen:], p[start:end])
+
+		fragments = append(fragments, fragment)
+	}
+
+	return fragments, nil
+}
+
+// merge 收到一个分片，分片收齐时返回完整的帧，否则返回 nil
+// 分片可以乱序到达，超时仍未收齐的帧会被丢弃
+func (f *fragmenter) merge(p []byte, now time.Time) ([]byte, error) {
+	if len(p) < fragmentHeadLen {
+		return nil, ErrFragmentInvalid
+	}
+
+	id := binary.BigEndian.Uint16(p)
+	index, count := int(p[2]), int(p[3])
+	if count == 0 || index >= count {
+		return nil, ErrFragmentInvalid
+	}
+
+	f.expire(now)
+
+	// 未分片
+	if count == 1 {
+		return p[fragmentHeadLen:], nil
+	}
+
+	partial, ok := f.partials[id]
+	if !ok || len(partial.parts) != count {
+		// 编号被复用，丢弃之前未收齐的帧
+		partial = &fragmentPartial{
+			parts:    make([][]byte, count),
+			deadline: now.Add(f.timeout),
+		}
+		f.partials[id] = partial
+	}
+
+	if partial.parts[index] == nil {
+		partial.parts[index] = append([]byte{}, p[fragmentHeadLen:]...)
+		partial.received++
+		partial.length += len(p) - fragmentHeadLen
+	}
+
+	if partial.received < count {
+		return nil, nil
+	}
+
+	delete(f.partials, id)
+
+	frame := make([]byte, 0, partial.length)
+	for _, part := range partial.parts {
+		frame = append(frame, part...)
+	}
+
+	return frame, nil
+}
+
+// expire 丢弃超时仍未收齐的帧
+func (f *fragmenter) expire(now time.Time) {
+	for id, partial := range f.partials {
+		if now.After(partial.deadline) {
+			delete(f.partials, id)
+		}
+	}
+}
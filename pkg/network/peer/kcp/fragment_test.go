@@ -0,0 +1,66 @@
+package kcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFragmentOutOfOrder(t *testing.T) {
+	sender := newFragmenter(100, time.Second)
+	receiver := newFragmenter(100, time.Second)
+
+	frame := make([]byte, 350)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+
+	fragments, err := sender.split(frame)
+	if err != nil {
+		t.Fatalf("split failed: %s", err.Error())
+	}
+	if len(fragments) != 4 {
+		t.Fatalf("unexpected fragments: %d", len(fragments))
+	}
+
+	now := time.Now()
+	for _, i := range []int{2, 0, 3} {
+		if p, err := receiver.merge(fragments[i], now); err != nil || p != nil {
+			t.Fatalf("merged before all fragments arrived, err: %v", err)
+		}
+	}
+
+	p, err := receiver.merge(fragments[1], now)
+	if err != nil {
+		t.Fatalf("merge failed: %s", err.Error())
+	}
+	if !bytes.Equal(p, frame) {
+		t.Fatal("unexpected frame")
+	}
+}
+
+func TestFragmentTimeout(t *testing.T) {
+	sender := newFragmenter(100, time.Second)
+	receiver := newFragmenter(100, time.Second)
+
+	lost, _ := sender.split(make([]byte, 150))
+	next, _ := sender.split([]byte("zero-node"))
+
+	// 第二个分片丢失，超时后被丢弃，不影响后续的帧
+	now := time.Now()
+	if _, err := receiver.merge(lost[0], now); err != nil {
+		t.Fatalf("merge failed: %s", err.Error())
+	}
+
+	p, err := receiver.merge(next[0], now.Add(2*time.Second))
+	if err != nil || string(p) != "zero-node" {
+		t.Fatalf("unexpected frame: %s, err: %v", p, err)
+	}
+	if len(receiver.partials) != 0 {
+		t.Fatalf("expired fragments not dropped: %d", len(receiver.partials))
+	}
+
+	if _, err := receiver.merge([]byte{0, 1}, now); err != ErrFragmentInvalid {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package kcp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// newEchoServer 在随机端口上启动服务，原样返回消息负载，返回监听端口
+func newEchoServer(t testing.TB, opts ...Option) int {
+	s := NewServer(opts...).WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		// accept 得到的连接共用监听套接字，无法单独设置缓冲区
+		zeronetwork.WithBufferFailPolicy(zeronetwork.BufferFailIgnore),
+	).(*server)
+
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+
+	ln, err := s.newListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	s.ln = ln
+	go s.serve(ln.AcceptKCP)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return ln.Addr().(*net.UDPAddr).Port
+}
+
+// echo 连接服务并发送一个消息，返回是否在 timeout 内收到响应
+func echo(t *testing.T, port int, timeout time.Duration, opts ...ClientOption) bool {
+	responses := make(chan zeronetwork.Message, 1)
+	opts = append(opts, WithClientLoggerLevel(zerologger.INFO))
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		// 处理函数返回之后消息会被释放，复制一份交给测试
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}, opts...)
+
+	if err := c.Connect("udp", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("zero-node"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+
+	select {
+	case message := <-responses:
+		if string(message.Payload()) != "zero-node" {
+			t.Fatalf("unexpected response: %s", message.String())
+		}
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestBlockCrypt(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	port := newEchoServer(t, WithBlockCrypt(key, "aes"), WithDatashard(4), WithParityshard(2))
+
+	if !echo(t, port, 2*time.Second, WithClientBlockCrypt(key, "aes"), WithClientDatashard(4), WithClientParityshard(2)) {
+		t.Fatal("no response with the same block crypt")
+	}
+
+	// 秘钥不一致时，服务端无法解析数据包
+	if echo(t, port, 300*time.Millisecond, WithClientBlockCrypt([]byte("fedcba9876543210"), "aes"), WithClientDatashard(4), WithClientParityshard(2)) {
+		t.Fatal("response received with mismatched block crypt key")
+	}
+}
+
+func TestFragment(t *testing.T) {
+	port := newEchoServer(t, WithStreamMode(false), WithFragmentSize(512))
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}, WithClientFragmentSize(512), WithClientLoggerLevel(zerologger.INFO))
+
+	if err := c.Connect("udp", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	// 超过分片长度与 mtu 的消息
+	payload := make([]byte, 4000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload)); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+
+	select {
+	case message := <-responses:
+		if !bytes.Equal(message.Payload(), payload) {
+			t.Fatalf("unexpected payload length: %d", len(message.Payload()))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for response")
+	}
+}
+
+func TestUnknownBlockCrypt(t *testing.T) {
+	c := NewClient(nil, WithClientBlockCrypt([]byte("0123456789abcdef"), "unknown"), WithClientLoggerLevel(zerologger.FATAL))
+	if err := c.Connect("udp", "127.0.0.1", 1); err != ErrUnknownBlockCrypt {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+// connectEchoClient 连接服务，收到的响应放入返回的通道
+func connectEchoClient(tb testing.TB, port int) (zeronetwork.Client, chan zeronetwork.Message) {
+	responses := make(chan zeronetwork.Message, 8)
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+
+	if err := c.Connect("udp", "127.0.0.1", port); err != nil {
+		tb.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	tb.Cleanup(c.Close)
+
+	return c, responses
+}
+
+// echoPayload 发送 payload 并等待响应，响应负载必须与 payload 一致
+func echoPayload(tb testing.TB, c zeronetwork.Client, responses chan zeronetwork.Message, payload []byte) {
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload)); err != nil {
+		tb.Fatalf("send failed: %s", err.Error())
+	}
+
+	select {
+	case message := <-responses:
+		if !bytes.Equal(message.Payload(), payload) {
+			tb.Fatalf("unexpected response: %s, expected: %s", message.Payload(), payload)
+		}
+	case <-time.After(2 * time.Second):
+		tb.Fatal("timeout waiting for response")
+	}
+}
+
+func TestRecvPollers(t *testing.T) {
+	port := newEchoServer(t, WithRecvPollers(2))
+
+	clients := make([]zeronetwork.Client, 5)
+	responses := make([]chan zeronetwork.Message, len(clients))
+	for i := range clients {
+		clients[i], responses[i] = connectEchoClient(t, port)
+	}
+
+	// 每一个会话只收到自己的响应，并且保持顺序
+	for round := 0; round < 3; round++ {
+		for i, c := range clients {
+			echoPayload(t, c, responses[i], []byte(fmt.Sprintf("client-%d-%d", i, round)))
+		}
+	}
+}
+
+func TestRecvPollersBlockedSession(t *testing.T) {
+	s := NewServer(WithRecvPollers(1)).WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithBufferFailPolicy(zeronetwork.BufferFailIgnore),
+		zeronetwork.WithRecvQueueSize(1),
+	).(*server)
+
+	// 模块 1 动作 2 的处理函数一直阻塞，直到 release 关闭
+	var handled int32
+	release := make(chan struct{})
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil, nil
+	})
+
+	ln, err := s.newListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	s.ln = ln
+	go s.serve(ln.AcceptKCP)
+	t.Cleanup(func() { _ = s.Close() })
+	port := ln.Addr().(*net.UDPAddr).Port
+
+	// 两个会话共用同一个 poller，第一个会话的 recvQueue 被阻塞的处理函数占满
+	blocked, _ := connectEchoClient(t, port)
+	const total = 10
+	for i := 0; i < total; i++ {
+		if err := blocked.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// 另一个会话仍然可以收发消息
+	c, responses := connectEchoClient(t, port)
+	for round := 0; round < 3; round++ {
+		echoPayload(t, c, responses, []byte(fmt.Sprintf("round-%d", round)))
+	}
+
+	// 阻塞解除之后，暂停期间的消息继续被处理
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&handled) != total && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&handled); n != total {
+		t.Fatalf("unexpected handled: %d, want: %d", n, total)
+	}
+}
+
+// BenchmarkRecvPollers 比较共享读取协程与每个会话各自读取时的 goroutine 数量
+func BenchmarkRecvPollers(b *testing.B) {
+	for _, recvPollers := range []int{0, 2} {
+		b.Run(fmt.Sprintf("pollers-%d", recvPollers), func(b *testing.B) {
+			base := runtime.NumGoroutine()
+			port := newEchoServer(b, WithRecvPollers(recvPollers))
+
+			clients := make([]zeronetwork.Client, 100)
+			responses := make([]chan zeronetwork.Message, len(clients))
+			for i := range clients {
+				clients[i], responses[i] = connectEchoClient(b, port)
+				echoPayload(b, clients[i], responses[i], []byte("zero-node"))
+			}
+			goroutines := runtime.NumGoroutine() - base
+
+			payload := []byte("zero-node")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index := i % len(clients)
+				echoPayload(b, clients[index], responses[index], payload)
+			}
+
+			b.ReportMetric(float64(goroutines), "goroutines")
+		})
+	}
+}
@@ -1,5 +1,45 @@
 package kcp
 
+import (
+	"errors"
+	"time"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+var (
+	// ErrUnknownBlockCrypt 不支持的 kcp 加密算法
+	ErrUnknownBlockCrypt = errors.New("unknown kcp block crypt")
+)
+
+// blockCrypts kcp 支持的加密算法，秘钥长度要求见 kcp-go
+var blockCrypts = map[string]func(key []byte) (kcp.BlockCrypt, error){
+	"aes":      kcp.NewAESBlockCrypt,
+	"salsa20":  kcp.NewSalsa20BlockCrypt,
+	"sm4":      kcp.NewSM4BlockCrypt,
+	"twofish":  kcp.NewTwofishBlockCrypt,
+	"3des":     kcp.NewTripleDESBlockCrypt,
+	"cast5":    kcp.NewCast5BlockCrypt,
+	"blowfish": kcp.NewBlowfishBlockCrypt,
+	"tea":      kcp.NewTEABlockCrypt,
+	"xtea":     kcp.NewXTEABlockCrypt,
+	"xor":      kcp.NewSimpleXORBlockCrypt,
+	"none":     kcp.NewNoneBlockCrypt,
+}
+
+// WriteTimeoutPolicy 写入套接字超时时的处理策略
+type WriteTimeoutPolicy int
+
+const (
+	// WriteTimeoutClose 关闭该会话，默认
+	WriteTimeoutClose WriteTimeoutPolicy = iota
+
+	// WriteTimeoutDrop 丢弃该消息，继续发送后续消息
+	// kcp 每一次写入要么全部放入发送窗口，要么超时一个字节都不写入，丢弃消息不会破坏后续的帧
+	// 开启分片时，已经写入的分片会在接收端超时后丢弃
+	WriteTimeoutDrop
+)
+
 // Config KCP 的一些专属配置
 type Config struct {
 	// streamMode 是否启用流模式
@@ -30,6 +70,20 @@ type Config struct {
 	sockbuf int
 	// tcp 是否使用 tcp 传输
 	tcp bool
+	// blockCryptKey kcp 传输层加密秘钥，与消息负载的加密相互独立
+	blockCryptKey []byte
+	// blockCryptAlgo kcp 传输层加密算法，为空表示不加密
+	blockCryptAlgo string
+	// fragmentSize 应用层分片长度，封包后超过该长度的帧会拆分发送，0 表示不分片，用于非流模式
+	fragmentSize int
+	// fragmentTimeout 分片重组的超时时间，超时仍未收齐的帧会被丢弃
+	fragmentTimeout time.Duration
+	// writeTimeoutPolicy 写入套接字超过 SendDeadline 时的处理策略，默认关闭会话
+	writeTimeoutPolicy WriteTimeoutPolicy
+	// recvPollers 共享读取协程的数量，所有会话由这些协程轮询读取，0 表示每一个会话使用各自的读取协程
+	recvPollers int
+	// recvPollInterval 共享读取协程一轮都没有读取到数据时的休眠时间
+	recvPollInterval time.Duration
 }
 
 func defaultConfig() *Config {
@@ -48,7 +102,24 @@ func defaultConfig() *Config {
 		nc:          1,
 		sockbuf:     4096,
 		tcp:         false,
+
+		fragmentTimeout:  5 * time.Second,
+		recvPollInterval: time.Millisecond,
+	}
+}
+
+// blockCrypt 根据配置创建 kcp 传输层加密工具，未配置时返回 nil
+func (c *Config) blockCrypt() (kcp.BlockCrypt, error) {
+	if c.blockCryptAlgo == "" {
+		return nil, nil
 	}
+
+	newBlockCrypt, ok := blockCrypts[c.blockCryptAlgo]
+	if !ok {
+		return nil, ErrUnknownBlockCrypt
+	}
+
+	return newBlockCrypt(c.blockCryptKey)
 }
 
 // Option 设置配置选项
@@ -151,3 +222,51 @@ func WithTCP(tcp bool) Option {
 		s.kcpConfig.tcp = tcp
 	}
 }
+
+// WithBlockCrypt kcp 传输层加密，客户端需要使用相同的秘钥与算法
+// algo: aes, salsa20, sm4, twofish, 3des, cast5, blowfish, tea, xtea, xor, none
+func WithBlockCrypt(key []byte, algo string) Option {
+	return func(s *server) {
+		s.kcpConfig.blockCryptKey = key
+		s.kcpConfig.blockCryptAlgo = algo
+	}
+}
+
+// WithFragmentSize 应用层分片长度，封包后超过该长度的帧会拆分发送，0 表示不分片
+// 用于非流模式，避免超过 mtu 的消息被截断，客户端需要使用相同的设置
+// 分片长度加上分片头(4 字节)不能超过 RecvBufferSize
+func WithFragmentSize(fragmentSize int) Option {
+	return func(s *server) {
+		s.kcpConfig.fragmentSize = fragmentSize
+	}
+}
+
+// WithFragmentTimeout 分片重组的超时时间，超时仍未收齐的帧会被丢弃，默认 5 秒
+func WithFragmentTimeout(fragmentTimeout time.Duration) Option {
+	return func(s *server) {
+		s.kcpConfig.fragmentTimeout = fragmentTimeout
+	}
+}
+
+// WithWriteTimeoutPolicy 写入套接字超过 SendDeadline 时的处理策略，默认 WriteTimeoutClose，关闭会话
+func WithWriteTimeoutPolicy(writeTimeoutPolicy WriteTimeoutPolicy) Option {
+	return func(s *server) {
+		s.kcpConfig.writeTimeoutPolicy = writeTimeoutPolicy
+	}
+}
+
+// WithRecvPollers 使用 recvPollers 个共享的协程轮询读取所有会话，代替每一个会话各自的读取协程，0 表示不开启
+// 会话较多且大部分空闲时可以减少 goroutine 数量，代价是读取延迟最多增加 RecvPollInterval，以及空闲时的轮询开销
+// 同一个会话只由一个协程读取，消息顺序不变；处理消息较慢导致 RecvQueueSize 被填满时，会阻塞同一个协程中的其它会话
+func WithRecvPollers(recvPollers int) Option {
+	return func(s *server) {
+		s.kcpConfig.recvPollers = recvPollers
+	}
+}
+
+// WithRecvPollInterval 共享读取协程一轮都没有读取到数据时的休眠时间，默认 1 毫秒
+func WithRecvPollInterval(recvPollInterval time.Duration) Option {
+	return func(s *server) {
+		s.kcpConfig.recvPollInterval = recvPollInterval
+	}
+}
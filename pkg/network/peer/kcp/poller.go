@@ -0,0 +1,295 @@
+package kcp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// poller 共享的读取协程，轮询多个会话的连接，代替每一个会话各自的 recvLoop，用于减少 goroutine 数量
+// kcp-go 没有提供可读事件，poller 使用已经过期的读超时进行非阻塞读取，一轮都没有读取到数据时休眠 interval
+// 同一个会话只由一个 poller 读取，保证消息顺序，读取到的数据与 recvLoop 一样经过分片重组与 Datapack 解包
+// poller 不会等待某一个会话：recvQueue 已满，或者需要等待的消息 (见 needBarrier) 尚未处理完毕时，暂停读取该会话，之后的轮询中再继续
+type poller struct {
+	// interval 一轮都没有读取到数据时的休眠时间
+	interval time.Duration
+
+	// sessions 正在轮询的会话
+	sessions []*polledSession
+
+	// mutex 保护 sessions
+	mutex sync.Mutex
+
+	// startOnce 添加第一个会话时才开始轮询
+	startOnce sync.Once
+
+	// closeOnce 防止多次关闭
+	closeOnce sync.Once
+
+	// closeCh 关闭之后停止轮询
+	closeCh chan bool
+}
+
+// polledSession 被轮询的会话以及接收状态
+type polledSession struct {
+	session *session
+
+	// recvBuffer 存储从套接字读取的数据
+	recvBuffer *zeronetwork.RecvBuffer
+
+	// lastRecv 最后一次读取到数据的时间，用于 RecvDeadline
+	lastRecv time.Time
+
+	// pending 已经解包，尚未放入 recvQueue 的消息
+	pending []zeronetwork.Message
+
+	// barrier 已经放入需要等待的消息，处理完毕之前不再解包与读取
+	barrier bool
+}
+
+func newPoller(interval time.Duration) *poller {
+	return &poller{
+		interval: interval,
+		closeCh:  make(chan bool),
+	}
+}
+
+// add 开始轮询会话
+func (p *poller) add(s *session) error {
+	headLen := s.config.Datapack.HeadLen()
+	if s.config.RecvBufferSize < headLen {
+		return fmt.Errorf("recvBufferSize: %d less than headLen: %d", s.config.RecvBufferSize, headLen)
+	}
+
+	// 读超时一直保持过期，没有数据时 Read 立即返回
+	if err := s.conn.SetReadDeadline(time.Now()); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.sessions = append(p.sessions, &polledSession{
+		session:    s,
+		recvBuffer: zeronetwork.NewRecvBuffer(s.config),
+		lastRecv:   time.Now(),
+	})
+	p.mutex.Unlock()
+
+	p.startOnce.Do(func() {
+		go p.run()
+	})
+
+	return nil
+}
+
+// remove 停止轮询会话
+func (p *poller) remove(ps *polledSession) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, v := range p.sessions {
+		if v == ps {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// close 停止轮询，不会关闭正在轮询的会话
+func (p *poller) close() {
+	p.closeOnce.Do(func() {
+		close(p.closeCh)
+	})
+}
+
+// run 循环轮询所有会话
+func (p *poller) run() {
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	var sessions []*polledSession
+
+	for {
+		p.mutex.Lock()
+		sessions = append(sessions[:0], p.sessions...)
+		p.mutex.Unlock()
+
+		received := false
+		now := time.Now()
+		for _, ps := range sessions {
+			ok, err := ps.poll(now)
+			if err != nil {
+				p.remove(ps)
+				ps.release()
+				ps.logRecvError(err)
+				ps.session.setCloseReason(zeronetwork.ReadCloseReason(err))
+				// 关闭会话会等待发送完毕，不能阻塞其它会话的读取
+				go ps.session.Close()
+				continue
+			}
+			received = received || ok
+		}
+
+		if received {
+			select {
+			case <-p.closeCh:
+				return
+			default:
+			}
+			continue
+		}
+
+		timer.Reset(p.interval)
+		select {
+		case <-p.closeCh:
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// poll 非阻塞读取一次会话的连接，返回是否读取到数据，返回错误时需要关闭会话
+func (ps *polledSession) poll(now time.Time) (received bool, err error) {
+	s := ps.session
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("recover p: %+v", p)
+		}
+	}()
+
+	// 会话正在关闭
+	if s.isStopRecv.Load() {
+		return false, io.ErrClosedPipe
+	}
+
+	// 先处理已经读取的数据，暂停期间不读取新的数据，由 kcp 的接收窗口限制对端
+	if ok, err := ps.deliver(); !ok || err != nil {
+		return false, err
+	}
+
+	readBuffer := zeronetwork.GetReadBuffer(s.config.RecvBufferSize)
+	defer zeronetwork.PutReadBuffer(readBuffer)
+
+	size, err := s.conn.Read(*readBuffer)
+	if err != nil {
+		if !isTimeout(err) {
+			return false, err
+		}
+
+		// 没有可读数据，超过 RecvDeadline 仍未读取到数据时关闭会话
+		if s.config.RecvDeadline > 0 && now.Sub(ps.lastRecv) > s.config.RecvDeadline {
+			return false, os.ErrDeadlineExceeded
+		}
+
+		return false, nil
+	}
+
+	ps.lastRecv = now
+	s.peerCounters.AddBytesIn(size)
+
+	if err := s.writeFrame(ps.recvBuffer, (*readBuffer)[:size]); err != nil {
+		return false, err
+	}
+	if _, err := ps.deliver(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// deliver 解包 recvBuffer 中的消息并放入 recvQueue，与 unpackAll 相同但是不会等待
+// 返回 false 表示需要暂停读取该会话，未能放入的消息保留在 pending 中
+func (ps *polledSession) deliver() (bool, error) {
+	s := ps.session
+
+	for {
+		if ps.barrier {
+			select {
+			case <-s.barrierCh:
+				ps.barrier = false
+			default:
+				return false, nil
+			}
+		}
+
+		if len(ps.pending) == 0 {
+			messages, err := s.unpack(ps.recvBuffer)
+			if err != nil {
+				s.logger.Errorf("unpack failed: %s", err.Error())
+				return false, err
+			}
+			if len(messages) == 0 {
+				return true, nil
+			}
+			ps.pending = messages
+		}
+
+		// 需要在放入 recvQueue 之前判断，与 dispatchLoop 的判断保持一致
+		message := ps.pending[0]
+		barrier := s.needBarrier(message)
+
+		ok, err := s.tryEnqueue(message)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+
+		ps.pending[0] = nil
+		ps.pending = ps.pending[1:]
+		ps.barrier = barrier
+	}
+}
+
+// release 停止轮询之后释放尚未放入 recvQueue 的消息
+func (ps *polledSession) release() {
+	for _, message := range ps.pending {
+		message.Release()
+	}
+	ps.pending = nil
+}
+
+// logRecvError 记录停止轮询的原因，与 recvLoop 一致
+func (ps *polledSession) logRecvError(err error) {
+	s := ps.session
+
+	if zeronetwork.IsEOFOrReadError(err) {
+		if s.config.Logger.IsDebugAble() {
+			s.logger.Debugf("stop polling: %s", err.Error())
+		}
+		return
+	}
+
+	s.logger.Errorf("read failed: %s", err.Error())
+}
+
+// pollerGroup 一组 poller，会话按照 ID 分配到其中一个 poller
+type pollerGroup struct {
+	pollers []*poller
+}
+
+func newPollerGroup(size int, interval time.Duration) *pollerGroup {
+	g := &pollerGroup{pollers: make([]*poller, size)}
+	for i := range g.pollers {
+		g.pollers[i] = newPoller(interval)
+	}
+
+	return g
+}
+
+// add 开始轮询会话
+func (g *pollerGroup) add(s *session) error {
+	return g.pollers[s.ID()%uint64(len(g.pollers))].add(s)
+}
+
+// close 停止所有 poller
+func (g *pollerGroup) close() {
+	for _, p := range g.pollers {
+		p.close()
+	}
+}
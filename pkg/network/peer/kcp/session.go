@@ -1,31 +1,41 @@
 package kcp
 
 import (
+	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	kcp "github.com/xtaci/kcp-go/v5"
-
-	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
 	zeronetworkkey "github.com/zerogo-hub/zero-node/pkg/network/key"
 	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
 )
 
+// 错误定义见 zeronetwork，这里是别名，可以使用 errors.Is 判断
 var (
 	// ErrWriteNotAll 未能将信息全部写入
-	ErrWriteNotAll = errors.New("write not all")
+	ErrWriteNotAll = zeronetwork.ErrWriteNotAll
 
 	// ErrStopSend 已关闭，不再收发消息
-	ErrStopSend = errors.New("stop send message")
+	ErrStopSend = zeronetwork.ErrStopSend
+
+	// ErrWriteTimeout 写入超时，放入发送队列超时 3 秒，或者写入套接字超过 SendDeadline
+	ErrWriteTimeout = zeronetwork.ErrWriteTimeout
 
-	// ErrWriteTimeout 放入发送队列超时 3秒
-	ErrWriteTimeout = errors.New("write timeout")
+	// ErrPrivateKeyEmpty 秘钥协商时私钥为空，可能尚未发起秘钥协商请求
+	ErrPrivateKeyEmpty = zeronetwork.ErrPrivateKeyEmpty
+
+	// ErrRandomValueEmpty 秘钥协商时随机值为空，可能尚未发起秘钥协商请求
+	ErrRandomValueEmpty = zeronetwork.ErrRandomValueEmpty
 )
 
 // session 会话，实现 network.go/Session 接口
@@ -42,62 +52,223 @@ type session struct {
 	// config 一些通用配置
 	config *zeronetwork.Config
 
+	// logger 会话日志，每一行带上会话 ID 与远端地址
+	logger zerologger.Logger
+
 	// sessionID 会话 ID，每一条链接都有一个唯一的 ID
 	sessionID zeronetwork.SessionID
 
 	// conn 客户端与服务器链接成功后的原始套接字，由 Accept() 生成
-	conn *kcp.UDPSession
+	conn net.Conn
+
+	// remoteAddr 远端地址，设置连接时缓存，同一个连接的地址不会变化，见 setConn
+	remoteAddr net.Addr
+
+	// remoteAddrString remoteAddr 的字符串形式，每一行会话日志都会输出，避免重复格式化
+	remoteAddrString string
 
 	// closeOnce 防止多次关闭会话
 	closeOnce sync.Once
 
 	// isStopRecv 是否停止接收消息
-	isStopRecv bool
+	isStopRecv atomic.Bool
 
 	// isStopSend 是否停止发送消息
 	isStopSend bool
 
-	// sendQueue 发送消息队列
-	sendQueue chan *sendElement
+	// isStopInflight 是否不再接收先于 Close 开始的处理函数的响应，见 Config.CloseGracePeriod
+	isStopInflight bool
+
+	// inflight 正在执行的处理函数
+	inflight zeronetwork.InflightHandlers
+
+	// sendMutex 保护 isStopSend 与放入 sendQueue 的过程
+	// 关闭会话时获取写锁，保证关闭 sendQueue 之后不会再有消息放入
+	sendMutex sync.RWMutex
+
+	// sendQueue 发送消息队列，见 Config.SendQueueMaxSize
+	sendQueue *zeronetwork.SendQueue
 
 	// sendWait 用于保证消息全部发送完成
 	sendWait sync.WaitGroup
 
+	// sendPending 已经放入 sendQueue，尚未写入套接字的消息数量
+	sendPending int32
+
+	// sendLooping sendLoop 是否正在运行
+	sendLooping int32
+
+	// mode 收发模式，见 zeronetwork.SessionMode
+	mode int32
+
+	// modeFixed 收发循环已经开始，不能再修改 mode，见 SetMode
+	modeFixed int32
+
+	// writeMutex 写锁，SendNow 与 sendLoop 写入套接字时都需要获取
+	writeMutex sync.Mutex
+
 	// recvQueue 存储接收到的消息
 	recvQueue chan zeronetwork.Message
 
+	// recvBytes recvQueue 中缓存的消息负载字节数，见 Config.RecvQueueMaxBytes
+	recvBytes *zeronetwork.RecvQueueBytes
+
 	// closeCh 关闭会话的信号
 	closeCh chan bool
 
+	// loops 正在运行的收发循环与心跳，全部退出之后关闭 closed，见 goLoop
+	loops sync.WaitGroup
+
+	// loopsMutex 保护 loopsWaiting
+	loopsMutex sync.Mutex
+
+	// loopsWaiting Close 已经开始等待 loops，之后的 Run 不再启动收发循环
+	loopsWaiting bool
+
+	// closed 会话完全关闭的信号，见 Closed
+	closed chan struct{}
+
+	// barrierCh 需要等待的消息处理完毕后通知 recvLoop，见 needBarrier
+	barrierCh chan bool
+
 	// closeCallback 关闭会话后的回调
 	// 先于 config.OnConnClose 触发
 	closeCallback zeronetwork.CloseCallbackFunc
 
-	// crypto 消息负载的加密与解密
-	crypto zeronetwork.Crypto
+	// crypto 消息负载的加密与解密，保存 cryptoValue，收发循环与 SetCrypto 可能在不同的 goroutine 中执行
+	crypto atomic.Value
+
+	// encrypted 是否已经设置了加解密工具，见 IsEncrypted
+	encrypted int32
+
+	// compressed 秘钥协商时是否协商了压缩，开启 Config.CompressNegotiation 时有效，见 IsCompressed
+	compressed int32
+
+	// checksumKey 秘钥，用于校验消息的完整性，保存 []byte
+	checksumKey atomic.Value
+
+	// recvDatapack 切换之后解包使用的封包工具，未切换时使用 config.Datapack
+	recvDatapack atomic.Value
+
+	// sendDatapack 切换之后封包使用的封包工具，未切换时使用 config.Datapack，受 writeMutex 保护
+	sendDatapack zeronetwork.Datapack
+
+	// datapackSwitched 是否已经切换过封包工具
+	datapackSwitched int32
+
+	// handshakeState 秘钥协商状态，见 zeronetwork.HandshakeState
+	handshakeState int32
+
+	// resumeToken 恢复令牌，服务端为连接分配，客户端为收到的令牌
+	resumeToken atomic.Value
+
+	// resumeCallback 恢复会话后的回调，仅服务端设置
+	resumeCallback zeronetwork.ResumeCallbackFunc
+
+	// resumeBuffer 关闭时保留会话状态，缓存断线期间发给该会话的消息，见 Config.ResumeBufferSize
+	resumeBuffer *zeronetwork.ResumeBuffer
+
+	// redirectCallback 收到重定向通知后的回调，仅客户端设置
+	redirectCallback zeronetwork.RedirectFunc
 
-	// checksumKey 秘钥，用于校验消息的完整性
-	checksumKey []byte
+	// shutdownCallback 收到服务端关闭通知后的回调，仅客户端设置
+	shutdownCallback zeronetwork.ShutdownFunc
+
+	// heartbeatInterval 发送心跳的间隔，为 0 时不发送，仅客户端设置
+	heartbeatInterval time.Duration
+
+	// closeReason 会话关闭的原因，见 zeronetwork.CloseReason，只记录第一次设置的原因
+	closeReason int32
+
+	// heartbeatEpoch 心跳负载中的发送时间为相对于该时间的纳秒数，不受系统时钟调整的影响
+	heartbeatEpoch time.Time
+
+	// rtt 平滑之后的往返时间，单位纳秒
+	rtt int64
+
+	// isClient 是否为客户端的会话，由 NewClient 设置，决定可以处理哪些特殊协议
+	isClient bool
+
+	// autoSN 发送 SN 为 0 的消息时是否自动分配 SN，仅客户端开启
+	autoSN bool
+
+	// sn 上一次自动分配的 SN
+	sn uint32
+
+	// fragmenter 应用层分片与重组，未开启时为 nil
+	fragmenter *fragmenter
+
+	// writeTimeoutPolicy 写入套接字超时时的处理策略
+	writeTimeoutPolicy WriteTimeoutPolicy
+
+	// pollers 共享的读取协程，为 nil 时使用会话自身的 recvLoop
+	pollers *pollerGroup
 
 	// handler 用于处理存储于 recvQueue 中的消息
 	handler zeronetwork.HandlerFunc
 
-	// paramters 自定义参数
+	// contextHandler 可以感知超时的处理函数，仅服务端设置，为 nil 时使用 handler
+	contextHandler zeronetwork.ContextHandlerFunc
+
+	// water 发送队列与接收队列的最高水位
+	water zeronetwork.QueueWater
+
+	// peerWater 服务中所有会话的最高水位，仅服务端设置
+	peerWater *zeronetwork.QueueWater
+
+	// peerCounters 服务中所有会话累计的收发字节数，仅服务端设置
+	peerCounters *zeronetwork.PeerCounters
+
+	// keyExchangeSlots 服务中同时进行的秘钥协商计算数量，仅服务端设置
+	keyExchangeSlots *zeronetwork.KeyExchangeSlots
+
+	// shapedDelay 因为 Config.SendRateLimit 而延迟发送的累计时间，单位纳秒
+	shapedDelay int64
+
+	// paramters 自定义参数，会话关闭时不清空，OnClose 回调与 Config.OnConnClose 中仍然需要读取
 	paramters map[string]interface{}
+
+	// paramtersMutex 保护 paramters 与 paramterExpires，关闭会话时会在其它 goroutine 中读取
+	paramtersMutex sync.RWMutex
+
+	// paramterExpires 通过 SetWithTTL 设置的参数的过期时间，见 Get
+	paramterExpires map[string]time.Time
+
+	// requests 通过 Request 发出、等待对方响应的请求
+	requests zeronetwork.PendingRequests
+
+	// acks 通过 SendReliable 发出、等待对方确认的消息
+	acks zeronetwork.PendingAcks
+
+	// onClose 会话关闭时执行的回调，按注册的相反顺序执行
+	onClose []func()
+
+	// onCloseDone 回调是否已经执行，之后注册的回调会立即执行
+	onCloseDone bool
+
+	// onCloseMutex 保护 onClose 与 onCloseDone
+	onCloseMutex sync.Mutex
+}
+
+// cryptoValue 包装加解密工具，atomic.Value 不能保存 nil
+type cryptoValue struct {
+	crypto zeronetwork.Crypto
 }
 
 // sendElement 表示一个将要发送的消息
 type sendElement struct {
 	// message 将要发送的网络消息
 	message zeronetwork.Message
-	// callback 发送成功之后的回调
-	callback zeronetwork.SendCallbackFunc
+	// callback 写入套接字之后的回调，写入失败时携带错误
+	callback zeronetwork.SendResultFunc
+	// datapack 不为 nil 时不发送消息，之后的消息使用该封包工具封包
+	datapack zeronetwork.Datapack
 }
 
 // newSession 创建一个 kcp 会话
 func newSession(
 	sessionID zeronetwork.SessionID,
-	conn *kcp.UDPSession,
+	conn net.Conn,
 	config *zeronetwork.Config,
 	closeCallback zeronetwork.CloseCallbackFunc,
 	handler zeronetwork.HandlerFunc,
@@ -106,28 +277,107 @@ func newSession(
 	session := &session{
 		config:        config,
 		sessionID:     sessionID,
-		conn:          conn,
 		recvQueue:     make(chan zeronetwork.Message, config.RecvQueueSize),
-		sendQueue:     make(chan *sendElement, config.SendQueueSize),
+		recvBytes:     zeronetwork.NewRecvQueueBytes(),
+		sendQueue:     zeronetwork.NewSendQueue(config),
 		closeCh:       make(chan bool),
+		closed:        make(chan struct{}),
+		barrierCh:     make(chan bool, 1),
 		closeCallback: closeCallback,
 		handler:       handler,
+		mode:          int32(config.SessionMode),
 	}
+	session.setConn(conn)
+	session.logger = zeronetwork.NewSessionLogger(config.Logger, session.ID, session.remoteAddress)
 
 	return session
 }
 
 // Run 让当前连接开始工作，比如收发消息，一般用于连接成功之后
 func (s *session) Run() {
+	// 收发循环开始之前同步执行，此时不会有消息被处理
+	if s.config.OnHandshake != nil {
+		if err := s.config.OnHandshake(s); err != nil {
+			s.logger.Errorf("handshake failed: %s", err.Error())
+			s.Close()
+			return
+		}
+	}
+
 	if s.config.OnConnected != nil {
-		s.config.OnConnected(s)
+		if s.config.OnConnectedAsync {
+			// 不阻塞收发循环，消息可能先于 OnConnected 完成被处理
+			go s.config.OnConnected(s)
+		} else {
+			s.config.OnConnected(s)
+		}
+	}
+
+	// 开启会话恢复时，为连接分配恢复令牌
+	// 需要秘钥协商时，令牌在协商完成之后加密发送，见 handleExchangeKeyRequest
+	if s.isResumeAble() && !s.config.WhetherCrypto {
+		s.issueResumeToken()
 	}
 
-	go s.recvLoop()
-	go s.dispatchLoop()
+	// 收发循环开始之后不能再修改收发模式
+	atomic.StoreInt32(&s.modeFixed, 1)
+	mode := s.Mode()
+
+	// 会话已经关闭时不再启动收发循环
+	if !s.addSendLoop() {
+		return
+	}
+	defer s.loops.Done()
+
+	if mode != zeronetwork.SessionModeWriteOnly {
+		if s.pollers != nil {
+			// 由共享的 poller 读取
+			if err := s.pollers.add(s); err != nil {
+				s.logger.Errorf("add to poller failed: %s", err.Error())
+				s.Close()
+				return
+			}
+		} else {
+			s.goLoop(s.recvLoop)
+		}
+		s.goLoop(s.dispatchLoop)
+	}
+	if s.heartbeatInterval > 0 && mode == zeronetwork.SessionModeDuplex {
+		s.heartbeatEpoch = time.Now()
+		s.goLoop(s.heartbeatLoop)
+	}
 	s.sendLoop()
 }
 
+// addSendLoop 将 Run 中执行的 sendLoop 计入 loops，Close 已经开始等待 loops 时返回 false
+// sendLoop 退出之前计数不为 0，之后的 goLoop 不会与 loops.Wait 冲突
+// 不能使用 sendMutex，Run 之前的 Send 可能在队列已满时持有读锁等待 sendLoop
+func (s *session) addSendLoop() bool {
+	s.loopsMutex.Lock()
+	defer s.loopsMutex.Unlock()
+
+	if s.loopsWaiting {
+		return false
+	}
+
+	s.loops.Add(1)
+	return true
+}
+
+// goLoop 在新的 goroutine 中执行循环，计入 loops
+func (s *session) goLoop(loop func()) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		loop()
+	}()
+}
+
+// Closed 会话完全关闭之后关闭的通道，此时连接已经关闭，收发循环与心跳均已退出
+func (s *session) Closed() <-chan struct{} {
+	return s.closed
+}
+
 // Close 关闭，停止接收客户端消息，也不再接收服务端消息。当已接收的服务端消息发送完毕后，断开连接
 func (s *session) Close() {
 	var once bool
@@ -139,20 +389,42 @@ func (s *session) Close() {
 	if once {
 		defer func() {
 			if p := recover(); p != nil {
-				s.config.Logger.Errorf("session: %d close, address: %s, recover error: %s", s.ID(), s.RemoteAddr().String(), p)
+				s.logger.Errorf("close, recover error: %s", p)
 			}
 
 			if s.config.Logger.IsDebugAble() {
-				s.config.Logger.Debugf("session: %d, address: %s, closed", s.ID(), s.RemoteAddr().String())
+				s.logger.Debugf("closed")
 			}
+
+			// 9 所有循环退出之后关闭 closed，Close 可能在循环中执行，不能在这里等待
+			s.loopsMutex.Lock()
+			s.loopsWaiting = true
+			s.loopsMutex.Unlock()
+			go func() {
+				s.loops.Wait()
+				close(s.closed)
+			}()
 		}()
 
+		// 未记录其它原因时，为本端主动关闭
+		s.setCloseReason(zeronetwork.CloseReasonLocal)
+
 		// 1 停止接收来自客户端的消息
-		s.isStopRecv = true
-		// 2 停止发送来自服务端的消息
-		s.isStopSend = true
+		s.isStopRecv.Store(true)
+
+		// 不会再收到响应，唤醒等待中的 Request
+		s.requests.Close()
+
+		// 不会再收到确认，等待确认的消息回调 ErrStopSend
+		s.acks.Close()
 
-		// 3 关闭会话后的回调
+		// 保留会话状态，需要在 closeCallback 将会话移除之前
+		s.saveResumeState()
+
+		// 3 执行会话自身注册的回调，用于清理与该会话绑定的资源
+		s.runOnClose()
+
+		// 关闭会话后的回调
 		if s.closeCallback != nil {
 			s.closeCallback(s)
 		}
@@ -164,20 +436,32 @@ func (s *session) Close() {
 		// closeCallback 与 OnConnClose 优先于 s.sendWait.Wait() 处理
 		// 一般这里存放角色下线处理，如保存数据等
 		// 如果在 s.sendWait.Wait() 之后，会受到超时影响，造成数据丢失
+		// 此时仍然可以发送消息，比如通知客户端下线原因，这些消息会在断开连接之前发送完毕
+
+		// 2 停止发送来自服务端的消息，等待正在放入 sendQueue 的消息完成
+		s.sendMutex.Lock()
+		s.isStopSend = true
+		s.sendMutex.Unlock()
+
+		// 先于 Close 开始的处理函数的响应仍然可以放入发送队列，最多等待 CloseGracePeriod
+		s.inflight.Stop(s.config.CloseGracePeriod)
+		s.sendMutex.Lock()
+		s.isStopInflight = true
+		s.sendMutex.Unlock()
 
 		// 5 等待发送队列中的消息发送完毕
 		// FIXME: 超时处理
+		s.waitSendQueue()
 		s.sendWait.Wait()
-		// 6 关闭接收与发送循环
-		s.closeCh <- true
+		// 6 关闭接收与发送循环，通道关闭后所有循环都能收到信号，避免某一个循环阻塞时关闭会话也被阻塞
+		close(s.closeCh)
 		// 7 关闭套接字连接
 		s.conn.Close()
-		// 8 关闭所有通道
-		close(s.closeCh)
-		close(s.sendQueue)
+		// 8 关闭所有通道，未能发送的消息存入断线缓存或者释放
+		s.keepSendElements(s.sendQueue.Close())
 		close(s.recvQueue)
 
-		s.config.Logger.Infof("session: %d closed, address: %s", s.ID(), s.RemoteAddr().String())
+		s.logger.Infof("closed")
 	}
 }
 
@@ -188,231 +472,1167 @@ func (s *session) Send(message zeronetwork.Message) error {
 
 // SendCallback 发送消息给客户端，发送之后还有回调函数
 func (s *session) SendCallback(message zeronetwork.Message, callback zeronetwork.SendCallbackFunc) error {
-	if s.isStopSend {
+	return s.SendResult(message, zeronetwork.SendCallbackResult(callback))
+}
+
+// SendResult 发送消息给客户端，写入套接字之后响应回调函数，写入失败时回调中携带错误
+func (s *session) SendResult(message zeronetwork.Message, callback zeronetwork.SendResultFunc) error {
+	return s.sendResult(message, callback, false)
+}
+
+// sendResult 见 SendResult，inflight 表示先于 Close 开始的处理函数返回的响应，关闭过程中仍然可以发送
+func (s *session) sendResult(message zeronetwork.Message, callback zeronetwork.SendResultFunc, inflight bool) error {
+	if s.Mode() == zeronetwork.SessionModeReadOnly {
+		return zeronetwork.ErrReadOnlySession
+	}
+
+	s.sendMutex.RLock()
+	defer s.sendMutex.RUnlock()
+
+	if s.isStopSend && (!inflight || s.isStopInflight) {
 		// 不再发送新的消息
 		return ErrStopSend
 	}
 
+	s.assignSN(message)
+
 	// 发送发送队列，异步发送
-	select {
-	case s.sendQueue <- &sendElement{message: message, callback: callback}:
-		if s.config.Logger.IsDebugAble() {
-			s.config.Logger.Debugf("session: %d, send to queue success, message: %s", s.ID(), message.String())
-		}
-		return nil
-	case <-time.After(3 * time.Second):
-		s.config.Logger.Errorf("session: %d, send to queue timeout, message: %s", s.ID(), message.String())
-		return ErrWriteTimeout
+	atomic.AddInt32(&s.sendPending, 1)
+	if err := s.sendQueue.Put(&sendElement{message: message, callback: callback}); err != nil {
+		atomic.AddInt32(&s.sendPending, -1)
+		s.sendQueueFailed(message, err)
+		return err
 	}
+
+	s.updateSendWater()
+	if s.config.Logger.IsDebugAble() {
+		s.logger.Debugf("send to queue success, message: %s", message.String())
+	}
+	return nil
 }
 
-// ID 获取 sessionID，每一条连接都分配有一个唯一的 id
-func (s *session) ID() zeronetwork.SessionID {
-	return s.sessionID
+// sendQueueFailed 放入发送队列失败，发送队列已满并且为 SendOverflowClose 时关闭会话
+func (s *session) sendQueueFailed(message zeronetwork.Message, err error) {
+	s.logger.Errorf("send to queue failed: %s, message: %s", err.Error(), message.String())
+
+	if errors.Is(err, zeronetwork.ErrSendQueueFull) && s.config.SendOverflowPolicy == zeronetwork.SendOverflowClose {
+		s.setCloseReason(zeronetwork.CloseReasonSendQueueFull)
+		// 调用方持有 sendMutex 的读锁，需要在新的 goroutine 中关闭
+		go s.Close()
+	}
 }
 
-// RemoteAddr 客户端地址信息
-func (s *session) RemoteAddr() net.Addr {
-	return s.conn.RemoteAddr()
+// SendAndClose 发送消息之后以 reason 关闭会话
+// 放入发送队列与停止发送在同一把锁中完成，之后的 Send 返回 ErrStopSend，不会排在该消息之后
+func (s *session) SendAndClose(message zeronetwork.Message, reason zeronetwork.CloseReason) error {
+	if s.Mode() == zeronetwork.SessionModeReadOnly {
+		return zeronetwork.ErrReadOnlySession
+	}
+
+	written := make(chan error, 1)
+
+	s.sendMutex.Lock()
+	if s.isStopSend {
+		s.sendMutex.Unlock()
+		return ErrStopSend
+	}
+
+	s.assignSN(message)
+
+	atomic.AddInt32(&s.sendPending, 1)
+	err := s.sendQueue.Put(&sendElement{message: message, callback: func(_ zeronetwork.Session, err error) {
+		written <- err
+	}})
+	if err != nil {
+		atomic.AddInt32(&s.sendPending, -1)
+	} else {
+		s.updateSendWater()
+	}
+	s.isStopSend = true
+	s.sendMutex.Unlock()
+
+	// 等待该消息写入套接字，之后再关闭，不受关闭过程中其它步骤的影响
+	if err == nil {
+		timeout := s.config.CloseTimeout
+		if timeout <= 0 {
+			err = <-written
+		} else {
+			timer := time.NewTimer(timeout)
+			select {
+			case err = <-written:
+			case <-timer.C:
+				err = ErrWriteTimeout
+			}
+			timer.Stop()
+		}
+	}
+
+	s.setCloseReason(reason)
+	s.Close()
+
+	return err
 }
 
-// Conn 获取原始的连接
-func (s *session) Conn() net.Conn {
-	return s.conn
+// TrySend 尝试将消息放入发送队列，不会阻塞
+// 队列已满时返回 (false, nil)，消息仍由调用方持有；会话已停止发送时返回 ErrStopSend
+func (s *session) TrySend(message zeronetwork.Message) (bool, error) {
+	if s.Mode() == zeronetwork.SessionModeReadOnly {
+		return false, zeronetwork.ErrReadOnlySession
+	}
+
+	s.sendMutex.RLock()
+	defer s.sendMutex.RUnlock()
+
+	if s.isStopSend {
+		// 不再发送新的消息
+		return false, ErrStopSend
+	}
+
+	s.assignSN(message)
+
+	atomic.AddInt32(&s.sendPending, 1)
+	if s.sendQueue.TryPut(&sendElement{message: message}) {
+		s.updateSendWater()
+		return true, nil
+	}
+
+	atomic.AddInt32(&s.sendPending, -1)
+	return false, nil
 }
 
-// SetCrypto 设置加密解密的工具
-func (s *session) SetCrypto(crypto zeronetwork.Crypto) {
-	s.crypto = crypto
+// assignSN 开启自动分配时，为 SN 为 0 的非特殊协议消息分配 SN
+func (s *session) assignSN(message zeronetwork.Message) {
+	if !s.autoSN || message.SN() != 0 || message.Flag()&zeronetwork.FlagZero != 0 {
+		return
+	}
+
+	for {
+		sn := atomic.LoadUint32(&s.sn)
+		next := nextSN(uint16(sn))
+		if atomic.CompareAndSwapUint32(&s.sn, sn, uint32(next)) {
+			message.SetSN(next)
+			return
+		}
+	}
 }
 
-// SetChecksumKey 设置校验秘钥
-func (s *session) SetChecksumKey(checksumKey []byte) {
-	s.checksumKey = checksumKey
+// NextSN 下一个自动分配的 SN
+func (s *session) NextSN() uint16 {
+	return nextSN(uint16(atomic.LoadUint32(&s.sn)))
 }
 
-// Config 配置
-func (s *session) Config() *zeronetwork.Config {
-	return s.config
+// nextSN sn 之后的 SN，在 [1, RequestSNMin) 之间循环，0 保留给服务端主动推送的消息
+// [RequestSNMin, 65535] 留给对方主动发出的请求，见 zeronetwork.RequestSNMin
+func nextSN(sn uint16) uint16 {
+	sn++
+	if sn == 0 || sn >= zeronetwork.RequestSNMin {
+		sn = 1
+	}
+
+	return sn
 }
 
-// Get 获取自定义参数
-func (s *session) Get(key string) interface{} {
-	if s.paramters == nil {
-		return nil
+// isStopSending 是否已经停止发送消息，即会话正在关闭
+func (s *session) isStopSending() bool {
+	s.sendMutex.RLock()
+	defer s.sendMutex.RUnlock()
+
+	return s.isStopSend
+}
+
+// isStopResponding 是否丢弃处理函数返回的响应，inflight 表示该处理函数先于 Close 开始
+// SessionModeReadOnly 的会话总是丢弃响应
+func (s *session) isStopResponding(inflight bool) bool {
+	if s.Mode() == zeronetwork.SessionModeReadOnly {
+		return true
 	}
 
-	return s.paramters[key]
+	s.sendMutex.RLock()
+	defer s.sendMutex.RUnlock()
+
+	return s.isStopSend && (!inflight || s.isStopInflight)
 }
 
-// Set 设置自定义参数
-func (s *session) Set(key string, value interface{}) {
-	if s.paramters == nil {
-		s.paramters = make(map[string]interface{})
+// SendNow 立即将消息写入套接字，不经过发送队列，阻塞直到写入完成
+// 与 sendLoop 共用同一把写锁，保证每一个消息完整写入，但可能先于队列中尚未发送的消息到达
+func (s *session) SendNow(message zeronetwork.Message) error {
+	if s.Mode() == zeronetwork.SessionModeReadOnly {
+		return zeronetwork.ErrReadOnlySession
 	}
-	s.paramters[key] = value
+
+	if s.isStopSending() {
+		// 不再发送新的消息
+		return ErrStopSend
+	}
+
+	s.assignSN(message)
+
+	defer message.Release()
+
+	_, err := s.write(message)
+	return err
 }
 
-// recvLoop 接收消息
-func (s *session) recvLoop() {
-	defer func() {
-		if p := recover(); p != nil {
-			s.config.Logger.Errorf("session: %d, recover p: %+v, address: %s", s.ID(), p, s.RemoteAddr().String())
-		}
+// SendProto 使用 config.Codec 对 v 进行编码，封装成消息后发送给客户端
+func (s *session) SendProto(module, action uint8, v interface{}) error {
+	message, err := zerodatapack.NewCodecMessage(s.config.Codec, 0, module, action, v)
+	if err != nil {
+		return err
+	}
 
-		s.Close()
-	}()
+	return s.Send(message)
+}
 
-	headLen := s.config.Datapack.HeadLen()
-	recvBufferSize := s.config.RecvBufferSize
-	if recvBufferSize < headLen {
-		s.config.Logger.Errorf("recvBufferSize: %d less than headLen: %d, session: %d", recvBufferSize, headLen, s.ID())
-		return
+// SendJSON 使用 encoding/json 对 v 进行编码，封装成消息后发送给客户端
+func (s *session) SendJSON(module, action uint8, v interface{}) error {
+	message, err := zerodatapack.NewJSONMessage(0, module, action, v)
+	if err != nil {
+		return err
 	}
 
-	// buffer 用于读取 socket 中的数据
-	buffer := make([]byte, recvBufferSize)
+	return s.Send(message)
+}
 
-	// ringBytesBuffer 用于存储从 socket 读取的数据
-	ringBytesBuffer := zeroringbytes.New(recvBufferSize * 2)
-	ringBytesBuffer.Reset()
+// Request 发送请求并等待对方的响应，SN 由会话分配，见 zeronetwork.RequestSNMin
+// 对方需要原样返回 SN 与 module，超过 timeout 返回 ErrRequestTimeout，等待期间会话关闭返回 ErrStopSend
+func (s *session) Request(message zeronetwork.Message, timeout time.Duration) (zeronetwork.Message, error) {
+	return s.requests.Do(s.Send, message, timeout)
+}
 
-	for {
-		if s.config.RecvDeadline > 0 {
-			if err := s.conn.SetReadDeadline(time.Now().Add(s.config.RecvDeadline)); err != nil {
-				s.config.Logger.Error("session: %d, set read deadline error: %s, deadline: %d", s.ID(), err.Error(), s.config.RecvDeadline)
-				break
-			}
+// SendReliable 发送需要对方确认的消息，SN 由会话分配，见 zeronetwork.AckSNMin
+// 对方处理成功之后回复确认，超时未确认时重发，callback 只会被调用一次
+func (s *session) SendReliable(message zeronetwork.Message, callback zeronetwork.SendResultFunc) error {
+	// 发送之后消息会被释放，保留一份副本用于重发
+	template := zerodatapack.CopyMessage(message, message.Flag()|zeronetwork.FlagAck)
+	message.Release()
+
+	return s.acks.Send(s.config, func(sn uint16) error {
+		resend := zerodatapack.CopyMessage(template, template.Flag())
+		resend.SetSN(sn)
+		return s.Send(resend)
+	}, func(err error) {
+		if callback != nil {
+			callback(s, err)
 		}
+	})
+}
 
-		size, err := io.ReadAtLeast(s.conn, buffer, headLen)
+// streamWindow 流式发送时，已放入发送队列但尚未写入套接字的分块数量上限
+const streamWindow = 4
 
-		if s.isStopRecv {
-			break
+// SendStream 将 reader 中的数据拆分为若干分块依次发送，阻塞直到全部写入套接字
+func (s *session) SendStream(module, action uint8, reader io.Reader) error {
+	buffer := make([]byte, zerodatapack.StreamChunkSize)
+
+	results := make(chan error, streamWindow)
+	inflight := 0
+
+	// wait 等待一个分块写入完成
+	wait := func() error {
+		select {
+		case err := <-results:
+			inflight--
+			return err
+		case <-s.closeCh:
+			return ErrStopSend
 		}
+	}
 
-		if err != nil {
-			// 远端关闭
-			if zeronetwork.IsEOFOrReadError(err) {
-				if s.config.Logger.IsDebugAble() {
-					s.config.Logger.Debugf("session: %d, closed by remote, io.EOF", s.ID())
-				}
-			} else {
-				s.config.Logger.Errorf("session: %d, read failed: %s", s.ID(), err.Error())
+	for seq := uint32(0); ; seq++ {
+		if inflight == streamWindow {
+			if err := wait(); err != nil {
+				return err
 			}
-			break
 		}
 
-		if size == 0 {
-			if s.config.Logger.IsDebugAble() {
-				s.config.Logger.Debugf("session: %d closed by remote, size is zero", s.ID())
-			}
-			break
+		n, err := io.ReadFull(reader, buffer)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
 		}
 
-		// 在 ringBytesBuffer 中存储所有收到的消息
-		// 需要注意的是，尚未处理的消息 + 收到的 buffer 的长度不得超过 ringBytesBuffer 的长度
-		err = ringBytesBuffer.WriteN(buffer, size)
-		if err != nil {
-			s.config.Logger.Errorf("session: %d, write to circle buffer failed: %s", s.ID(), err.Error())
-			break
+		message := zerodatapack.NewStreamMessage(module, action, seq, last, buffer[:n])
+		if err := s.SendResult(message, func(_ zeronetwork.Session, err error) { results <- err }); err != nil {
+			return err
 		}
+		inflight++
 
-		messages, err := s.config.Datapack.Unpack(ringBytesBuffer, s.crypto, s.checksumKey)
-		if err != nil {
-			s.config.Logger.Errorf("session: %d unpack failed: %s", s.ID(), err.Error())
+		if last {
 			break
 		}
+	}
 
-		// TODO 接收数据统计
-
-		// 将消息存入缓冲队列 recvQueue 中，等待 dispatchLoop 处理
-		for _, message := range messages {
-			// 消息设置连接 ID
-			message.SetSessionID(s.sessionID)
-
-			s.recvQueue <- message
+	for inflight > 0 {
+		if err := wait(); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-// dispatchLoop 执行 recvQueue 中的消息，并将结果推送到 sendQueue 中
-func (s *session) dispatchLoop() {
-	defer func() {
-		if p := recover(); p != nil {
-			s.config.Logger.Errorf("recover p: %+v, address: %s", p, s.RemoteAddr().String())
-		}
+// ID 获取 sessionID，每一条连接都分配有一个唯一的 id
+func (s *session) ID() zeronetwork.SessionID {
+	return atomic.LoadUint64(&s.sessionID)
+}
 
-		s.Close()
-	}()
+// RemoteAddr 客户端地址信息，设置连接时缓存，不会每次调用 conn.RemoteAddr
+func (s *session) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
 
-	for {
-		select {
-		case message, ok := <-s.recvQueue:
-			if message != nil {
-				defer message.Release()
-			}
+// remoteAddress 远端地址，连接尚未建立时为空
+func (s *session) remoteAddress() string {
+	return s.remoteAddrString
+}
 
-			if !ok {
+// setConn 设置连接并缓存远端地址，需要在 Run 之前调用
+func (s *session) setConn(conn net.Conn) {
+	s.conn = conn
+	if conn == nil {
+		return
+	}
+
+	s.remoteAddr = conn.RemoteAddr()
+	if s.remoteAddr != nil {
+		s.remoteAddrString = s.remoteAddr.String()
+	}
+}
+
+// Conn 获取原始的连接
+func (s *session) Conn() net.Conn {
+	return s.conn
+}
+
+// SetCrypto 设置加密解密的工具
+func (s *session) SetCrypto(crypto zeronetwork.Crypto) {
+	s.crypto.Store(cryptoValue{crypto: crypto})
+
+	var encrypted int32
+	if crypto != nil {
+		encrypted = 1
+	}
+	atomic.StoreInt32(&s.encrypted, encrypted)
+}
+
+// IsEncrypted 是否已经设置了加解密工具
+func (s *session) IsEncrypted() bool {
+	return atomic.LoadInt32(&s.encrypted) == 1
+}
+
+// IsCompressed 当前使用的封包工具是否会压缩消息负载
+// 开启 Config.CompressNegotiation 时，还需要该会话在秘钥协商时协商了压缩
+func (s *session) IsCompressed() bool {
+	if s.config.CompressNegotiation && !s.compressNegotiated() {
+		return false
+	}
+
+	datapack, ok := s.datapack().(zeronetwork.CompressDatapack)
+	return ok && datapack.WhetherCompress()
+}
+
+// compressNegotiated 秘钥协商时是否协商了压缩
+func (s *session) compressNegotiated() bool {
+	return atomic.LoadInt32(&s.compressed) == 1
+}
+
+// setCompressNegotiated 记录秘钥协商的压缩结果
+func (s *session) setCompressNegotiated(compressed bool) {
+	var value int32
+	if compressed {
+		value = 1
+	}
+	atomic.StoreInt32(&s.compressed, value)
+}
+
+// SetChecksumKey 设置校验秘钥
+func (s *session) SetChecksumKey(checksumKey []byte) {
+	s.checksumKey.Store(checksumKey)
+}
+
+// loadCrypto 当前的加解密工具，未设置时为 nil
+func (s *session) loadCrypto() zeronetwork.Crypto {
+	value, _ := s.crypto.Load().(cryptoValue)
+	return value.crypto
+}
+
+// loadChecksumKey 当前的校验秘钥，未设置时为 nil
+func (s *session) loadChecksumKey() []byte {
+	checksumKey, _ := s.checksumKey.Load().([]byte)
+	return checksumKey
+}
+
+// HandshakeState 秘钥协商状态
+func (s *session) HandshakeState() zeronetwork.HandshakeState {
+	return zeronetwork.HandshakeState(atomic.LoadInt32(&s.handshakeState))
+}
+
+// setHandshakeState 设置秘钥协商状态
+func (s *session) setHandshakeState(state zeronetwork.HandshakeState) {
+	atomic.StoreInt32(&s.handshakeState, int32(state))
+}
+
+// Mode 会话的收发模式
+func (s *session) Mode() zeronetwork.SessionMode {
+	return zeronetwork.SessionMode(atomic.LoadInt32(&s.mode))
+}
+
+// SetMode 修改该会话的收发模式，只能在 Config.OnHandshake 中调用，收发循环开始之后返回 ErrSessionRunning
+func (s *session) SetMode(mode zeronetwork.SessionMode) error {
+	if atomic.LoadInt32(&s.modeFixed) == 1 {
+		return zeronetwork.ErrSessionRunning
+	}
+
+	atomic.StoreInt32(&s.mode, int32(mode))
+	return nil
+}
+
+// Ready 是否可以收发业务消息，未开启加密时无需等待秘钥协商
+func (s *session) Ready() bool {
+	return !s.config.WhetherCrypto || s.HandshakeState() == zeronetwork.HandshakeReady
+}
+
+// ResumeToken 恢复令牌，服务端为连接分配，客户端为收到的令牌，未开启时为空
+func (s *session) ResumeToken() string {
+	token, _ := s.resumeToken.Load().(string)
+	return token
+}
+
+// Redirect 通知客户端连接到新的地址，通知写入套接字之后关闭连接
+// 通知之前已经放入发送队列的消息会先发送
+func (s *session) Redirect(host string, port int) error {
+	return s.SendResult(zeronetworkkey.Redirect(host, port), func(zeronetwork.Session, error) {
+		go s.Close()
+	})
+}
+
+// SetDatapack 握手之后切换该会话的封包工具，只能切换一次
+func (s *session) SetDatapack(datapack zeronetwork.Datapack) error {
+	if !s.config.DatapackSwitchable {
+		return zeronetwork.ErrDatapackNotSwitchable
+	}
+
+	if !atomic.CompareAndSwapInt32(&s.datapackSwitched, 0, 1) {
+		return zeronetwork.ErrDatapackSwitched
+	}
+
+	s.recvDatapack.Store(datapack)
+
+	// 通过 sendQueue 切换，保证之前放入的消息仍然使用原来的封包工具
+	s.sendMutex.RLock()
+	defer s.sendMutex.RUnlock()
+
+	if s.isStopSend {
+		return ErrStopSend
+	}
+
+	// 队列已满时最多等待 3 秒，不会一直持有 sendMutex 的读锁
+	return s.sendQueue.PutWait(&sendElement{datapack: datapack}, 0, s.closeCh)
+}
+
+// datapack 解包使用的封包工具
+func (s *session) datapack() zeronetwork.Datapack {
+	if datapack, ok := s.recvDatapack.Load().(zeronetwork.Datapack); ok {
+		return datapack
+	}
+
+	return s.config.Datapack
+}
+
+// RTT 心跳测量的往返时间，尚未测量时为 0
+func (s *session) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.rtt))
+}
+
+// CloseReason 会话关闭的原因，尚未关闭时为 CloseReasonNone
+func (s *session) CloseReason() zeronetwork.CloseReason {
+	return zeronetwork.CloseReason(atomic.LoadInt32(&s.closeReason))
+}
+
+// setCloseReason 记录会话关闭的原因，已经记录过时忽略
+func (s *session) setCloseReason(reason zeronetwork.CloseReason) {
+	atomic.CompareAndSwapInt32(&s.closeReason, int32(zeronetwork.CloseReasonNone), int32(reason))
+}
+
+// QueueStats 发送队列与接收队列当前的长度、容量与最高水位
+func (s *session) QueueStats() zeronetwork.QueueStats {
+	return zeronetwork.QueueStats{
+		SendLen:       s.sendQueue.Len(),
+		SendCap:       s.sendQueue.Cap(),
+		SendMax:       s.sendQueue.Max(),
+		SendHighWater: s.water.Send(),
+		RecvLen:       len(s.recvQueue),
+		RecvCap:       cap(s.recvQueue),
+		RecvHighWater: s.water.Recv(),
+
+		SendShapedDelay: time.Duration(atomic.LoadInt64(&s.shapedDelay)),
+	}
+}
+
+// updateSendWater 放入 sendQueue 之后更新最高水位
+func (s *session) updateSendWater() {
+	n := s.sendQueue.Len()
+	s.water.UpdateSend(n)
+	if s.peerWater != nil {
+		s.peerWater.UpdateSend(n)
+	}
+}
+
+// updateRecvWater 放入 recvQueue 之后更新最高水位
+func (s *session) updateRecvWater() {
+	n := len(s.recvQueue)
+	s.water.UpdateRecv(n)
+	if s.peerWater != nil {
+		s.peerWater.UpdateRecv(n)
+	}
+}
+
+// Config 配置
+func (s *session) Config() *zeronetwork.Config {
+	return s.config
+}
+
+// Get 获取自定义参数
+func (s *session) Get(key string) interface{} {
+	s.paramtersMutex.RLock()
+	defer s.paramtersMutex.RUnlock()
+
+	if s.paramters == nil {
+		return nil
+	}
+
+	if expire, ok := s.paramterExpires[key]; ok && !time.Now().Before(expire) {
+		return nil
+	}
+
+	return s.paramters[key]
+}
+
+// Set 设置自定义参数
+func (s *session) Set(key string, value interface{}) {
+	s.paramtersMutex.Lock()
+	defer s.paramtersMutex.Unlock()
+
+	if s.paramters == nil {
+		s.paramters = make(map[string]interface{})
+	}
+	s.paramters[key] = value
+	delete(s.paramterExpires, key)
+}
+
+// SetWithTTL 设置自定义参数，超过 ttl 之后 Get 返回 nil，ttl <= 0 时与 Set 相同
+func (s *session) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		s.Set(key, value)
+		return
+	}
+
+	s.paramtersMutex.Lock()
+	defer s.paramtersMutex.Unlock()
+
+	if s.paramters == nil {
+		s.paramters = make(map[string]interface{})
+	}
+	if s.paramterExpires == nil {
+		s.paramterExpires = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+
+	// 设置时顺便清理已经过期的参数，避免不再读取的参数一直占用内存
+	for k, expire := range s.paramterExpires {
+		if !now.Before(expire) {
+			delete(s.paramters, k)
+			delete(s.paramterExpires, k)
+		}
+	}
+
+	s.paramters[key] = value
+	s.paramterExpires[key] = now.Add(ttl)
+}
+
+// OnClose 注册会话关闭时执行的回调，多个回调按注册的相反顺序执行，并且先于 Config.OnConnClose
+// 会话已经关闭时，回调会立即执行
+func (s *session) OnClose(callback func()) {
+	s.onCloseMutex.Lock()
+	if !s.onCloseDone {
+		s.onClose = append(s.onClose, callback)
+		s.onCloseMutex.Unlock()
+		return
+	}
+	s.onCloseMutex.Unlock()
+
+	s.callOnClose(callback)
+}
+
+// runOnClose 按注册的相反顺序执行 OnClose 注册的回调，只会执行一次
+func (s *session) runOnClose() {
+	s.onCloseMutex.Lock()
+	callbacks := s.onClose
+	s.onClose = nil
+	s.onCloseDone = true
+	s.onCloseMutex.Unlock()
+
+	for i := len(callbacks) - 1; i >= 0; i-- {
+		s.callOnClose(callbacks[i])
+	}
+}
+
+// callOnClose 执行一个回调，回调中的 panic 不会中断关闭流程
+// 回调中调用 Close 会直接返回，不会重复关闭
+func (s *session) callOnClose(callback func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			s.logger.Errorf("on close callback, recover error: %v", p)
+		}
+	}()
+
+	callback()
+}
+
+// recvLoop 接收消息
+func (s *session) recvLoop() {
+	defer func() {
+		if p := recover(); p != nil {
+			s.logger.Errorf("recover p: %+v", p)
+		}
+
+		// 未记录其它原因时，为读取或者解包失败
+		s.setCloseReason(zeronetwork.CloseReasonReadError)
+
+		s.Close()
+	}()
+
+	headLen := s.config.Datapack.HeadLen()
+	recvBufferSize := s.config.RecvBufferSize
+	if recvBufferSize < headLen {
+		s.logger.Errorf("recvBufferSize: %d less than headLen: %d", recvBufferSize, headLen)
+		return
+	}
+
+	// buffer 用于读取 socket 中的数据，来自共享缓冲池
+	readBuffer := zeronetwork.GetReadBuffer(recvBufferSize)
+	defer zeronetwork.PutReadBuffer(readBuffer)
+	buffer := *readBuffer
+
+	// recvBuffer 用于存储从 socket 读取的数据
+	recvBuffer := zeronetwork.NewRecvBuffer(s.config)
+
+	for {
+		if s.config.RecvDeadline > 0 && s.config.RecvDeadlineMode == zeronetwork.DeadlineFixed {
+			if err := s.conn.SetReadDeadline(time.Now().Add(s.config.RecvDeadline)); err != nil {
+				s.logger.Errorf("set read deadline error: %s, deadline: %d", err.Error(), s.config.RecvDeadline)
 				break
 			}
+		}
+
+		min := headLen
+		if s.fragmenter != nil {
+			// 非流模式下每次读取一个完整的数据包，分片可能小于消息头长度
+			min = fragmentHeadLen
+		}
+
+		size, err := s.read(buffer, min)
+
+		if s.isStopRecv.Load() {
+			break
+		}
+
+		if err != nil {
+			s.setCloseReason(zeronetwork.ReadCloseReason(err))
+
+			// 远端关闭
+			if zeronetwork.IsEOFOrReadError(err) {
+				if s.config.Logger.IsDebugAble() {
+					s.logger.Debugf("closed by remote, io.EOF")
+				}
+			} else {
+				s.logger.Errorf("read failed: %s", err.Error())
+			}
+			break
+		}
+
+		if size == 0 {
+			s.setCloseReason(zeronetwork.CloseReasonRemoteClosed)
+			if s.config.Logger.IsDebugAble() {
+				s.logger.Debugf("closed by remote, size is zero")
+			}
+			break
+		}
+
+		if err := s.handleFrame(recvBuffer, buffer[:size]); err != nil {
+			break
+		}
+	}
+}
+
+// handleFrame 处理从套接字读取到的数据，重组分片并解包，将得到的消息放入 recvQueue
+func (s *session) handleFrame(recvBuffer *zeronetwork.RecvBuffer, frame []byte) error {
+	if err := s.writeFrame(recvBuffer, frame); err != nil {
+		return err
+	}
+
+	return s.unpackAll(recvBuffer)
+}
+
+// writeFrame 分片重组之后写入 recvBuffer，分片尚未收齐时不写入
+func (s *session) writeFrame(recvBuffer *zeronetwork.RecvBuffer, frame []byte) error {
+	var err error
+	if s.fragmenter != nil {
+		frame, err = s.fragmenter.merge(frame, time.Now())
+		if err != nil {
+			s.logger.Errorf("merge fragment failed: %s", err.Error())
+			return err
+		}
+
+		// 分片尚未收齐
+		if frame == nil {
+			return nil
+		}
+	}
+
+	// 在 recvBuffer 中存储所有收到的消息
+	// 空间不足以存放一个完整的消息时按需扩容，见 RecvBuffer
+	err = recvBuffer.Write(frame)
+	if err != nil {
+		s.logger.Errorf("write to circle buffer failed: %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// unpackAll 解包 recvBuffer 中所有完整的消息，并放入 recvQueue
+// 会改变秘钥的消息之后的数据，需要等待秘钥更新之后再解包
+func (s *session) unpackAll(recvBuffer *zeronetwork.RecvBuffer) error {
+	for {
+		messages, err := s.unpack(recvBuffer)
+		if err != nil {
+			s.logger.Errorf("unpack failed: %s", err.Error())
+			return err
+		}
+
+		if len(messages) == 0 {
+			return nil
+		}
+
+		// TODO 接收数据统计
+
+		for _, message := range messages {
+			if !s.enqueue(message) {
+				return ErrStopSend
+			}
+		}
+	}
+}
+
+// enqueue 将消息存入缓冲队列 recvQueue 中，等待 dispatchLoop 处理
+// 需要等待的消息 (见 needBarrier) 处理完毕之后才返回，之后的消息才能使用新的秘钥或者封包工具解包
+// 会话关闭时返回 false
+func (s *session) enqueue(message zeronetwork.Message) bool {
+	// 消息设置连接 ID
+	message.SetSessionID(s.ID())
+
+	// 需要在放入 recvQueue 之前判断，与 dispatchLoop 的判断保持一致
+	barrier := s.needBarrier(message)
+
+	// 缓存的消息负载超过 RecvQueueMaxBytes 时，按照 RecvOverflowPolicy 等待或者关闭连接
+	if err := s.recvBytes.Acquire(s.config, len(message.Payload()), s.closeCh); err != nil {
+		if errors.Is(err, zeronetwork.ErrRecvQueueBytes) {
+			s.logger.Errorf("%s, max: %d, message: %s", err.Error(), s.config.RecvQueueMaxBytes, message.String())
+		}
+		message.Release()
+		return false
+	}
+
+	s.recvQueue <- message
+	s.updateRecvWater()
+
+	if !barrier {
+		return true
+	}
+
+	select {
+	case <-s.barrierCh:
+		return true
+	case <-s.closeCh:
+		return false
+	}
+}
+
+// tryEnqueue 见 enqueue，不会等待，用于共享的 poller
+// recvQueue 已满或者缓存的负载超过 RecvQueueMaxBytes 时返回 false，消息仍由调用方持有，之后再重试
+// 需要等待的消息放入之后由调用方等待 barrierCh，返回错误时需要关闭会话
+func (s *session) tryEnqueue(message zeronetwork.Message) (bool, error) {
+	// 消息设置连接 ID
+	message.SetSessionID(s.ID())
+
+	size := len(message.Payload())
+	ok, err := s.recvBytes.TryAcquire(s.config, size)
+	if err != nil {
+		s.logger.Errorf("%s, max: %d, message: %s", err.Error(), s.config.RecvQueueMaxBytes, message.String())
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	select {
+	case s.recvQueue <- message:
+		s.updateRecvWater()
+		return true, nil
+	default:
+		s.recvBytes.Release(size)
+		return false, nil
+	}
+}
+
+// needBarrier 该消息处理完毕之前，recvLoop 是否需要暂停解包
+// 会改变秘钥的特殊协议消息，以及开启 Config.DatapackSwitchable 且尚未切换封包工具时的所有消息
+// 服务端的关闭通知之后紧跟着连接关闭，需要等待通知处理完毕，避免读取到 io.EOF 后关闭会话导致通知被丢弃
+func (s *session) needBarrier(message zeronetwork.Message) bool {
+	if zeronetwork.RotatesCrypto(message) {
+		return true
+	}
+
+	if message.Flag()&zeronetwork.FlagZero != 0 && message.ActionID() == zeronetwork.FlagZeroShutdown {
+		return true
+	}
+
+	return s.isSwitchPending()
+}
+
+// isSwitchPending 开启 Config.DatapackSwitchable 且尚未切换封包工具
+func (s *session) isSwitchPending() bool {
+	return s.config.DatapackSwitchable && atomic.LoadInt32(&s.datapackSwitched) == 0
+}
+
+// unpack 从 recvBuffer 中解包，尚未切换封包工具时每次只解包一个消息
+func (s *session) unpack(recvBuffer *zeronetwork.RecvBuffer) ([]zeronetwork.Message, error) {
+	datapack := s.datapack()
+
+	if s.isSwitchPending() {
+		if single, ok := datapack.(zeronetwork.SingleDatapack); ok {
+			message, err := single.UnpackOne(recvBuffer.RingBytes(), s.loadCrypto(), s.loadChecksumKey())
+			if message == nil || err != nil {
+				return nil, err
+			}
+			return []zeronetwork.Message{message}, nil
+		}
+	}
+
+	return datapack.Unpack(recvBuffer.RingBytes(), s.loadCrypto(), s.loadChecksumKey())
+}
+
+// read 从套接字中至少读取 min 个字节
+// 滑动超时模式下，每次读取到数据后都会刷新超时时间
+func (s *session) read(buffer []byte, min int) (n int, err error) {
+	defer func() {
+		s.peerCounters.AddBytesIn(n)
+	}()
+
+	if s.config.RecvDeadline > 0 && s.config.RecvDeadlineMode == zeronetwork.DeadlineSliding {
+		return zeronetwork.ReadAtLeastSliding(s.conn, buffer, min, s.config.RecvDeadline)
+	}
+
+	return io.ReadAtLeast(s.conn, buffer, min)
+}
+
+// isTimeout 是否为读写超时
+// kcp-go 的超时错误没有实现 net.Error，只能通过错误信息判断
+func isTimeout(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+
+	for {
+		inner := errors.Unwrap(err)
+		if inner == nil {
+			return err.Error() == "timeout"
+		}
+		err = inner
+	}
+}
+
+// dispatchLoop 执行 recvQueue 中的消息，并将结果推送到 sendQueue 中
+func (s *session) dispatchLoop() {
+	// inflight 当前处理函数先于 Close 开始，响应放入发送队列之后结束计数
+	var inflight bool
+
+	// zeroLimiter 特殊协议消息的限制，见 Config.ZeroMessageRate
+	var zeroLimiter zeronetwork.ZeroLimiter
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.logger.Errorf("recover p: %+v", p)
+		}
+
+		if inflight {
+			s.inflight.End()
+		}
+		s.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-s.recvQueue:
+			// 通道已关闭，退出循环，break 只会跳出 select
+			if !ok {
+				return
+			}
+			s.recvBytes.Release(len(message.Payload()))
+
+			// 处理之前判断，处理过程中可能会切换封包工具
+			barrier := s.needBarrier(message)
 
 			var responseMessage zeronetwork.Message
 			var err error
 			if message.Flag()&zeronetwork.FlagZero == 0 {
-				responseMessage, err = s.handler(message)
+				if !s.Ready() {
+					s.logger.Errorf("reject message: %s, handshake state: %s, message: %s", zeronetwork.ErrHandshakeNotReady.Error(), s.HandshakeState(), message.String())
+					if s.config.HandshakeKick {
+						return
+					}
+					if barrier {
+						s.barrierCh <- true
+					}
+					message.Release()
+					continue
+				}
+
+				// 通过 Request 发出的请求的响应，交给等待者，不再派发给路由，由等待者持有，不在这里释放
+				if s.requests.Resolve(message) {
+					if barrier {
+						s.barrierCh <- true
+					}
+					continue
+				}
+
+				// recvLoop 先于 dispatchLoop 执行，恢复会话之后 ID 可能已经改变
+				message.SetSessionID(s.ID())
+				s.peerCounters.AddMessage()
+				inflight = s.inflight.Begin()
+				start := time.Now()
+				// 设置了 Config.DispatchPool 时在协程池中处理，阻塞直到处理完毕，同一个会话的消息仍然按顺序处理
+				responseMessage, err = s.config.DispatchPool.Handle(message, s.handle)
+				zeronetwork.LogSlowHandler(s.config, message, time.Since(start))
+				// 没有匹配的路由，默认丢弃该消息，会话保持
+				if errors.Is(err, zeronetwork.ErrHandlerNotFound) {
+					err = zeronetwork.HandleUnknownMessage(s.config, s, message, err)
+				}
+				if barrier {
+					s.barrierCh <- true
+				}
 			} else {
+				// 与路由消息分开限制，避免大量秘钥协商请求或者心跳占用 CPU
+				if err := zeroLimiter.Allow(s.config, message, time.Now()); err != nil {
+					s.peerCounters.AddZeroLimited()
+					s.logger.Warnf("reject message: %s, message: %s", err.Error(), message.String())
+					if s.config.ZeroLimitKick {
+						s.setCloseReason(zeronetwork.CloseReasonZeroLimit)
+						return
+					}
+					if barrier {
+						s.barrierCh <- true
+					}
+					message.Release()
+					continue
+				}
+				s.peerCounters.AddZeroMessage()
+
 				responseMessage, err = s.handleZero(message)
+				if barrier {
+					// 通知 recvLoop 消息处理完毕，可以继续解包
+					s.barrierCh <- true
+				}
+				if err != nil {
+					// 特殊协议处理失败，比如异常的秘钥协商，忽略该消息，不断开连接
+					s.logger.Errorf("handle zero message failed: %s, message: %s", err.Error(), message.String())
+					message.Release()
+					continue
+				}
 			}
 
-			if err != nil {
+			if err != nil && s.config.Logger.IsDebugAble() {
+				s.logger.Debugf("dispatch message failed: %s, message: %s", err.Error(), message.String())
+			}
+
+			// 处理函数直接返回收到的消息作为响应时，由发送流程释放，之后不再访问该消息
+			echoed := responseMessage != nil && responseMessage == message
+			ackSN := message.SN()
+			ack := message.Flag()&zeronetwork.FlagAck != 0 && err == nil
+
+			// 处理完毕之后释放消息，供之后解包复用，处理函数超时之后可能仍在使用消息，此时不释放
+			release := !echoed && !errors.Is(err, zeronetwork.ErrHandlerTimeout)
+
+			// 会话正在关闭，不再发送新的响应，先于 Close 开始的处理函数的响应在 CloseGracePeriod 内仍然发送
+			if responseMessage != nil && s.isStopResponding(inflight) {
 				if s.config.Logger.IsDebugAble() {
-					s.config.Logger.Debugf("session: %d, dispatch message failed: %s, message: %s", message.SessionID(), err.Error(), message.String())
+					s.logger.Debugf("drop response message, mode: %d, message: %s", s.Mode(), responseMessage.String())
 				}
-				return
+				responseMessage.Release()
+				responseMessage = nil
 			}
 
+			// 即使处理出错，也会发送响应消息，比如携带错误码的响应
 			if responseMessage != nil {
-				if err := s.Send(responseMessage); err != nil {
-					s.config.Logger.Errorf("session: %d, send response message failed: %s, message: %s", message.SessionID(), err.Error(), message.String())
+				// 响应的负载可能引用该消息的负载，比如 datapack.Respond(message, action, message.Payload())，响应写入之后再释放
+				var callback zeronetwork.SendResultFunc
+				if release {
+					release = false
+					callback = func(zeronetwork.Session, error) { message.Release() }
+				}
+				if err := s.sendResult(responseMessage, callback, inflight); err != nil {
+					s.logger.Errorf("send response message failed: %s, message: %s", err.Error(), message.String())
+					return
+				}
+			}
+
+			// 对方要求确认的消息，处理成功之后回复确认，见 SendReliable
+			if ack && !s.isStopResponding(inflight) {
+				if err := s.sendResult(zeronetworkkey.Ack(ackSN), nil, inflight); err != nil {
+					s.logger.Errorf("send ack failed: %s, sn: %d", err.Error(), ackSN)
 					return
 				}
 			}
+			if inflight {
+				inflight = false
+				s.inflight.End()
+			}
+
+			if release {
+				message.Release()
+			}
+
+			// 只有致命错误才会断开连接
+			if errors.Is(err, zeronetwork.ErrFatal) {
+				return
+			}
 		case <-s.closeCh:
 			return
 		}
 	}
 }
 
+// handleResult 处理函数的返回结果
+type handleResult struct {
+	message zeronetwork.Message
+	err     error
+	panic   interface{}
+}
+
+// handle 执行处理函数，设置了 Config.HandlerTimeout 时，超时之后不再等待该处理函数
+// 超时的处理函数仍在运行，返回的结果会被丢弃
+func (s *session) handle(message zeronetwork.Message) (zeronetwork.Message, error) {
+	if s.config.HandlerTimeout <= 0 {
+		return s.handler(message)
+	}
+
+	handler := s.contextHandler
+	if handler == nil {
+		handler = func(_ context.Context, message zeronetwork.Message) (zeronetwork.Message, error) {
+			return s.handler(message)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.HandlerTimeout)
+	defer cancel()
+
+	done := make(chan handleResult, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- handleResult{panic: p}
+			}
+		}()
+
+		responseMessage, err := handler(ctx, message)
+		done <- handleResult{message: responseMessage, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		// 与不限制超时时一致，由 dispatchLoop 恢复并关闭会话
+		if result.panic != nil {
+			panic(result.panic)
+		}
+		return result.message, result.err
+	case <-ctx.Done():
+	}
+
+	s.logger.Errorf("handler timeout: %s, message: %s", s.config.HandlerTimeout, message.String())
+
+	if s.config.HandlerTimeoutCode == 0 {
+		return nil, zeronetwork.ErrHandlerTimeout
+	}
+
+	return zerodatapack.NewLTDMessage(0, message.SN(), s.config.HandlerTimeoutCode, message.ModuleID(), message.ActionID(), nil), zeronetwork.ErrHandlerTimeout
+}
+
 // sendLoop 发送消息
 func (s *session) sendLoop() {
+	atomic.StoreInt32(&s.sendLooping, 1)
+
 	defer func() {
 		if p := recover(); p != nil {
-			s.config.Logger.Errorf("session: %d, recover p: %+v, address: %s", p, s.RemoteAddr().String())
+			s.logger.Errorf("recover p: %+v", p)
 		}
 
+		atomic.StoreInt32(&s.sendLooping, 0)
 		s.Close()
 	}()
 
+	// limiter 限制发送速度，见 Config.SendRateLimit
+	var limiter *zeronetwork.RateLimiter
+	if s.config.SendRateLimit > 0 {
+		limiter = zeronetwork.NewRateLimiter(s.config.SendRateLimit)
+	}
+
 	for {
 		select {
-		case element, ok := <-s.sendQueue:
-			if element != nil && element.message != nil {
-				defer element.message.Release()
-			}
-
+		case value, ok := <-s.sendQueue.C:
 			if !ok {
-				s.config.Logger.Errorf("session: %d, sendQueue error", s.ID())
+				s.logger.Errorf("sendQueue error")
 				return
 			}
+			element := value.(*sendElement)
 
-			if err := s.write(element.message); err != nil {
-				s.config.Logger.Errorf("session: %d, message: %s, write failed: %s", s.ID(), element.message.String(), err.Error())
-				return
+			// 将溢出缓冲区中的消息移入通道，唤醒等待空间的 Put
+			s.sendQueue.Refill()
+
+			// 切换封包工具，之后的消息使用新的封包工具
+			if element.datapack != nil {
+				s.writeMutex.Lock()
+				s.sendDatapack = element.datapack
+				s.writeMutex.Unlock()
+				continue
 			}
 
+			n, err := s.write(element.message)
+			atomic.AddInt32(&s.sendPending, -1)
 			if element.callback != nil {
-				element.callback(s)
+				element.callback(s, err)
+			}
+
+			drop := errors.Is(err, ErrWriteTimeout) && s.writeTimeoutPolicy == WriteTimeoutDrop
+			if drop {
+				s.logger.Errorf("message: %s, write timeout, message dropped: %s", element.message.String(), err.Error())
+			} else if err != nil {
+				s.logger.Errorf("message: %s, write failed: %s", element.message.String(), err.Error())
+			}
+
+			// 写入套接字并且响应回调之后归还消息，不能等到 sendLoop 退出时才释放
+			element.message.Release()
+			if drop {
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			if limiter != nil && !s.shape(limiter, n) {
+				return
 			}
 		case <-s.closeCh:
 			return
@@ -420,38 +1640,93 @@ func (s *session) sendLoop() {
 	}
 }
 
-// write 将消息写入套接字
-func (s *session) write(message zeronetwork.Message) error {
+// waitSendQueue 等待 sendLoop 将已经放入 sendQueue 的消息写入套接字，sendLoop 未运行或者已经退出时直接返回
+// 写入阻塞时受 Config.SendDeadline 限制，关闭服务时超过 Config.CloseTimeout 的连接会被强行关闭
+// 发送回调在 sendLoop 中执行，回调中需要使用 go s.Close() 关闭会话，见 Redirect
+func (s *session) waitSendQueue() {
+	for atomic.LoadInt32(&s.sendPending) > 0 && atomic.LoadInt32(&s.sendLooping) == 1 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// shape 消耗 n 个字节的发送额度，额度不足时等待，只会延迟该会话的发送，会话关闭时返回 false
+func (s *session) shape(limiter *zeronetwork.RateLimiter, n int) bool {
+	delay := limiter.Reserve(n, time.Now())
+	if delay <= 0 {
+		return true
+	}
+
+	atomic.AddInt64(&s.shapedDelay, int64(delay))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.closeCh:
+		return false
+	}
+}
+
+// write 将消息写入套接字，返回写入的字节数
+func (s *session) write(message zeronetwork.Message) (int, error) {
 	s.sendWait.Add(1)
 	defer s.sendWait.Done()
 
-	p, err := s.config.Datapack.Pack(message, s.crypto, s.checksumKey)
+	// 封包(加密)与写入在同一把锁中完成，保证消息完整，并且加密顺序与写入顺序一致
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	datapack := s.sendDatapack
+	if datapack == nil {
+		datapack = s.config.Datapack
+	}
+
+	p, err := zeronetwork.PackMessage(s.config, datapack, message, s.loadCrypto(), s.loadChecksumKey(), s.compressNegotiated())
 	if err != nil {
-		s.config.Logger.Errorf("session: %d, pack message failed; %s, message: %s", s.ID, err.Error(), message.String())
-		return err
+		s.logger.Errorf("pack message failed; %s, message: %s", err.Error(), message.String())
+		return 0, err
 	}
 
-	if s.config.SendDeadline > 0 {
-		if err := s.conn.SetWriteDeadline(time.Now().Add(s.config.SendDeadline)); err != nil {
-			s.config.Logger.Errorf("session: %d, set write deadline failed: %s, deadline: %d", s.ID, err.Error(), s.config.SendDeadline)
-			return err
+	packets := [][]byte{p}
+	if s.fragmenter != nil {
+		packets, err = s.fragmenter.split(p)
+		if err != nil {
+			s.logger.Errorf("split fragment failed: %s, message: %s", err.Error(), message.String())
+			return 0, err
 		}
 	}
 
-	n, err := s.conn.Write(p)
-	if err != nil {
-		s.config.Logger.Errorf("session: %d, conn write failed: %s, message: %s", s.ID, err.Error(), message.String())
-		return err
-	}
+	written := 0
+	for _, packet := range packets {
+		// 每一次写入套接字都重新设置超时，避免分片较多时共用一个超时
+		if s.config.SendDeadline > 0 {
+			if err := s.conn.SetWriteDeadline(time.Now().Add(s.config.SendDeadline)); err != nil {
+				s.logger.Errorf("set write deadline failed: %s, deadline: %d", err.Error(), s.config.SendDeadline)
+				return 0, err
+			}
+		}
+
+		n, err := s.conn.Write(packet)
+		if err != nil {
+			s.logger.Errorf("conn write failed: %s, message: %s", err.Error(), message.String())
+			if isTimeout(err) {
+				return 0, fmt.Errorf("%w: %w", ErrWriteTimeout, err)
+			}
+			return 0, err
+		}
 
-	if n != len(p) {
-		s.config.Logger.Errorf("session: %d, write data is not complete: %d/%d", n, len(p))
-		return ErrWriteNotAll
+		if n != len(packet) {
+			s.logger.Errorf("write data is not complete: %d/%d", n, len(packet))
+			return 0, ErrWriteNotAll
+		}
+		written += n
 	}
 
-	// TODO 发送数据统计
+	s.peerCounters.AddBytesOut(written)
 
-	return nil
+	return written, nil
 }
 
 // handleZero 处理一些特殊协议
@@ -461,60 +1736,366 @@ func (s *session) handleZero(message zeronetwork.Message) (zeronetwork.Message,
 	}
 
 	action := message.ActionID()
+	if !s.zeroActionAllowed(action) {
+		return nil, fmt.Errorf("%w: %d", zeronetwork.ErrZeroActionNotAllowed, action)
+	}
+
 	if action == zeronetwork.FlagZeroExchangeKeyRequest {
 		return s.handleExchangeKeyRequest(message)
 	} else if action == zeronetwork.FlagZeroExchangeKeyResponse {
 		return s.handleExchangeKeyResponse(message)
+	} else if action == zeronetwork.FlagZeroResumeToken {
+		return s.handleResumeToken(message)
+	} else if action == zeronetwork.FlagZeroResumeRequest {
+		return s.handleResumeRequest(message)
+	} else if action == zeronetwork.FlagZeroResumeResponse {
+		return s.handleResumeResponse(message)
+	} else if action == zeronetwork.FlagZeroRedirect {
+		return s.handleRedirect(message)
+	} else if action == zeronetwork.FlagZeroHeartBeat {
+		return zeronetworkkey.HeartBeatResponse(message.Payload()), nil
+	} else if action == zeronetwork.FlagZeroHeartBeatResponse {
+		return s.handleHeartBeatResponse(message)
+	} else if action == zeronetwork.FlagZeroShutdown {
+		return s.handleShutdown(message)
+	} else if action == zeronetwork.FlagZeroAck {
+		return s.handleAck(message)
 	}
 
 	return nil, fmt.Errorf("action not supported: %d", action)
 }
 
+// zeroActionAllowed 特殊协议只接受对方一端发出的 action，避免伪造的协议修改本端状态
+// 比如服务端收到伪造的恢复结果时会改变会话 ID，关闭时删除其它会话
+func (s *session) zeroActionAllowed(action uint8) bool {
+	switch action {
+	case zeronetwork.FlagZeroExchangeKeyRequest, zeronetwork.FlagZeroResumeRequest, zeronetwork.FlagZeroHeartBeat:
+		return !s.isClient
+	case zeronetwork.FlagZeroExchangeKeyResponse, zeronetwork.FlagZeroResumeToken, zeronetwork.FlagZeroResumeResponse,
+		zeronetwork.FlagZeroRedirect, zeronetwork.FlagZeroHeartBeatResponse, zeronetwork.FlagZeroShutdown:
+		return s.isClient
+	}
+
+	// 确认两端均可发出
+	return true
+}
+
 func (s *session) handleExchangeKeyRequest(message zeronetwork.Message) (zeronetwork.Message, error) {
-	key, message, err := zeronetworkkey.ExchangeKeyResponse(message.Payload())
+	// 限制同时进行的秘钥协商计算，超出上限时排队等待，超时的会话由 WatchHandshake 拒绝并关闭
+	if s.keyExchangeSlots != nil {
+		if !s.keyExchangeSlots.Acquire(s.config.MaxKeyExchanges, s.config.HandshakeTimeout) {
+			return nil, zeronetwork.ErrHandshakeTimeout
+		}
+		defer s.keyExchangeSlots.Release()
+	}
+
+	compress := s.config.CompressNegotiation && s.config.WhetherCompress
+	key, message, compressed, err := zeronetworkkey.ExchangeKeyResponseWithCompress(message.Payload(), compress)
 	if err != nil {
 		return nil, err
 	}
 
 	// 目前用于 rc4 和 checksum 都是同一个秘钥
-	crypto, _ := zerorc4.New(key)
+	crypto, err := zerorc4.NewWithMinKeySize(key, s.config.MinCryptoKeySize)
+	if err != nil {
+		return nil, err
+	}
 	s.SetCrypto(crypto)
 	s.SetChecksumKey(key)
+	s.setHandshakeState(zeronetwork.HandshakeKeyExchanged)
 
 	if s.config.Logger.IsDebugAble() {
-		s.config.Logger.Debugf("session: %d, key: %s", s.ID(), hex.EncodeToString(key))
+		s.logger.Debugf("key: %s", hex.EncodeToString(key))
 	}
 
-	return message, nil
+	// 协商结果发送给客户端之后，才可以处理业务消息
+	// 响应本身不压缩，发送之后才按照协商结果压缩
+	return nil, s.SendCallback(message, func(zeronetwork.Session) {
+		s.setCompressNegotiated(compressed)
+		s.setHandshakeState(zeronetwork.HandshakeReady)
+
+		// 回调在 sendLoop 中执行，发送队列已满时 Send 会阻塞 sendLoop
+		if s.isResumeAble() {
+			go s.issueResumeToken()
+		}
+	})
 }
 
 func (s *session) handleExchangeKeyResponse(message zeronetwork.Message) (zeronetwork.Message, error) {
-	privateKey := s.Get("ecdhPrivateKey").([]byte)
-	randomValue := s.Get("ecdhRandomValue").([]byte)
+	// 未发起秘钥协商请求，或者已经协商完毕，这里均为 nil
+	privateKey, _ := s.Get("ecdhPrivateKey").([]byte)
+	randomValue, _ := s.Get("ecdhRandomValue").([]byte)
 
 	if len(privateKey) == 0 {
-		return nil, errors.New("private key is empty")
+		return nil, ErrPrivateKeyEmpty
 	}
 	if len(randomValue) == 0 {
-		return nil, errors.New("random value is empty")
+		return nil, ErrRandomValueEmpty
 	}
 
-	key, err := zeronetworkkey.ExchangeKeyParseResponse(message.Payload(), privateKey, randomValue)
+	key, compressed, err := zeronetworkkey.ExchangeKeyParseResponseWithCompress(message.Payload(), privateKey, randomValue)
 	if err != nil {
 		return nil, err
 	}
 
 	// 目前用于 rc4 和 checksum 都是同一个秘钥
-	crypto, _ := zerorc4.New(key)
+	crypto, err := zerorc4.NewWithMinKeySize(key, s.config.MinCryptoKeySize)
+	if err != nil {
+		return nil, err
+	}
 	s.SetCrypto(crypto)
 	s.SetChecksumKey(key)
 
 	s.Set("ecdhPrivateKey", nil)
 	s.Set("ecdhRandomValue", nil)
+	s.setCompressNegotiated(compressed)
+	s.setHandshakeState(zeronetwork.HandshakeReady)
 
 	if s.config.Logger.IsDebugAble() {
-		s.config.Logger.Debugf("session: %d, key: %s", s.ID(), hex.EncodeToString(key))
+		s.logger.Debugf("key: %s", hex.EncodeToString(key))
+	}
+
+	return nil, nil
+}
+
+// isResumeAble 是否为连接分配恢复令牌，仅服务端开启会话恢复时
+func (s *session) isResumeAble() bool {
+	return s.resumeCallback != nil && s.config.ResumeTTL > 0
+}
+
+// issueResumeToken 为连接分配恢复令牌并发送给客户端
+func (s *session) issueResumeToken() {
+	token := zeronetwork.NewResumeToken()
+	s.resumeToken.Store(token)
+
+	message := zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroResumeToken, []byte(token))
+	if err := s.Send(message); err != nil {
+		s.logger.Errorf("send resume token failed: %s", err.Error())
+	}
+}
+
+// saveResumeState 保留会话状态，客户端可以在 ResumeTTL 内携带恢复令牌重连
+func (s *session) saveResumeState() {
+	token := s.ResumeToken()
+	if s.resumeCallback == nil || token == "" || s.config.ResumeTTL <= 0 || s.config.ResumeStore == nil {
+		return
+	}
+
+	state := &zeronetwork.ResumeState{SessionID: s.ID()}
+
+	s.paramtersMutex.RLock()
+	state.Parameters = make(map[string]interface{}, len(s.paramters))
+	for key, value := range s.paramters {
+		// 设置了 ttl 的参数只在此次会话中有效
+		if _, ok := s.paramterExpires[key]; ok {
+			continue
+		}
+		state.Parameters[key] = value
+	}
+	s.paramtersMutex.RUnlock()
+
+	// 目前用于 rc4 和 checksum 都是同一个秘钥
+	if s.HandshakeState() == zeronetwork.HandshakeReady {
+		state.Key = s.loadChecksumKey()
+	}
+
+	// 由 closeCallback 交给会话管理器，断线期间发给该会话的消息存入其中
+	if s.config.ResumeBufferSize > 0 {
+		state.Buffer = zeronetwork.NewResumeBuffer(s.config.ResumeBufferSize, s.config.ResumeBufferBytes, s.config.ResumeTTL)
+		s.resumeBuffer = state.Buffer
+
+		// sendLoop 已经退出时，发送队列中的消息不会再发送，排在断线期间的消息之前
+		if atomic.LoadInt32(&s.sendLooping) == 0 {
+			s.keepSendElements(s.sendQueue.Drain())
+		}
+	}
+
+	s.config.ResumeStore.Save(token, state, s.config.ResumeTTL)
+}
+
+// keepSendElements 接管发送队列中未发送的消息
+// 开启断线缓存时存入 resumeBuffer，恢复会话后重新发送，否则释放，有回调时回调 ErrStopSend
+func (s *session) keepSendElements(elements []interface{}) {
+	for _, value := range elements {
+		element := value.(*sendElement)
+		if element.message == nil {
+			// 切换封包工具
+			continue
+		}
+
+		atomic.AddInt32(&s.sendPending, -1)
+		if s.resumeBuffer == nil || s.resumeBuffer.Push(element.message) != nil {
+			element.message.Release()
+		}
+		if element.callback != nil {
+			element.callback(s, ErrStopSend)
+		}
+	}
+}
+
+// handleResumeToken 客户端收到恢复令牌
+func (s *session) handleResumeToken(message zeronetwork.Message) (zeronetwork.Message, error) {
+	s.resumeToken.Store(string(message.Payload()))
+	return nil, nil
+}
+
+// handleResumeRequest 客户端重连后请求恢复会话
+// 成功时沿用原会话 ID、自定义参数与秘钥，失败时客户端继续使用新的会话
+func (s *session) handleResumeRequest(message zeronetwork.Message) (zeronetwork.Message, error) {
+	if s.resumeCallback == nil || s.config.ResumeTTL <= 0 || s.config.ResumeStore == nil {
+		return nil, zeronetwork.ErrResumeTokenInvalid
+	}
+
+	state, err := s.config.ResumeStore.Load(string(message.Payload()))
+	if err != nil {
+		s.logger.Infof("resume failed: %s", err.Error())
+		return zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroResumeResponse, nil), nil
+	}
+
+	// 恢复秘钥
+	if len(state.Key) > 0 {
+		crypto, err := zerorc4.NewWithMinKeySize(state.Key, s.config.MinCryptoKeySize)
+		if err != nil {
+			return nil, err
+		}
+		s.SetCrypto(crypto)
+		s.SetChecksumKey(state.Key)
+		s.setHandshakeState(zeronetwork.HandshakeReady)
+	}
+
+	// 未经秘钥协商直接恢复的会话尚未分配令牌，恢复秘钥之后加密发送
+	if s.ResumeToken() == "" && s.Ready() {
+		s.issueResumeToken()
+	}
+
+	// 恢复自定义参数
+	for key, value := range state.Parameters {
+		s.Set(key, value)
+	}
+
+	// 沿用原会话 ID
+	oldSessionID := s.ID()
+	atomic.StoreUint64(&s.sessionID, state.SessionID)
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, state.SessionID)
+	response := zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroResumeResponse, payload)
+
+	// 断线期间缓存的消息排在恢复结果之后，按照原来的顺序重新发送
+	if err := s.Send(response); err != nil {
+		return nil, err
+	}
+	if err := state.Buffer.Replay(s.Send); err != nil {
+		return nil, err
+	}
+
+	// 缓存的消息全部放入发送队列之后再替换会话，之后发给该会话的消息排在它们之后
+	s.resumeCallback(s, oldSessionID)
+
+	s.logger.Infof("resumed from temporary session: %d", oldSessionID)
+
+	return nil, nil
+}
+
+// handleResumeResponse 客户端收到恢复会话的结果，成功时沿用原会话 ID
+func (s *session) handleResumeResponse(message zeronetwork.Message) (zeronetwork.Message, error) {
+	payload := message.Payload()
+	if len(payload) != 8 {
+		return nil, zeronetwork.ErrResumeTokenInvalid
+	}
+
+	atomic.StoreUint64(&s.sessionID, binary.BigEndian.Uint64(payload))
+
+	return nil, nil
+}
+
+// handleRedirect 客户端收到重定向通知
+func (s *session) handleRedirect(message zeronetwork.Message) (zeronetwork.Message, error) {
+	host, port, err := zeronetworkkey.ParseRedirect(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("redirect to: %s:%d", host, port)
+
+	if s.redirectCallback != nil {
+		// 回调中会关闭当前会话，不能阻塞 dispatchLoop
+		go s.redirectCallback(host, port)
+	}
+
+	return nil, nil
+}
+
+// handleAck 对方确认已经处理完毕通过 SendReliable 发送的消息
+func (s *session) handleAck(message zeronetwork.Message) (zeronetwork.Message, error) {
+	sn, err := zeronetworkkey.ParseAck(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	s.acks.Ack(sn)
+
+	return nil, nil
+}
+
+// handleShutdown 客户端收到服务端的关闭通知，之后服务端会关闭连接
+func (s *session) handleShutdown(message zeronetwork.Message) (zeronetwork.Message, error) {
+	reason, retryAfter, err := zeronetworkkey.ParseShutdown(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("server shutdown, reason: %s, retry after: %s", reason, retryAfter)
+
+	if s.shutdownCallback != nil {
+		s.shutdownCallback(reason, retryAfter)
+	}
+
+	return nil, nil
+}
+
+// heartbeatLoop 定时发送心跳，负载为发送时间，收到响应之后计算往返时间
+func (s *session) heartbeatLoop() {
+	ticker := time.NewTicker(s.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Send(zeronetworkkey.HeartBeat(int64(time.Since(s.heartbeatEpoch)))); err != nil {
+				return
+			}
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// handleHeartBeatResponse 收到心跳响应，更新往返时间
+// 与 TCP 的 SRTT 一致，新的测量值占 1/8
+func (s *session) handleHeartBeatResponse(message zeronetwork.Message) (zeronetwork.Message, error) {
+	// 没有发送过心跳
+	if s.heartbeatInterval == 0 {
+		return nil, nil
+	}
+
+	timestamp, err := zeronetworkkey.ParseHeartBeat(message.Payload())
+	if err != nil {
+		return nil, err
+	}
+
+	sample := int64(time.Since(s.heartbeatEpoch)) - timestamp
+	if sample <= 0 {
+		return nil, nil
+	}
+
+	rtt := atomic.LoadInt64(&s.rtt)
+	if rtt == 0 {
+		rtt = sample
+	} else {
+		rtt += (sample - rtt) / 8
 	}
+	atomic.StoreInt64(&s.rtt, rtt)
 
 	return nil, nil
 }
@@ -0,0 +1,359 @@
+package kcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zeronetworkkey "github.com/zerogo-hub/zero-node/pkg/network/key"
+	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
+)
+
+// newKCPConn 创建一个 kcp 连接，关闭会话时需要关闭连接
+func newKCPConn(t *testing.T) *kcp.UDPSession {
+	ln, err := kcp.ListenWithOptions("127.0.0.1:0", nil, 0, 0)
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	conn, err := kcp.DialWithOptions(ln.Addr().String(), nil, 0, 0)
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+
+	return conn
+}
+
+func newExchangeKeyResponse() zeronetwork.Message {
+	return zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroExchangeKeyResponse, []byte("{}"))
+}
+
+func TestUnsolicitedExchangeKeyResponse(t *testing.T) {
+	s := newSession(1, nil, zeronetwork.DefaultConfig(), nil, nil)
+	s.isClient = true
+
+	_, err := s.handleZero(newExchangeKeyResponse())
+	if err != ErrPrivateKeyEmpty {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// 协商完毕后，参数会被置为 nil
+	s.Set("ecdhPrivateKey", nil)
+	s.Set("ecdhRandomValue", nil)
+
+	_, err = s.handleZero(newExchangeKeyResponse())
+	if err != ErrPrivateKeyEmpty {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestDispatchAfterUnsolicitedExchangeKeyResponse(t *testing.T) {
+	handled := make(chan bool, 1)
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		handled <- true
+		return nil, nil
+	}
+
+	s := newSession(1, nil, zeronetwork.DefaultConfig(), nil, handler)
+	go s.dispatchLoop()
+
+	s.recvQueue <- newExchangeKeyResponse()
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("dispatchLoop stopped after unsolicited exchange key response")
+	}
+}
+
+func TestRejectMessageBeforeHandshake(t *testing.T) {
+	handled := make(chan bool, 1)
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		handled <- true
+		return nil, nil
+	}
+
+	config := zeronetwork.DefaultConfig()
+	config.WhetherCrypto = true
+
+	s := newSession(1, nil, config, nil, handler)
+	go s.dispatchLoop()
+
+	// 秘钥协商之前的业务消息被丢弃
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)
+
+	select {
+	case <-handled:
+		t.Fatal("message handled before handshake")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 秘钥协商
+	_, _, request := zeronetworkkey.ExchangeKeyRequest()
+	if _, err := s.handleZero(request); err != nil {
+		t.Fatalf("exchange key failed: %s", err.Error())
+	}
+	if s.HandshakeState() != zeronetwork.HandshakeKeyExchanged {
+		t.Fatalf("unexpected handshake state: %s", s.HandshakeState())
+	}
+
+	// 模拟 sendLoop 将协商结果发送给客户端
+	element := (<-s.sendQueue.C).(*sendElement)
+	element.callback(s, nil)
+	if s.HandshakeState() != zeronetwork.HandshakeReady {
+		t.Fatalf("unexpected handshake state: %s", s.HandshakeState())
+	}
+
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("message not handled after handshake")
+	}
+}
+
+func TestDispatchErrorResponse(t *testing.T) {
+	errBadRequest := errors.New("bad request")
+	handled := make(chan bool, 2)
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		handled <- true
+		if message.ActionID() == 1 {
+			return zerodatapack.NewLTDMessage(0, message.SN(), 400, 1, 2, nil), errBadRequest
+		}
+		return nil, nil
+	}
+
+	s := newSession(1, nil, zeronetwork.DefaultConfig(), nil, handler)
+	go s.dispatchLoop()
+
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 7, 0, 1, 1, nil)
+
+	select {
+	case value := <-s.sendQueue.C:
+		element := value.(*sendElement)
+		if element.message.Code() != 400 || element.message.SN() != 7 {
+			t.Fatalf("unexpected response: %s, code: %d", element.message.String(), element.message.Code())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("error response not sent")
+	}
+
+	// 连接保持，可以继续处理消息
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 8, 0, 1, 3, nil)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("session closed after handler error")
+		}
+	}
+}
+
+func TestDispatchFatalError(t *testing.T) {
+	closed := make(chan bool, 1)
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, fmt.Errorf("kick: %w", zeronetwork.ErrFatal)
+	}
+
+	config := zeronetwork.DefaultConfig()
+	config.OnConnClose = func(session zeronetwork.Session) {
+		closed <- true
+	}
+
+	s := newSession(1, newKCPConn(t), config, nil, handler)
+	go s.dispatchLoop()
+
+	s.recvQueue <- zerodatapack.NewLTDMessage(0, 7, 0, 1, 1, nil)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session not closed after fatal error")
+	}
+}
+
+func TestExchangeKeyTooShort(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.WhetherCrypto = true
+	// 超过 rc4 秘钥的最大长度 256，协商得到的秘钥无法满足
+	config.MinCryptoKeySize = 1024
+
+	s := newSession(1, nil, config, nil, nil)
+
+	_, _, request := zeronetworkkey.ExchangeKeyRequest()
+	if _, err := s.handleZero(request); !errors.Is(err, zerorc4.ErrKeyTooShort) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if s.loadCrypto() != nil || s.loadChecksumKey() != nil {
+		t.Fatal("crypto installed after exchange key failed")
+	}
+	if s.HandshakeState() != zeronetwork.HandshakeInit {
+		t.Fatalf("unexpected handshake state: %s", s.HandshakeState())
+	}
+	if s.sendQueue.Len() != 0 {
+		t.Fatal("exchange key response sent after exchange key failed")
+	}
+}
+
+// slowConn 模拟写入缓慢的连接，每一次写入耗时 delay，前 block 次写入一直阻塞到超时
+type slowConn struct {
+	net.Conn
+
+	delay time.Duration
+	block int32
+
+	mutex    sync.Mutex
+	deadline time.Time
+	writes   int
+
+	closeOnce sync.Once
+	closed    chan bool
+}
+
+func newSlowConn(delay time.Duration, block int32) *slowConn {
+	return &slowConn{delay: delay, block: block, closed: make(chan bool)}
+}
+
+func (c *slowConn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	deadline := c.deadline
+	c.mutex.Unlock()
+
+	if atomic.AddInt32(&c.block, -1) >= 0 || (!deadline.IsZero() && time.Now().Add(c.delay).After(deadline)) {
+		time.Sleep(time.Until(deadline))
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	time.Sleep(c.delay)
+
+	c.mutex.Lock()
+	c.writes++
+	c.mutex.Unlock()
+
+	return len(p), nil
+}
+
+func (c *slowConn) Writes() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.writes
+}
+
+func (c *slowConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *slowConn) RemoteAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func TestWriteDeadlinePerPacket(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.SendDeadline = 50 * time.Millisecond
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	// 拆分为 3 个分片，总耗时超过 SendDeadline，但每一次写入都不超时
+	conn := newSlowConn(30*time.Millisecond, 0)
+	s := newSession(1, nil, config, nil, nil)
+	s.setConn(conn)
+
+	message := zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, make([]byte, 64))
+	p, err := config.Datapack.Pack(message, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	s.fragmenter = newFragmenter((len(p)+2)/3, time.Second)
+
+	if _, err := s.write(message); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	if conn.Writes() != 3 {
+		t.Fatalf("unexpected writes: %d", conn.Writes())
+	}
+
+	// 阻塞的写入触发超时
+	atomic.StoreInt32(&conn.block, 1)
+	_, err = s.write(zerodatapack.NewLTDMessage(0, 2, 0, 1, 1, nil))
+	if !errors.Is(err, ErrWriteTimeout) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestWriteTimeoutPolicy(t *testing.T) {
+	for _, policy := range []WriteTimeoutPolicy{WriteTimeoutClose, WriteTimeoutDrop} {
+		config := zeronetwork.DefaultConfig()
+		config.SendDeadline = 30 * time.Millisecond
+		config.Datapack = zerodatapack.DefaultDatapck(config)
+
+		// 第一条消息写入超时
+		conn := newSlowConn(0, 1)
+		s := newSession(1, nil, config, nil, nil)
+		s.setConn(conn)
+		s.writeTimeoutPolicy = policy
+		go s.sendLoop()
+
+		results := make(chan error, 2)
+		callback := func(session zeronetwork.Session, err error) {
+			results <- err
+		}
+		_ = s.SendResult(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), callback)
+		_ = s.SendResult(zerodatapack.NewLTDMessage(0, 2, 0, 1, 1, nil), callback)
+
+		select {
+		case err := <-results:
+			if !errors.Is(err, ErrWriteTimeout) {
+				t.Fatalf("policy: %d, unexpected err: %v", policy, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("policy: %d, write timeout not triggered", policy)
+		}
+
+		if policy == WriteTimeoutClose {
+			select {
+			case <-conn.closed:
+			case <-time.After(time.Second):
+				t.Fatal("session not closed after write timeout")
+			}
+			if conn.Writes() != 0 {
+				t.Fatalf("message sent after session closed, writes: %d", conn.Writes())
+			}
+			continue
+		}
+
+		// 丢弃超时的消息，后续消息正常发送
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatalf("unexpected err: %s", err.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not sent after write timeout dropped")
+		}
+		if conn.Writes() != 1 {
+			t.Fatalf("unexpected writes: %d", conn.Writes())
+		}
+
+		s.Close()
+	}
+}
@@ -0,0 +1,224 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// wsPrefix websocket 握手请求的开头，握手请求一定是 GET 请求
+var wsPrefix = []byte("GET ")
+
+// Listener 在一个端口上同时接收 tcp 与 websocket 连接
+// 查看连接的前几个字节，http 升级请求交给 WS()，其余的交给 TCP()，查看过的数据会被重新读取
+// 使用方式:
+//
+//	ln, err := mux.Listen("tcp", "127.0.0.1:8001")
+//	router, sessionManager := zeronetwork.NewRouter(), zeronetwork.NewSessionManager()
+//	tcpServer := zerotcp.NewServer().WithOption(zeronetwork.WithRouter(router), zeronetwork.WithSessionManager(sessionManager))
+//	wsServer := zerows.NewServer(websocket.BinaryMessage, "", "").WithOption(zeronetwork.WithRouter(router), zeronetwork.WithSessionManager(sessionManager))
+//	go tcpServer.(mux.Server).Serve(ln.TCP())
+//	go wsServer.(mux.Server).Serve(ln.WS())
+//	go ln.Serve()
+//
+// 只有客户端先发送数据才能区分连接类型，websocket 不能使用 TLS
+// tcp 消息头的前 4 个字节恰好为 "GET " 时会被误认为 websocket，比如未启用版本号、消息体长度为 18245 的消息
+type Listener struct {
+	ln net.Listener
+
+	// sniffTimeout 等待客户端发送前几个字节的时间，超时仍未区分出类型的连接会被关闭
+	sniffTimeout time.Duration
+
+	tcp *subListener
+	ws  *subListener
+
+	// closeOnce 防止多次关闭
+	closeOnce sync.Once
+
+	// closeCh 关闭的信号
+	closeCh chan struct{}
+}
+
+// Server 可以使用外部监听器的服务，tcp 与 ws 服务均已实现
+type Server interface {
+	// Serve 使用外部创建的监听器提供服务，阻塞直到监听器关闭
+	Serve(ln net.Listener) error
+}
+
+// Option Listener 的可选配置
+type Option func(*Listener)
+
+// WithSniffTimeout 等待客户端发送前几个字节的时间，超时仍未区分出类型的连接会被关闭，默认 5 秒
+func WithSniffTimeout(sniffTimeout time.Duration) Option {
+	return func(l *Listener) {
+		l.sniffTimeout = sniffTimeout
+	}
+}
+
+// New 在 ln 的基础上区分 tcp 与 websocket 连接，需要调用 Serve 开始接收连接
+func New(ln net.Listener, opts ...Option) *Listener {
+	l := &Listener{
+		ln:           ln,
+		sniffTimeout: 5 * time.Second,
+		closeCh:      make(chan struct{}),
+	}
+	l.tcp = newSubListener(l)
+	l.ws = newSubListener(l)
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Listen 监听 address，见 New
+func Listen(network, address string, opts ...Option) (*Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(ln, opts...), nil
+}
+
+// TCP 接收 tcp 连接的监听器
+func (l *Listener) TCP() net.Listener {
+	return l.tcp
+}
+
+// WS 接收 websocket 连接的监听器
+func (l *Listener) WS() net.Listener {
+	return l.ws
+}
+
+// Addr 监听地址
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Serve 循环接收新连接并区分类型，阻塞直到关闭
+func (l *Listener) Serve() error {
+	// acceptDelay accept 失败后的等待时间
+	var acceptDelay time.Duration
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case <-l.closeCh:
+				return nil
+			default:
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			// 等待一段时间后再重试，避免持续出错时空转
+			acceptDelay = zeronetwork.AcceptDelay(acceptDelay)
+			time.Sleep(acceptDelay)
+			continue
+		}
+		acceptDelay = 0
+
+		// 需要等待客户端发送数据，在新的 goroutine 中执行，避免阻塞 Accept
+		go l.sniff(conn)
+	}
+}
+
+// Close 关闭监听器，TCP() 与 WS() 也随之关闭
+func (l *Listener) Close() error {
+	var err error
+
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		err = l.ln.Close()
+	})
+
+	return err
+}
+
+// sniff 查看连接的前几个字节，交给对应的监听器
+func (l *Listener) sniff(conn net.Conn) {
+	peekConn := zeronetwork.NewPeekConn(conn)
+
+	if l.sniffTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(l.sniffTimeout))
+	}
+	p, err := peekConn.Peek(len(wsPrefix))
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if bytes.Equal(p, wsPrefix) {
+		l.ws.push(peekConn)
+	} else {
+		l.tcp.push(peekConn)
+	}
+}
+
+// subListener 只接收一种类型的连接
+type subListener struct {
+	parent *Listener
+
+	// conns 已经区分出类型，等待 Accept 的连接
+	conns chan net.Conn
+
+	// closeOnce 防止多次关闭
+	closeOnce sync.Once
+
+	// closeCh 关闭的信号
+	closeCh chan struct{}
+}
+
+func newSubListener(parent *Listener) *subListener {
+	return &subListener{
+		parent:  parent,
+		conns:   make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// push 将连接交给 Accept，已经关闭时关闭该连接
+func (l *subListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closeCh:
+		_ = conn.Close()
+	case <-l.parent.closeCh:
+		_ = conn.Close()
+	}
+}
+
+// Accept 等待下一个连接
+func (l *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	case <-l.parent.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 不再接收该类型的连接，不影响另一种类型
+func (l *subListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+	})
+
+	return nil
+}
+
+// Addr 监听地址
+func (l *subListener) Addr() net.Addr {
+	return l.parent.Addr()
+}
@@ -0,0 +1,128 @@
+package mux_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zeromux "github.com/zerogo-hub/zero-node/pkg/network/peer/mux"
+	zerotcp "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp"
+	zerows "github.com/zerogo-hub/zero-node/pkg/network/peer/ws"
+)
+
+func TestTCPAndWSOnOnePort(t *testing.T) {
+	ln, err := zeromux.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	defer ln.Close()
+
+	router := zeronetwork.NewRouter()
+	sessionManager := zeronetwork.NewSessionManager()
+	opts := []zeronetwork.Option{
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithRouter(router),
+		zeronetwork.WithSessionManager(sessionManager),
+	}
+
+	tcpServer := zerotcp.NewServer().WithOption(opts...)
+	wsServer := zerows.NewServer(websocket.BinaryMessage, "", "").WithOption(opts...)
+	defer tcpServer.Close()
+	defer wsServer.Close()
+
+	// 响应中带上会话的远端地址，用于区分来自哪一个客户端
+	_ = router.AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := sessionManager.Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+		return zerodatapack.Respond(message, 1, []byte(session.RemoteAddr().String())), nil
+	})
+
+	go func() { _ = tcpServer.(zeromux.Server).Serve(ln.TCP()) }()
+	go func() { _ = wsServer.(zeromux.Server).Serve(ln.WS()) }()
+	go func() { _ = ln.Serve() }()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	newHandler := func(responses chan zeronetwork.Message) zeronetwork.HandlerFunc {
+		return func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			// 处理函数返回之后消息会被释放，复制一份交给测试
+			responses <- zerodatapack.CopyMessage(message, message.Flag())
+			return nil, nil
+		}
+	}
+
+	tcpResponses := make(chan zeronetwork.Message, 1)
+	tcpClient := zerotcp.NewClient(newHandler(tcpResponses), zerotcp.WithClientLoggerLevel(zerologger.INFO))
+	if err := tcpClient.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("tcp connect failed: %s", err.Error())
+	}
+	go tcpClient.Run()
+	defer tcpClient.Close()
+
+	wsResponses := make(chan zeronetwork.Message, 1)
+	wsClient := zerows.NewClient(websocket.BinaryMessage, false, newHandler(wsResponses), zerows.WithClientLoggerLevel(zerologger.INFO))
+	if err := wsClient.Connect("ws", "127.0.0.1", port); err != nil {
+		t.Fatalf("ws connect failed: %s", err.Error())
+	}
+	go wsClient.Run()
+	defer wsClient.Close()
+
+	for name, c := range map[string]struct {
+		client    zeronetwork.Client
+		responses chan zeronetwork.Message
+	}{
+		"tcp": {tcpClient, tcpResponses},
+		"ws":  {wsClient, wsResponses},
+	} {
+		if err := c.client.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("%s send failed: %s", name, err.Error())
+		}
+
+		select {
+		case message := <-c.responses:
+			// 客户端的本地地址即为服务端会话的远端地址
+			local := c.client.Conn().LocalAddr().String()
+			if string(message.Payload()) != local {
+				t.Fatalf("%s unexpected response: %s, local address: %s", name, message.Payload(), local)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s timeout waiting for response", name)
+		}
+	}
+
+	// 两种连接共用同一个会话管理器
+	if sessionManager.Len() != 2 {
+		t.Fatalf("unexpected session count: %d", sessionManager.Len())
+	}
+}
+
+func TestSniffTimeout(t *testing.T) {
+	ln, err := zeromux.Listen("tcp", "127.0.0.1:0", zeromux.WithSniffTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	defer ln.Close()
+	go func() { _ = ln.Serve() }()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 一直不发送数据的连接会被关闭
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected conn closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("conn not closed after sniff timeout")
+	}
+}
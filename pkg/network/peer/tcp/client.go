@@ -2,9 +2,12 @@ package tcp
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
+	zerocodec "github.com/zerogo-hub/zero-helper/codec"
 	zerocompress "github.com/zerogo-hub/zero-helper/compress"
 	zerologger "github.com/zerogo-hub/zero-helper/logger"
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
@@ -14,7 +17,17 @@ import (
 // client 实现 Session 和 Client  接口
 // 定义见 pkg/network/network.go
 type client struct {
-	ss *session
+	// ss 当前会话，存储 *session，重定向之后替换为新的会话
+	ss atomic.Value
+
+	// network 连接时使用的网络类型，重定向之后使用相同的网络类型连接新的地址
+	network string
+
+	// autoRedirect 收到重定向通知后是否自动连接到新的地址
+	autoRedirect bool
+
+	// onRedirect 收到重定向通知时的回调
+	onRedirect zeronetwork.RedirectFunc
 }
 
 // NewClient 创建一个 tcp 客户端，测试使用
@@ -27,7 +40,11 @@ func NewClient(handler zeronetwork.HandlerFunc, opts ...ClientOption) zeronetwor
 		handler,
 	)
 
-	c := &client{ss: session}
+	c := &client{}
+	session.redirectCallback = c.redirect
+	session.isClient = true
+	session.autoSN = true
+	c.ss.Store(session)
 
 	for _, opt := range opts {
 		opt(c)
@@ -42,6 +59,11 @@ func NewClient(handler zeronetwork.HandlerFunc, opts ...ClientOption) zeronetwor
 
 // Connect 连接服务
 func (c *client) Connect(network, host string, port int) error {
+	c.network = network
+
+	if err := c.Config().Validate(); err != nil {
+		return err
+	}
 
 	address := fmt.Sprintf("%s:%d", host, port)
 	addr, err := net.ResolveTCPAddr(network, address)
@@ -56,11 +78,57 @@ func (c *client) Connect(network, host string, port int) error {
 		return err
 	}
 
-	c.ss.conn = conn
+	if err := conn.SetNoDelay(c.Config().NoDelay); err != nil {
+		_ = conn.Close()
+		c.Config().Logger.Error(err.Error())
+		return err
+	}
+
+	if err := zeronetwork.SetSocketBuffer(conn, c.Config(), address); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	c.session().setConn(conn)
 
 	return nil
 }
 
+// session 当前会话
+func (c *client) session() *session {
+	return c.ss.Load().(*session)
+}
+
+// redirect 收到服务端的重定向通知，开启自动重定向时关闭当前会话，使用新的会话连接到新的地址
+func (c *client) redirect(host string, port int) {
+	if c.onRedirect != nil {
+		c.onRedirect(host, port)
+	}
+
+	if !c.autoRedirect {
+		return
+	}
+
+	old := c.session()
+	session := newSession(0, nil, old.config, nil, old.handler)
+	session.redirectCallback = c.redirect
+	session.heartbeatInterval = old.heartbeatInterval
+	session.shutdownCallback = old.shutdownCallback
+	session.isClient = old.isClient
+	session.autoSN = old.autoSN
+	atomic.StoreUint32(&session.sn, atomic.LoadUint32(&old.sn))
+	c.ss.Store(session)
+
+	old.Close()
+
+	if err := c.Connect(c.network, host, port); err != nil {
+		c.Config().Logger.Errorf("redirect to %s:%d failed: %s", host, port, err.Error())
+		return
+	}
+
+	go session.Run()
+}
+
 // Logger 日志
 func (c *client) Logger() zerologger.Logger {
 	return c.Config().Logger
@@ -68,62 +136,192 @@ func (c *client) Logger() zerologger.Logger {
 
 // Run 让当前连接开始工作，比如收发消息，一般用于连接成功之后
 func (c *client) Run() {
-	c.ss.Run()
+	c.session().Run()
 }
 
 // Close 停止接收客户端消息，也不再接收服务端消息。当已接收的服务端消息发送完毕后，断开连接
 func (c *client) Close() {
-	c.ss.Close()
+	c.session().Close()
+}
+
+// Closed 会话完全关闭之后关闭的通道，此时连接已经关闭，收发循环均已退出
+func (c *client) Closed() <-chan struct{} {
+	return c.session().Closed()
 }
 
 // Send 发送消息给客户端
 func (c *client) Send(message zeronetwork.Message) error {
-	return c.ss.Send(message)
+	return c.session().Send(message)
 }
 
 // SendCallback 发送消息给客户端，发送之后响应回调函数
 func (c *client) SendCallback(message zeronetwork.Message, callback zeronetwork.SendCallbackFunc) error {
-	return c.ss.SendCallback(message, callback)
+	return c.session().SendCallback(message, callback)
+}
+
+// SendResult 发送消息给客户端，写入套接字之后响应回调函数，写入失败时回调中携带错误
+func (c *client) SendResult(message zeronetwork.Message, callback zeronetwork.SendResultFunc) error {
+	return c.session().SendResult(message, callback)
+}
+
+// SendAndClose 发送消息之后以 reason 关闭会话
+func (c *client) SendAndClose(message zeronetwork.Message, reason zeronetwork.CloseReason) error {
+	return c.session().SendAndClose(message, reason)
+}
+
+// TrySend 尝试将消息放入发送队列，队列已满时返回 false，不会阻塞
+func (c *client) TrySend(message zeronetwork.Message) (bool, error) {
+	return c.session().TrySend(message)
+}
+
+// SendNow 立即将消息写入套接字，不经过发送队列
+func (c *client) SendNow(message zeronetwork.Message) error {
+	return c.session().SendNow(message)
+}
+
+// SendProto 使用 Config.Codec 对 v 进行编码，封装成消息后发送
+func (c *client) SendProto(module, action uint8, v interface{}) error {
+	return c.session().SendProto(module, action, v)
+}
+
+// SendJSON 使用 encoding/json 对 v 进行编码，封装成消息后发送
+func (c *client) SendJSON(module, action uint8, v interface{}) error {
+	return c.session().SendJSON(module, action, v)
+}
+
+// Request 发送请求并等待服务端的响应，SN 由会话分配，见 zeronetwork.RequestSNMin
+func (c *client) Request(message zeronetwork.Message, timeout time.Duration) (zeronetwork.Message, error) {
+	return c.session().Request(message, timeout)
+}
+
+// SendReliable 发送需要服务端确认的消息，见 zeronetwork.Session.SendReliable
+func (c *client) SendReliable(message zeronetwork.Message, callback zeronetwork.SendResultFunc) error {
+	return c.session().SendReliable(message, callback)
+}
+
+// SendStream 将 reader 中的数据拆分为若干分块依次发送，阻塞直到全部写入套接字
+func (c *client) SendStream(module, action uint8, reader io.Reader) error {
+	return c.session().SendStream(module, action, reader)
 }
 
 // ID 获取 sessionID，每一条连接都分配有一个唯一的 id
 func (c *client) ID() zeronetwork.SessionID {
-	return c.ss.ID()
+	return c.session().ID()
 }
 
 // RemoteAddr 客户端地址信息
 func (c *client) RemoteAddr() net.Addr {
-	return c.ss.RemoteAddr()
+	return c.session().RemoteAddr()
 }
 
 // Conn 获取原始的连接
 func (c *client) Conn() net.Conn {
-	return c.ss.Conn()
+	return c.session().Conn()
 }
 
 // SetCrypto 设置加密解密的工具
 func (c *client) SetCrypto(crypto zeronetwork.Crypto) {
-	c.ss.SetCrypto(crypto)
+	c.session().SetCrypto(crypto)
 }
 
 // SetChecksumKey 设置校验秘钥
 func (c *client) SetChecksumKey(checksumKey []byte) {
-	c.ss.SetChecksumKey(checksumKey)
+	c.session().SetChecksumKey(checksumKey)
+}
+
+// HandshakeState 秘钥协商状态
+func (c *client) HandshakeState() zeronetwork.HandshakeState {
+	return c.session().HandshakeState()
+}
+
+// Ready 是否可以收发业务消息
+func (c *client) Ready() bool {
+	return c.session().Ready()
+}
+
+// Mode 会话的收发模式
+func (c *client) Mode() zeronetwork.SessionMode {
+	return c.session().Mode()
+}
+
+// SetMode 修改会话的收发模式，只能在 OnHandshake 中调用
+func (c *client) SetMode(mode zeronetwork.SessionMode) error {
+	return c.session().SetMode(mode)
+}
+
+// IsEncrypted 是否已经设置了加解密工具
+func (c *client) IsEncrypted() bool {
+	return c.session().IsEncrypted()
+}
+
+// IsCompressed 当前使用的封包工具是否会压缩消息负载
+func (c *client) IsCompressed() bool {
+	return c.session().IsCompressed()
+}
+
+// ResumeToken 服务端下发的恢复令牌，重连后可以通过 zeronetworkkey.ResumeRequest 请求恢复会话
+func (c *client) ResumeToken() string {
+	return c.session().ResumeToken()
+}
+
+// Redirect 通知对端连接到新的地址，通知发送完毕之后关闭连接
+func (c *client) Redirect(host string, port int) error {
+	return c.session().Redirect(host, port)
+}
+
+// SetDatapack 握手之后切换封包工具，只能切换一次
+func (c *client) SetDatapack(datapack zeronetwork.Datapack) error {
+	return c.session().SetDatapack(datapack)
+}
+
+// RTT 心跳测量的往返时间，尚未测量时为 0
+func (c *client) RTT() time.Duration {
+	return c.session().RTT()
+}
+
+// CloseReason 当前会话关闭的原因，尚未关闭时为 CloseReasonNone
+func (c *client) CloseReason() zeronetwork.CloseReason {
+	return c.session().CloseReason()
+}
+
+// QueueStats 发送队列与接收队列当前的长度、容量与最高水位
+func (c *client) QueueStats() zeronetwork.QueueStats {
+	return c.session().QueueStats()
+}
+
+// SetNoDelay 设置当前连接是否禁用 Nagle 算法 (TCP_NODELAY)
+func (c *client) SetNoDelay(noDelay bool) error {
+	return c.session().SetNoDelay(noDelay)
+}
+
+// NextSN 下一个自动分配的 SN
+func (c *client) NextSN() uint16 {
+	return c.session().NextSN()
 }
 
 // Config 配置
 func (c *client) Config() *zeronetwork.Config {
-	return c.ss.Config()
+	return c.session().Config()
 }
 
 // Get 获取自定义参数
 func (c *client) Get(key string) interface{} {
-	return c.ss.Get(key)
+	return c.session().Get(key)
 }
 
 // Set 设置自定义参数
 func (c *client) Set(key string, value interface{}) {
-	c.ss.Set(key, value)
+	c.session().Set(key, value)
+}
+
+// SetWithTTL 设置自定义参数，超过 ttl 之后 Get 返回 nil
+func (c *client) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.session().SetWithTTL(key, value, ttl)
+}
+
+// OnClose 注册会话关闭时执行的回调，多个回调按注册的相反顺序执行
+func (c *client) OnClose(callback func()) {
+	c.session().OnClose(callback)
 }
 
 // ClientOption 设置配置选项
@@ -155,6 +353,13 @@ func WithClientRecvDeadLine(recvDeadLine time.Duration) ClientOption {
 	}
 }
 
+// WithClientRecvDeadlineMode 读超时的计算方式，固定超时或者滑动超时
+func WithClientRecvDeadlineMode(recvDeadlineMode zeronetwork.DeadlineMode) ClientOption {
+	return func(c *client) {
+		c.Config().RecvDeadlineMode = recvDeadlineMode
+	}
+}
+
 // WithClientRecvQueueSize 在 session 中接收到的消息队列大小，session 接收到消息后并非立即处理，而是丢到一个消息队列中，异步处理
 func WithClientRecvQueueSize(recvQueueSize int) ClientOption {
 	return func(c *client) {
@@ -162,6 +367,27 @@ func WithClientRecvQueueSize(recvQueueSize int) ClientOption {
 	}
 }
 
+// WithClientRingBufferSize 在 session 中存储待解包数据的环形缓冲区大小，<= 0 表示使用 RecvBufferSize 的 2 倍
+func WithClientRingBufferSize(ringBufferSize int) ClientOption {
+	return func(c *client) {
+		c.Config().RingBufferSize = ringBufferSize
+	}
+}
+
+// WithClientRingBufferLazy 收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 RingBufferSize
+func WithClientRingBufferLazy(ringBufferLazy bool) ClientOption {
+	return func(c *client) {
+		c.Config().RingBufferLazy = ringBufferLazy
+	}
+}
+
+// WithClientMaxMessageSize 单个完整消息的最大长度，未设置 RingBufferSize 时环形缓冲区按需扩容以容纳该长度的消息
+func WithClientMaxMessageSize(maxMessageSize int) ClientOption {
+	return func(c *client) {
+		c.Config().MaxMessageSize = maxMessageSize
+	}
+}
+
 // WithClientSendBufferSize 发送消息 buffer 大小
 func WithClientSendBufferSize(sendBufferSize int) ClientOption {
 	return func(c *client) {
@@ -183,6 +409,62 @@ func WithClientSendQueueSize(sendQueueSize int) ClientOption {
 	}
 }
 
+// WithClientSendQueueMaxSize 发送的消息队列可以增长到的消息数量上限，大于 SendQueueSize 时生效，达到上限后按照 SendOverflowPolicy 处理
+func WithClientSendQueueMaxSize(sendQueueMaxSize int) ClientOption {
+	return func(c *client) {
+		c.Config().SendQueueMaxSize = sendQueueMaxSize
+	}
+}
+
+// WithClientSendOverflowPolicy 发送消息队列已满时的处理策略，队列的上限见 SendQueueMaxSize
+func WithClientSendOverflowPolicy(sendOverflowPolicy zeronetwork.SendOverflowPolicy) ClientOption {
+	return func(c *client) {
+		c.Config().SendOverflowPolicy = sendOverflowPolicy
+	}
+}
+
+// WithClientSendRateLimit 每个会话每秒最多发送的字节数，超出时延迟之后的写入，0 表示不限制
+func WithClientSendRateLimit(sendRateLimit int) ClientOption {
+	return func(c *client) {
+		c.Config().SendRateLimit = sendRateLimit
+	}
+}
+
+// WithClientLinger 关闭连接时调用 conn.SetLinger 设置的值，仅在 tcp 下有效，< 0 表示使用系统默认行为
+func WithClientLinger(linger int) ClientOption {
+	return func(c *client) {
+		c.Config().Linger = linger
+	}
+}
+
+// WithClientHalfCloseTimeout 关闭连接时先关闭写方向，等待对方关闭连接的最长时间，仅在 tcp 下有效，0 表示直接关闭
+func WithClientHalfCloseTimeout(halfCloseTimeout time.Duration) ClientOption {
+	return func(c *client) {
+		c.Config().HalfCloseTimeout = halfCloseTimeout
+	}
+}
+
+// WithClientNoDelay 新连接是否禁用 Nagle 算法 (TCP_NODELAY)，仅在 tcp 下有效，默认 true
+func WithClientNoDelay(noDelay bool) ClientOption {
+	return func(c *client) {
+		c.Config().NoDelay = noDelay
+	}
+}
+
+// WithClientHandlerTimeout 处理函数的超时时间，超时之后不再等待，继续处理之后的消息
+func WithClientHandlerTimeout(handlerTimeout time.Duration) ClientOption {
+	return func(c *client) {
+		c.Config().HandlerTimeout = handlerTimeout
+	}
+}
+
+// WithClientHandlerTimeoutCode 处理函数超时时返回给客户端的响应错误码，为 0 时不返回响应
+func WithClientHandlerTimeoutCode(handlerTimeoutCode uint16) ClientOption {
+	return func(c *client) {
+		c.Config().HandlerTimeoutCode = handlerTimeoutCode
+	}
+}
+
 // WithClientOnConnected 客户端连接到来时触发，此时客户端已经可以开始收发消息
 func WithClientOnConnected(onConnected zeronetwork.ConnFunc) ClientOption {
 	return func(c *client) {
@@ -190,7 +472,14 @@ func WithClientOnConnected(onConnected zeronetwork.ConnFunc) ClientOption {
 	}
 }
 
-// WithClientOnConnClose 客户端连接关闭触发，此时客户端不可以再收发消息
+// WithClientOnHandshake 连接开始收发消息之前同步触发，先于 OnConnected，返回错误时关闭连接
+func WithClientOnHandshake(onHandshake zeronetwork.HandshakeFunc) ClientOption {
+	return func(c *client) {
+		c.Config().OnHandshake = onHandshake
+	}
+}
+
+// WithClientOnConnClose 客户端连接关闭触发，此时不再接收消息，但仍然可以发送消息，这些消息会在断开连接之前发送完毕
 func WithClientOnConnClose(onConnClose zeronetwork.ConnFunc) ClientOption {
 	return func(c *client) {
 		c.Config().OnConnClose = onConnClose
@@ -204,6 +493,13 @@ func WithClientDatapack(datapack zeronetwork.Datapack) ClientOption {
 	}
 }
 
+// WithClientDatapackSwitchable 会话是否可能在握手之后切换封包工具，开启后切换之前逐个解包与处理消息
+func WithClientDatapackSwitchable(datapackSwitchable bool) ClientOption {
+	return func(c *client) {
+		c.Config().DatapackSwitchable = datapackSwitchable
+	}
+}
+
 // WithClientWhetherCompress 是否需要对消息负载进行压缩
 func WithClientWhetherCompress(whetherCompress bool) ClientOption {
 	return func(c *client) {
@@ -232,9 +528,170 @@ func WithClientCompress(compress zerocompress.Compress) ClientOption {
 	}
 }
 
+// WithClientMinCryptoKeySize 秘钥协商得到的秘钥最小长度，不足时秘钥协商失败
+func WithClientMinCryptoKeySize(minCryptoKeySize int) ClientOption {
+	return func(c *client) {
+		c.Config().MinCryptoKeySize = minCryptoKeySize
+	}
+}
+
+// WithClientMaxDecompressedSize 解压后负载的最大长度，超出则解包失败，<= 0 表示不限制
+func WithClientMaxDecompressedSize(maxDecompressedSize int) ClientOption {
+	return func(c *client) {
+		c.Config().MaxDecompressedSize = maxDecompressedSize
+	}
+}
+
 // WithClientWhetherChecksum 是否使用校验值，默认 false
 func WithClientWhetherChecksum(whetherChecksum bool) ClientOption {
 	return func(c *client) {
 		c.Config().WhetherChecksum = whetherChecksum
 	}
 }
+
+// WithClientCodec 编码与解码器，用于 SendProto
+func WithClientCodec(codec zerocodec.Codec) ClientOption {
+	return func(c *client) {
+		c.Config().Codec = codec
+	}
+}
+
+// WithClientHeartbeat 定时发送心跳，收到响应之后测量往返时间，见 RTT，默认 0 不发送
+func WithClientHeartbeat(interval time.Duration) ClientOption {
+	return func(c *client) {
+		c.session().heartbeatInterval = interval
+	}
+}
+
+// WithClientAutoRedirect 收到服务端的重定向通知后，自动连接到新的地址，默认 false
+func WithClientAutoRedirect(autoRedirect bool) ClientOption {
+	return func(c *client) {
+		c.autoRedirect = autoRedirect
+	}
+}
+
+// WithClientOnRedirect 收到服务端的重定向通知时触发，无论是否开启自动重定向
+func WithClientOnRedirect(onRedirect zeronetwork.RedirectFunc) ClientOption {
+	return func(c *client) {
+		c.onRedirect = onRedirect
+	}
+}
+
+// WithClientOnShutdown 收到服务端的关闭通知时触发，在 dispatchLoop 中执行，不要阻塞
+func WithClientOnShutdown(onShutdown zeronetwork.ShutdownFunc) ClientOption {
+	return func(c *client) {
+		c.session().shutdownCallback = onShutdown
+	}
+}
+
+// WithClientPayloadPoolMaxSize 解包时负载不超过该长度则从共享缓冲池中获取内存，消息 Release 时归还，0 表示不使用
+func WithClientPayloadPoolMaxSize(payloadPoolMaxSize int) ClientOption {
+	return func(c *client) {
+		c.Config().PayloadPoolMaxSize = payloadPoolMaxSize
+	}
+}
+
+// WithClientOnConnectedAsync 是否在新的 goroutine 中执行 OnConnected，此时消息可能先于 OnConnected 完成被处理
+func WithClientOnConnectedAsync(onConnectedAsync bool) ClientOption {
+	return func(c *client) {
+		c.Config().OnConnectedAsync = onConnectedAsync
+	}
+}
+
+// WithClientSessionMode 会话的收发模式，默认 SessionModeDuplex，见 SessionMode
+func WithClientSessionMode(sessionMode zeronetwork.SessionMode) ClientOption {
+	return func(c *client) {
+		c.Config().SessionMode = sessionMode
+	}
+}
+
+// WithClientSlowHandlerThreshold 处理函数的耗时超过该值时记录一条警告日志，0 表示不记录
+func WithClientSlowHandlerThreshold(slowHandlerThreshold time.Duration) ClientOption {
+	return func(c *client) {
+		c.Config().SlowHandlerThreshold = slowHandlerThreshold
+	}
+}
+
+// WithClientSocketReadBuffer 套接字接收缓冲区大小 (SO_RCVBUF)，0 表示使用系统默认值
+func WithClientSocketReadBuffer(socketReadBuffer int) ClientOption {
+	return func(c *client) {
+		c.Config().SocketReadBuffer = socketReadBuffer
+	}
+}
+
+// WithClientSocketWriteBuffer 套接字发送缓冲区大小 (SO_SNDBUF)，0 表示使用系统默认值
+func WithClientSocketWriteBuffer(socketWriteBuffer int) ClientOption {
+	return func(c *client) {
+		c.Config().SocketWriteBuffer = socketWriteBuffer
+	}
+}
+
+// WithClientRecvQueueMaxBytes 接收消息队列中缓存的消息负载字节数上限，超出时按照 RecvOverflowPolicy 处理，0 表示不限制
+func WithClientRecvQueueMaxBytes(recvQueueMaxBytes int) ClientOption {
+	return func(c *client) {
+		c.Config().RecvQueueMaxBytes = recvQueueMaxBytes
+	}
+}
+
+// WithClientRecvOverflowPolicy 接收消息队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+func WithClientRecvOverflowPolicy(recvOverflowPolicy zeronetwork.RecvOverflowPolicy) ClientOption {
+	return func(c *client) {
+		c.Config().RecvOverflowPolicy = recvOverflowPolicy
+	}
+}
+
+// WithClientCloseGracePeriod 关闭会话时等待已经开始的处理函数结束的最长时间，其响应仍然会被发送，0 表示不等待
+func WithClientCloseGracePeriod(closeGracePeriod time.Duration) ClientOption {
+	return func(c *client) {
+		c.Config().CloseGracePeriod = closeGracePeriod
+	}
+}
+
+// WithClientAckTimeout SendReliable 发送的消息等待确认的时间，超时之后重发，默认 5 秒
+func WithClientAckTimeout(ackTimeout time.Duration) ClientOption {
+	return func(c *client) {
+		c.Config().AckTimeout = ackTimeout
+	}
+}
+
+// WithClientAckRetries SendReliable 发送的消息超时之后的重发次数，默认 2
+func WithClientAckRetries(ackRetries int) ClientOption {
+	return func(c *client) {
+		c.Config().AckRetries = ackRetries
+	}
+}
+
+// WithClientAckWindow 每个会话等待确认的消息数量上限，默认 64
+func WithClientAckWindow(ackWindow int) ClientOption {
+	return func(c *client) {
+		c.Config().AckWindow = ackWindow
+	}
+}
+
+// WithClientOnUnknownMessage 收到没有匹配路由的消息时触发，之后丢弃该消息
+func WithClientOnUnknownMessage(onUnknownMessage zeronetwork.UnknownMessageFunc) ClientOption {
+	return func(c *client) {
+		c.Config().OnUnknownMessage = onUnknownMessage
+	}
+}
+
+// WithClientStrictRouting 收到没有匹配路由的消息时关闭会话，默认 false
+func WithClientStrictRouting(strictRouting bool) ClientOption {
+	return func(c *client) {
+		c.Config().StrictRouting = strictRouting
+	}
+}
+
+// WithClientCompressNegotiation 是否由每个会话在秘钥协商时决定是否压缩
+func WithClientCompressNegotiation(compressNegotiation bool) ClientOption {
+	return func(c *client) {
+		c.Config().CompressNegotiation = compressNegotiation
+	}
+}
+
+// WithClientChecksummer 开启 WhetherChecksum 时计算校验值的算法，默认 HMAC-MD5
+func WithClientChecksummer(checksummer zeronetwork.Checksummer) ClientOption {
+	return func(c *client) {
+		c.Config().Checksummer = checksummer
+	}
+}
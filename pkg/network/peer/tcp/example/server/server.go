@@ -1,9 +1,6 @@
 package main
 
 import (
-	"net/http"
-	_ "net/http/pprof"
-
 	protocol "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp/example/protocol"
 
 	zerocodec "github.com/zerogo-hub/zero-helper/codec"
@@ -12,6 +9,7 @@ import (
 
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zerodebug "github.com/zerogo-hub/zero-node/pkg/network/debug"
 	zerotcp "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp"
 )
 
@@ -26,6 +24,11 @@ const (
 	ActionHelloSayResp = 2
 )
 
+const (
+	// RouteHelloSayReq hello 模块 客户端请求的路由
+	RouteHelloSayReq zeronetwork.RouteID = ModuleHello<<8 | ActionHelloSayReq
+)
+
 type server struct {
 	p zeronetwork.Peer
 
@@ -63,17 +66,13 @@ func main() {
 		zeronetwork.WithWhetherChecksum(true),
 	)
 
-	// pprof
-	go func() {
-		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
-			s.p.Logger().Errorf("ListenAndServe failed: %s", err.Error())
-		}
-	}()
+	// pprof，随服务开启与关闭
+	s.p = zerodebug.Wrap(s.p, "localhost:6060")
 	s.p.Logger().Info("pprof: http://localhost:6060/debug/pprof/")
 
 	// 注册路由
-	if err := s.p.Router().AddRouter(ModuleHello, ActionHelloSayReq, s.reqSayHello); err != nil {
-		s.p.Logger().Errorf("AddRouter failed: %s", err.Error())
+	if err := s.p.Router().AddRoute(RouteHelloSayReq, s.reqSayHello); err != nil {
+		s.p.Logger().Errorf("AddRoute failed: %s", err.Error())
 	}
 
 	if err := s.p.Start(); err != nil {
@@ -118,12 +117,5 @@ func (s *server) reqSayHello(message zeronetwork.Message) (zeronetwork.Message,
 		return nil, err
 	}
 
-	return s.newMessage(message.SN(), ModuleHello, ActionHelloSayResp, res), nil
-}
-
-func (s *server) newMessage(sn uint16, module, action uint8, payload []byte) zeronetwork.Message {
-	flag := uint16(0)
-	code := uint16(0)
-	message := zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
-	return message
+	return zerodatapack.Respond(message, ActionHelloSayResp, res), nil
 }
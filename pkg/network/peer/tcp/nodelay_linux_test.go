@@ -0,0 +1,76 @@
+package tcp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// getNoDelay 读取连接的 TCP_NODELAY
+func getNoDelay(t *testing.T, conn net.Conn) bool {
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("syscall conn failed: %s", err.Error())
+	}
+
+	var value int
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	}); err != nil {
+		t.Fatalf("control failed: %s", err.Error())
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt failed: %s", sockErr.Error())
+	}
+
+	return value != 0
+}
+
+func TestNoDelay(t *testing.T) {
+	for _, noDelay := range []bool{true, false} {
+		opts := []zeronetwork.Option{zeronetwork.WithLoggerLevel(zerologger.INFO)}
+		clientOpts := []ClientOption{WithClientLoggerLevel(zerologger.INFO)}
+		// 默认禁用 Nagle 算法
+		if !noDelay {
+			opts = append(opts, zeronetwork.WithNoDelay(false))
+			clientOpts = append(clientOpts, WithClientNoDelay(false))
+		}
+		s := NewServer().WithOption(opts...).(*server)
+		port := listenTestServer(t, s)
+
+		c := NewClient(nil, clientOpts...)
+		if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+
+		waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+		var ss zeronetwork.Session
+		s.SessionManager().Range(func(session zeronetwork.Session) bool {
+			ss = session
+			return false
+		})
+
+		if got := getNoDelay(t, ss.Conn()); got != noDelay {
+			t.Fatalf("unexpected server no delay: %t, expected: %t", got, noDelay)
+		}
+		if got := getNoDelay(t, c.Conn()); got != noDelay {
+			t.Fatalf("unexpected client no delay: %t, expected: %t", got, noDelay)
+		}
+
+		// 单独修改一个连接
+		if err := ss.(interface{ SetNoDelay(bool) error }).SetNoDelay(!noDelay); err != nil {
+			t.Fatalf("SetNoDelay failed: %s", err.Error())
+		}
+		if got := getNoDelay(t, ss.Conn()); got != !noDelay {
+			t.Fatalf("unexpected server no delay after SetNoDelay: %t", got)
+		}
+
+		c.Close()
+		_ = s.Close()
+	}
+}
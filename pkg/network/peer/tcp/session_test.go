@@ -0,0 +1,1043 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zeronetworklogger "github.com/zerogo-hub/zero-node/pkg/network/logger"
+	protocol "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp/example/protocol"
+	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
+)
+
+func newTestSession(handler zeronetwork.HandlerFunc) *session {
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	return newSession(1, nil, config, nil, handler)
+}
+
+// unpackSent 取出发送队列中的消息，经过封包与解包后返回
+func unpackSent(t *testing.T, s *session) zeronetwork.Message {
+	element := (<-s.sendQueue.C).(*sendElement)
+
+	p, err := s.config.Datapack.Pack(element.message, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+
+	buffer := zeroringbytes.New(len(p) * 2)
+	if _, err := buffer.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	messages, err := s.config.Datapack.Unpack(buffer, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack failed: %s", err.Error())
+	}
+	if len(messages) != 1 {
+		t.Fatalf("unexpected messages: %d", len(messages))
+	}
+
+	return messages[0]
+}
+
+func TestSendProto(t *testing.T) {
+	s := newTestSession(nil)
+
+	req := &protocol.Req1{Name: "MyClient", Word: "Hello MyServer"}
+	if err := s.SendProto(1, 2, req); err != nil {
+		t.Fatalf("SendProto failed: %s", err.Error())
+	}
+
+	message := unpackSent(t, s)
+	if message.ModuleID() != 1 || message.ActionID() != 2 {
+		t.Fatalf("unexpected message: %s", message.String())
+	}
+
+	out := &protocol.Req1{}
+	if err := s.config.Codec.Unmarshal(message.Payload(), out); err != nil {
+		t.Fatalf("unmarshal failed: %s", err.Error())
+	}
+	if out.Name != req.Name || out.Word != req.Word {
+		t.Fatalf("unexpected payload, name: %s, word: %s", out.Name, out.Word)
+	}
+}
+
+// newTCPPair 创建一对已连接的 tcp 连接
+func newTCPPair(t testing.TB) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	defer ln.Close()
+
+	client, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+
+	server, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatalf("accept failed: %s", err.Error())
+	}
+
+	return server, client
+}
+
+func TestSendNowAndSendOrdering(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	key := []byte("0123456789abcdef")
+
+	config := zeronetwork.DefaultConfig()
+	config.WhetherCrypto = true
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	s := newSession(1, server, config, nil, nil)
+	crypto, _ := zerorc4.New(key)
+	s.SetCrypto(crypto)
+	go s.sendLoop()
+	defer s.Close()
+
+	const total = 500
+
+	// payload 中存储 SN，用于校验消息是否完整
+	newMessage := func(module uint8, sn uint16) zeronetwork.Message {
+		payload := make([]byte, 64)
+		for i := 0; i < len(payload); i += 2 {
+			binary.BigEndian.PutUint16(payload[i:], sn)
+		}
+		return zerodatapack.NewLTDMessage(0, sn, 0, module, 1, payload)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for sn := uint16(1); sn <= total; sn++ {
+			if err := s.Send(newMessage(1, sn)); err != nil {
+				t.Errorf("Send failed: %s", err.Error())
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for sn := uint16(1); sn <= total; sn++ {
+			if err := s.SendNow(newMessage(2, sn)); err != nil {
+				t.Errorf("SendNow failed: %s", err.Error())
+				return
+			}
+		}
+	}()
+
+	// 接收端解密并校验
+	decrypto, _ := zerorc4.New(key)
+	buffer := make([]byte, 4096)
+	ringBytesBuffer := zeroringbytes.New(len(buffer) * 4)
+	lastSN := map[uint8]uint16{}
+	received := 0
+
+	for received < total*2 {
+		n, err := client.Read(buffer)
+		if err != nil && err != io.EOF {
+			t.Fatalf("read failed: %s", err.Error())
+		}
+		if err := ringBytesBuffer.WriteN(buffer, n); err != nil {
+			t.Fatalf("write to ring buffer failed: %s", err.Error())
+		}
+
+		messages, err := config.Datapack.Unpack(ringBytesBuffer, decrypto, nil)
+		if err != nil {
+			t.Fatalf("unpack failed after %d messages: %s", received, err.Error())
+		}
+
+		for _, message := range messages {
+			payload := message.Payload()
+			for i := 0; i < len(payload); i += 2 {
+				if binary.BigEndian.Uint16(payload[i:]) != message.SN() {
+					t.Fatalf("corrupted message: %s", message.String())
+				}
+			}
+
+			if message.SN() != lastSN[message.ModuleID()]+1 {
+				t.Fatalf("out of order message: %s, last sn: %d", message.String(), lastSN[message.ModuleID()])
+			}
+			lastSN[message.ModuleID()] = message.SN()
+			received++
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestRecvFramesFragmented(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	s := newSession(1, server, config, nil, nil)
+	go s.recvLoop()
+	go s.sendLoop()
+	defer s.Close()
+
+	const total = 50
+
+	stream := []byte{}
+	for sn := uint16(1); sn <= total; sn++ {
+		p, err := config.Datapack.Pack(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, make([]byte, sn)), nil, nil)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		stream = append(stream, p...)
+	}
+
+	// 拆分成长度不一的片段发送，片段边界与消息边界不对齐
+	go func() {
+		for i := 0; i < len(stream); {
+			end := i + 7 + i%13
+			if end > len(stream) {
+				end = len(stream)
+			}
+			if _, err := client.Write(stream[i:end]); err != nil {
+				return
+			}
+			i = end
+		}
+	}()
+
+	for sn := uint16(1); sn <= total; sn++ {
+		message := <-s.recvQueue
+		if message.SN() != sn || len(message.Payload()) != int(sn) || message.SessionID() != s.ID() {
+			t.Fatalf("unexpected message: %s, payload: %d", message.String(), len(message.Payload()))
+		}
+	}
+}
+
+func TestCloseWithConcurrentSend(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.Logger.SetEnable(false)
+
+	s := newSession(1, server, config, nil, nil)
+	go s.sendLoop()
+
+	// 丢弃客户端收到的数据，避免阻塞写入
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sn := uint16(1); sn <= 200; sn++ {
+				err := s.Send(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil))
+				if err == ErrStopSend {
+					return
+				}
+				if err != nil {
+					t.Errorf("unexpected err: %s", err.Error())
+					return
+				}
+			}
+		}()
+	}
+
+	s.Close()
+	wg.Wait()
+
+	if err := s.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != ErrStopSend {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestSendResultWriteFailed(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.Logger.SetEnable(false)
+
+	s := newSession(1, server, config, nil, nil)
+	go s.sendLoop()
+
+	results := make(chan error, 1)
+	callback := func(session zeronetwork.Session, err error) {
+		results <- err
+	}
+
+	// 写入成功时 err 为 nil
+	if err := s.SendResult(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), callback); err != nil {
+		t.Fatalf("SendResult failed: %s", err.Error())
+	}
+	if err := <-results; err != nil {
+		t.Fatalf("unexpected err: %s", err.Error())
+	}
+
+	// 连接关闭后写入失败，回调中携带错误
+	server.Close()
+
+	if err := s.SendResult(zerodatapack.NewLTDMessage(0, 2, 0, 1, 1, nil), callback); err != nil {
+		t.Fatalf("SendResult failed: %s", err.Error())
+	}
+
+	select {
+	case err := <-results:
+		if err == nil {
+			t.Fatal("write failed without err")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not invoked after write failed")
+	}
+}
+
+func TestSessionLogger(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	var buffer bytes.Buffer
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.Logger = zeronetworklogger.NewSlog(slog.New(slog.NewTextHandler(&buffer, nil)))
+
+	s := newSession(7, server, config, nil, nil)
+	address := server.RemoteAddr().String()
+
+	// 写入失败的日志带上会话 ID 与远端地址
+	server.Close()
+	if err := s.SendNow(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err == nil {
+		t.Fatal("write to closed conn succeeded")
+	}
+
+	line := buffer.String()
+	if !strings.Contains(line, "session: 7, address: "+address+", conn write failed") {
+		t.Fatalf("unexpected log: %s", line)
+	}
+	if strings.Contains(line, "%!") {
+		t.Fatalf("bad format in log: %s", line)
+	}
+}
+
+func TestRemoteAddrCached(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	s := newSession(1, server, config, nil, nil)
+	defer server.Close()
+
+	if got, want := s.RemoteAddr().String(), server.RemoteAddr().String(); got != want {
+		t.Fatalf("cached remote addr: %s, live: %s", got, want)
+	}
+	if got, want := s.remoteAddress(), client.LocalAddr().String(); got != want {
+		t.Fatalf("cached remote address: %s, peer local addr: %s", got, want)
+	}
+
+	// 客户端的会话在连接建立之前没有地址
+	pending := newSession(0, nil, config, nil, nil)
+	if pending.RemoteAddr() != nil || pending.remoteAddress() != "" {
+		t.Fatalf("unexpected remote addr before connect: %v", pending.RemoteAddr())
+	}
+}
+
+// BenchmarkRemoteAddr 每一行会话日志都需要远端地址
+func BenchmarkRemoteAddr(b *testing.B) {
+	server, client := newTCPPair(b)
+	defer client.Close()
+	defer server.Close()
+
+	config := zeronetwork.DefaultConfig()
+	s := newSession(1, server, config, nil, nil)
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = s.remoteAddress()
+		}
+	})
+	b.Run("live", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = s.conn.RemoteAddr().String()
+		}
+	})
+}
+
+func TestSendCallbackResult(t *testing.T) {
+	called := 0
+	callback := zeronetwork.SendCallbackResult(func(zeronetwork.Session) {
+		called++
+	})
+
+	// 旧的回调只在写入成功时触发
+	callback(nil, ErrWriteNotAll)
+	callback(nil, nil)
+	if called != 1 {
+		t.Fatalf("unexpected called: %d", called)
+	}
+
+	if zeronetwork.SendCallbackResult(nil) != nil {
+		t.Fatal("nil callback converted to non nil")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.Logger.SetEnable(false)
+	// 写入之前已经超时
+	config.SendDeadline = time.Nanosecond
+
+	s := newSession(1, server, config, nil, nil)
+	defer server.Close()
+
+	err := s.SendNow(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil))
+	if !errors.Is(err, zeronetwork.ErrWriteTimeout) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestOnClose(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	var order []string
+	s := newTestSession(nil)
+	s.setConn(server)
+	s.config.OnConnClose = func(session zeronetwork.Session) {
+		order = append(order, "OnConnClose")
+	}
+
+	s.OnClose(func() { order = append(order, "timer") })
+	s.OnClose(func() {
+		order = append(order, "subscription")
+		// 回调中关闭会话直接返回
+		s.Close()
+	})
+
+	s.Close()
+	s.Close()
+
+	expected := "subscription,timer,OnConnClose"
+	if got := strings.Join(order, ","); got != expected {
+		t.Fatalf("unexpected order: %s, expected: %s", got, expected)
+	}
+
+	// 关闭之后注册的回调立即执行
+	s.OnClose(func() { order = append(order, "late") })
+	if len(order) != 4 || order[3] != "late" {
+		t.Fatalf("late callback not called: %v", order)
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	s := newTestSession(nil)
+	peerWater := &zeronetwork.QueueWater{}
+	s.peerWater = peerWater
+
+	// sendLoop 与 dispatchLoop 未运行，消息停留在队列中
+	for i := 0; i < 5; i++ {
+		if err := s.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		s.enqueue(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil))
+	}
+
+	stats := s.QueueStats()
+	expected := zeronetwork.QueueStats{
+		SendLen: 5, SendCap: s.config.SendQueueSize, SendMax: s.config.SendQueueSize, SendHighWater: 5,
+		RecvLen: 3, RecvCap: s.config.RecvQueueSize, RecvHighWater: 3,
+	}
+	if stats != expected {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 取出之后长度减少，最高水位保持不变
+	<-s.sendQueue.C
+	<-s.sendQueue.C
+	<-s.recvQueue
+
+	stats = s.QueueStats()
+	if stats.SendLen != 3 || stats.SendHighWater != 5 || stats.RecvLen != 2 || stats.RecvHighWater != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if peerWater.Send() != 5 || peerWater.Recv() != 3 {
+		t.Fatalf("unexpected peer water, send: %d, recv: %d", peerWater.Send(), peerWater.Recv())
+	}
+}
+
+func TestTrySend(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.SendQueueSize = 2
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	s := newSession(1, nil, config, nil, nil)
+
+	// sendLoop 未运行，队列放满之后立即返回 false
+	for i := 0; i < 2; i++ {
+		if ok, err := s.TrySend(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); !ok || err != nil {
+			t.Fatalf("try send failed: %v, %v", ok, err)
+		}
+	}
+
+	start := time.Now()
+	if ok, err := s.TrySend(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); ok || err != nil {
+		t.Fatalf("unexpected try send on full queue: %v, %v", ok, err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("try send blocked: %s", elapsed)
+	}
+	if stats := s.QueueStats(); stats.SendLen != 2 {
+		t.Fatalf("unexpected send len: %d", stats.SendLen)
+	}
+
+	// 取出一个之后再次可以放入
+	<-s.sendQueue.C
+	if ok, err := s.TrySend(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); !ok || err != nil {
+		t.Fatalf("try send after drain failed: %v, %v", ok, err)
+	}
+
+	// 停止发送之后返回错误
+	s.sendMutex.Lock()
+	s.isStopSend = true
+	s.sendMutex.Unlock()
+	if ok, err := s.TrySend(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); ok || !errors.Is(err, ErrStopSend) {
+		t.Fatalf("unexpected try send after stop: %v, %v", ok, err)
+	}
+}
+
+// releaseCounter 记录 Release 的调用次数
+type releaseCounter struct {
+	zeronetwork.Message
+	released *int32
+}
+
+func (m *releaseCounter) Release() {
+	atomic.AddInt32(m.released, 1)
+	m.Message.Release()
+}
+
+func TestSendLoopRelease(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	s := newSession(1, server, config, nil, nil)
+	defer s.Close()
+	go s.sendLoop()
+
+	// 会话仍然打开，写入之后的消息就应当归还，不能等到 sendLoop 退出
+	const total = 100
+	var released int32
+	for sn := uint16(1); sn <= total; sn++ {
+		message := &releaseCounter{Message: zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil), released: &released}
+		if err := s.Send(message); err != nil {
+			t.Fatalf("send %d failed: %s", sn, err.Error())
+		}
+	}
+
+	waitFor(t, "messages released", func() bool { return atomic.LoadInt32(&released) == total })
+	select {
+	case <-s.Closed():
+		t.Fatal("unexpected session closed")
+	default:
+	}
+}
+
+func TestCloseReleasesQueuedMessages(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.SendQueueSize = 2
+	config.SendQueueMaxSize = 8
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	server, client := newTCPPair(t)
+	defer client.Close()
+	s := newSession(1, server, config, nil, nil)
+
+	// sendLoop 未运行，通道与溢出缓冲区中都有消息
+	const total = 5
+	var released, failed int32
+	for sn := uint16(1); sn <= total; sn++ {
+		message := &releaseCounter{Message: zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil), released: &released}
+		err := s.SendResult(message, func(_ zeronetwork.Session, err error) {
+			if errors.Is(err, ErrStopSend) {
+				atomic.AddInt32(&failed, 1)
+			}
+		})
+		if err != nil {
+			t.Fatalf("send %d failed: %s", sn, err.Error())
+		}
+	}
+	s.Close()
+
+	// 关闭之后未发送的消息全部释放，回调 ErrStopSend
+	if atomic.LoadInt32(&released) != total || atomic.LoadInt32(&failed) != total {
+		t.Fatalf("unexpected released: %d, failed: %d", released, failed)
+	}
+}
+
+func TestResumeKeepsQueuedMessages(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.ResumeTTL = time.Minute
+	config.ResumeBufferSize = 8
+	config.ResumeStore = zeronetwork.NewResumeStore()
+	s := newSession(1, nil, config, nil, nil)
+	s.resumeCallback = func(zeronetwork.Session, zeronetwork.SessionID) {}
+	s.resumeToken.Store("token")
+
+	// sendLoop 未运行，会话关闭时发送队列中的消息存入断线缓存，恢复之后重新发送
+	var failed int32
+	for sn := uint16(1); sn <= 3; sn++ {
+		err := s.SendResult(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil), func(_ zeronetwork.Session, err error) {
+			if errors.Is(err, ErrStopSend) {
+				atomic.AddInt32(&failed, 1)
+			}
+		})
+		if err != nil {
+			t.Fatalf("send %d failed: %s", sn, err.Error())
+		}
+	}
+	s.Close()
+
+	state, err := config.ResumeStore.Load("token")
+	if err != nil {
+		t.Fatalf("load resume state failed: %s", err.Error())
+	}
+	messages := state.Buffer.Drain()
+	if len(messages) != 3 || atomic.LoadInt32(&failed) != 3 {
+		t.Fatalf("unexpected buffered: %d, failed: %d", len(messages), failed)
+	}
+	for i, message := range messages {
+		if message.SN() != uint16(i+1) {
+			t.Fatalf("out of order message: %s", message.String())
+		}
+	}
+}
+
+func TestSendQueueBurst(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.SendQueueSize = 4
+	config.SendQueueMaxSize = 256
+	config.SendOverflowPolicy = zeronetwork.SendOverflowClose
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	s := newSession(1, server, config, nil, nil)
+	defer s.Close()
+
+	// sendLoop 未运行，突发的消息超过 SendQueueSize 但是没有超过 SendQueueMaxSize，全部放入队列
+	const total = 200
+	for sn := uint16(1); sn <= total; sn++ {
+		if err := s.Send(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("send %d failed: %s", sn, err.Error())
+		}
+	}
+	if stats := s.QueueStats(); stats.SendLen != total || stats.SendCap != 4 || stats.SendMax != 256 || stats.SendHighWater != total {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 开始发送之后，对方按顺序收到所有消息
+	go s.sendLoop()
+
+	buffer := make([]byte, 4096)
+	ringBytesBuffer := zeroringbytes.New(len(buffer) * 4)
+	received := uint16(0)
+	for received < total {
+		_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := client.Read(buffer)
+		if err != nil {
+			t.Fatalf("read failed after %d messages: %s", received, err.Error())
+		}
+		if err := ringBytesBuffer.WriteN(buffer, n); err != nil {
+			t.Fatalf("write to ring buffer failed: %s", err.Error())
+		}
+
+		messages, err := config.Datapack.Unpack(ringBytesBuffer, nil, nil)
+		if err != nil {
+			t.Fatalf("unpack failed after %d messages: %s", received, err.Error())
+		}
+		for _, message := range messages {
+			if message.SN() != received+1 {
+				t.Fatalf("out of order message: %s, last sn: %d", message.String(), received)
+			}
+			received++
+		}
+	}
+
+	if s.CloseReason() != zeronetwork.CloseReasonNone {
+		t.Fatalf("unexpected close reason: %s", s.CloseReason())
+	}
+}
+
+func TestSendQueueOverflowClose(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.SendQueueSize = 4
+	config.SendQueueMaxSize = 8
+	config.SendOverflowPolicy = zeronetwork.SendOverflowClose
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	s := newSession(1, nil, config, nil, nil)
+
+	for i := 0; i < 8; i++ {
+		if err := s.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("send %d failed: %s", i, err.Error())
+		}
+	}
+
+	// 超过 SendQueueMaxSize 之后不再等待，丢弃该消息并关闭会话
+	start := time.Now()
+	if err := s.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); !errors.Is(err, zeronetwork.ErrSendQueueFull) {
+		t.Fatalf("expected ErrSendQueueFull, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("send blocked: %s", elapsed)
+	}
+
+	select {
+	case <-s.Closed():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not closed")
+	}
+	if s.CloseReason() != zeronetwork.CloseReasonSendQueueFull {
+		t.Fatalf("unexpected close reason: %s", s.CloseReason())
+	}
+}
+
+func TestHalfCloseDeliversQueuedBytes(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.HalfCloseTimeout = time.Second
+
+	s := newSession(1, server, config, nil, nil)
+	go s.sendLoop()
+	waitFor(t, "send loop", func() bool { return atomic.LoadInt32(&s.sendLooping) == 1 })
+
+	// 服务端尚未读取的数据，直接关闭连接时会发送 RST，尚未发出的数据会被丢弃
+	if _, err := client.Write([]byte("unread")); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	const total = 64
+	payload := make([]byte, 32*1024)
+	for i := 1; i <= total; i++ {
+		if err := s.Send(zerodatapack.NewLTDMessage(0, uint16(i), 0, 1, 1, payload)); err != nil {
+			t.Fatalf("Send failed: %s", err.Error())
+		}
+	}
+	go s.Close()
+
+	// 客户端稍后才开始读取，关闭时仍有数据留在服务端的发送缓冲区中
+	time.Sleep(100 * time.Millisecond)
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	reader := bufio.NewReader(client)
+	for i := 1; i <= total; i++ {
+		message, err := config.Datapack.(zeronetwork.ReaderDatapack).UnpackFrom(reader, nil, nil)
+		if err != nil {
+			t.Fatalf("unpack message %d failed: %s", i, err.Error())
+		}
+		if message.SN() != uint16(i) || len(message.Payload()) != len(payload) {
+			t.Fatalf("unexpected message: %s", message.String())
+		}
+	}
+
+	// 服务端关闭写方向之后，客户端读取到 io.EOF
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got: %v", err)
+	}
+}
+
+func TestLinger(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.Linger = 0
+
+	// Linger 为 0 时丢弃未发送的数据，直接发送 RST
+	s := newSession(1, server, config, nil, nil)
+	s.Close()
+
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("expected connection reset, got: %v", err)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	s := newTestSession(nil)
+
+	s.Set("name", "alice")
+	s.SetWithTTL("token", "abc", 50*time.Millisecond)
+	s.SetWithTTL("counter", 1, 50*time.Millisecond)
+	// 再次使用 Set 设置之后不再过期
+	s.Set("counter", 2)
+
+	if s.Get("token") != "abc" || s.Get("name") != "alice" || s.Get("counter") != 2 {
+		t.Fatalf("unexpected values before expiry, token: %v, name: %v, counter: %v", s.Get("token"), s.Get("name"), s.Get("counter"))
+	}
+
+	time.Sleep(80 * time.Millisecond)
+
+	if s.Get("token") != nil {
+		t.Fatalf("expected expired token, got: %v", s.Get("token"))
+	}
+	if s.Get("name") != "alice" || s.Get("counter") != 2 {
+		t.Fatalf("unexpected values after expiry, name: %v, counter: %v", s.Get("name"), s.Get("counter"))
+	}
+
+	// 过期之后可以重新设置
+	s.SetWithTTL("token", "def", time.Second)
+	if s.Get("token") != "def" {
+		t.Fatalf("unexpected token: %v", s.Get("token"))
+	}
+}
+
+// sessionGoroutines 当前执行会话方法的 goroutine 数量
+func sessionGoroutines() int {
+	buf := make([]byte, 1<<20)
+	n := 0
+	for _, stack := range strings.Split(string(buf[:runtime.Stack(buf, true)]), "\n\n") {
+		if strings.Contains(stack, "tcp.(*session).") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClosed(t *testing.T) {
+	for _, remote := range []bool{false, true} {
+		before := sessionGoroutines()
+
+		server, client := newTCPPair(t)
+
+		config := zeronetwork.DefaultConfig()
+		config.Datapack = zerodatapack.DefaultDatapck(config)
+		s := newSession(1, server, config, nil, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			return zerodatapack.Respond(message, 1, nil), nil
+		})
+		s.heartbeatInterval = time.Hour
+		go s.Run()
+		waitFor(t, "send loop", func() bool { return atomic.LoadInt32(&s.sendLooping) == 1 })
+
+		select {
+		case <-s.Closed():
+			t.Fatal("closed before Close")
+		default:
+		}
+
+		if remote {
+			// 对方关闭连接，由 recvLoop 执行 Close
+			_ = client.Close()
+		} else {
+			s.Close()
+			_ = client.Close()
+		}
+
+		select {
+		case <-s.Closed():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("remote: %v, Closed not signaled", remote)
+		}
+
+		// 通道关闭时，会话的所有 goroutine 均已退出
+		if after := sessionGoroutines(); after > before {
+			t.Fatalf("remote: %v, session goroutines leaked, before: %d, after: %d", remote, before, after)
+		}
+	}
+
+	// 关闭之后才执行 Run，不再启动收发循环
+	s := newTestSession(nil)
+	s.Close()
+	s.Run()
+	select {
+	case <-s.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Closed not signaled for a session closed before Run")
+	}
+}
+
+// countRecvLoops 当前正在运行的 recvLoop 数量
+func countRecvLoops() int {
+	buf := make([]byte, 1<<20)
+	return strings.Count(string(buf[:runtime.Stack(buf, true)]), "tcp.(*session).recvLoop")
+}
+
+func TestSessionModeWriteOnly(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.SessionMode = zeronetwork.SessionModeWriteOnly
+	config.HalfCloseTimeout = time.Second
+
+	var handled int32
+	s := newSession(1, server, config, nil, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		atomic.AddInt32(&handled, 1)
+		return nil, nil
+	})
+
+	before := countRecvLoops()
+	go s.Run()
+	waitFor(t, "send loop", func() bool { return atomic.LoadInt32(&s.sendLooping) == 1 })
+	if n := countRecvLoops(); n > before {
+		t.Fatalf("write only session started recvLoop, before: %d, after: %d", before, n)
+	}
+	if err := s.SetMode(zeronetwork.SessionModeDuplex); err != zeronetwork.ErrSessionRunning {
+		t.Fatalf("SetMode after Run, unexpected err: %v", err)
+	}
+
+	// 对方发送的消息不会被读取
+	p, err := config.Datapack.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	if _, err := client.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	if err := s.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, []byte("push"))); err != nil {
+		t.Fatalf("Send failed: %s", err.Error())
+	}
+	_ = client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	message, err := config.Datapack.(zeronetwork.ReaderDatapack).UnpackFrom(client, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack failed: %s", err.Error())
+	}
+	if message.SN() != 2 || string(message.Payload()) != "push" {
+		t.Fatalf("unexpected message: %s", message.String())
+	}
+
+	// 没有 recvLoop，关闭时不需要等待 HalfCloseTimeout
+	start := time.Now()
+	s.Close()
+	if elapsed := time.Since(start); elapsed >= config.HalfCloseTimeout {
+		t.Fatalf("close waited for recvLoop: %s", elapsed)
+	}
+	if atomic.LoadInt32(&handled) != 0 {
+		t.Fatal("write only session handled a message")
+	}
+}
+
+func TestSessionModeReadOnly(t *testing.T) {
+	server, client := newTCPPair(t)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	config.OnHandshake = func(session zeronetwork.Session) error {
+		return session.SetMode(zeronetwork.SessionModeReadOnly)
+	}
+
+	handled := make(chan uint16, 1)
+	s := newSession(1, server, config, nil, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		handled <- message.SN()
+		return zerodatapack.Respond(message, 2, nil), nil
+	})
+	go s.Run()
+	defer s.Close()
+	waitFor(t, "send loop", func() bool { return atomic.LoadInt32(&s.sendLooping) == 1 })
+
+	if s.Mode() != zeronetwork.SessionModeReadOnly {
+		t.Fatalf("unexpected mode: %d", s.Mode())
+	}
+
+	// 所有的发送方式都被拒绝
+	message := zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)
+	if err := s.Send(message); err != zeronetwork.ErrReadOnlySession {
+		t.Fatalf("Send, unexpected err: %v", err)
+	}
+	if _, err := s.TrySend(message); err != zeronetwork.ErrReadOnlySession {
+		t.Fatalf("TrySend, unexpected err: %v", err)
+	}
+	if err := s.SendNow(message); err != zeronetwork.ErrReadOnlySession {
+		t.Fatalf("SendNow, unexpected err: %v", err)
+	}
+	if err := s.SendAndClose(message, zeronetwork.CloseReasonKicked); err != zeronetwork.ErrReadOnlySession {
+		t.Fatalf("SendAndClose, unexpected err: %v", err)
+	}
+
+	// 仍然接收并处理消息，但是不发送响应
+	p, err := config.Datapack.Pack(zerodatapack.NewLTDMessage(0, 7, 0, 1, 1, nil), nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	if _, err := client.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	select {
+	case sn := <-handled:
+		if sn != 7 {
+			t.Fatalf("unexpected sn: %d", sn)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read only session did not handle the message")
+	}
+
+	_ = client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := client.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Fatalf("read only session sent data, n: %d, err: %v", n, err)
+	}
+}
+
+func BenchmarkDispatchLoop(b *testing.B) {
+	server, client := newTCPPair(b)
+	defer client.Close()
+
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+
+	done := make(chan struct{}, 1)
+	s := newSession(1, server, config, nil, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		done <- struct{}{}
+		return nil, nil
+	})
+	go s.dispatchLoop()
+	defer s.Close()
+
+	payload := make([]byte, 64)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.recvQueue <- zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload)
+		<-done
+	}
+}
@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+// getSockBuffer 读取连接的 SO_RCVBUF 或 SO_SNDBUF
+func getSockBuffer(t *testing.T, conn net.Conn, opt int) int {
+	rawConn, err := tcpConnOf(conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("syscall conn failed: %s", err.Error())
+	}
+
+	var value int
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, opt)
+	}); err != nil {
+		t.Fatalf("control failed: %s", err.Error())
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt failed: %s", sockErr.Error())
+	}
+
+	return value
+}
+
+func TestSocketBuffer(t *testing.T) {
+	const (
+		socketReadBuffer  = 48 * 1024
+		socketWriteBuffer = 24 * 1024
+	)
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithSocketReadBuffer(socketReadBuffer),
+		zeronetwork.WithSocketWriteBuffer(socketWriteBuffer),
+		zeronetwork.WithRecvBufferSize(4*1024),
+		zeronetwork.WithSendBufferSize(2*1024),
+		zeronetwork.WithRecvQueueSize(7),
+		zeronetwork.WithSendQueueSize(9),
+	).(*server)
+
+	// 每个配置只修改对应的字段
+	if s.config.SocketReadBuffer != socketReadBuffer || s.config.SocketWriteBuffer != socketWriteBuffer {
+		t.Fatalf("unexpected socket buffer: %d, %d", s.config.SocketReadBuffer, s.config.SocketWriteBuffer)
+	}
+	if s.config.RecvBufferSize != 4*1024 || s.config.SendBufferSize != 2*1024 {
+		t.Fatalf("unexpected buffer size: %d, %d", s.config.RecvBufferSize, s.config.SendBufferSize)
+	}
+	if s.config.RecvQueueSize != 7 || s.config.SendQueueSize != 9 {
+		t.Fatalf("unexpected queue size: %d, %d", s.config.RecvQueueSize, s.config.SendQueueSize)
+	}
+
+	port := listenTestServer(t, s)
+
+	c := NewClient(nil,
+		WithClientLoggerLevel(zerologger.INFO),
+		WithClientSocketReadBuffer(socketWriteBuffer),
+	)
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+	var ss zeronetwork.Session
+	s.SessionManager().Range(func(session zeronetwork.Session) bool {
+		ss = session
+		return false
+	})
+
+	// linux 会将设置的值翻倍，预留给内核使用
+	if got := getSockBuffer(t, ss.Conn(), syscall.SO_RCVBUF); got != 2*socketReadBuffer {
+		t.Errorf("unexpected server SO_RCVBUF: %d", got)
+	}
+	if got := getSockBuffer(t, ss.Conn(), syscall.SO_SNDBUF); got != 2*socketWriteBuffer {
+		t.Errorf("unexpected server SO_SNDBUF: %d", got)
+	}
+	if got := getSockBuffer(t, c.Conn(), syscall.SO_RCVBUF); got != 2*socketWriteBuffer {
+		t.Errorf("unexpected client SO_RCVBUF: %d", got)
+	}
+
+	// 队列大小只影响会话的消息队列
+	sess := ss.(*session)
+	if cap(sess.recvQueue) != 7 || sess.sendQueue.Cap() != 9 {
+		t.Errorf("unexpected session queue cap: %d, %d", cap(sess.recvQueue), sess.sendQueue.Cap())
+	}
+
+	_ = s.Close()
+}
@@ -0,0 +1,2719 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zerozlib "github.com/zerogo-hub/zero-helper/compress/zlib"
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zeronetworkkey "github.com/zerogo-hub/zero-node/pkg/network/key"
+	zeronetworklogger "github.com/zerogo-hub/zero-node/pkg/network/logger"
+)
+
+func TestServeAcceptBackoff(t *testing.T) {
+	s := NewServer().(*server)
+
+	errAccept := errors.New("accept: too many open files")
+	calls := 0
+	accept := func() (net.Conn, error) {
+		calls++
+		if calls > 5 {
+			s.isClosed.Store(true)
+		}
+		return nil, errAccept
+	}
+
+	start := time.Now()
+	s.serve(accept)
+	elapsed := time.Since(start)
+
+	if calls != 6 {
+		t.Fatalf("unexpected accept calls: %d", calls)
+	}
+
+	// 5ms + 10ms + 20ms + 40ms + 80ms
+	if elapsed < 155*time.Millisecond {
+		t.Fatalf("accept loop did not back off, elapsed: %s", elapsed)
+	}
+}
+
+func TestServeStopOnClosedListener(t *testing.T) {
+	s := NewServer().(*server)
+
+	calls := 0
+	accept := func() (net.Conn, error) {
+		calls++
+		return nil, net.ErrClosed
+	}
+
+	s.serve(accept)
+
+	if calls != 1 {
+		t.Fatalf("unexpected accept calls: %d", calls)
+	}
+}
+
+// newResumeServer 创建一个开启会话恢复的服务，返回监听端口
+// 模块 1 动作 1 设置自定义参数 user，动作 2 返回自定义参数 user
+func newResumeServer(t *testing.T, resumeTTL time.Duration, opts ...zeronetwork.Option) (*server, int) {
+	s := NewServer().WithOption(append([]zeronetwork.Option{
+		zeronetwork.WithResumeTTL(resumeTTL),
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+	}, opts...)...).(*server)
+
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := s.SessionManager().Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+		session.Set("user", string(message.Payload()))
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := s.SessionManager().Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+		user, _ := session.Get("user").(string)
+		return zerodatapack.Respond(message, 2, []byte(user)), nil
+	})
+
+	return s, listenTestServer(t, s)
+}
+
+// listenTestServer 在随机端口上开始 accept，返回监听端口
+func listenTestServer(t *testing.T, s *server) int {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	s.ln = ln
+	go s.serve(ln.Accept)
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// connectResumeClient 连接服务，收到的响应放入 responses
+func connectResumeClient(t *testing.T, port int, responses chan zeronetwork.Message) zeronetwork.Client {
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		// 处理函数返回之后消息会被释放，复制一份交给测试
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+
+	return c
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", desc)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitResponse(t *testing.T, responses chan zeronetwork.Message) zeronetwork.Message {
+	select {
+	case message := <-responses:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for response")
+	}
+	return nil
+}
+
+func TestResumeSession(t *testing.T) {
+	s, port := newResumeServer(t, 300*time.Millisecond)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 8)
+
+	// 第一次连接，设置自定义参数后断开
+	c1 := connectResumeClient(t, port, responses)
+	waitFor(t, "resume token", func() bool { return c1.ResumeToken() != "" })
+	token := c1.ResumeToken()
+
+	_ = c1.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("alice")))
+	waitResponse(t, responses)
+
+	// 第一个连接的会话 ID
+	sessionID := zeronetwork.SessionID(1)
+	if _, err := s.SessionManager().Get(sessionID); err != nil {
+		t.Fatalf("session not found: %s", err.Error())
+	}
+
+	c1.Close()
+	waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+
+	// 有效期内携带恢复令牌重连，沿用原会话 ID 与自定义参数
+	c2 := connectResumeClient(t, port, responses)
+	_ = c2.Send(zeronetworkkey.ResumeRequest(token))
+	_ = c2.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, nil))
+
+	if response := waitResponse(t, responses); string(response.Payload()) != "alice" {
+		t.Fatalf("parameters not resumed, user: %s", response.Payload())
+	}
+	waitFor(t, "client session id", func() bool { return c2.ID() == sessionID })
+	if _, err := s.SessionManager().Get(sessionID); err != nil {
+		t.Fatalf("session not rebound: %s", err.Error())
+	}
+
+	// 令牌只能使用一次
+	c2.Close()
+	waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+
+	c3 := connectResumeClient(t, port, responses)
+	defer c3.Close()
+	_ = c3.Send(zeronetworkkey.ResumeRequest(token))
+	_ = c3.Send(zerodatapack.NewLTDMessage(0, 3, 0, 1, 2, nil))
+
+	if response := waitResponse(t, responses); string(response.Payload()) != "" {
+		t.Fatalf("token reused, user: %s", response.Payload())
+	}
+}
+
+func TestResumeTokenAfterHandshake(t *testing.T) {
+	s, port := newResumeServer(t, 300*time.Millisecond, zeronetwork.WithWhetherCrypto(true))
+	defer s.Close()
+
+	c := NewClient(nil, WithClientLoggerLevel(zerologger.INFO), WithClientWhetherCrypto(true))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	// 秘钥协商完成之前不会下发恢复令牌
+	time.Sleep(100 * time.Millisecond)
+	if token := c.ResumeToken(); token != "" {
+		t.Fatalf("resume token sent before handshake: %s", token)
+	}
+
+	privateKey, randomValue, request := zeronetworkkey.ExchangeKeyRequest()
+	c.Set("ecdhPrivateKey", privateKey)
+	c.Set("ecdhRandomValue", randomValue)
+	if err := c.Send(request); err != nil {
+		t.Fatalf("send exchange key request failed: %s", err.Error())
+	}
+	waitFor(t, "resume token", func() bool { return c.ResumeToken() != "" })
+
+	ss, err := s.SessionManager().Get(1)
+	if err != nil {
+		t.Fatalf("session not found: %s", err.Error())
+	}
+	if token := ss.(*session).ResumeToken(); token != c.ResumeToken() {
+		t.Fatalf("unexpected resume token: %s, want: %s", c.ResumeToken(), token)
+	}
+}
+
+func TestForgedResumeResponse(t *testing.T) {
+	s, port := newResumeServer(t, 300*time.Millisecond)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 8)
+
+	victim := connectResumeClient(t, port, responses)
+	defer victim.Close()
+	_ = victim.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("alice")))
+	waitResponse(t, responses)
+
+	attacker := connectResumeClient(t, port, responses)
+	waitFor(t, "attacker session", func() bool { return s.SessionManager().Len() == 2 })
+
+	// 服务端不处理仅发给客户端的恢复结果，攻击者的会话 ID 保持不变
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, 1)
+	_ = attacker.Send(zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroResumeResponse, payload))
+	_ = attacker.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("mallory")))
+	waitResponse(t, responses)
+
+	ss, err := s.SessionManager().Get(2)
+	if err != nil {
+		t.Fatalf("attacker session not found: %s", err.Error())
+	}
+	if id := ss.ID(); id != 2 {
+		t.Fatalf("unexpected attacker session id: %d", id)
+	}
+
+	// 攻击者断开之后，被冒充的会话仍然存在，数据没有被修改
+	attacker.Close()
+	waitFor(t, "attacker closed", func() bool { return s.SessionManager().Len() == 1 })
+	if _, err := s.SessionManager().Get(1); err != nil {
+		t.Fatalf("victim session removed: %s", err.Error())
+	}
+
+	_ = victim.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, nil))
+	if response := waitResponse(t, responses); string(response.Payload()) != "alice" {
+		t.Fatalf("victim session modified, user: %s", response.Payload())
+	}
+}
+
+func TestResumeSessionExpired(t *testing.T) {
+	s, port := newResumeServer(t, 50*time.Millisecond)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 8)
+
+	c1 := connectResumeClient(t, port, responses)
+	waitFor(t, "resume token", func() bool { return c1.ResumeToken() != "" })
+	token := c1.ResumeToken()
+
+	_ = c1.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("bob")))
+	waitResponse(t, responses)
+
+	c1.Close()
+	waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+
+	time.Sleep(100 * time.Millisecond)
+
+	// 令牌过期，得到一个新的会话
+	c2 := connectResumeClient(t, port, responses)
+	defer c2.Close()
+	_ = c2.Send(zeronetworkkey.ResumeRequest(token))
+	_ = c2.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, nil))
+
+	if response := waitResponse(t, responses); string(response.Payload()) != "" {
+		t.Fatalf("expired session resumed, user: %s", response.Payload())
+	}
+	if c2.ID() != 0 {
+		t.Fatalf("unexpected session id: %d", c2.ID())
+	}
+}
+
+func TestResumeBufferReplay(t *testing.T) {
+	s, port := newResumeServer(t, 300*time.Millisecond, zeronetwork.WithResumeBufferSize(2))
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 8)
+
+	c1 := connectResumeClient(t, port, responses)
+	waitFor(t, "resume token", func() bool { return c1.ResumeToken() != "" })
+	token := c1.ResumeToken()
+	sessionID := zeronetwork.SessionID(1)
+
+	c1.Close()
+	waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+
+	// 断线期间推送的消息被缓存，超出数量时丢弃最早的消息
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, []byte(payload))); err != nil {
+			t.Fatalf("push during disconnect failed: %s", err.Error())
+		}
+	}
+
+	// 恢复会话之后按照原来的顺序收到缓存的消息
+	c2 := connectResumeClient(t, port, responses)
+	defer c2.Close()
+	_ = c2.Send(zeronetworkkey.ResumeRequest(token))
+	for _, expected := range []string{"b", "c"} {
+		if message := waitResponse(t, responses); string(message.Payload()) != expected {
+			t.Fatalf("unexpected replay: %s, expected: %s", message.Payload(), expected)
+		}
+	}
+	waitFor(t, "client session id", func() bool { return c2.ID() == sessionID })
+
+	// 恢复之后直接发送给新的连接
+	if err := s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, []byte("d"))); err != nil {
+		t.Fatalf("push after resume failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "d" {
+		t.Fatalf("unexpected push: %s", message.Payload())
+	}
+}
+
+func TestResumeBufferExpired(t *testing.T) {
+	s, port := newResumeServer(t, 50*time.Millisecond, zeronetwork.WithResumeBufferSize(8))
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 8)
+
+	c1 := connectResumeClient(t, port, responses)
+	waitFor(t, "resume token", func() bool { return c1.ResumeToken() != "" })
+	token := c1.ResumeToken()
+	sessionID := zeronetwork.SessionID(1)
+
+	c1.Close()
+	waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+
+	if err := s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, []byte("a"))); err != nil {
+		t.Fatalf("push during disconnect failed: %s", err.Error())
+	}
+
+	// 过期之后缓存的消息全部丢弃，不再缓存新的消息
+	time.Sleep(100 * time.Millisecond)
+	if err := s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, []byte("b"))); !errors.Is(err, zeronetwork.ErrSessionNotFound) {
+		t.Fatalf("unexpected err after expired: %v", err)
+	}
+
+	c2 := connectResumeClient(t, port, responses)
+	defer c2.Close()
+	_ = c2.Send(zeronetworkkey.ResumeRequest(token))
+	_ = c2.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, nil))
+	if response := waitResponse(t, responses); response.ModuleID() != 1 || len(response.Payload()) != 0 {
+		t.Fatalf("unexpected response: %s", response.String())
+	}
+}
+
+// forceCountManager 记录被强行关闭的连接数量
+type forceCountManager struct {
+	zeronetwork.SessionManager
+
+	forced int
+}
+
+func (m *forceCountManager) Close(timeout time.Duration) int {
+	m.forced = m.SessionManager.Close(timeout)
+	return m.forced
+}
+
+func TestCloseForceAfterTimeout(t *testing.T) {
+	closeTimeout := 200 * time.Millisecond
+
+	s := NewServer().WithOption(
+		zeronetwork.WithCloseTimeout(closeTimeout),
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+	).(*server)
+	manager := &forceCountManager{SessionManager: s.sessionManager}
+	s.sessionManager = manager
+
+	stop := make(chan bool)
+	defer close(stop)
+
+	// 持续产生推送，客户端不读取，写入最终会阻塞
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := s.SessionManager().Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			select {
+			case <-stop:
+				return nil, nil
+			default:
+			}
+
+			if err := session.Send(zerodatapack.NewLTDMessage(0, message.SN(), 0, 1, 2, make([]byte, 4096))); err != nil {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	})
+
+	port := listenTestServer(t, s)
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	p, err := s.config.Datapack.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	if _, err := conn.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	// 等待发送缓冲区被填满
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	_ = s.Close()
+	elapsed := time.Since(start)
+
+	if elapsed < closeTimeout || elapsed > closeTimeout+time.Second {
+		t.Fatalf("unexpected close elapsed: %s", elapsed)
+	}
+	if manager.forced != 1 {
+		t.Fatalf("unexpected forced close num: %d", manager.forced)
+	}
+}
+
+func TestSendJSON(t *testing.T) {
+	type user struct {
+		Name  string   `json:"name"`
+		Level int      `json:"level"`
+		Tags  []string `json:"tags"`
+	}
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+	).(*server)
+	defer s.Close()
+
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		in := &user{}
+		if err := message.BindJSON(in); err != nil {
+			return nil, err
+		}
+		in.Level++
+		return zerodatapack.RespondJSON(message, 2, in)
+	})
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, listenTestServer(t, s), responses)
+	defer c.Close()
+
+	if err := c.SendJSON(1, 1, &user{Name: "zero", Level: 1, Tags: []string{"a", "b"}}); err != nil {
+		t.Fatalf("SendJSON failed: %s", err.Error())
+	}
+
+	message := waitResponse(t, responses)
+	if message.ModuleID() != 1 || message.ActionID() != 2 {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+
+	out := &user{}
+	if err := message.BindJSON(out); err != nil {
+		t.Fatalf("BindJSON failed: %s", err.Error())
+	}
+	if out.Name != "zero" || out.Level != 2 || len(out.Tags) != 2 || out.Tags[1] != "b" {
+		t.Fatalf("unexpected user: %+v", out)
+	}
+}
+
+func TestMaxConnPerIP(t *testing.T) {
+	rejects := make(chan error, 8)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithMaxConnPerIP(2),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			rejects <- reason
+		}),
+	).(*server)
+	defer s.Close()
+
+	address := fmt.Sprintf("127.0.0.1:%d", listenTestServer(t, s))
+
+	dial := func(localIP string) net.Conn {
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(localIP)}}
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("dial failed: %s", err.Error())
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+
+	first := dial("127.0.0.1")
+	dial("127.0.0.1")
+	dial("127.0.0.1")
+
+	select {
+	case reason := <-rejects:
+		if reason != zeronetwork.ErrMaxConnPerIP {
+			t.Fatalf("unexpected reason: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("excess conn not rejected")
+	}
+	waitFor(t, "2 sessions", func() bool { return s.SessionManager().Len() == 2 })
+
+	// 其它 IP 不受影响
+	dial("127.0.0.2")
+	waitFor(t, "3 sessions", func() bool { return s.SessionManager().Len() == 3 })
+
+	// 连接关闭后归还名额
+	_ = first.Close()
+	waitFor(t, "released", func() bool { return s.ipConnCounter.Count("127.0.0.1") == 1 })
+	dial("127.0.0.1")
+	waitFor(t, "3 sessions", func() bool { return s.SessionManager().Len() == 3 })
+
+	if len(rejects) != 0 {
+		t.Fatalf("unexpected rejects: %d", len(rejects))
+	}
+}
+
+func TestRedirectAll(t *testing.T) {
+	s1, port1 := newResumeServer(t, 0)
+	defer s1.Close()
+	s2, port2 := newResumeServer(t, 0)
+	defer s2.Close()
+
+	redirects := make(chan string, 8)
+	onRedirect := func(host string, port int) {
+		redirects <- fmt.Sprintf("%s:%d", host, port)
+	}
+
+	responses := make(chan zeronetwork.Message, 8)
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}
+
+	// c1 自动连接到新的地址，c2 只接收通知
+	c1 := NewClient(handler, WithClientLoggerLevel(zerologger.INFO), WithClientAutoRedirect(true), WithClientOnRedirect(onRedirect))
+	c2 := NewClient(handler, WithClientLoggerLevel(zerologger.INFO), WithClientOnRedirect(onRedirect))
+	for _, c := range []zeronetwork.Client{c1, c2} {
+		if err := c.Connect("tcp4", "127.0.0.1", port1); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+		defer c.Close()
+	}
+	waitFor(t, "2 sessions", func() bool { return s1.SessionManager().Len() == 2 })
+
+	s1.SessionManager().RedirectAll("127.0.0.1", port2)
+
+	expected := fmt.Sprintf("127.0.0.1:%d", port2)
+	for i := 0; i < 2; i++ {
+		select {
+		case address := <-redirects:
+			if address != expected {
+				t.Fatalf("unexpected redirect address: %s, expected: %s", address, expected)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for redirect")
+		}
+	}
+
+	waitFor(t, "old sessions closed", func() bool { return s1.SessionManager().Len() == 0 })
+	waitFor(t, "reconnected", func() bool { return s2.SessionManager().Len() == 1 })
+
+	_ = c1.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("alice")))
+	if response := waitResponse(t, responses); response.ActionID() != 1 {
+		t.Fatalf("unexpected response: %s", response.String())
+	}
+}
+
+func TestOnHandshake(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		handled := make(chan bool, 1)
+		s := NewServer().WithOption(
+			zeronetwork.WithLoggerLevel(zerologger.INFO),
+			zeronetwork.WithOnHandshake(func(session zeronetwork.Session) error {
+				// 等待客户端的请求到达，请求不会先于 server-hello 被处理
+				time.Sleep(50 * time.Millisecond)
+				if fail {
+					return errors.New("handshake failed")
+				}
+				return session.SendNow(zerodatapack.NewLTDMessage(0, 0, 0, 0, 1, []byte("hello")))
+			}),
+		).(*server)
+
+		_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			handled <- true
+			return zerodatapack.Respond(message, 1, nil), nil
+		})
+
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listenTestServer(t, s)})
+		if err != nil {
+			t.Fatalf("dial failed: %s", err.Error())
+		}
+
+		p, err := s.config.Datapack.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), nil, nil)
+		if err != nil {
+			t.Fatalf("pack failed: %s", err.Error())
+		}
+		if _, err := conn.Write(p); err != nil {
+			t.Fatalf("write failed: %s", err.Error())
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		message, err := s.config.Datapack.(zeronetwork.ReaderDatapack).UnpackFrom(conn, nil, nil)
+
+		if fail {
+			// 握手失败时关闭连接，请求不会被处理
+			if err == nil {
+				t.Fatalf("unexpected message after handshake failed: %s", message.String())
+			}
+			waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+			if len(handled) != 0 {
+				t.Fatal("message dispatched after handshake failed")
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("unpack failed: %s", err.Error())
+			}
+			if message.ModuleID() != 0 || message.ActionID() != 1 || string(message.Payload()) != "hello" {
+				t.Fatalf("first frame is not server-hello: %s", message.String())
+			}
+		}
+
+		_ = conn.Close()
+		_ = s.Close()
+	}
+}
+
+func TestSetDatapack(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithDatapackSwitchable(true),
+	).(*server)
+
+	v1 := s.config.Datapack
+	v2 := zerodatapack.NewLTD(false, 0, nil, false, false, s.config.Logger, zerodatapack.WithLTDVersion(2))
+
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, _ := s.SessionManager().Get(message.SessionID())
+
+		// 握手响应仍然使用原来的封包工具
+		if err := session.Send(zerodatapack.Respond(message, 1, []byte("v1"))); err != nil {
+			return nil, err
+		}
+		if err := session.SetDatapack(v2); err != nil {
+			return nil, err
+		}
+		if err := session.SetDatapack(v2); !errors.Is(err, zeronetwork.ErrDatapackSwitched) {
+			t.Errorf("expected ErrDatapackSwitched, got: %v", err)
+		}
+		return nil, nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listenTestServer(t, s)})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+	defer s.Close()
+
+	// 握手消息与切换之后的消息在同一次写入中到达
+	handshake, err := v1.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil), nil, nil)
+	if err != nil {
+		t.Fatalf("pack handshake failed: %s", err.Error())
+	}
+	echo, err := v2.Pack(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, []byte("v2")), nil, nil)
+	if err != nil {
+		t.Fatalf("pack echo failed: %s", err.Error())
+	}
+	if _, err := conn.Write(append(handshake, echo...)); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	message, err := v1.(zeronetwork.ReaderDatapack).UnpackFrom(conn, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack handshake response failed: %s", err.Error())
+	}
+	if message.ActionID() != 1 || string(message.Payload()) != "v1" {
+		t.Fatalf("unexpected handshake response: %s", message.String())
+	}
+
+	message, err = v2.(zeronetwork.ReaderDatapack).UnpackFrom(conn, nil, nil)
+	if err != nil {
+		t.Fatalf("unpack echo response failed: %s", err.Error())
+	}
+	if message.ActionID() != 2 || string(message.Payload()) != "v2" {
+		t.Fatalf("unexpected echo response: %s", message.String())
+	}
+}
+
+func TestHeartbeatRTT(t *testing.T) {
+	s := NewServer().WithOption(zeronetwork.WithLoggerLevel(zerologger.INFO)).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO), WithClientHeartbeat(10*time.Millisecond))
+	defer c.Close()
+
+	if c.RTT() != 0 {
+		t.Fatalf("expected zero rtt before heartbeat, got: %s", c.RTT())
+	}
+
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+
+	waitFor(t, "rtt measured", func() bool { return c.RTT() > 0 })
+
+	// 本地回环的往返时间不会超过 1 秒
+	time.Sleep(50 * time.Millisecond)
+	if rtt := c.RTT(); rtt <= 0 || rtt > time.Second {
+		t.Fatalf("implausible rtt: %s", rtt)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithHandlerTimeout(50*time.Millisecond),
+		zeronetwork.WithHandlerTimeoutCode(504),
+	).(*server)
+
+	canceled := make(chan bool, 1)
+	_ = s.Router().AddContextRoute(zeronetwork.NewRouteID(1, 1), func(ctx context.Context, message zeronetwork.Message) (zeronetwork.Message, error) {
+		<-ctx.Done()
+		canceled <- true
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 4)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+		t.Fatalf("send slow request failed: %s", err.Error())
+	}
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 2, []byte("next"))); err != nil {
+		t.Fatalf("send request failed: %s", err.Error())
+	}
+
+	// 超时响应携带错误码，沿用请求的 SN 与 action
+	message := waitResponse(t, responses)
+	if message.SN() != 1 || message.ActionID() != 1 || message.Code() != 504 {
+		t.Fatalf("unexpected timeout response: %s", message.String())
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context not canceled")
+	}
+
+	// 会话没有被阻塞，继续处理之后的消息
+	message = waitResponse(t, responses)
+	if message.SN() != 2 || string(message.Payload()) != "next" {
+		t.Fatalf("unexpected response after timeout: %s", message.String())
+	}
+
+	select {
+	case message := <-responses:
+		t.Fatalf("late response from timed out handler: %s", message.String())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	// 套接字接收缓冲区小于分块长度时，本地回环的吞吐量很低
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithRecvBufferSize(256*1024),
+	).(*server)
+
+	var received bytes.Buffer
+	reassembler := zerodatapack.NewStreamReassembler(&received)
+	done := make(chan error, 1)
+	_ = s.Router().AddRouter(1, 3, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		last, err := reassembler.Write(message)
+		if err != nil || last {
+			done <- err
+		}
+		return nil, nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	c := connectResumeClient(t, port, make(chan zeronetwork.Message, 1))
+	defer c.Close()
+
+	// 长度不是分块长度的整数倍，最后一个分块不满
+	data := make([]byte, 3*1024*1024+123)
+	_, _ = rand.Read(data)
+
+	if err := c.SendStream(1, 3, bytes.NewReader(data)); err != nil {
+		t.Fatalf("send stream failed: %s", err.Error())
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("reassemble failed: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for stream")
+	}
+
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Fatalf("stream mismatch, received %d bytes, sent %d bytes", received.Len(), len(data))
+	}
+}
+
+func TestClientAutoSN(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithRecvBufferSize(256*1024),
+	).(*server)
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	const total = 70000
+
+	responses := make(chan zeronetwork.Message, 1024)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	if c.NextSN() != 1 {
+		t.Fatalf("expected first sn 1, got %d", c.NextSN())
+	}
+
+	go func() {
+		for i := 0; i < total; i++ {
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint32(payload, uint32(i))
+			if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, payload)); err != nil {
+				t.Errorf("send %d failed: %s", i, err.Error())
+				return
+			}
+		}
+	}()
+
+	// 自动分配的 SN 在 [1, RequestSNMin) 之间循环，第 i 个消息的 SN 为 i%span+1
+	span := int(zeronetwork.RequestSNMin) - 1
+	for i := 0; i < total; i++ {
+		message := waitResponse(t, responses)
+		index := binary.BigEndian.Uint32(message.Payload())
+		if message.SN() == 0 || message.SN() >= zeronetwork.RequestSNMin || message.SN() != uint16(int(index)%span+1) {
+			t.Fatalf("message %d has sn %d", index, message.SN())
+		}
+	}
+
+	if next := c.NextSN(); int(next) != total%span+1 {
+		t.Fatalf("expected next sn %d, got %d", total%span+1, next)
+	}
+}
+
+func TestOnAccept(t *testing.T) {
+	rejected := make(chan error, 1)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithOnAccept(func(conn net.Conn) (bool, error) {
+			p, err := conn.(*zeronetwork.PeekConn).Peek(1)
+			if err != nil {
+				return false, err
+			}
+			return p[0] != 0xff, nil
+		}),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			rejected <- reason
+		}),
+	).(*server)
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	// 首字节为 0xff 的连接被拒绝
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte{0xff}); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	select {
+	case reason := <-rejected:
+		if !errors.Is(reason, zeronetwork.ErrAcceptRejected) {
+			t.Fatalf("unexpected reject reason: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reject")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("rejected conn is not closed")
+	}
+	if s.SessionManager().Len() != 0 {
+		t.Fatalf("unexpected sessions: %d", s.SessionManager().Len())
+	}
+
+	// 被查看的数据不会丢失，会话可以正常解包
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, []byte("peeked"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "peeked" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+}
+
+// ringDatapack 隐藏 ReaderDatapack，使会话使用环形缓冲区解包
+type ringDatapack struct {
+	zeronetwork.Datapack
+}
+
+func TestRecvMessageLargerThanBuffer(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithRecvBufferSize(16*1024),
+	).(*server)
+	s.config.Datapack = ringDatapack{s.config.Datapack}
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	// 消息长度远大于 RecvBufferSize 及其 2 倍的环形缓冲区
+	payload := make([]byte, 60*1024)
+	_, _ = rand.Read(payload)
+
+	for i := 0; i < 3; i++ {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, payload)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+		if message := waitResponse(t, responses); !bytes.Equal(message.Payload(), payload) {
+			t.Fatalf("unexpected response, length: %d", len(message.Payload()))
+		}
+	}
+
+	// 之后的小消息不受影响
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, []byte("small"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "small" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+}
+
+func TestShutdownNotice(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithShutdownNotice(true),
+		zeronetwork.WithShutdownReason("maintenance"),
+		zeronetwork.WithShutdownRetryAfter(3*time.Second),
+	).(*server)
+	port := listenTestServer(t, s)
+
+	// 每个客户端按顺序记录收到关闭通知与连接关闭
+	events := make([]chan string, 2)
+	for i := range events {
+		ch := make(chan string, 2)
+		events[i] = ch
+
+		c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			return nil, nil
+		},
+			WithClientLoggerLevel(zerologger.INFO),
+			WithClientOnShutdown(func(reason string, retryAfter time.Duration) {
+				ch <- fmt.Sprintf("shutdown %s %s", reason, retryAfter)
+			}),
+			WithClientOnConnClose(func(zeronetwork.Session) {
+				ch <- "closed"
+			}),
+		)
+		defer c.Close()
+
+		if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+	}
+
+	waitFor(t, "sessions connected", func() bool { return s.SessionManager().Len() == len(events) })
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %s", err.Error())
+	}
+
+	for i, ch := range events {
+		for _, expected := range []string{"shutdown maintenance 3s", "closed"} {
+			select {
+			case event := <-ch:
+				if event != expected {
+					t.Fatalf("client %d: expected %q, got %q", i, expected, event)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("client %d: timeout waiting for %q", i, expected)
+			}
+		}
+	}
+}
+
+func TestShutdownNoticeQueueFull(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithShutdownNotice(true),
+		zeronetwork.WithSendQueueSize(1),
+		zeronetwork.WithCloseTimeout(200*time.Millisecond),
+	).(*server)
+
+	// sendLoop 未运行，发送队列已满的会话
+	server, client := newTCPPair(t)
+	defer client.Close()
+	session := newSession(s.sessionManager.GenSessionID(), server, s.config, nil, nil)
+	for {
+		message := zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)
+		if ok, _ := session.TrySend(message); !ok {
+			message.Release()
+			break
+		}
+	}
+	s.sessionManager.Add(session)
+
+	// 未调用 Start 也可以关闭，关闭通知不等待发送队列的空间
+	start := time.Now()
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close blocked by shutdown notice: %s", elapsed)
+	}
+}
+func TestPeerStats(t *testing.T) {
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	s := NewServer().WithOption(zeronetwork.WithLoggerLevel(zerologger.INFO)).(*server)
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		started <- true
+		<-release
+		return nil, nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	// 第一个消息阻塞 dispatchLoop，之后的消息停留在接收队列中
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, nil)); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	<-started
+
+	for i := 0; i < 4; i++ {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+
+	waitFor(t, "messages queued", func() bool { return s.Stats().RecvLen == 4 })
+
+	stats := s.Stats()
+	if stats.Sessions != 1 || stats.RecvHighWater != 4 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	close(release)
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	waitFor(t, "queue drained", func() bool { return s.Stats().RecvLen == 0 })
+	if stats := s.Stats(); stats.RecvHighWater != 4 {
+		t.Fatalf("high water changed after drain: %+v", stats)
+	}
+}
+
+func TestSendRateLimit(t *testing.T) {
+	received := make(chan bool, 32)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithRecvBufferSize(256*1024),
+	).(*server)
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		received <- true
+		return nil, nil
+	})
+
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	const (
+		rate  = 400 * 1024
+		count = 20
+		size  = 10 * 1024
+	)
+
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO), WithClientSendRateLimit(rate))
+	defer c.Close()
+
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, make([]byte, size))); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+
+	// 消息很快放入发送队列，实际写入受限于发送速度
+	for i := 0; i < count; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for message %d", i)
+		}
+	}
+	elapsed := time.Since(start)
+
+	// 写入最后一个消息之前已经发送了 190K，扣除 0.1 秒的突发流量，至少需要 (190K - 40K) / 400K ≈ 0.37 秒
+	if elapsed < 300*time.Millisecond {
+		t.Fatalf("egress not shaped, elapsed: %s", elapsed)
+	}
+	if delay := c.QueueStats().SendShapedDelay; delay < 300*time.Millisecond {
+		t.Fatalf("unexpected shaped delay: %s", delay)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	rejected := make(chan error, 4)
+	closed := make(chan zeronetwork.SessionID, 4)
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithWhetherCrypto(true),
+		zeronetwork.WithHandshakeTimeout(200*time.Millisecond),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			rejected <- reason
+		}),
+		zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+			closed <- session.ID()
+		}),
+	).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	// 完成秘钥协商的连接
+	c := NewClient(nil, WithClientLoggerLevel(zerologger.INFO), WithClientWhetherCrypto(true))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	privateKey, randomValue, request := zeronetworkkey.ExchangeKeyRequest()
+	c.Set("ecdhPrivateKey", privateKey)
+	c.Set("ecdhRandomValue", randomValue)
+	if err := c.Send(request); err != nil {
+		t.Fatalf("send exchange key request failed: %s", err.Error())
+	}
+	waitFor(t, "handshake", func() bool { return c.HandshakeState() == zeronetwork.HandshakeReady })
+
+	// 连接之后什么都不发送
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	select {
+	case reason := <-rejected:
+		if !errors.Is(reason, zeronetwork.ErrHandshakeTimeout) {
+			t.Fatalf("unexpected reject reason: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reject")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected conn closed")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for OnConnClose")
+	}
+
+	// 完成秘钥协商的连接不受影响
+	time.Sleep(300 * time.Millisecond)
+	if len(rejected) != 0 || len(closed) != 0 || s.SessionManager().Len() != 1 {
+		t.Fatalf("unexpected rejected: %d, closed: %d, sessions: %d", len(rejected), len(closed), s.SessionManager().Len())
+	}
+}
+
+func TestOnConnectedAsync(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		// loaded OnConnected 是否已经完成，比如加载玩家数据
+		var loaded int32
+		release := make(chan struct{})
+
+		s := NewServer().WithOption(
+			zeronetwork.WithLoggerLevel(zerologger.INFO),
+			zeronetwork.WithOnConnectedAsync(async),
+			zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+				select {
+				case <-release:
+				case <-time.After(200 * time.Millisecond):
+				}
+				atomic.StoreInt32(&loaded, 1)
+			}),
+		).(*server)
+
+		_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			return zerodatapack.Respond(message, 1, []byte{byte(atomic.LoadInt32(&loaded))}), nil
+		})
+		port := listenTestServer(t, s)
+
+		responses := make(chan zeronetwork.Message, 1)
+		c := connectResumeClient(t, port, responses)
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+
+		response := waitResponse(t, responses)
+		if async {
+			// 第一个消息不需要等待 OnConnected 完成
+			if response.Payload()[0] != 0 {
+				t.Fatal("first message waited for async OnConnected")
+			}
+		} else if response.Payload()[0] != 1 {
+			t.Fatal("first message dispatched before OnConnected finished")
+		}
+
+		close(release)
+		c.Close()
+		_ = s.Close()
+	}
+}
+
+func TestAcceptGoroutines(t *testing.T) {
+	const maxConnNum, total = 20, 60
+
+	var rejected int32
+	var mutex sync.Mutex
+	sessionIDs := make(map[zeronetwork.SessionID]bool)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.ERROR),
+		zeronetwork.WithAcceptGoroutines(8),
+		zeronetwork.WithMaxConnNum(maxConnNum),
+		zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if sessionIDs[session.ID()] {
+				t.Errorf("duplicate session id: %d", session.ID())
+			}
+			sessionIDs[session.ID()] = true
+		}),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			atomic.AddInt32(&rejected, 1)
+		}),
+	).(*server)
+	defer s.Close()
+
+	address := fmt.Sprintf("127.0.0.1:%d", listenTestServer(t, s))
+
+	// 同时发起连接，被拒绝的连接由服务端关闭
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				t.Errorf("dial failed: %s", err.Error())
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}()
+	}
+	wg.Wait()
+
+	waitFor(t, "all conns handled", func() bool {
+		return s.SessionManager().Len()+int(atomic.LoadInt32(&rejected)) == total
+	})
+	if s.SessionManager().Len() != maxConnNum {
+		t.Fatalf("expected %d sessions, got %d", maxConnNum, s.SessionManager().Len())
+	}
+
+	// 每个会话的 ID 都不相同
+	waitFor(t, "OnConnected", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(sessionIDs) == maxConnNum
+	})
+}
+
+func BenchmarkAccept(b *testing.B) {
+	for _, acceptGoroutines := range []int{1, 8} {
+		b.Run(fmt.Sprintf("goroutines-%d", acceptGoroutines), func(b *testing.B) {
+			var connected int64
+			s := NewServer().WithOption(
+				zeronetwork.WithLoggerLevel(zerologger.ERROR),
+				zeronetwork.WithAcceptGoroutines(acceptGoroutines),
+				zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+					atomic.AddInt64(&connected, 1)
+				}),
+			).(*server)
+			defer s.Close()
+
+			ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatalf("listen failed: %s", err.Error())
+			}
+			s.ln = ln
+			go s.serve(ln.Accept)
+			address := ln.Addr().String()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					conn, err := net.Dial("tcp", address)
+					if err != nil {
+						b.Errorf("dial failed: %s", err.Error())
+						return
+					}
+					_ = conn.Close()
+				}
+			})
+			for atomic.LoadInt64(&connected) < int64(b.N) {
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestSessionManagerRequest(t *testing.T) {
+	connected := make(chan zeronetwork.SessionID, 1)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+			connected <- session.ID()
+		}),
+	).(*server)
+	routed := make(chan bool, 1)
+	_ = s.Router().AddRouter(5, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		routed <- true
+		return nil, nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	// 客户端原样返回 SN 与 module，module 6 的请求不响应
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		if message.ModuleID() == 5 {
+			return zerodatapack.Respond(message, 2, []byte("confirmed")), nil
+		}
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	var sessionID zeronetwork.SessionID
+	select {
+	case sessionID = <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for session")
+	}
+
+	response, err := s.SessionManager().Request(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 5, 1, []byte("confirm?")), 2*time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+	if response.SN() < zeronetwork.RequestSNMin || response.ActionID() != 2 || string(response.Payload()) != "confirmed" {
+		t.Fatalf("unexpected response: %s", response.String())
+	}
+	if len(routed) != 0 {
+		t.Fatal("response dispatched to router")
+	}
+
+	if _, err := s.SessionManager().Request(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 6, 1, nil), 100*time.Millisecond); err != zeronetwork.ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got: %v", err)
+	}
+
+	// 等待期间会话关闭
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.SessionManager().Request(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 6, 1, nil), 0)
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.Close()
+
+	select {
+	case err := <-result:
+		if err != zeronetwork.ErrStopSend {
+			t.Fatalf("expected ErrStopSend, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("request not woken up by session close")
+	}
+}
+
+func TestRequestSNCollision(t *testing.T) {
+	connected := make(chan zeronetwork.SessionID, 1)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+			connected <- session.ID()
+		}),
+	).(*server)
+	routed := make(chan uint16, 8)
+	_ = s.Router().AddRouter(5, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		routed <- message.SN()
+		return nil, nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	// 客户端不响应服务端的请求
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	var sessionID zeronetwork.SessionID
+	select {
+	case sessionID = <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for session")
+	}
+
+	// 服务端等待中的请求使用 SN RequestSNMin
+	result := make(chan error, 1)
+	go func() {
+		_, err := s.SessionManager().Request(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 5, 1, nil), 500*time.Millisecond)
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 客户端自动分配的 SN 即将到达 RequestSNMin，同一 module 的消息仍然派发给路由，不会被当作请求的响应
+	atomic.StoreUint32(&c.(*client).session().sn, uint32(zeronetwork.RequestSNMin-2))
+	for i := 0; i < 3; i++ {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 5, 1, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case sn := <-routed:
+			if sn >= zeronetwork.RequestSNMin {
+				t.Fatalf("client message uses request sn %d", sn)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not dispatched to router", i)
+		}
+	}
+
+	if err := <-result; err != zeronetwork.ErrRequestTimeout {
+		t.Fatalf("expected ErrRequestTimeout, got: %v", err)
+	}
+}
+
+// slowLogRecorder 记录慢处理的警告日志
+type slowLogRecorder struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (h *slowLogRecorder) Enabled(_ context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn
+}
+
+func (h *slowLogRecorder) Handle(_ context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.messages = append(h.messages, record.Message)
+	return nil
+}
+
+func (h *slowLogRecorder) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *slowLogRecorder) WithGroup(name string) slog.Handler { return h }
+
+func TestSlowHandlerThreshold(t *testing.T) {
+	recorder := &slowLogRecorder{}
+	s := NewServer().WithOption(
+		zeronetwork.WithLogger(zeronetworklogger.NewSlog(slog.New(recorder))),
+		zeronetwork.WithSlowHandlerThreshold(50*time.Millisecond),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		time.Sleep(100 * time.Millisecond)
+		return zerodatapack.Respond(message, 2, nil), nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	responses := make(chan zeronetwork.Message, 2)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	for _, action := range []uint8{1, 2} {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, action, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+		waitResponse(t, responses)
+	}
+
+	// 只有慢的处理函数记录日志
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if len(recorder.messages) != 1 || !strings.Contains(recorder.messages[0], "module: 1, action: 2") {
+		t.Fatalf("unexpected slow handler logs: %v", recorder.messages)
+	}
+}
+
+func TestMaxKeyExchanges(t *testing.T) {
+	const maxKeyExchanges = 2
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithWhetherCrypto(true),
+		zeronetwork.WithHandshakeTimeout(5*time.Second),
+		zeronetwork.WithMaxKeyExchanges(maxKeyExchanges),
+	).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	// 协商过程中采样同时进行的数量
+	var peak int32
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if active := int32(s.keyExchangeSlots.Active()); active > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, active)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	clients := make([]zeronetwork.Client, 16)
+	for i := range clients {
+		c := NewClient(nil, WithClientLoggerLevel(zerologger.INFO), WithClientWhetherCrypto(true))
+		if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+		defer c.Close()
+		clients[i] = c
+	}
+
+	for _, c := range clients {
+		privateKey, randomValue, request := zeronetworkkey.ExchangeKeyRequest()
+		c.Set("ecdhPrivateKey", privateKey)
+		c.Set("ecdhRandomValue", randomValue)
+		if err := c.Send(request); err != nil {
+			t.Fatalf("send exchange key request failed: %s", err.Error())
+		}
+	}
+
+	for _, c := range clients {
+		waitFor(t, "handshake", func() bool { return c.HandshakeState() == zeronetwork.HandshakeReady })
+	}
+	close(done)
+	<-sampled
+
+	if peak := atomic.LoadInt32(&peak); peak > maxKeyExchanges {
+		t.Fatalf("concurrent key exchanges exceeded: %d", peak)
+	}
+	if active := s.keyExchangeSlots.Active(); active != 0 {
+		t.Fatalf("unexpected active key exchanges: %d", active)
+	}
+}
+
+func TestDispatchPool(t *testing.T) {
+	pool := zeronetwork.NewDispatchPool(4, nil)
+	defer pool.Close()
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithDispatchPool(pool),
+	).(*server)
+
+	// 第一个会话的首个消息阻塞，直到另一个会话的消息开始处理
+	started := make(chan struct{})
+	var mutex sync.Mutex
+	var order []uint16
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		if message.SN() == 1 {
+			select {
+			case <-started:
+			case <-time.After(2 * time.Second):
+				t.Error("sessions are not handled concurrently")
+			}
+		}
+		mutex.Lock()
+		order = append(order, message.SN())
+		mutex.Unlock()
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		close(started)
+		return zerodatapack.Respond(message, 2, nil), nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	responses := make(chan zeronetwork.Message, 64)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+	for sn := uint16(1); sn <= 50; sn++ {
+		if err := c.Send(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+	}
+
+	other := connectResumeClient(t, port, make(chan zeronetwork.Message, 1))
+	defer other.Close()
+	if err := other.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, nil)); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+
+	for i := 0; i < 50; i++ {
+		waitResponse(t, responses)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for i, sn := range order {
+		if sn != uint16(i+1) {
+			t.Fatalf("unexpected order at %d: %d", i, sn)
+		}
+	}
+}
+
+func TestDispatchPoolClose(t *testing.T) {
+	pool := zeronetwork.NewDispatchPool(2, nil)
+	defer pool.Close()
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.ERROR),
+		zeronetwork.WithDispatchPool(pool),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		time.Sleep(time.Millisecond)
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	// 消息仍在协程池中处理时关闭会话，关闭状态的读写不能产生竞争
+	for i := 0; i < 5; i++ {
+		c := connectResumeClient(t, port, make(chan zeronetwork.Message, 64))
+		for sn := uint16(1); sn <= 20; sn++ {
+			if err := c.Send(zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, nil)); err != nil {
+				t.Fatalf("send failed: %s", err.Error())
+			}
+		}
+		waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+		c.Close()
+		waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+	}
+}
+
+func TestRecvQueueMaxBytes(t *testing.T) {
+	const payloadSize = 1024
+
+	for _, policy := range []zeronetwork.RecvOverflowPolicy{zeronetwork.RecvOverflowBlock, zeronetwork.RecvOverflowClose} {
+		closed := make(chan struct{})
+		s := NewServer().WithOption(
+			zeronetwork.WithLoggerLevel(zerologger.FATAL),
+			zeronetwork.WithRecvQueueSize(128),
+			zeronetwork.WithRecvQueueMaxBytes(4*payloadSize),
+			zeronetwork.WithRecvOverflowPolicy(policy),
+			zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+				close(closed)
+			}),
+		).(*server)
+
+		// 处理函数阻塞，消息堆积在接收队列中
+		unblock := make(chan struct{})
+		_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			<-unblock
+			return zerodatapack.Respond(message, 1, nil), nil
+		})
+		port := listenTestServer(t, s)
+
+		responses := make(chan zeronetwork.Message, 16)
+		c := connectResumeClient(t, port, responses)
+		for i := 0; i < 10; i++ {
+			if err := c.Send(zerodatapack.NewLTDMessage(0, uint16(i+1), 0, 1, 1, make([]byte, payloadSize))); err != nil {
+				t.Fatalf("send failed: %s", err.Error())
+			}
+		}
+
+		if policy == zeronetwork.RecvOverflowClose {
+			// 远未达到 RecvQueueSize 时关闭连接
+			select {
+			case <-closed:
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for session closed")
+			}
+		} else {
+			// 第一个消息正在处理，队列中最多缓存 4 个消息，之后暂停读取
+			var ss *session
+			waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+			s.SessionManager().Range(func(value zeronetwork.Session) bool {
+				ss = value.(*session)
+				return false
+			})
+			waitFor(t, "recv queue", func() bool { return len(ss.recvQueue) == 4 })
+			time.Sleep(50 * time.Millisecond)
+			if n, bytes := len(ss.recvQueue), ss.recvBytes.Len(); n != 4 || bytes != 4*payloadSize {
+				t.Fatalf("unexpected recv queue: %d, bytes: %d", n, bytes)
+			}
+
+			close(unblock)
+			for i := 0; i < 10; i++ {
+				waitResponse(t, responses)
+			}
+			if bytes := ss.recvBytes.Len(); bytes != 0 {
+				t.Fatalf("unexpected recv bytes after drain: %d", bytes)
+			}
+		}
+
+		select {
+		case <-unblock:
+		default:
+			close(unblock)
+		}
+		c.Close()
+		_ = s.Close()
+	}
+}
+
+func TestCloseGracePeriod(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithCloseGracePeriod(2*time.Second),
+	).(*server)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		close(started)
+		<-release
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	defer s.Close()
+	port := listenTestServer(t, s)
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	<-started
+
+	var ss *session
+	s.SessionManager().Range(func(value zeronetwork.Session) bool {
+		ss = value.(*session)
+		return false
+	})
+
+	// 处理函数开始之后关闭会话
+	closed := make(chan struct{})
+	go func() {
+		ss.Close()
+		close(closed)
+	}()
+	waitFor(t, "closing", ss.isStopSending)
+
+	// Close 之后新的发送被拒绝
+	if err := ss.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 2, nil)); !errors.Is(err, zeronetwork.ErrStopSend) {
+		t.Fatalf("expected ErrStopSend, got: %v", err)
+	}
+
+	// 先于 Close 开始的处理函数的响应仍然发送
+	close(release)
+	message := waitResponse(t, responses)
+	if message.SN() != 1 || message.ActionID() != 1 {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for close")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.FATAL),
+		zeronetwork.WithWhetherChecksum(true),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	// 只开启校验，协商得到的秘钥用于计算校验值
+	responses := make(chan zeronetwork.Message, 1)
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		responses <- zerodatapack.CopyMessage(message, message.Flag())
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO), WithClientWhetherChecksum(true))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	privateKey, randomValue, request := zeronetworkkey.ExchangeKeyRequest()
+	c.Set("ecdhPrivateKey", privateKey)
+	c.Set("ecdhRandomValue", randomValue)
+	if err := c.Send(request); err != nil {
+		t.Fatalf("send exchange key request failed: %s", err.Error())
+	}
+	waitFor(t, "handshake", func() bool { return c.HandshakeState() == zeronetwork.HandshakeReady })
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("checksum"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "checksum" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+
+	// 篡改负载之后无法通过校验，服务端关闭连接
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, true, s.config.Logger)
+	p, err := datapack.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("checksum")), nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	p[len(p)-1] ^= 0x01
+	if _, err := conn.Write(p); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected connection closed, got: %v", err)
+	}
+}
+
+func TestSendReliable(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithAckTimeout(50*time.Millisecond),
+		zeronetwork.WithAckRetries(2),
+	).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	// 客户端处理失败时不回复确认
+	var handled int32
+	c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		atomic.AddInt32(&handled, 1)
+		if string(message.Payload()) == "reject" {
+			return nil, errors.New("reject")
+		}
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+	if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+	defer c.Close()
+
+	waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+	var ss zeronetwork.Session
+	s.SessionManager().Range(func(session zeronetwork.Session) bool {
+		ss = session
+		return false
+	})
+
+	results := make(chan error, 1)
+	callback := func(_ zeronetwork.Session, err error) { results <- err }
+	waitResult := func() error {
+		select {
+		case err := <-results:
+			return err
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for ack")
+		}
+		return nil
+	}
+
+	if err := s.SessionManager().SendReliable(ss.ID(), zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("accept")), callback); err != nil {
+		t.Fatalf("send reliable failed: %s", err.Error())
+	}
+	if err := waitResult(); err != nil {
+		t.Fatalf("unexpected ack err: %s", err.Error())
+	}
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Fatalf("unexpected handled: %d", n)
+	}
+
+	// 未确认的消息重发 2 次之后回调 ErrAckTimeout
+	atomic.StoreInt32(&handled, 0)
+	if err := ss.SendReliable(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("reject")), callback); err != nil {
+		t.Fatalf("send reliable failed: %s", err.Error())
+	}
+	if err := waitResult(); !errors.Is(err, zeronetwork.ErrAckTimeout) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 3 {
+		t.Fatalf("unexpected handled: %d", n)
+	}
+}
+
+func TestDispatchReleaseReuse(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithPayloadPoolMaxSize(1024),
+	).(*server)
+	// 响应直接引用请求的负载，或者直接返回请求本身
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return message, nil
+	})
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	const total = 500
+
+	responses := make(chan zeronetwork.Message, total)
+	c := connectResumeClient(t, port, responses)
+	defer c.Close()
+
+	payload := func(sn uint16) []byte {
+		return bytes.Repeat([]byte{byte(sn)}, 16+int(sn)%64)
+	}
+
+	go func() {
+		for sn := uint16(1); sn <= total; sn++ {
+			if err := c.Send(zerodatapack.NewLTDMessage(0, sn, 0, 1, uint8(1+sn%2), payload(sn))); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < total; i++ {
+		message := waitResponse(t, responses)
+		if !bytes.Equal(message.Payload(), payload(message.SN())) {
+			t.Fatalf("unexpected payload, sn: %d, payload: %x", message.SN(), message.Payload())
+		}
+	}
+}
+
+func TestStartInvalidConfig(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithPort(0),
+		zeronetwork.WithRecvQueueSize(0),
+	)
+	if err := s.Start(); !errors.Is(err, zeronetwork.ErrInvalidConfig) {
+		t.Fatalf("unexpected start err: %v", err)
+	}
+
+	c := NewClient(nil, WithClientLoggerLevel(zerologger.INFO), WithClientRecvQueueSize(0))
+	if err := c.Connect("tcp4", "127.0.0.1", 1); !errors.Is(err, zeronetwork.ErrInvalidConfig) {
+		t.Fatalf("unexpected connect err: %v", err)
+	}
+}
+
+func TestUnknownMessage(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		unknown := make(chan zeronetwork.RouteID, 1)
+		s := NewServer().WithOption(
+			zeronetwork.WithLoggerLevel(zerologger.ERROR),
+			zeronetwork.WithStrictRouting(strict),
+			zeronetwork.WithOnUnknownMessage(func(session zeronetwork.Session, message zeronetwork.Message) {
+				unknown <- zeronetwork.MessageRouteID(message)
+			}),
+		).(*server)
+		_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			return zerodatapack.Respond(message, 1, message.Payload()), nil
+		})
+		port := listenTestServer(t, s)
+
+		responses := make(chan zeronetwork.Message, 1)
+		c := connectResumeClient(t, port, responses)
+		waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 9, 9, nil)); err != nil {
+			t.Fatalf("send unknown failed: %s", err.Error())
+		}
+		select {
+		case routeID := <-unknown:
+			if routeID != zeronetwork.NewRouteID(9, 9) {
+				t.Fatalf("unexpected route: %d", routeID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for unknown message")
+		}
+
+		if strict {
+			// 严格模式下关闭会话
+			waitFor(t, "session closed", func() bool { return s.SessionManager().Len() == 0 })
+		} else {
+			// 丢弃未知消息，会话保持，之后的消息正常处理
+			if err := c.Send(zerodatapack.NewLTDMessage(0, 2, 0, 1, 1, []byte("alive"))); err != nil {
+				t.Fatalf("send failed: %s", err.Error())
+			}
+			if message := waitResponse(t, responses); string(message.Payload()) != "alive" {
+				t.Fatalf("unexpected response: %s", message.String())
+			}
+			if s.SessionManager().Len() != 1 {
+				t.Fatalf("unexpected sessions: %d", s.SessionManager().Len())
+			}
+		}
+
+		c.Close()
+		_ = s.Close()
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// Host 与 Port 被忽略，不会监听 8001
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithListener(ln),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("start failed: %s", err.Error())
+	}
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("hello"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "hello" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+	c.Close()
+
+	// 关闭服务时关闭监听器
+	_ = s.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("listener not closed: %v", err)
+	}
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	// 服务端只有一个封包工具，按照每个会话协商的结果决定是否压缩
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithWhetherCompress(true),
+		zeronetwork.WithCompressNegotiation(true),
+		zeronetwork.WithCompress(zerozlib.NewZlib()),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	payload := bytes.Repeat([]byte("zero-node"), 64)
+
+	for _, compress := range []bool{true, false} {
+		options := []ClientOption{WithClientLoggerLevel(zerologger.INFO)}
+		if compress {
+			options = append(options,
+				WithClientWhetherCompress(true),
+				WithClientCompressNegotiation(true),
+				WithClientCompress(zerozlib.NewZlib()),
+			)
+		}
+
+		// 不压缩的客户端没有设置解压器，收到压缩的消息无法解包
+		responses := make(chan zeronetwork.Message, 1)
+		c := NewClient(func(message zeronetwork.Message) (zeronetwork.Message, error) {
+			responses <- zerodatapack.CopyMessage(message, message.Flag())
+			return nil, nil
+		}, options...)
+		if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+
+		privateKey, randomValue, request := zeronetworkkey.ExchangeKeyRequestWithCompress(compress)
+		c.Set("ecdhPrivateKey", privateKey)
+		c.Set("ecdhRandomValue", randomValue)
+		if err := c.Send(request); err != nil {
+			t.Fatalf("send exchange key request failed: %s", err.Error())
+		}
+		waitFor(t, "handshake", func() bool { return c.HandshakeState() == zeronetwork.HandshakeReady })
+		if c.IsCompressed() != compress {
+			t.Fatalf("unexpected client compressed: %v", c.IsCompressed())
+		}
+
+		// 客户端与服务端的会话 ID 不同，按照地址找到服务端的会话
+		var ss zeronetwork.Session
+		waitFor(t, "server handshake", func() bool {
+			s.SessionManager().Range(func(session zeronetwork.Session) bool {
+				if session.Conn().RemoteAddr().String() == c.Conn().LocalAddr().String() {
+					ss = session
+				}
+				return ss == nil
+			})
+			return ss != nil && ss.HandshakeState() == zeronetwork.HandshakeReady
+		})
+		if ss.IsCompressed() != compress {
+			t.Fatalf("unexpected server compressed: %v", ss.IsCompressed())
+		}
+
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, payload)); err != nil {
+			t.Fatalf("send failed: %s", err.Error())
+		}
+		message := waitResponse(t, responses)
+		if !bytes.Equal(message.Payload(), payload) {
+			t.Fatalf("unexpected payload, compress: %v", compress)
+		}
+		if (message.Flag()&zeronetwork.FlagCompress != 0) != compress {
+			t.Fatalf("unexpected flag: %x, compress: %v", message.Flag(), compress)
+		}
+
+		c.Close()
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	rejected := make(chan error, 1)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.FATAL),
+		zeronetwork.WithProxyProtocol(true),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			rejected <- reason
+		}),
+	).(*server)
+	// 返回会话的对端地址与收到的负载
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := s.SessionManager().Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+		payload := session.RemoteAddr().String() + " " + string(message.Payload())
+		return zerodatapack.Respond(message, 1, []byte(payload)), nil
+	})
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	frame, err := s.config.Datapack.Pack(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("hello")), nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+
+	// v2 头部：签名，版本 2 + PROXY，TCP over IPv6，地址长度 36，之后附带一个 TLV
+	v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x21, 0x00, 36+4)
+	v2 = append(v2, net.ParseIP("2001:db8::7")...)
+	v2 = append(v2, net.ParseIP("2001:db8::1")...)
+	v2 = append(v2, 0x1f, 0x90, 0x01, 0xbb)
+	v2 = append(v2, 0x04, 0x00, 0x01, 0x00)
+
+	for _, c := range []struct {
+		header   []byte
+		expected string
+	}{
+		{[]byte("PROXY TCP4 203.0.113.7 198.51.100.1 5555 443\r\n"), "203.0.113.7:5555 hello"},
+		{v2, "[2001:db8::7]:8080 hello"},
+	} {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			t.Fatalf("dial failed: %s", err.Error())
+		}
+
+		// 头部与第一个消息在同一次写入中到达
+		if _, err := conn.Write(append(append([]byte(nil), c.header...), frame...)); err != nil {
+			t.Fatalf("write failed: %s", err.Error())
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response, err := s.config.Datapack.(zeronetwork.ReaderDatapack).UnpackFrom(conn, nil, nil)
+		if err != nil {
+			t.Fatalf("read response failed: %s", err.Error())
+		}
+		if string(response.Payload()) != c.expected {
+			t.Fatalf("unexpected response: %s, expected: %s", response.Payload(), c.expected)
+		}
+		conn.Close()
+	}
+
+	// 没有头部时拒绝连接
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	select {
+	case reason := <-rejected:
+		if !errors.Is(reason, zeronetwork.ErrProxyHeader) {
+			t.Fatalf("unexpected reject reason: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for reject")
+	}
+}
+
+func TestKick(t *testing.T) {
+	reasons := make(chan zeronetwork.CloseReason, 1)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.ERROR),
+		zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+			reasons <- session.CloseReason()
+		}),
+	).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	datapack := s.config.Datapack.(zeronetwork.ReaderDatapack)
+
+	// 直接读取套接字，踢下线的通知总是在断开连接之前写入，并且排在之前的消息之后
+	for i := 0; i < 30; i++ {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			t.Fatalf("dial failed: %s", err.Error())
+		}
+		waitFor(t, "session", func() bool { return s.SessionManager().Len() == 1 })
+
+		var sessionID zeronetwork.SessionID
+		s.SessionManager().Range(func(session zeronetwork.Session) bool {
+			sessionID = session.ID()
+			return false
+		})
+
+		for j := 0; j < 4; j++ {
+			_ = s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, []byte("push")))
+		}
+		if err := s.SessionManager().Kick(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 2, []byte("kicked"))); err != nil {
+			t.Fatalf("kick failed: %s", err.Error())
+		}
+		if err := s.SessionManager().Send(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 1, nil)); err == nil {
+			t.Fatal("send after kick succeeded")
+		}
+		if reason := <-reasons; reason != zeronetwork.CloseReasonKicked {
+			t.Fatalf("unexpected close reason: %s", reason)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var payloads []string
+		for {
+			message, err := datapack.UnpackFrom(conn, nil, nil)
+			if err != nil {
+				if err != io.EOF {
+					t.Fatalf("iteration %d: read failed: %s", i, err.Error())
+				}
+				break
+			}
+			payloads = append(payloads, string(message.Payload()))
+		}
+		if strings.Join(payloads, ",") != "push,push,push,push,kicked" {
+			t.Fatalf("iteration %d: unexpected messages: %v", i, payloads)
+		}
+		conn.Close()
+	}
+}
+
+func TestAdmissionCheck(t *testing.T) {
+	// 交替接受与拒绝
+	var calls int32
+	rejected := make(chan error, 8)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.ERROR),
+		zeronetwork.WithAdmissionCheck(func() error {
+			if atomic.AddInt32(&calls, 1)%2 == 0 {
+				return zeronetwork.ErrMemoryPressure
+			}
+			return nil
+		}),
+		zeronetwork.WithOnConnReject(func(remoteAddress string, reason error) {
+			rejected <- reason
+		}),
+	).(*server)
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	for i := 1; i <= 4; i++ {
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			t.Fatalf("dial failed: %s", err.Error())
+		}
+		defer conn.Close()
+
+		if i%2 == 0 {
+			select {
+			case reason := <-rejected:
+				if !errors.Is(reason, zeronetwork.ErrMemoryPressure) {
+					t.Fatalf("unexpected reject reason: %v", reason)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("connection %d not rejected", i)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Fatalf("expected connection closed, got: %v", err)
+			}
+		}
+
+		expected := (i + 1) / 2
+		waitFor(t, "sessions", func() bool { return s.SessionManager().Len() == expected })
+	}
+}
+
+// statsLogRecorder 记录统计日志
+type statsLogRecorder struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (h *statsLogRecorder) Enabled(_ context.Context, level slog.Level) bool {
+	return level == slog.LevelInfo
+}
+
+func (h *statsLogRecorder) Handle(_ context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if strings.HasPrefix(record.Message, "stats") {
+		h.messages = append(h.messages, record.Message)
+	}
+	return nil
+}
+
+func (h *statsLogRecorder) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *statsLogRecorder) WithGroup(name string) slog.Handler { return h }
+
+func (h *statsLogRecorder) len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.messages)
+}
+
+func TestStatsInterval(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	recorder := &statsLogRecorder{}
+	s := NewServer().WithOption(
+		zeronetwork.WithLogger(zeronetworklogger.NewSlog(slog.New(recorder))),
+		zeronetwork.WithListener(ln),
+		zeronetwork.WithStatsInterval(20*time.Millisecond),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("start failed: %s", err.Error())
+	}
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, port, responses)
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("hello"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	waitResponse(t, responses)
+	defer c.Close()
+
+	// 统计中包括接收的会话与收发的字节数
+	stats := s.Stats()
+	if stats.Sessions != 1 || stats.Accepted != 1 || stats.BytesIn == 0 || stats.BytesOut == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	waitFor(t, "stats log", func() bool { return recorder.len() >= 2 })
+
+	// 关闭之后不再输出
+	_ = s.Close()
+	n := recorder.len()
+	time.Sleep(100 * time.Millisecond)
+	if recorder.len() != n {
+		t.Fatalf("stats logged after close: %d -> %d", n, recorder.len())
+	}
+}
+
+func TestListenSignal(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %s", err.Error())
+	}
+
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithListener(ln),
+	).(*server)
+
+	// Start 不会注册信号处理，也不会阻塞
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("start failed: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("start blocked")
+	}
+
+	// 显式调用 ListenSignal，服务关闭之后返回
+	done := make(chan struct{})
+	go func() {
+		s.ListenSignal()
+		close(done)
+	}()
+
+	_ = s.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ListenSignal not returned after close")
+	}
+}
+
+func TestStartReturnsListening(t *testing.T) {
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithHost("127.0.0.1"),
+		zeronetwork.WithPort(0),
+	).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+
+	// Start 不会阻塞，返回时已经在监听
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("start failed: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("start blocked")
+	}
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectResumeClient(t, s.ln.Addr().(*net.TCPAddr).Port, responses)
+	defer c.Close()
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("hello"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "hello" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+
+	// 端口被占用时返回错误，而不是在监听协程中退出进程
+	other := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithHost("127.0.0.1"),
+		zeronetwork.WithPort(s.ln.Addr().(*net.TCPAddr).Port),
+	)
+	if err := other.Start(); err == nil {
+		t.Fatal("start on used port succeeded")
+	}
+}
+
+func TestParametersReadableOnClose(t *testing.T) {
+	type resource struct{ released int32 }
+
+	cleaned := make(chan string, 2)
+	s := NewServer().WithOption(
+		zeronetwork.WithLoggerLevel(zerologger.ERROR),
+		zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+			// 处理函数保存的资源在关闭回调中仍然可以读取并清理
+			r, ok := session.Get("resource").(*resource)
+			if !ok || session.Get("token") != "t" {
+				cleaned <- "on conn close: missing resource"
+				return
+			}
+			atomic.StoreInt32(&r.released, 1)
+			cleaned <- "on conn close"
+		}),
+	).(*server)
+
+	resources := make(chan *resource, 1)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		session, err := s.SessionManager().Get(message.SessionID())
+		if err != nil {
+			return nil, err
+		}
+		r := &resource{}
+		session.Set("resource", r)
+		session.SetWithTTL("token", "t", time.Minute)
+		session.OnClose(func() {
+			if session.Get("resource") != r {
+				cleaned <- "on close: missing resource"
+				return
+			}
+			cleaned <- "on close"
+		})
+		resources <- r
+		return zerodatapack.Respond(message, 1, nil), nil
+	})
+	port := listenTestServer(t, s)
+	defer s.Close()
+
+	closers := map[string]func(c zeronetwork.Client){
+		"client": func(c zeronetwork.Client) { c.Close() },
+		"kick": func(c zeronetwork.Client) {
+			var sessionID zeronetwork.SessionID
+			s.SessionManager().Range(func(session zeronetwork.Session) bool {
+				sessionID = session.ID()
+				return false
+			})
+			_ = s.SessionManager().Kick(sessionID, zerodatapack.NewLTDMessage(0, 0, 0, 2, 2, []byte("kicked")))
+		},
+	}
+	for name, closer := range closers {
+		responses := make(chan zeronetwork.Message, 1)
+		c := connectResumeClient(t, port, responses)
+		if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+			t.Fatalf("%s: send failed: %s", name, err.Error())
+		}
+		waitResponse(t, responses)
+		r := <-resources
+
+		closer(c)
+		for _, want := range []string{"on close", "on conn close"} {
+			select {
+			case got := <-cleaned:
+				if got != want {
+					t.Fatalf("%s: got %q, want %q", name, got, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: timeout waiting for %q", name, want)
+			}
+		}
+		if atomic.LoadInt32(&r.released) != 1 {
+			t.Fatalf("%s: resource not released", name)
+		}
+		c.Close()
+		waitFor(t, "session removed", func() bool { return s.SessionManager().Len() == 0 })
+	}
+}
+
+func TestZeroMessageLimit(t *testing.T) {
+	for _, kick := range []bool{false, true} {
+		reasons := make(chan zeronetwork.CloseReason, 1)
+		s := NewServer().WithOption(
+			zeronetwork.WithLoggerLevel(zerologger.ERROR),
+			zeronetwork.WithWhetherCrypto(true),
+			zeronetwork.WithMaxExchangeKeyRequests(1),
+			zeronetwork.WithZeroMessageRate(10),
+			zeronetwork.WithZeroLimitKick(kick),
+			zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+				reasons <- session.CloseReason()
+			}),
+		).(*server)
+		port := listenTestServer(t, s)
+
+		// 由服务端关闭连接，客户端的 recvLoop 读取到 io.EOF 之后自行关闭
+		clientClosed := make(chan struct{})
+		c := NewClient(nil, WithClientLoggerLevel(zerologger.ERROR), WithClientWhetherCrypto(true),
+			WithClientOnConnClose(func(zeronetwork.Session) { close(clientClosed) }))
+		if err := c.Connect("tcp4", "127.0.0.1", port); err != nil {
+			t.Fatalf("connect failed: %s", err.Error())
+		}
+		go c.Run()
+
+		// 反复发送秘钥协商请求，只有第一个被处理
+		// 请求在发送之前生成，避免与服务端同时使用随机数缓冲池，客户端保留第一个请求的秘钥
+		const flood = 50
+		requests := make([]zeronetwork.Message, flood)
+		for i := len(requests) - 1; i >= 0; i-- {
+			var privateKey, randomValue []byte
+			privateKey, randomValue, requests[i] = zeronetworkkey.ExchangeKeyRequest()
+			c.Set("ecdhPrivateKey", privateKey)
+			c.Set("ecdhRandomValue", randomValue)
+		}
+		for _, request := range requests {
+			if err := c.Send(request); err != nil {
+				if kick {
+					break
+				}
+				t.Fatalf("send exchange key request failed: %s", err.Error())
+			}
+		}
+
+		if kick {
+			select {
+			case reason := <-reasons:
+				if reason != zeronetwork.CloseReasonZeroLimit {
+					t.Fatalf("unexpected close reason: %s", reason)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("session not closed after exceeding the limit")
+			}
+		} else {
+			waitFor(t, "flood handled", func() bool { return s.Stats().ZeroMessages+s.Stats().ZeroLimited == flood })
+			if s.SessionManager().Len() != 1 {
+				t.Fatal("session closed without ZeroLimitKick")
+			}
+		}
+
+		stats := s.Stats()
+		if stats.ZeroMessages != 1 || stats.ZeroLimited == 0 || stats.Messages != 0 {
+			t.Fatalf("kick: %v, unexpected stats: %+v", kick, stats)
+		}
+
+		_ = s.Close()
+		<-clientClosed
+	}
+}
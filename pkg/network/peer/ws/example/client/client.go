@@ -32,8 +32,6 @@ const (
 type client struct {
 	cc zeronetwork.Client
 
-	sn uint16
-
 	// router 路由
 	router zeronetwork.Router
 
@@ -144,8 +142,9 @@ func (c *client) respSayHello(message zeronetwork.Message) (zeronetwork.Message,
 
 func (c *client) send(module, action uint8, payload []byte) error {
 	flag := uint16(0)
-	c.sn++
+	// SN 为 0 时由客户端自动分配
+	sn := uint16(0)
 	code := uint16(0)
-	message := zerodatapack.NewLTDMessage(flag, c.sn, code, module, action, payload)
+	message := zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
 	return c.cc.Send(message)
 }
@@ -1,9 +1,6 @@
 package main
 
 import (
-	"net/http"
-	_ "net/http/pprof"
-
 	"github.com/gorilla/websocket"
 	protocol "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp/example/protocol"
 
@@ -13,6 +10,7 @@ import (
 
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zerodebug "github.com/zerogo-hub/zero-node/pkg/network/debug"
 	zerows "github.com/zerogo-hub/zero-node/pkg/network/peer/ws"
 )
 
@@ -65,12 +63,8 @@ func main() {
 		zeronetwork.WithWhetherCrypto(true),
 	)
 
-	// pprof
-	go func() {
-		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
-			s.p.Logger().Errorf("ListenAndServe failed: %s", err.Error())
-		}
-	}()
+	// pprof，随服务开启与关闭
+	s.p = zerodebug.Wrap(s.p, "localhost:6060")
 	s.p.Logger().Info("pprof: http://localhost:6060/debug/pprof/")
 
 	// 注册路由
@@ -120,12 +114,5 @@ func (s *server) reqSayHello(message zeronetwork.Message) (zeronetwork.Message,
 		return nil, err
 	}
 
-	return s.newMessage(message.SN(), ModuleHello, ActionHelloSayResp, res), nil
-}
-
-func (s *server) newMessage(sn uint16, module, action uint8, payload []byte) zeronetwork.Message {
-	flag := uint16(0)
-	code := uint16(0)
-	message := zerodatapack.NewLTDMessage(flag, sn, code, module, action, payload)
-	return message
+	return zerodatapack.Respond(message, ActionHelloSayResp, res), nil
 }
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"net/http"
+	"time"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+// newUpgrader 默认的升级配置，允许跨域，其余使用 gorilla/websocket 的默认值
+func newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		// 允许跨域
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+}
+
+// Option websocket 专属配置，见 NewServer
+type Option func(*server)
+
+// WithWSHandshakeTimeout 升级握手的超时时间，默认 0 不超时
+func WithWSHandshakeTimeout(handshakeTimeout time.Duration) Option {
+	return func(s *server) {
+		s.upgrader.HandshakeTimeout = handshakeTimeout
+	}
+}
+
+// WithWSReadBufferSize 升级之后连接的读缓冲区大小，默认 0 时沿用 http 服务的缓冲区，大小为 4096
+func WithWSReadBufferSize(readBufferSize int) Option {
+	return func(s *server) {
+		s.upgrader.ReadBufferSize = readBufferSize
+	}
+}
+
+// WithWSWriteBufferSize 升级之后连接的写缓冲区大小，决定单个帧的大小，默认 0 时沿用 http 服务的缓冲区，大小为 4096
+func WithWSWriteBufferSize(writeBufferSize int) Option {
+	return func(s *server) {
+		s.upgrader.WriteBufferSize = writeBufferSize
+	}
+}
+
+// WithWSEnableCompression 是否与客户端协商 permessage-deflate 压缩，默认不开启
+func WithWSEnableCompression(enableCompression bool) Option {
+	return func(s *server) {
+		s.upgrader.EnableCompression = enableCompression
+	}
+}
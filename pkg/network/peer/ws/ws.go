@@ -2,28 +2,25 @@ package ws
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	websocket "github.com/gorilla/websocket"
+	zerocodec "github.com/zerogo-hub/zero-helper/codec"
 	zerocompress "github.com/zerogo-hub/zero-helper/compress"
 	zerologger "github.com/zerogo-hub/zero-helper/logger"
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
-)
-
-var (
-	upgrader = websocket.Upgrader{
-		// 允许跨域
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
+	zeronetworkkey "github.com/zerogo-hub/zero-node/pkg/network/key"
 )
 
 // server websocket 服务
@@ -33,14 +30,35 @@ type server struct {
 	// sessionManager 会话管理
 	sessionManager zeronetwork.SessionManager
 
+	// ipConnCounter 按远端 IP 统计活跃连接数量
+	ipConnCounter *zeronetwork.IPConnCounter
+
+	// connSlots 为尚未加入 sessionManager 的连接预留名额，并发接收连接时不会超出 MaxConnNum
+	connSlots zeronetwork.ConnSlots
+
+	// water 所有会话发送队列与接收队列的最高水位
+	water zeronetwork.QueueWater
+
+	// counters 服务启动以来累计的连接数量与收发字节数
+	counters zeronetwork.PeerCounters
+
+	// statsReporter 周期性输出统计日志，见 Config.StatsInterval
+	statsReporter *zeronetwork.StatsReporter
+
+	// keyExchangeSlots 同时进行的秘钥协商计算数量，不超过 MaxKeyExchanges
+	keyExchangeSlots zeronetwork.KeyExchangeSlots
+
 	// closeOnce 防止多次关闭服务
 	closeOnce sync.Once
 
 	// isClosed 服务器已关闭
-	isClosed bool
+	isClosed atomic.Bool
+
+	// closed 服务关闭时关闭，用于结束 ListenSignal
+	closed chan struct{}
 
 	// isCloseConn 服务器不再接收新连接
-	isCloseConn bool
+	isCloseConn atomic.Bool
 
 	// router 路由
 	router zeronetwork.Router
@@ -51,19 +69,39 @@ type server struct {
 	messageType int
 
 	certFile, keyFile string
+
+	// ln 监听器，使用 Serve 时为外部传入的监听器
+	ln net.Listener
+
+	// upgrader 将 http 请求升级为 websocket 连接，见 Option
+	upgrader websocket.Upgrader
+
+	// httpServer 处理 websocket 升级请求，关闭服务时调用 Shutdown 停止接收新的连接
+	httpServer *http.Server
 }
 
 // NewServer 创建一个 websocket 服务
-func NewServer(messageType int, certFile, keyFile string) zeronetwork.Peer {
+func NewServer(messageType int, certFile, keyFile string, opts ...Option) zeronetwork.Peer {
 	s := &server{
 		config:         zeronetwork.DefaultConfig(),
 		sessionManager: zeronetwork.NewSessionManager(),
+		ipConnCounter:  zeronetwork.NewIPConnCounter(),
 		router:         zeronetwork.NewRouter(),
+		closed:         make(chan struct{}),
 		messageType:    messageType,
 		certFile:       certFile,
 		keyFile:        keyFile,
+		upgrader:       newUpgrader(),
 	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/", s.wsHandler)
+	s.httpServer = &http.Server{Handler: serveMux}
+
 	return s
 }
 
@@ -77,37 +115,85 @@ func (s *server) WithOption(opts ...zeronetwork.Option) zeronetwork.Peer {
 		s.config.Datapack = zerodatapack.DefaultDatapck(s.config)
 	}
 
+	if s.config.ResumeTTL > 0 && s.config.ResumeStore == nil {
+		s.config.ResumeStore = zeronetwork.NewResumeStore()
+	}
+
 	return s
 }
 
-// Start 开启服务
+// Start 开启服务，返回时已经可以接收连接，不会阻塞
 func (s *server) Start() error {
-	address := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
+	if err := s.config.Validate(); err != nil {
+		return err
+	}
 
-	serveMux := http.NewServeMux()
+	if s.config.OnServerStart != nil {
+		if err := s.config.OnServerStart(); err != nil {
+			return err
+		}
+	}
+	s.startStats()
+
+	// 使用外部创建的监听器，见 Config.Listener
+	ln := s.config.Listener
+	if ln == nil {
+		address := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
+
+		var err error
+		ln, err = net.Listen("tcp", address)
+		if err != nil {
+			s.statsReporter.Stop()
+			return fmt.Errorf("listen failed: %w, address: %s", err, address)
+		}
+	}
+	s.ln = ln
 
 	go func() {
-		if len(s.certFile) > 0 && len(s.keyFile) > 0 {
-			s.config.Logger.Infof("certFile: %s, keyFile: %s", s.certFile, s.keyFile)
-			if err := http.ListenAndServeTLS(address, s.certFile, s.keyFile, serveMux); err != nil {
-				s.Logger().Errorf("listen failed, address: %s, err: %s", address, err.Error())
-			}
-		} else {
-			if err := http.ListenAndServe(address, serveMux); err != nil {
-				s.Logger().Errorf("listen failed, address: %s, err: %s", address, err.Error())
-			}
+		if err := s.serve(ln); err != nil {
+			s.Logger().Errorf("serve failed, address: %s, err: %s", ln.Addr().String(), err.Error())
 		}
 	}()
 
-	s.config.Logger.Infof("server start, listen at %s, pid: %d", address, os.Getpid())
+	return nil
+}
+
+// Serve 使用外部创建的监听器提供服务，不再监听 Host 与 Port，比如 mux.Listener.WS()
+// 阻塞直到监听器关闭，关闭服务时会关闭该监听器
+func (s *server) Serve(ln net.Listener) error {
+	if err := s.config.Validate(); err != nil {
+		return err
+	}
+
 	if s.config.OnServerStart != nil {
 		if err := s.config.OnServerStart(); err != nil {
 			return err
 		}
 	}
-	serveMux.HandleFunc("/", s.wsHandler)
+	s.startStats()
 
-	return nil
+	s.ln = ln
+
+	return s.serve(ln)
+}
+
+// serve 在监听器上提供 websocket 服务，阻塞直到监听器关闭
+func (s *server) serve(ln net.Listener) error {
+	s.config.Logger.Infof("server start, serve at %s, pid: %d", ln.Addr().String(), os.Getpid())
+
+	var err error
+	if len(s.certFile) > 0 && len(s.keyFile) > 0 {
+		s.config.Logger.Infof("certFile: %s, keyFile: %s", s.certFile, s.keyFile)
+		err = s.httpServer.ServeTLS(ln, s.certFile, s.keyFile)
+	} else {
+		err = s.httpServer.Serve(ln)
+	}
+
+	if s.isClosed.Load() || errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+
+	return err
 }
 
 // Close 关闭服务，释放资源
@@ -119,34 +205,44 @@ func (s *server) Close() error {
 	})
 
 	if once {
-		ctx, cancel := context.WithTimeout(context.Background(), s.config.CloseTimeout)
-		defer cancel()
-
-		ch := make(chan bool)
+		s.isClosed.Store(true)
+		close(s.closed)
+		s.isCloseConn.Store(true)
 
-		go func() {
-			s.isClosed = true
-			s.isCloseConn = true
+		// 停止输出统计日志
+		s.statsReporter.Stop()
 
-			// 关闭所有连接
-			s.sessionManager.Close()
-
-			// 处理自定义行为
-			if s.config.OnServerClose != nil {
-				s.config.OnServerClose()
-			}
+		// 关闭监听器，不再接收新的连接，最多等待 CloseTimeout 让正在进行的升级请求结束
+		// 已经升级的 websocket 连接不受影响，由下面的 sessionManager 关闭
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.CloseTimeout)
+		if err := s.httpServer.Shutdown(ctx); err != nil {
+			s.config.Logger.Errorf("shutdown http server failed: %s", err.Error())
+		}
+		cancel()
+
+		// 通知所有客户端服务器即将关闭，通知发送完毕之后才会关闭连接
+		// 不等待发送队列的空间，队列已满的会话不再通知，避免逐个阻塞关闭流程
+		if s.config.ShutdownNotice {
+			s.sessionManager.Range(func(session zeronetwork.Session) bool {
+				message := zeronetworkkey.Shutdown(s.config.ShutdownReason, s.config.ShutdownRetryAfter)
+				if ok, _ := session.TrySend(message); !ok {
+					message.Release()
+				}
+				return true
+			})
+		}
 
-			ch <- true
-		}()
+		// 关闭所有连接，超过 CloseTimeout 仍未关闭的连接会被强行关闭
+		if forced := s.sessionManager.Close(s.config.CloseTimeout); forced > 0 {
+			s.config.Logger.Errorf("close timeout, force closed sessions: %d", forced)
+		}
 
-		select {
-		case <-ch:
-			s.config.Logger.Info("close success")
-			break
-		case <-ctx.Done():
-			s.config.Logger.Error("close timeout")
-			break
+		// 处理自定义行为
+		if s.config.OnServerClose != nil {
+			s.config.OnServerClose()
 		}
+
+		s.config.Logger.Info("close success")
 	}
 
 	return nil
@@ -167,12 +263,52 @@ func (s *server) SessionManager() zeronetwork.SessionManager {
 	return s.sessionManager
 }
 
+// startStats 按照 Config.StatsInterval 开始输出统计日志
+func (s *server) startStats() {
+	s.statsReporter = zeronetwork.NewStatsReporter(s.config.Logger, s.config.StatsInterval, s.Stats)
+}
+
+// Stats 所有会话的队列统计，最高水位包括已经关闭的会话
+func (s *server) Stats() zeronetwork.PeerStats {
+	stats := zeronetwork.PeerStats{
+		SendHighWater: s.water.Send(),
+		RecvHighWater: s.water.Recv(),
+	}
+	s.counters.Fill(&stats)
+
+	s.sessionManager.Range(func(session zeronetwork.Session) bool {
+		queue := session.QueueStats()
+		stats.Sessions++
+		stats.SendLen += queue.SendLen
+		stats.RecvLen += queue.RecvLen
+		stats.SendShapedDelay += queue.SendShapedDelay
+		return true
+	})
+
+	return stats
+}
+
 // SetMaxConnNum 连接数量上限，超过数量则拒绝连接
 // 负数表示不限制
 func (s *server) SetMaxConnNum(MaxConnNum int) {
 	s.config.MaxConnNum = MaxConnNum
 }
 
+// SetMaxConnPerIP 同一个 IP 的连接数量上限，超过数量则拒绝连接，<= 0 表示不限制
+func (s *server) SetMaxConnPerIP(maxConnPerIP int) {
+	s.config.MaxConnPerIP = maxConnPerIP
+}
+
+// SetAdmissionCheck 接受连接之后、创建会话之前调用，返回错误时拒绝该连接
+func (s *server) SetAdmissionCheck(admissionCheck zeronetwork.AdmissionFunc) {
+	s.config.AdmissionCheck = admissionCheck
+}
+
+// SetAcceptGoroutines websocket 由 http.Server 为每个连接单独开启 goroutine，该配置没有效果
+func (s *server) SetAcceptGoroutines(acceptGoroutines int) {
+	s.config.AcceptGoroutines = acceptGoroutines
+}
+
 // SetNetwork 可选 "tcp", "tcp4", "tcp6"
 func (s *server) SetNetwork(network string) {
 
@@ -188,6 +324,11 @@ func (s *server) SetPort(port int) {
 	s.config.Port = port
 }
 
+// SetListener 设置外部创建的监听器，Start 时不再监听 Host 与 Port，仅在 tcp、ws 下有效
+func (s *server) SetListener(ln net.Listener) {
+	s.config.Listener = ln
+}
+
 // SetLogger 设置日志
 func (s *server) SetLogger(logger zerologger.Logger) {
 	s.config.Logger = logger
@@ -214,24 +355,74 @@ func (s *server) SetCloseTimeout(closeTimeout time.Duration) {
 	s.config.CloseTimeout = closeTimeout
 }
 
+// SetStatsInterval 大于 0 时，服务每隔该时长输出一行统计日志
+func (s *server) SetStatsInterval(statsInterval time.Duration) {
+	s.config.StatsInterval = statsInterval
+}
+
+// SetShutdownNotice 关闭服务器时，先向所有客户端发送关闭通知，再等待连接关闭
+func (s *server) SetShutdownNotice(shutdownNotice bool) {
+	s.config.ShutdownNotice = shutdownNotice
+}
+
+// SetShutdownReason 关闭通知中携带的关闭原因，仅在开启 ShutdownNotice 时有效
+func (s *server) SetShutdownReason(shutdownReason string) {
+	s.config.ShutdownReason = shutdownReason
+}
+
+// SetShutdownRetryAfter 关闭通知中携带的建议重连间隔，仅在开启 ShutdownNotice 时有效
+func (s *server) SetShutdownRetryAfter(shutdownRetryAfter time.Duration) {
+	s.config.ShutdownRetryAfter = shutdownRetryAfter
+}
+
 // SetRecvBufferSize 在 session 中接收消息 buffer 大小
 func (s *server) SetRecvBufferSize(recvBufferSize int) {
 	s.config.RecvBufferSize = recvBufferSize
 }
 
+// SetRingBufferSize 在 session 中存储待解包数据的环形缓冲区大小，<= 0 表示使用 RecvBufferSize 的 2 倍
+func (s *server) SetRingBufferSize(ringBufferSize int) {
+	s.config.RingBufferSize = ringBufferSize
+}
+
+// SetRingBufferLazy 收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 RingBufferSize
+func (s *server) SetRingBufferLazy(ringBufferLazy bool) {
+	s.config.RingBufferLazy = ringBufferLazy
+}
+
+// SetMaxMessageSize 单个完整消息的最大长度，未设置 RingBufferSize 时环形缓冲区按需扩容以容纳该长度的消息
+func (s *server) SetMaxMessageSize(maxMessageSize int) {
+	s.config.MaxMessageSize = maxMessageSize
+}
+
 // SetRecvDeadline 通信超时时间，最终调用 conn.SetReadDeadline
 func (s *server) SetRecvDeadline(recvDeadLine time.Duration) {
 	s.config.RecvDeadline = recvDeadLine
 }
 
+// SetRecvDeadlineMode 读超时的计算方式，仅在 tcp、kcp 下有效
+func (s *server) SetRecvDeadlineMode(recvDeadlineMode zeronetwork.DeadlineMode) {
+	s.config.RecvDeadlineMode = recvDeadlineMode
+}
+
 // SetRecvQueueSize 在 session 中接收到的消息队列大小，session 接收到消息后并非立即处理，而是丢到一个消息队列中，异步处理
 func (s *server) SetRecvQueueSize(recvQueueSize int) {
 	s.config.RecvQueueSize = recvQueueSize
 }
 
+// SetRecvQueueMaxBytes 接收消息队列中缓存的消息负载字节数上限，超出时按照 RecvOverflowPolicy 处理，0 表示不限制
+func (s *server) SetRecvQueueMaxBytes(recvQueueMaxBytes int) {
+	s.config.RecvQueueMaxBytes = recvQueueMaxBytes
+}
+
+// SetRecvOverflowPolicy 接收消息队列中缓存的消息负载超过 RecvQueueMaxBytes 时的处理策略
+func (s *server) SetRecvOverflowPolicy(recvOverflowPolicy zeronetwork.RecvOverflowPolicy) {
+	s.config.RecvOverflowPolicy = recvOverflowPolicy
+}
+
 // SetSendBufferSize 发送消息 buffer 大小
-func (s *server) SetSendBufferSize(recvBufferSize int) {
-	s.config.RecvBufferSize = recvBufferSize
+func (s *server) SetSendBufferSize(sendBufferSize int) {
+	s.config.SendBufferSize = sendBufferSize
 }
 
 // SetSendDeadline SendDeadline
@@ -240,8 +431,78 @@ func (s *server) SetSendDeadline(recvDeadLine time.Duration) {
 }
 
 // SetSendQueueSize 发送的消息队列大小，消息优先发送到 sesion 的消息队列，然后写入到套接字中
-func (s *server) SetSendQueueSize(recvQueueSize int) {
-	s.config.RecvQueueSize = recvQueueSize
+func (s *server) SetSendQueueSize(sendQueueSize int) {
+	s.config.SendQueueSize = sendQueueSize
+}
+
+// SetSendQueueMaxSize 发送的消息队列可以增长到的消息数量上限，大于 SendQueueSize 时生效，达到上限后按照 SendOverflowPolicy 处理
+func (s *server) SetSendQueueMaxSize(sendQueueMaxSize int) {
+	s.config.SendQueueMaxSize = sendQueueMaxSize
+}
+
+// SetSendOverflowPolicy 发送消息队列已满时的处理策略，队列的上限见 SendQueueMaxSize
+func (s *server) SetSendOverflowPolicy(sendOverflowPolicy zeronetwork.SendOverflowPolicy) {
+	s.config.SendOverflowPolicy = sendOverflowPolicy
+}
+
+// SetSendRateLimit 每个会话每秒最多发送的字节数，超出时延迟之后的写入，0 表示不限制
+func (s *server) SetSendRateLimit(sendRateLimit int) {
+	s.config.SendRateLimit = sendRateLimit
+}
+
+// SetLinger 关闭连接时调用 conn.SetLinger 设置的值，仅在 tcp 下有效，< 0 表示使用系统默认行为
+func (s *server) SetLinger(linger int) {
+	s.config.Linger = linger
+}
+
+// SetHalfCloseTimeout 关闭连接时先关闭写方向，等待对方关闭连接的最长时间，仅在 tcp 下有效，0 表示直接关闭
+func (s *server) SetHalfCloseTimeout(halfCloseTimeout time.Duration) {
+	s.config.HalfCloseTimeout = halfCloseTimeout
+}
+
+// SetNoDelay 新连接是否禁用 Nagle 算法 (TCP_NODELAY)，仅在 tcp 下有效，默认 true
+func (s *server) SetNoDelay(noDelay bool) {
+	s.config.NoDelay = noDelay
+}
+
+// SetHandlerTimeout 处理函数的超时时间，超时之后不再等待，继续处理之后的消息
+func (s *server) SetHandlerTimeout(handlerTimeout time.Duration) {
+	s.config.HandlerTimeout = handlerTimeout
+}
+
+// SetHandlerTimeoutCode 处理函数超时时返回给客户端的响应错误码，为 0 时不返回响应
+func (s *server) SetHandlerTimeoutCode(handlerTimeoutCode uint16) {
+	s.config.HandlerTimeoutCode = handlerTimeoutCode
+}
+
+// SetSlowHandlerThreshold 处理函数的耗时超过该值时记录一条警告日志，0 表示不记录
+func (s *server) SetSlowHandlerThreshold(slowHandlerThreshold time.Duration) {
+	s.config.SlowHandlerThreshold = slowHandlerThreshold
+}
+
+// SetCloseGracePeriod 关闭会话时等待已经开始的处理函数结束的最长时间，其响应仍然会被发送，0 表示不等待
+func (s *server) SetCloseGracePeriod(closeGracePeriod time.Duration) {
+	s.config.CloseGracePeriod = closeGracePeriod
+}
+
+// SetAckTimeout SendReliable 发送的消息等待确认的时间，超时之后重发，默认 5 秒
+func (s *server) SetAckTimeout(ackTimeout time.Duration) {
+	s.config.AckTimeout = ackTimeout
+}
+
+// SetAckRetries SendReliable 发送的消息超时之后的重发次数，默认 2
+func (s *server) SetAckRetries(ackRetries int) {
+	s.config.AckRetries = ackRetries
+}
+
+// SetAckWindow 每个会话等待确认的消息数量上限，默认 64
+func (s *server) SetAckWindow(ackWindow int) {
+	s.config.AckWindow = ackWindow
+}
+
+// SetDispatchPool 多个会话共享的消息处理协程池，同一个分片的消息按顺序处理，nil 表示每个会话在自己的协程中处理
+func (s *server) SetDispatchPool(dispatchPool *zeronetwork.DispatchPool) {
+	s.config.DispatchPool = dispatchPool
 }
 
 // SetOnConnected 客户端连接到来时触发，此时客户端已经可以开始收发消息
@@ -249,21 +510,146 @@ func (s *server) SetOnConnected(onConnected zeronetwork.ConnFunc) {
 	s.config.OnConnected = onConnected
 }
 
-// SetOnConnClose 客户端连接关闭触发，此时客户端不可以再收发消息
+// SetOnConnectedAsync 是否在新的 goroutine 中执行 OnConnected，此时消息可能先于 OnConnected 完成被处理
+func (s *server) SetOnConnectedAsync(onConnectedAsync bool) {
+	s.config.OnConnectedAsync = onConnectedAsync
+}
+
+// SetSessionMode 会话的收发模式，默认 SessionModeDuplex，见 SessionMode
+func (s *server) SetSessionMode(sessionMode zeronetwork.SessionMode) {
+	s.config.SessionMode = sessionMode
+}
+
+// SetOnHandshake 连接开始收发消息之前同步触发，先于 OnConnected，返回错误时关闭连接
+func (s *server) SetOnHandshake(onHandshake zeronetwork.HandshakeFunc) {
+	s.config.OnHandshake = onHandshake
+}
+
+// SetOnConnClose 客户端连接关闭触发，此时不再接收消息，但仍然可以发送消息，这些消息会在断开连接之前发送完毕
 func (s *server) SetOnConnClose(onConnClose zeronetwork.ConnFunc) {
 	s.config.OnConnClose = onConnClose
 }
 
+// SetOnConnReject 连接因超过 MaxConnNum、MaxConnPerIP，或者超过 HandshakeTimeout 仍未完成秘钥协商被拒绝时触发
+func (s *server) SetOnConnReject(onConnReject zeronetwork.ConnRejectFunc) {
+	s.config.OnConnReject = onConnReject
+}
+
+// SetOnAccept 接受连接之后，创建会话之前触发，返回 false 或者错误时关闭连接
+func (s *server) SetOnAccept(onAccept zeronetwork.AcceptFunc) {
+	s.config.OnAccept = onAccept
+}
+
+// SetProxyProtocol 连接开头是否为 PROXY protocol v1/v2 头部，仅在 tcp 下有效
+func (s *server) SetProxyProtocol(proxyProtocol bool) {
+	s.config.ProxyProtocol = proxyProtocol
+}
+
+// SetOnUnknownMessage 收到没有匹配路由的消息时触发，之后丢弃该消息
+func (s *server) SetOnUnknownMessage(onUnknownMessage zeronetwork.UnknownMessageFunc) {
+	s.config.OnUnknownMessage = onUnknownMessage
+}
+
+// SetStrictRouting 收到没有匹配路由的消息时关闭会话，默认 false
+func (s *server) SetStrictRouting(strictRouting bool) {
+	s.config.StrictRouting = strictRouting
+}
+
+// SetHandshakeKick 开启加密时，秘钥协商完成之前收到业务消息，是否断开连接
+func (s *server) SetHandshakeKick(handshakeKick bool) {
+	s.config.HandshakeKick = handshakeKick
+}
+
+// SetHandshakeTimeout 开启加密时，连接建立之后需要在该时间内完成秘钥协商，否则关闭连接，0 表示不限制
+func (s *server) SetHandshakeTimeout(handshakeTimeout time.Duration) {
+	s.config.HandshakeTimeout = handshakeTimeout
+}
+
+// SetBroadcastReadyOnly SendAll 与 SendMany 跳过尚未完成秘钥协商的会话
+func (s *server) SetBroadcastReadyOnly(broadcastReadyOnly bool) {
+	s.config.BroadcastReadyOnly = broadcastReadyOnly
+}
+
+// SetMaxKeyExchanges 服务端同时进行的秘钥协商计算数量上限，超出时排队等待，最多等待 HandshakeTimeout，0 表示不限制
+func (s *server) SetMaxKeyExchanges(maxKeyExchanges int) {
+	s.config.MaxKeyExchanges = maxKeyExchanges
+}
+
+// SetZeroMessageRate 每个会话每秒最多处理的特殊协议消息数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+func (s *server) SetZeroMessageRate(zeroMessageRate int) {
+	s.config.ZeroMessageRate = zeroMessageRate
+}
+
+// SetMaxExchangeKeyRequests 每个会话最多处理的秘钥协商请求数量，超出时按照 ZeroLimitKick 处理，0 表示不限制
+func (s *server) SetMaxExchangeKeyRequests(maxExchangeKeyRequests int) {
+	s.config.MaxExchangeKeyRequests = maxExchangeKeyRequests
+}
+
+// SetZeroLimitKick 特殊协议消息超过 ZeroMessageRate 或者 MaxExchangeKeyRequests 时是否断开连接，默认只丢弃该消息
+func (s *server) SetZeroLimitKick(zeroLimitKick bool) {
+	s.config.ZeroLimitKick = zeroLimitKick
+}
+
+// SetMinCryptoKeySize 秘钥协商得到的秘钥最小长度，不足时秘钥协商失败
+func (s *server) SetMinCryptoKeySize(minCryptoKeySize int) {
+	s.config.MinCryptoKeySize = minCryptoKeySize
+}
+
+// SetResumeTTL 断线后保留会话状态的时长，<= 0 表示不开启会话恢复
+func (s *server) SetResumeTTL(resumeTTL time.Duration) {
+	s.config.ResumeTTL = resumeTTL
+}
+
+// SetResumeBufferSize 断线后保留会话状态期间最多缓存的消息数量，恢复会话后重新发送，<= 0 表示不缓存
+func (s *server) SetResumeBufferSize(resumeBufferSize int) {
+	s.config.ResumeBufferSize = resumeBufferSize
+}
+
+// SetResumeBufferBytes 断线后每个会话缓存的消息负载总长度上限，<= 0 表示只按数量限制
+func (s *server) SetResumeBufferBytes(resumeBufferBytes int) {
+	s.config.ResumeBufferBytes = resumeBufferBytes
+}
+
+// SetResumeStore 存储断线后保留的会话状态
+func (s *server) SetResumeStore(resumeStore zeronetwork.ResumeStore) {
+	s.config.ResumeStore = resumeStore
+}
+
+// SetBufferFailPolicy 设置连接缓冲区失败时的处理策略
+func (s *server) SetBufferFailPolicy(bufferFailPolicy zeronetwork.BufferFailPolicy) {
+	s.config.BufferFailPolicy = bufferFailPolicy
+}
+
+// SetSocketReadBuffer 套接字接收缓冲区大小 (SO_RCVBUF)，0 表示使用系统默认值
+func (s *server) SetSocketReadBuffer(socketReadBuffer int) {
+	s.config.SocketReadBuffer = socketReadBuffer
+}
+
+// SetSocketWriteBuffer 套接字发送缓冲区大小 (SO_SNDBUF)，0 表示使用系统默认值
+func (s *server) SetSocketWriteBuffer(socketWriteBuffer int) {
+	s.config.SocketWriteBuffer = socketWriteBuffer
+}
+
 // SetDatapack 封包与解包
 func (s *server) SetDatapack(datapack zeronetwork.Datapack) {
 	s.config.Datapack = datapack
 }
 
+// SetDatapackSwitchable 会话是否可能在握手之后切换封包工具，开启后切换之前逐个解包与处理消息
+func (s *server) SetDatapackSwitchable(datapackSwitchable bool) {
+	s.config.DatapackSwitchable = datapackSwitchable
+}
+
 // SetWhetherCompress 是否需要对消息负载进行压缩
 func (s *server) SetWhetherCompress(whetherCompress bool) {
 	s.config.WhetherCompress = whetherCompress
 }
 
+// SetCompressNegotiation 是否由每个会话在秘钥协商时决定是否压缩
+func (s *server) SetCompressNegotiation(compressNegotiation bool) {
+	s.config.CompressNegotiation = compressNegotiation
+}
+
 // SetCompressThreshold 压缩的阈值，当消息负载长度超过该值时才会压缩
 func (s *server) SetCompressThreshold(compressThreshold int) {
 	s.config.CompressThreshold = compressThreshold
@@ -274,6 +660,16 @@ func (s *server) SetCompress(compress zerocompress.Compress) {
 	s.config.Compress = compress
 }
 
+// SetMaxDecompressedSize 解压后负载的最大长度，超出则解包失败，<= 0 表示不限制
+func (s *server) SetMaxDecompressedSize(maxDecompressedSize int) {
+	s.config.MaxDecompressedSize = maxDecompressedSize
+}
+
+// SetPayloadPoolMaxSize 解包时负载不超过该长度则从共享缓冲池中获取内存，消息 Release 时归还，0 表示不使用
+func (s *server) SetPayloadPoolMaxSize(payloadPoolMaxSize int) {
+	s.config.PayloadPoolMaxSize = payloadPoolMaxSize
+}
+
 // SetWhetherCrypto 是否需要对消息负载进行加密
 func (s *server) SetWhetherCrypto(whetherCrypto bool) {
 	s.config.WhetherCrypto = whetherCrypto
@@ -284,34 +680,82 @@ func (s *server) SetWhetherChecksum(whetherChecksum bool) {
 	s.config.WhetherChecksum = whetherChecksum
 }
 
+// SetChecksummer 开启 WhetherChecksum 时计算校验值的算法，默认 HMAC-MD5
+func (s *server) SetChecksummer(checksummer zeronetwork.Checksummer) {
+	s.config.Checksummer = checksummer
+}
+
+// SetCodec 编码与解码器，用于 Session.SendProto
+func (s *server) SetCodec(codec zerocodec.Codec) {
+	s.config.Codec = codec
+}
+
+// SetRouter 使用外部创建的路由器，多个服务可以共用同一个路由器
+func (s *server) SetRouter(router zeronetwork.Router) {
+	s.router = router
+}
+
+// SetSessionManager 使用外部创建的会话管理器，多个服务共用时会话 ID 不会重复
+func (s *server) SetSessionManager(sessionManager zeronetwork.SessionManager) {
+	s.sessionManager = sessionManager
+}
+
 // wsHandler 客户端连接过来时的处理
 // 将原本的 http 请求升级为 websocket
 func (s *server) wsHandler(w http.ResponseWriter, r *http.Request) {
 	remoteAddress := r.RemoteAddr
 
 	// 服务器已经关闭
-	if s.isClosed {
+	if s.isClosed.Load() {
 		s.Logger().Infof("reject conn, server is closed, remote remoteAddress: %s", remoteAddress)
 		return
 	}
 	// 此时不接收新的连接
-	if s.isCloseConn {
+	if s.isCloseConn.Load() {
 		s.Logger().Infof("reject conn, conn is closed, remote remoteAddress: %s", remoteAddress)
 		return
 	}
 
+	// 资源压力过大时拒绝新的连接
+	if s.config.AdmissionCheck != nil {
+		if err := s.config.AdmissionCheck(); err != nil {
+			s.rejectConn(remoteAddress, err)
+			return
+		}
+	}
+
 	// 是否超出连接数量上限，关闭新的连接
-	if s.config.MaxConnNum > 0 && s.sessionManager.Len() >= s.config.MaxConnNum {
-		s.Logger().Infof("reject conn, max conn num, remote remoteAddress: %s", remoteAddress)
+	// http.Server 为每个连接单独开启 goroutine，会话加入 sessionManager 或者连接被拒绝时归还预留的名额
+	if !s.connSlots.Acquire(s.sessionManager, s.config.MaxConnNum) {
+		s.rejectConn(remoteAddress, zeronetwork.ErrMaxConnNum)
+		return
+	}
+
+	// 是否超出同一个 IP 的连接数量上限，连接关闭时归还名额
+	if !s.ipConnCounter.Acquire(remoteAddress, s.config.MaxConnPerIP) {
+		s.connSlots.Release()
+		s.rejectConn(remoteAddress, zeronetwork.ErrMaxConnPerIP)
 		return
 	}
 
 	// 完成 websocket 协议的握手操作
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		s.ipConnCounter.Release(remoteAddress)
+		s.connSlots.Release()
 		return
 	}
 
+	// 设置套接字缓冲区
+	if sc := socketConnOf(conn.UnderlyingConn()); sc != nil {
+		if err := zeronetwork.SetSocketBuffer(sc, s.config, remoteAddress); err != nil {
+			_ = conn.Close()
+			s.ipConnCounter.Release(remoteAddress)
+			s.connSlots.Release()
+			return
+		}
+	}
+
 	// session 用于管理该连接
 	session := newSession(
 		s.sessionManager.GenSessionID(),
@@ -321,32 +765,79 @@ func (s *server) wsHandler(w http.ResponseWriter, r *http.Request) {
 		s.router.Handler,
 		s.messageType,
 	)
+	session.resumeCallback = s.resumeSession
+	session.contextHandler = s.router.HandlerContext
+	session.peerWater = &s.water
+	session.peerCounters = &s.counters
+	session.keyExchangeSlots = &s.keyExchangeSlots
 	s.sessionManager.Add(session)
+	s.counters.AddAccepted()
+	s.connSlots.Release()
+	zeronetwork.WatchHandshake(session, func(reason error) {
+		s.rejectConn(remoteAddress, reason)
+	})
 	s.Logger().Infof("sessin: %d, address: %s connected", session.ID(), remoteAddress)
 
 	go session.Run()
 }
 
-// ListenSignal 监听信号
-func (s *server) ListenSignal() {
-	// ctrl + c 或者 kill
-	sigs := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+// socketConnOf 可以设置套接字缓冲区的原始连接，conn 可能是 *tls.Conn 或者 *zeronetwork.PeekConn，不支持时返回 nil
+func socketConnOf(conn net.Conn) zeronetwork.SocketBufferConn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+			continue
+		case *zeronetwork.PeekConn:
+			conn = c.Conn
+			continue
+		}
+		break
+	}
+
+	sc, _ := conn.(zeronetwork.SocketBufferConn)
+	return sc
+}
 
+// ListenSignal 阻塞监听 SIGINT 与 SIGTERM，收到信号时关闭服务，服务被 Close 关闭时停止监听并返回
+// Start 与 Serve 不会注册信号处理，需要时显式调用，已经自行处理信号或者运行多个服务的程序不需要调用
+func (s *server) ListenSignal() {
+	// ctrl + c 或者 kill，SIGKILL 无法被捕获
 	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(ch)
 
-	signal.Notify(ch, sigs...)
+	select {
+	case sig := <-ch:
+		s.config.Logger.Infof("received signal, sig: %+v", sig)
 
-	sig := <-ch
+		// 关闭服务器
+		s.Close()
+	case <-s.closed:
+	}
+}
 
-	signal.Stop(ch)
+// closeSession 关闭会话后的回调
+func (s *server) closeSession(ss zeronetwork.Session) {
+	// 先缓存再移除，断线期间发给该会话的消息不会丢失
+	if session, ok := ss.(*session); ok && session.resumeBuffer != nil {
+		s.sessionManager.Park(session.ID(), session.resumeBuffer)
+	}
+	s.sessionManager.Del(ss.ID())
+	s.ipConnCounter.Release(ss.RemoteAddr().String())
+}
 
-	s.config.Logger.Infof("received signal, sig: %+v", sig)
+// rejectConn 拒绝连接时触发 OnConnReject
+func (s *server) rejectConn(remoteAddress string, reason error) {
+	s.counters.AddRejected()
+	s.Logger().Infof("reject conn, %s, remote remoteAddress: %s", reason.Error(), remoteAddress)
 
-	// 关闭服务器
-	s.Close()
+	if s.config.OnConnReject != nil {
+		s.config.OnConnReject(remoteAddress, reason)
+	}
 }
 
-// closeSession 关闭会话后的回调
-func (s *server) closeSession(session zeronetwork.Session) {
-	s.sessionManager.Del(session.ID())
+// resumeSession 恢复会话后的回调
+func (s *server) resumeSession(session zeronetwork.Session, oldSessionID zeronetwork.SessionID) {
+	s.sessionManager.Rebind(oldSessionID, session)
 }
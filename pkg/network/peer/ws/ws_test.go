@@ -0,0 +1,212 @@
+package ws
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	websocket "github.com/gorilla/websocket"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// startTestServer 在随机端口上启动回显服务，返回监听的端口
+func startTestServer(t *testing.T, opts ...zeronetwork.Option) (*server, int) {
+	opts = append([]zeronetwork.Option{
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithHost("127.0.0.1"),
+		zeronetwork.WithPort(0),
+	}, opts...)
+	s := NewServer(websocket.BinaryMessage, "", "").WithOption(opts...).(*server)
+	_ = s.Router().AddRouter(1, 1, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 1, message.Payload()), nil
+	})
+
+	// Start 不会阻塞，返回时已经在监听
+	started := make(chan error, 1)
+	go func() { started <- s.Start() }()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("start failed: %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("start blocked")
+	}
+
+	return s, s.ln.Addr().(*net.TCPAddr).Port
+}
+
+// connectTestClient 连接服务，收到的响应放入 responses
+func connectTestClient(t *testing.T, port int, responses chan zeronetwork.Message) zeronetwork.Client {
+	c := NewClient(websocket.BinaryMessage, false, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		responses <- message
+		return nil, nil
+	}, WithClientLoggerLevel(zerologger.INFO))
+	if err := c.Connect("ws", "127.0.0.1", port); err != nil {
+		t.Fatalf("connect failed: %s", err.Error())
+	}
+	go c.Run()
+
+	return c
+}
+
+func waitResponse(t *testing.T, responses chan zeronetwork.Message) zeronetwork.Message {
+	select {
+	case message := <-responses:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatal("response timeout")
+		return nil
+	}
+}
+
+func TestStartReturnsListening(t *testing.T) {
+	s, port := startTestServer(t)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectTestClient(t, port, responses)
+	defer c.Close()
+
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("hello"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	if message := waitResponse(t, responses); string(message.Payload()) != "hello" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+}
+
+func TestCloseStopsAccepting(t *testing.T) {
+	s, port := startTestServer(t, zeronetwork.WithCloseGracePeriod(time.Second))
+
+	// 处理中的消息在关闭时仍然可以完成，响应发送之后才关闭连接
+	handling := make(chan struct{})
+	_ = s.Router().AddRouter(1, 2, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		close(handling)
+		time.Sleep(100 * time.Millisecond)
+		return zerodatapack.Respond(message, 2, message.Payload()), nil
+	})
+
+	responses := make(chan zeronetwork.Message, 1)
+	c := connectTestClient(t, port, responses)
+	defer c.Close()
+	if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 2, []byte("slow"))); err != nil {
+		t.Fatalf("send failed: %s", err.Error())
+	}
+	<-handling
+
+	_ = s.Close()
+	if message := waitResponse(t, responses); string(message.Payload()) != "slow" {
+		t.Fatalf("unexpected response: %s", message.String())
+	}
+	if n := s.SessionManager().Len(); n != 0 {
+		t.Fatalf("sessions left after close: %d", n)
+	}
+
+	// 关闭之后不再接受新的升级请求
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/", port), nil)
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("upgrade accepted after close")
+	}
+}
+
+func TestUpgraderOptions(t *testing.T) {
+	s := NewServer(websocket.BinaryMessage, "", "",
+		WithWSHandshakeTimeout(3*time.Second),
+		WithWSReadBufferSize(8*1024),
+		WithWSWriteBufferSize(16*1024),
+		WithWSEnableCompression(true),
+	).(*server)
+
+	upgrader := s.upgrader
+	if upgrader.HandshakeTimeout != 3*time.Second || upgrader.ReadBufferSize != 8*1024 || upgrader.WriteBufferSize != 16*1024 || !upgrader.EnableCompression {
+		t.Fatalf("unexpected upgrader: %+v", upgrader)
+	}
+	if upgrader.CheckOrigin == nil {
+		t.Fatal("CheckOrigin lost")
+	}
+
+	// 每个服务使用自己的升级配置
+	if other := NewServer(websocket.BinaryMessage, "", "").(*server); other.upgrader.EnableCompression || other.upgrader.ReadBufferSize != 0 {
+		t.Fatalf("options leaked to another server: %+v", other.upgrader)
+	}
+}
+
+func TestRemoteAddrCached(t *testing.T) {
+	s, port := startTestServer(t)
+	defer s.Close()
+
+	c := connectTestClient(t, port, make(chan zeronetwork.Message, 1))
+	defer c.Close()
+
+	var session zeronetwork.Session
+	deadline := time.Now().Add(2 * time.Second)
+	for session == nil && time.Now().Before(deadline) {
+		s.SessionManager().Range(func(ss zeronetwork.Session) bool {
+			session = ss
+			return false
+		})
+		time.Sleep(time.Millisecond)
+	}
+	if session == nil {
+		t.Fatal("session not created")
+	}
+
+	// 缓存的地址与底层连接的地址一致
+	if got, want := session.RemoteAddr().String(), session.Conn().RemoteAddr().String(); got != want {
+		t.Fatalf("server session remote addr: %s, underlying: %s", got, want)
+	}
+	if got, want := session.RemoteAddr().String(), c.Conn().LocalAddr().String(); got != want {
+		t.Fatalf("server session remote addr: %s, client local addr: %s", got, want)
+	}
+	if got, want := c.RemoteAddr().String(), c.Conn().RemoteAddr().String(); got != want {
+		t.Fatalf("client remote addr: %s, underlying: %s", got, want)
+	}
+}
+
+// releaseCounter 记录 Release 的调用次数
+type releaseCounter struct {
+	zeronetwork.Message
+	released *int32
+}
+
+func (m *releaseCounter) Release() {
+	atomic.AddInt32(m.released, 1)
+	m.Message.Release()
+}
+
+func TestSendRelease(t *testing.T) {
+	s, port := startTestServer(t)
+	defer s.Close()
+
+	responses := make(chan zeronetwork.Message, 16)
+	c := connectTestClient(t, port, responses)
+	defer c.Close()
+
+	// 会话仍然打开，写入之后的消息就应当归还
+	const total = 10
+	var released int32
+	for sn := uint16(1); sn <= total; sn++ {
+		message := &releaseCounter{Message: zerodatapack.NewLTDMessage(0, sn, 0, 1, 1, []byte("hello")), released: &released}
+		if err := c.Send(message); err != nil {
+			t.Fatalf("send %d failed: %s", sn, err.Error())
+		}
+	}
+	for i := 0; i < total; i++ {
+		waitResponse(t, responses)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&released) != total && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&released); n != total {
+		t.Fatalf("unexpected released: %d, want: %d", n, total)
+	}
+}
@@ -0,0 +1,181 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrProxyHeader 连接开头没有有效的 PROXY protocol 头部，见 Config.ProxyProtocol
+var ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+// ProxyHeaderTimeout 接受连接之后读取 PROXY protocol 头部的超时时间
+const ProxyHeaderTimeout = 5 * time.Second
+
+const (
+	// proxyV1MaxLength v1 头部的最大长度，包含结尾的 \r\n
+	proxyV1MaxLength = 107
+
+	// proxyV2HeadLength v2 头部中固定部分的长度：签名 12 字节，版本与命令、地址族与协议、地址长度
+	proxyV2HeadLength = 16
+)
+
+// proxyV2Signature v2 头部的签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ReadProxyHeader 读取并去掉连接开头的 PROXY protocol v1 (文本) 或 v2 (二进制) 头部，见 Config.ProxyProtocol
+// 返回的连接中 RemoteAddr 为头部中的真实客户端地址，之后读取到的是头部之后的数据
+// 头部为 v1 UNKNOWN、v2 LOCAL 或者非 TCP 地址族时保留连接本身的地址，没有有效头部时返回 ErrProxyHeader
+func ReadProxyHeader(conn net.Conn) (*PeekConn, error) {
+	peekConn, ok := conn.(*PeekConn)
+	if !ok {
+		peekConn = NewPeekConn(conn)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout)); err != nil {
+		return nil, err
+	}
+
+	addr, err := readProxyHeader(peekConn.reader)
+	if err != nil {
+		// 读取超时、连接关闭等错误同样视为没有有效的头部
+		if !errors.Is(err, ErrProxyHeader) {
+			err = fmt.Errorf("%w: %w", ErrProxyHeader, err)
+		}
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	if addr != nil {
+		peekConn.remoteAddr = addr
+	}
+
+	return peekConn, nil
+}
+
+// readProxyHeader 按照第一个字节区分 v1 与 v2，返回头部中的源地址
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	b, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b[0] {
+	case 'P':
+		return readProxyV1(reader)
+	case proxyV2Signature[0]:
+		return readProxyV2(reader)
+	}
+
+	return nil, fmt.Errorf("%w: unknown prefix 0x%02x", ErrProxyHeader, b[0])
+}
+
+// readProxyV1 读取文本格式的头部，比如 "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrProxyHeader)
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header not terminated by CRLF", ErrProxyHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, fmt.Errorf("%w: malformed v1 header", ErrProxyHeader)
+	}
+
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, fmt.Errorf("%w: malformed v1 header", ErrProxyHeader)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("%w: invalid v1 source address: %s", ErrProxyHeader, fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid v1 source port: %s", ErrProxyHeader, fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 读取二进制格式的头部，忽略地址之后的 TLV
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	head := make([]byte, proxyV2HeadLength)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(head[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, fmt.Errorf("%w: invalid v2 signature", ErrProxyHeader)
+	}
+
+	if head[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported v2 version: %d", ErrProxyHeader, head[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	switch head[12] & 0x0f {
+	case 0x00:
+		// LOCAL，代理自身的连接，比如健康检查
+		return nil, nil
+	case 0x01:
+		// PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported v2 command: %d", ErrProxyHeader, head[12]&0x0f)
+	}
+
+	// 高 4 位为地址族，低 4 位为协议，只处理 TCP (STREAM)
+	if head[13]&0x0f != 0x01 {
+		return nil, nil
+	}
+
+	switch head[13] >> 4 {
+	case 0x01:
+		// AF_INET：源地址、目标地址各 4 字节，源端口、目标端口各 2 字节
+		if len(body) < 12 {
+			return nil, fmt.Errorf("%w: v2 address too short", ErrProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(append([]byte(nil), body[0:4]...)), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x02:
+		// AF_INET6：源地址、目标地址各 16 字节，源端口、目标端口各 2 字节
+		if len(body) < 36 {
+			return nil, fmt.Errorf("%w: v2 address too short", ErrProxyHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(append([]byte(nil), body[0:16]...)), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// QueueStats 会话中发送队列与接收队列的占用情况，用于排查背压
+type QueueStats struct {
+	// SendLen 发送队列中等待发送的消息数量，包括溢出缓冲区中的消息
+	SendLen int
+
+	// SendCap 发送队列的容量，即 Config.SendQueueSize
+	SendCap int
+
+	// SendMax 发送队列可以增长到的消息数量上限，见 Config.SendQueueMaxSize，不会增长时与 SendCap 相同
+	SendMax int
+
+	// SendHighWater 会话创建以来发送队列的最高水位
+	SendHighWater int
+
+	// RecvLen 接收队列中等待处理的消息数量
+	RecvLen int
+
+	// RecvCap 接收队列的容量，即 Config.RecvQueueSize
+	RecvCap int
+
+	// RecvHighWater 会话创建以来接收队列的最高水位
+	RecvHighWater int
+
+	// SendShapedDelay 因为 Config.SendRateLimit 而延迟发送的累计时间
+	SendShapedDelay time.Duration
+}
+
+// PeerStats 服务中所有会话的队列统计
+type PeerStats struct {
+	// Sessions 当前会话数量
+	Sessions int
+
+	// SendLen 当前所有会话发送队列中等待发送的消息总数
+	SendLen int
+
+	// RecvLen 当前所有会话接收队列中等待处理的消息总数
+	RecvLen int
+
+	// SendHighWater 服务启动以来，单个会话发送队列的最高水位，包括已经关闭的会话
+	SendHighWater int
+
+	// RecvHighWater 服务启动以来，单个会话接收队列的最高水位，包括已经关闭的会话
+	RecvHighWater int
+
+	// SendShapedDelay 当前所有会话因为 Config.SendRateLimit 而延迟发送的累计时间
+	SendShapedDelay time.Duration
+
+	// Accepted 服务启动以来创建的会话数量
+	Accepted uint64
+
+	// Rejected 服务启动以来拒绝的连接数量，见 Config.OnConnReject
+	Rejected uint64
+
+	// BytesIn 服务启动以来从所有连接读取的字节数
+	BytesIn uint64
+
+	// BytesOut 服务启动以来向所有连接写入的字节数
+	BytesOut uint64
+
+	// Messages 服务启动以来所有会话处理的路由消息数量
+	Messages uint64
+
+	// ZeroMessages 服务启动以来所有会话处理的特殊协议消息 (FlagZero) 数量，不包括被拒绝的消息
+	ZeroMessages uint64
+
+	// ZeroLimited 服务启动以来超过 Config.ZeroMessageRate 或者 Config.MaxExchangeKeyRequests 而被拒绝的特殊协议消息数量
+	ZeroLimited uint64
+}
+
+// QueueWater 记录发送队列与接收队列的最高水位，可以在多个 goroutine 中同时更新
+type QueueWater struct {
+	send int64
+	recv int64
+}
+
+// UpdateSend 放入发送队列之后，使用当前长度更新最高水位
+func (w *QueueWater) UpdateSend(n int) {
+	updateHighWater(&w.send, n)
+}
+
+// UpdateRecv 放入接收队列之后，使用当前长度更新最高水位
+func (w *QueueWater) UpdateRecv(n int) {
+	updateHighWater(&w.recv, n)
+}
+
+// Send 发送队列的最高水位
+func (w *QueueWater) Send() int {
+	return int(atomic.LoadInt64(&w.send))
+}
+
+// Recv 接收队列的最高水位
+func (w *QueueWater) Recv() int {
+	return int(atomic.LoadInt64(&w.recv))
+}
+
+func updateHighWater(water *int64, n int) {
+	for {
+		old := atomic.LoadInt64(water)
+		if int64(n) <= old || atomic.CompareAndSwapInt64(water, old, int64(n)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package network
+
+import "time"
+
+// RateLimiter 令牌桶，按字节限制发送速度，允许 0.1 秒的突发流量
+// 不是并发安全的，只在 sendLoop 中使用
+type RateLimiter struct {
+	// rate 每秒产生的令牌数量，即每秒允许发送的字节数
+	rate float64
+
+	// burst 令牌桶的容量
+	burst float64
+
+	// tokens 当前令牌数量，预支之后可能为负数
+	tokens float64
+
+	// last 上一次计算令牌的时间
+	last time.Time
+}
+
+// NewRateLimiter 创建令牌桶，rate 为每秒允许发送的字节数
+func NewRateLimiter(rate int) *RateLimiter {
+	burst := float64(rate) / 10
+
+	return &RateLimiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// Reserve 消耗 n 个令牌，令牌不足时预支，返回令牌恢复为非负之前需要等待的时间
+func (r *RateLimiter) Reserve(n int, now time.Time) time.Duration {
+	if !r.last.IsZero() {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
@@ -0,0 +1,31 @@
+package network_test
+
+import (
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := zeronetwork.NewRateLimiter(1000)
+
+	// 突发流量不超过 0.1 秒的额度时不需要等待
+	if delay := r.Reserve(100, now); delay != 0 {
+		t.Fatalf("unexpected delay within burst: %s", delay)
+	}
+
+	// 超出额度的部分按速率计算等待时间
+	if delay := r.Reserve(500, now); delay != 500*time.Millisecond {
+		t.Fatalf("unexpected delay: %s", delay)
+	}
+
+	// 等待之后额度恢复为 0，空闲再久也不超过桶的容量
+	if delay := r.Reserve(0, now.Add(500*time.Millisecond)); delay != 0 {
+		t.Fatalf("unexpected delay after wait: %s", delay)
+	}
+	if delay := r.Reserve(200, now.Add(10*time.Second)); delay != 100*time.Millisecond {
+		t.Fatalf("unexpected delay after idle: %s", delay)
+	}
+}
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"sync"
+
+	zeroringbytes "github.com/zerogo-hub/zero-helper/buffer/ringbytes"
+)
+
+// RecvBuffer 存储从套接字读取的数据，等待 Datapack.Unpack 解包
+// 开启 Config.RingBufferLazy 时，收到数据之后才创建环形缓冲区，空间不足时按需扩容，最大为 Config.RingBufferSize
+// 未设置 Config.RingBufferSize 时，为了存放比 RecvBufferSize 更长的消息，最大可以扩容到 Config.MaxMessageSize + Config.RecvBufferSize
+type RecvBuffer struct {
+	// ring 环形缓冲区，延迟创建时收到数据之前为 nil
+	ring *zeroringbytes.RingBytes
+
+	// size 环形缓冲区的最大长度
+	size int
+
+	// initSize 延迟创建时环形缓冲区的初始长度
+	initSize int
+
+	// limit 为了存放一个完整的消息，环形缓冲区允许扩容到的最大长度，不小于 size
+	limit int
+}
+
+// NewRecvBuffer 根据配置创建接收缓冲区
+func NewRecvBuffer(config *Config) *RecvBuffer {
+	size := config.RingBufferSize
+	if size <= 0 {
+		size = config.RecvBufferSize * 2
+	}
+
+	b := &RecvBuffer{size: size, limit: size}
+
+	// 未处理的数据最多为一个不完整的消息，加上一次读取的长度
+	if config.RingBufferSize <= 0 {
+		if limit := config.MaxMessageSize + config.RecvBufferSize; limit > b.limit {
+			b.limit = limit
+		}
+	}
+
+	if config.RingBufferLazy {
+		b.initSize = config.RecvBufferSize
+		if b.initSize <= 0 || b.initSize > size {
+			b.initSize = size
+		}
+	} else {
+		b.ring = zeroringbytes.New(size)
+		b.ring.Reset()
+	}
+
+	return b
+}
+
+// Write 写入数据，只有全部写入或者都不写入两种情况
+// 剩余空间不足且未达到最大长度时会先扩容
+func (b *RecvBuffer) Write(p []byte) error {
+	if b.ring == nil {
+		b.ring = zeroringbytes.New(b.initSize)
+		b.ring.Reset()
+	}
+
+	// 为了存放较长的消息扩容之后，数据处理完毕时恢复为原来的大小
+	if b.ring.Cap() > b.size && b.ring.Len() == 0 && len(p) <= b.size {
+		b.ring = zeroringbytes.New(b.size)
+		b.ring.Reset()
+	}
+
+	need := b.ring.Len() + len(p)
+	if need > b.ring.Cap() && b.ring.Cap() < b.limit {
+		b.grow(need)
+	}
+
+	_, err := b.ring.Write(p)
+	return err
+}
+
+// grow 扩容到不小于 need，每次至少扩大一倍，不超过最大长度
+func (b *RecvBuffer) grow(need int) {
+	size := b.ring.Cap() * 2
+	if size < need {
+		size = need
+	}
+	if size > b.limit {
+		size = b.limit
+	}
+
+	ring := zeroringbytes.New(size)
+	ring.Reset()
+
+	if n := b.ring.Len(); n > 0 {
+		p, _ := b.ring.Read(n)
+		_, _ = ring.Write(p)
+	}
+
+	b.ring = ring
+}
+
+// RingBytes 环形缓冲区，用于 Datapack.Unpack，延迟创建时收到数据之前为 nil
+func (b *RecvBuffer) RingBytes() *zeroringbytes.RingBytes {
+	return b.ring
+}
+
+// Cap 环形缓冲区当前的容量
+func (b *RecvBuffer) Cap() int {
+	if b.ring == nil {
+		return 0
+	}
+
+	return b.ring.Cap()
+}
+
+// readBufferPools 共享的读取缓冲区，按长度区分
+var readBufferPools sync.Map
+
+// GetReadBuffer 从共享缓冲池中获取长度为 size 的读取缓冲区，用完之后需要调用 PutReadBuffer 归还
+func GetReadBuffer(size int) *[]byte {
+	pool, ok := readBufferPools.Load(size)
+	if !ok {
+		pool, _ = readBufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, size)
+				return &buffer
+			},
+		})
+	}
+
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// PutReadBuffer 归还读取缓冲区
+func PutReadBuffer(buffer *[]byte) {
+	if pool, ok := readBufferPools.Load(len(*buffer)); ok {
+		pool.(*sync.Pool).Put(buffer)
+	}
+}
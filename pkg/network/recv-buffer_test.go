@@ -0,0 +1,122 @@
+package network_test
+
+import (
+	"bytes"
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func TestRecvBufferSize(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+
+	// 未设置时使用 RecvBufferSize 的 2 倍
+	if size := zeronetwork.NewRecvBuffer(config).Cap(); size != config.RecvBufferSize*2 {
+		t.Fatalf("unexpected ring buffer size: %d", size)
+	}
+
+	config.RingBufferSize = 1000
+	if size := zeronetwork.NewRecvBuffer(config).Cap(); size != 1000 {
+		t.Fatalf("unexpected ring buffer size: %d", size)
+	}
+}
+
+func TestRecvBufferLazy(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.RecvBufferSize = 64
+	config.RingBufferSize = 1000
+	config.RingBufferLazy = true
+
+	b := zeronetwork.NewRecvBuffer(config)
+	if b.Cap() != 0 || b.RingBytes() != nil {
+		t.Fatalf("ring buffer created before data arrived, size: %d", b.Cap())
+	}
+
+	// 收到数据后创建，初始大小为 RecvBufferSize
+	if err := b.Write(bytes.Repeat([]byte{1}, 10)); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	if b.Cap() != 64 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+
+	// 空间不足时扩容，已有数据保持不变
+	if err := b.Write(bytes.Repeat([]byte{2}, 100)); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	if b.Cap() != 128 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+	p, err := b.RingBytes().Read(110)
+	if err != nil {
+		t.Fatalf("read failed: %s", err.Error())
+	}
+	if !bytes.Equal(p[:10], bytes.Repeat([]byte{1}, 10)) || !bytes.Equal(p[10:], bytes.Repeat([]byte{2}, 100)) {
+		t.Fatal("unexpected data after grow")
+	}
+
+	// 不超过 RingBufferSize
+	if err := b.Write(make([]byte, 1001)); err == nil {
+		t.Fatal("write more than ring buffer size")
+	}
+	if b.Cap() != 1000 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+}
+
+// BenchmarkIdleRecvBuffer 空闲连接的接收缓冲区占用的内存，见 B/op
+func BenchmarkIdleRecvBuffer(b *testing.B) {
+	for _, lazy := range []bool{false, true} {
+		name := "eager"
+		if lazy {
+			name = "lazy"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			config := zeronetwork.DefaultConfig()
+			config.RingBufferLazy = lazy
+
+			buffers := make([]*zeronetwork.RecvBuffer, b.N)
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buffers[i] = zeronetwork.NewRecvBuffer(config)
+			}
+		})
+	}
+}
+
+func TestRecvBufferMaxMessageSize(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.RecvBufferSize = 64
+	config.MaxMessageSize = 1000
+
+	b := zeronetwork.NewRecvBuffer(config)
+	if b.Cap() != 128 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+
+	// 未处理的数据超过环形缓冲区大小时扩容，最大为 MaxMessageSize + RecvBufferSize
+	for i := 0; i < 16; i++ {
+		if err := b.Write(bytes.Repeat([]byte{byte(i)}, 64)); err != nil {
+			t.Fatalf("write failed: %s", err.Error())
+		}
+	}
+	if b.Cap() != 1024 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+	if err := b.Write(make([]byte, 64)); err == nil {
+		t.Fatal("write more than max message size")
+	}
+
+	// 数据处理完毕之后恢复为原来的大小
+	if _, err := b.RingBytes().Read(b.RingBytes().Len()); err != nil {
+		t.Fatalf("read failed: %s", err.Error())
+	}
+	if err := b.Write(make([]byte, 10)); err != nil {
+		t.Fatalf("write failed: %s", err.Error())
+	}
+	if b.Cap() != 128 {
+		t.Fatalf("unexpected ring buffer size: %d", b.Cap())
+	}
+}
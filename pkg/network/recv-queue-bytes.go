@@ -0,0 +1,74 @@
+package network
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrRecvQueueBytes 接收队列中缓存的消息负载超过 Config.RecvQueueMaxBytes
+var ErrRecvQueueBytes = errors.New("recv queue bytes exceeded")
+
+// RecvQueueBytes 统计接收队列中缓存的消息负载字节数，用于 Config.RecvQueueMaxBytes
+// 与 RecvQueueSize 共同限制每个会话缓存的消息占用的内存，使用 NewRecvQueueBytes 创建
+type RecvQueueBytes struct {
+	// bytes 已放入接收队列，尚未取出的消息负载字节数
+	bytes int64
+
+	// drained 有消息取出时通知等待者
+	drained chan struct{}
+}
+
+// NewRecvQueueBytes 创建统计器
+func NewRecvQueueBytes() *RecvQueueBytes {
+	return &RecvQueueBytes{
+		drained: make(chan struct{}, 1),
+	}
+}
+
+// Acquire 消息放入接收队列之前调用，队列中的字节数加上 size 超过 Config.RecvQueueMaxBytes 时按照 Config.RecvOverflowPolicy 处理
+// RecvOverflowBlock 等待队列中的消息被取出，RecvOverflowClose 直接返回 ErrRecvQueueBytes；closeCh 关闭时返回 ErrStopSend
+// 队列为空时总是可以放入，单个消息超过上限也不会一直等待。成功之后，消息取出时需要调用 Release
+func (b *RecvQueueBytes) Acquire(config *Config, size int, closeCh <-chan bool) error {
+	for {
+		ok, err := b.TryAcquire(config, size)
+		if ok || err != nil {
+			return err
+		}
+
+		select {
+		case <-b.drained:
+		case <-closeCh:
+			return ErrStopSend
+		}
+	}
+}
+
+// TryAcquire 见 Acquire，不会等待，RecvOverflowBlock 时超过上限返回 false，用于不能阻塞的共享读取协程
+func (b *RecvQueueBytes) TryAcquire(config *Config, size int) (bool, error) {
+	bytes := atomic.LoadInt64(&b.bytes)
+	if config.RecvQueueMaxBytes <= 0 || bytes == 0 || bytes+int64(size) <= int64(config.RecvQueueMaxBytes) {
+		atomic.AddInt64(&b.bytes, int64(size))
+		return true, nil
+	}
+
+	if config.RecvOverflowPolicy == RecvOverflowClose {
+		return false, ErrRecvQueueBytes
+	}
+
+	return false, nil
+}
+
+// Release 消息从接收队列中取出之后调用，唤醒等待的 Acquire
+func (b *RecvQueueBytes) Release(size int) {
+	atomic.AddInt64(&b.bytes, -int64(size))
+
+	select {
+	case b.drained <- struct{}{}:
+	default:
+	}
+}
+
+// Len 接收队列中缓存的消息负载字节数
+func (b *RecvQueueBytes) Len() int64 {
+	return atomic.LoadInt64(&b.bytes)
+}
@@ -0,0 +1,161 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRequestTimeout 超时仍未收到请求的响应
+	ErrRequestTimeout = errors.New("request timeout")
+
+	// ErrTooManyRequests 等待响应的请求过多，可以使用的 SN 已经用完
+	ErrTooManyRequests = errors.New("too many pending requests")
+)
+
+// RequestSNMin 主动发出的请求使用 [RequestSNMin, 65535] 之间的 SN，见 Session.Request
+// 对方需要原样返回请求的 SN 与 module，比如使用 datapack.Respond 创建响应
+// 客户端自动分配的 SN 在 [1, RequestSNMin) 之间循环，手动设置的 SN 也需要小于 RequestSNMin，否则可能被当作请求的响应
+const RequestSNMin = uint16(0x8000)
+
+// PendingRequests 主动发出、等待对方响应的请求，用于 Session.Request
+// 收到的消息 SN 与 module 均与某个等待中的请求一致时，视为该请求的响应，不再派发给路由
+type PendingRequests struct {
+	mutex sync.Mutex
+
+	// waiters SN 对应的等待者
+	waiters map[uint16]*requestWaiter
+
+	// nextSN 下一个尝试分配的 SN
+	nextSN uint16
+
+	// closed 会话已关闭，不再发出新的请求
+	closed bool
+}
+
+// requestWaiter 等待一个请求的响应
+type requestWaiter struct {
+	// module 请求的 module，响应需要一致
+	module uint8
+
+	// ch 收到响应或者会话关闭
+	ch chan Message
+}
+
+// Do 为 message 分配 SN，使用 send 发送之后等待响应
+// timeout <= 0 表示一直等待，等待期间会话关闭时返回 ErrStopSend
+func (p *PendingRequests) Do(send func(Message) error, message Message, timeout time.Duration) (Message, error) {
+	sn, waiter, err := p.register(message.ModuleID())
+	if err != nil {
+		return nil, err
+	}
+
+	message.SetSN(sn)
+	if err := send(message); err != nil {
+		p.remove(sn, waiter)
+		return nil, err
+	}
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case response, ok := <-waiter.ch:
+		if !ok {
+			return nil, ErrStopSend
+		}
+		return response, nil
+	case <-timeoutCh:
+		// 超时的同时收到了响应，响应已经交给等待者，不会再派发给路由，需要在这里释放
+		if !p.remove(sn, waiter) {
+			if response, ok := <-waiter.ch; ok {
+				response.Release()
+			}
+		}
+		return nil, ErrRequestTimeout
+	}
+}
+
+// Resolve message 为等待中的请求的响应时交给等待者，返回 true，此时不需要再处理该消息
+func (p *PendingRequests) Resolve(message Message) bool {
+	if message.SN() < RequestSNMin {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	waiter, ok := p.waiters[message.SN()]
+	if !ok || waiter.module != message.ModuleID() {
+		return false
+	}
+
+	delete(p.waiters, message.SN())
+	waiter.ch <- message
+
+	return true
+}
+
+// Close 会话关闭，唤醒所有等待者，之后的请求返回 ErrStopSend
+func (p *PendingRequests) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+	for sn, waiter := range p.waiters {
+		close(waiter.ch)
+		delete(p.waiters, sn)
+	}
+}
+
+// register 分配一个未被占用的 SN
+func (p *PendingRequests) register(module uint8) (uint16, *requestWaiter, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return 0, nil, ErrStopSend
+	}
+
+	if p.waiters == nil {
+		p.waiters = make(map[uint16]*requestWaiter)
+	}
+
+	for i := 0; i <= int(^uint16(0)-RequestSNMin); i++ {
+		sn := p.nextSN
+		if sn < RequestSNMin {
+			sn = RequestSNMin
+		}
+		// 超过 65535 之后从 RequestSNMin 开始
+		p.nextSN = sn + 1
+
+		if _, ok := p.waiters[sn]; ok {
+			continue
+		}
+
+		waiter := &requestWaiter{module: module, ch: make(chan Message, 1)}
+		p.waiters[sn] = waiter
+		return sn, waiter, nil
+	}
+
+	return 0, nil, ErrTooManyRequests
+}
+
+// remove 请求结束，移除仍在等待的等待者
+// 返回 false 表示等待者已经被 Resolve 或者 Close 移除，此时 ch 中已有响应或者 ch 已经关闭
+func (p *PendingRequests) remove(sn uint16, waiter *requestWaiter) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.waiters[sn] != waiter {
+		return false
+	}
+
+	delete(p.waiters, sn)
+	return true
+}
@@ -0,0 +1,55 @@
+package network_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// releaseCounter 记录 Release 的调用次数
+type releaseCounter struct {
+	zeronetwork.Message
+	released *int32
+}
+
+func (m *releaseCounter) Release() {
+	atomic.AddInt32(m.released, 1)
+}
+
+func TestPendingRequestsLateResponse(t *testing.T) {
+	var p zeronetwork.PendingRequests
+	var released int32
+
+	// 等待者超时的同时，dispatchLoop 收到了响应
+	for i := 0; i < 200; i++ {
+		request := zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)
+		_, err := p.Do(func(message zeronetwork.Message) error {
+			response := &releaseCounter{Message: zerodatapack.Respond(message, 1, nil), released: &released}
+			go func() {
+				time.Sleep(time.Millisecond)
+				if !p.Resolve(response) {
+					response.Release()
+				}
+			}()
+			return nil
+		}, request, time.Millisecond)
+		if err != nil && err != zeronetwork.ErrRequestTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err == nil {
+			atomic.AddInt32(&released, 1)
+		}
+	}
+
+	// 每个响应都被等待者取走或者释放，不会遗留在等待者中
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&released) != 200 {
+		if time.Now().After(deadline) {
+			t.Fatalf("responses lost: %d released", atomic.LoadInt32(&released))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrResumeBufferOverflow 消息负载超过 Config.ResumeBufferBytes，无法缓存
+var ErrResumeBufferOverflow = errors.New("message larger than resume buffer")
+
+// ResumeBuffer 断线后保留会话状态期间发给该会话的消息，恢复会话后重新发送，见 Config.ResumeBufferSize
+// 按照数量与负载总长度限制，超出时丢弃最早的消息，可以在多个协程中使用
+type ResumeBuffer struct {
+	mutex sync.Mutex
+
+	// messages 缓存的消息，按照发送顺序排列
+	messages []Message
+
+	// bytes 缓存的消息负载总长度
+	bytes int
+
+	// size 最多缓存的消息数量
+	size int
+
+	// maxBytes 消息负载总长度上限，<= 0 表示不限制
+	maxBytes int
+
+	// dropped 因超出上限被丢弃的消息数量
+	dropped int
+
+	// expireAt 过期时间，与会话状态同时过期
+	expireAt time.Time
+
+	// send 恢复会话之后，Push 的消息直接交给恢复的会话发送，见 Replay
+	send func(Message) error
+}
+
+// NewResumeBuffer 创建最多缓存 size 个消息、负载总长度不超过 maxBytes 的缓存，ttl 之后过期
+func NewResumeBuffer(size, maxBytes int, ttl time.Duration) *ResumeBuffer {
+	return &ResumeBuffer{
+		size:     size,
+		maxBytes: maxBytes,
+		expireAt: time.Now().Add(ttl),
+	}
+}
+
+// Push 缓存消息，超出上限时丢弃并释放最早的消息
+// 已经过期时返回 ErrResumeTokenInvalid，负载本身超过上限时返回 ErrResumeBufferOverflow，这两种情况不会持有该消息
+// 已经调用 Replay 时直接交给恢复的会话发送，返回发送的结果
+func (b *ResumeBuffer) Push(message Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.send != nil {
+		return b.send(message)
+	}
+
+	if b.expired() {
+		return ErrResumeTokenInvalid
+	}
+
+	n := len(message.Payload())
+	if b.maxBytes > 0 && n > b.maxBytes {
+		b.dropped++
+		return ErrResumeBufferOverflow
+	}
+
+	for len(b.messages) > 0 && (len(b.messages) >= b.size || (b.maxBytes > 0 && b.bytes+n > b.maxBytes)) {
+		oldest := b.messages[0]
+		b.messages[0] = nil
+		b.messages = b.messages[1:]
+		b.bytes -= len(oldest.Payload())
+		b.dropped++
+		oldest.Release()
+	}
+
+	b.messages = append(b.messages, message)
+	b.bytes += n
+
+	return nil
+}
+
+// Drain 取出所有缓存的消息，之后缓存为空，b 为 nil 时返回 nil
+func (b *ResumeBuffer) Drain() []Message {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messages := b.messages
+	b.messages = nil
+	b.bytes = 0
+
+	return messages
+}
+
+// Replay 按照原来的顺序将缓存的消息交给 send 重新发送，之后 Push 的消息也直接交给 send，b 为 nil 时什么都不做
+// 与 Push 使用同一把锁，恢复会话的过程中发给该会话的消息不会丢失，也不会排到缓存的消息之前
+// send 失败时释放剩余的消息并返回错误
+func (b *ResumeBuffer) Replay(send func(Message) error) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	messages := b.messages
+	b.messages = nil
+	b.bytes = 0
+	b.send = send
+
+	for i, message := range messages {
+		if err := send(message); err != nil {
+			for _, m := range messages[i+1:] {
+				m.Release()
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Len 缓存的消息数量
+func (b *ResumeBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.messages)
+}
+
+// Dropped 因超出上限被丢弃的消息数量
+func (b *ResumeBuffer) Dropped() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.dropped
+}
+
+// Expired 是否已经过期
+func (b *ResumeBuffer) Expired() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.expired()
+}
+
+func (b *ResumeBuffer) expired() bool {
+	return time.Now().After(b.expireAt)
+}
@@ -0,0 +1,90 @@
+package network_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+func TestResumeBuffer(t *testing.T) {
+	push := func(buffer *zeronetwork.ResumeBuffer, payload string) error {
+		return buffer.Push(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte(payload)))
+	}
+	drain := func(buffer *zeronetwork.ResumeBuffer) string {
+		var s string
+		for _, message := range buffer.Drain() {
+			s += string(message.Payload())
+		}
+		return s
+	}
+
+	// 超出数量时丢弃最早的消息
+	buffer := zeronetwork.NewResumeBuffer(2, 0, time.Minute)
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := push(buffer, payload); err != nil {
+			t.Fatalf("push failed: %s", err.Error())
+		}
+	}
+	if got := drain(buffer); got != "bc" || buffer.Dropped() != 1 {
+		t.Fatalf("unexpected buffer: %q, dropped: %d", got, buffer.Dropped())
+	}
+	if buffer.Len() != 0 {
+		t.Fatalf("unexpected len after drain: %d", buffer.Len())
+	}
+
+	// 超出负载总长度时丢弃最早的消息，负载本身超出上限时不缓存
+	buffer = zeronetwork.NewResumeBuffer(10, 4, time.Minute)
+	_ = push(buffer, "aa")
+	_ = push(buffer, "bb")
+	_ = push(buffer, "c")
+	if err := push(buffer, "ddddd"); !errors.Is(err, zeronetwork.ErrResumeBufferOverflow) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got := drain(buffer); got != "bbc" || buffer.Dropped() != 2 {
+		t.Fatalf("unexpected buffer: %q, dropped: %d", got, buffer.Dropped())
+	}
+
+	// 过期之后不再缓存
+	buffer = zeronetwork.NewResumeBuffer(10, 0, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := push(buffer, "a"); !errors.Is(err, zeronetwork.ErrResumeTokenInvalid) || !buffer.Expired() {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var nilBuffer *zeronetwork.ResumeBuffer
+	if nilBuffer.Drain() != nil {
+		t.Fatal("nil buffer drained messages")
+	}
+}
+
+func TestResumeBufferReplay(t *testing.T) {
+	buffer := zeronetwork.NewResumeBuffer(10, 0, time.Minute)
+	for _, payload := range []string{"a", "b"} {
+		_ = buffer.Push(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte(payload)))
+	}
+
+	var sent string
+	send := func(message zeronetwork.Message) error {
+		sent += string(message.Payload())
+		return nil
+	}
+
+	// 缓存的消息按顺序重新发送，之后 Push 的消息直接发送，不会留在缓存中
+	if err := buffer.Replay(send); err != nil {
+		t.Fatalf("replay failed: %s", err.Error())
+	}
+	if err := buffer.Push(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("c"))); err != nil {
+		t.Fatalf("push after replay failed: %s", err.Error())
+	}
+	if sent != "abc" || buffer.Len() != 0 {
+		t.Fatalf("unexpected sent: %q, len: %d", sent, buffer.Len())
+	}
+
+	var nilBuffer *zeronetwork.ResumeBuffer
+	if err := nilBuffer.Replay(send); err != nil {
+		t.Fatalf("nil buffer replay failed: %s", err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrResumeTokenInvalid 恢复令牌不存在或者已过期
+	ErrResumeTokenInvalid = errors.New("resume token is invalid or expired")
+)
+
+// ResumeState 断线后保留的会话状态，用于客户端重连后恢复
+type ResumeState struct {
+	// SessionID 原会话 ID，恢复后新连接沿用该 ID
+	SessionID SessionID
+
+	// Parameters 原会话中的自定义参数
+	Parameters map[string]interface{}
+
+	// Key 秘钥协商得到的秘钥，未协商时为 nil
+	Key []byte
+
+	// Buffer 断线期间发给该会话的消息，恢复会话后重新发送，未开启 Config.ResumeBufferSize 时为 nil
+	Buffer *ResumeBuffer
+}
+
+// ResumeStore 存储断线后保留的会话状态
+type ResumeStore interface {
+	// Save 保存会话状态，ttl 之后过期
+	Save(token string, state *ResumeState, ttl time.Duration)
+
+	// Load 取出会话状态，令牌只能使用一次，不存在或者已过期时返回 ErrResumeTokenInvalid
+	Load(token string) (*ResumeState, error)
+}
+
+// NewResumeToken 生成一个随机的恢复令牌
+func NewResumeToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// resumeStore 基于内存的 ResumeStore
+type resumeStore struct {
+	mutex sync.Mutex
+
+	// states 令牌 -> 会话状态
+	states map[string]*resumeElement
+}
+
+// resumeElement 一个保留的会话状态
+type resumeElement struct {
+	state    *ResumeState
+	expireAt time.Time
+}
+
+// NewResumeStore 创建一个基于内存的 ResumeStore
+func NewResumeStore() ResumeStore {
+	return &resumeStore{states: make(map[string]*resumeElement)}
+}
+
+// Save 保存会话状态，同时清理已过期的状态
+func (r *resumeStore) Save(token string, state *ResumeState, ttl time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for t, element := range r.states {
+		if now.After(element.expireAt) {
+			delete(r.states, t)
+		}
+	}
+
+	r.states[token] = &resumeElement{state: state, expireAt: now.Add(ttl)}
+}
+
+// Load 取出会话状态
+func (r *resumeStore) Load(token string) (*ResumeState, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	element, ok := r.states[token]
+	if !ok {
+		return nil, ErrResumeTokenInvalid
+	}
+	delete(r.states, token)
+
+	if time.Now().After(element.expireAt) {
+		return nil, ErrResumeTokenInvalid
+	}
+
+	return element.state, nil
+}
@@ -1,6 +1,11 @@
 package network
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
 
 var (
 	// ErrRouterRepeated 路由已存在
@@ -8,11 +13,41 @@ var (
 
 	// ErrHandlerNotFound 处理函数未找到
 	ErrHandlerNotFound = errors.New("handler not found")
+
+	// ErrFatal 处理函数返回该错误(或者包装了该错误)时，会断开连接
+	// 其它错误仅记录日志，连接保持
+	ErrFatal = errors.New("fatal error")
 )
 
+// RouteID 路由 ID，高 8 位为 module，低 8 位为 action
+// 与消息体中 module、action 两个字节按大端读取的值一致，可以定义为常量，注册路由与创建消息共用
+//
+//	const RouteHelloSay zeronetwork.RouteID = ModuleHello<<8 | ActionHelloSay
+type RouteID uint16
+
+// NewRouteID 根据 module 与 action 创建路由 ID
+func NewRouteID(module, action uint8) RouteID {
+	return RouteID(uint16(module)<<8 | uint16(action))
+}
+
+// MessageRouteID 消息对应的路由 ID
+func MessageRouteID(message Message) RouteID {
+	return NewRouteID(message.ModuleID(), message.ActionID())
+}
+
+// Module 功能模块
+func (id RouteID) Module() uint8 {
+	return uint8(id >> 8)
+}
+
+// Action 功能细分
+func (id RouteID) Action() uint8 {
+	return uint8(id)
+}
+
 type router struct {
 	// 路由
-	routes map[uint16]HandlerFunc
+	routes map[RouteID]ContextHandlerFunc
 
 	// 自定义处理逻辑
 	// 路由未命中，则调用此函数
@@ -22,40 +57,92 @@ type router struct {
 // NewRouter 创建一个路由器
 func NewRouter() Router {
 	return &router{
-		routes: make(map[uint16]HandlerFunc),
+		routes: make(map[RouteID]ContextHandlerFunc),
 	}
 }
 
-// RouterID 转化路由 Id
+// RouterID 转化路由 Id，见 RouteID
 func RouterID(module, action uint8) uint16 {
-	return uint16(module<<4 + action)
+	return uint16(NewRouteID(module, action))
 }
 
 // AddRouter 添加路由
 func (router *router) AddRouter(module, action uint8, handler HandlerFunc) error {
+	return router.AddRoute(NewRouteID(module, action), handler)
+}
+
+// AddRoute 使用路由 ID 添加路由
+func (router *router) AddRoute(routeID RouteID, handler HandlerFunc) error {
 	if handler == nil {
 		return errors.New("handle can not be nil")
 	}
 
-	routerID := RouterID(module, action)
+	return router.AddContextRoute(routeID, func(_ context.Context, message Message) (Message, error) {
+		return handler(message)
+	})
+}
+
+// AddContextRoute 使用路由 ID 添加可以感知超时的路由
+func (router *router) AddContextRoute(routeID RouteID, handler ContextHandlerFunc) error {
+	if handler == nil {
+		return errors.New("handle can not be nil")
+	}
 
-	if _, ok := router.routes[routerID]; ok {
+	if _, ok := router.routes[routeID]; ok {
 		return ErrRouterRepeated
 	}
 
-	router.routes[routerID] = handler
+	router.routes[routeID] = handler
+
+	return nil
+}
+
+// AddRouters 批量添加路由，任意一个路由已存在或者处理函数为空时全部不添加
+// 返回的错误包含所有冲突的路由，已存在的路由可以使用 errors.Is(err, ErrRouterRepeated) 判断
+func (router *router) AddRouters(routes map[RouteID]HandlerFunc) error {
+	routeIDs := make([]RouteID, 0, len(routes))
+	for routeID := range routes {
+		routeIDs = append(routeIDs, routeID)
+	}
+	// 按路由 ID 排序，错误信息保持稳定
+	sort.Slice(routeIDs, func(i, j int) bool { return routeIDs[i] < routeIDs[j] })
+
+	// 先检查全部路由，再统一添加
+	var errs []error
+	for _, routeID := range routeIDs {
+		if routes[routeID] == nil {
+			errs = append(errs, fmt.Errorf("handle can not be nil, module: %d, action: %d", routeID.Module(), routeID.Action()))
+			continue
+		}
+
+		if _, ok := router.routes[routeID]; ok {
+			errs = append(errs, fmt.Errorf("%w, module: %d, action: %d", ErrRouterRepeated, routeID.Module(), routeID.Action()))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, routeID := range routeIDs {
+		if err := router.AddRoute(routeID, routes[routeID]); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // Handler 路由处理
 func (router *router) Handler(message Message) (Message, error) {
-	routerID := RouterID(message.ModuleID(), message.ActionID())
+	return router.HandlerContext(context.Background(), message)
+}
 
+// HandlerContext 路由处理，ctx 传递给使用 AddContextRoute 注册的处理函数
+func (router *router) HandlerContext(ctx context.Context, message Message) (Message, error) {
 	// 已注册的路由中进行数据处理
-	handler, ok := router.routes[routerID]
+	handler, ok := router.routes[MessageRouteID(message)]
 	if ok {
-		return handler(message)
+		return handler(ctx, message)
 	}
 
 	// 尚未注册的路由进行额外处理
@@ -0,0 +1,97 @@
+package network_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+const (
+	moduleShop = 3
+	actionBuy  = 7
+
+	routeShopBuy zeronetwork.RouteID = moduleShop<<8 | actionBuy
+)
+
+func TestAddRoute(t *testing.T) {
+	if routeShopBuy != zeronetwork.NewRouteID(moduleShop, actionBuy) || routeShopBuy.Module() != moduleShop || routeShopBuy.Action() != actionBuy {
+		t.Fatalf("unexpected route id: %d", routeShopBuy)
+	}
+
+	router := zeronetwork.NewRouter()
+	if err := router.AddRoute(routeShopBuy, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 8, nil), nil
+	}); err != nil {
+		t.Fatalf("AddRoute failed: %s", err.Error())
+	}
+	if err := router.AddRouter(moduleShop, actionBuy, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return nil, nil
+	}); err != zeronetwork.ErrRouterRepeated {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	message := zerodatapack.NewLTDRouteMessage(0, 1, 0, routeShopBuy, nil)
+	response, err := router.Handler(message)
+	if err != nil || response == nil || response.ActionID() != 8 {
+		t.Fatalf("unexpected response: %v, err: %v", response, err)
+	}
+
+	// module 超过 15 时不会与其它路由冲突
+	if _, err := router.Handler(zerodatapack.NewLTDMessage(0, 1, 0, moduleShop+16, actionBuy, nil)); err != zeronetwork.ErrHandlerNotFound {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// 与消息体中 module、action 两个字节一致
+	datapack := zerodatapack.NewLTD(false, 0, nil, false, false, zerologger.NewSampleLogger())
+	p, err := datapack.Pack(message, nil, nil)
+	if err != nil {
+		t.Fatalf("pack failed: %s", err.Error())
+	}
+	// 消息头 + 错误码之后是 module 与 action
+	index := datapack.HeadLen() + 2
+	if zeronetwork.RouteID(binary.BigEndian.Uint16(p[index:])) != routeShopBuy {
+		t.Fatalf("unexpected route id on the wire: %d", binary.BigEndian.Uint16(p[index:]))
+	}
+}
+
+func TestAddRouters(t *testing.T) {
+	handler := func(message zeronetwork.Message) (zeronetwork.Message, error) {
+		return zerodatapack.Respond(message, 0, nil), nil
+	}
+
+	router := zeronetwork.NewRouter()
+	if err := router.AddRouters(map[zeronetwork.RouteID]zeronetwork.HandlerFunc{
+		routeShopBuy:                 handler,
+		zeronetwork.NewRouteID(3, 8): handler,
+	}); err != nil {
+		t.Fatalf("AddRouters failed: %s", err.Error())
+	}
+	for _, action := range []uint8{actionBuy, 8} {
+		if _, err := router.Handler(zerodatapack.NewLTDMessage(0, 1, 0, moduleShop, action, nil)); err != nil {
+			t.Fatalf("route %d not registered: %s", action, err.Error())
+		}
+	}
+
+	// 有一个路由冲突时，其它路由也不会添加
+	err := router.AddRouters(map[zeronetwork.RouteID]zeronetwork.HandlerFunc{
+		zeronetwork.NewRouteID(4, 1): handler,
+		routeShopBuy:                 handler,
+		zeronetwork.NewRouteID(4, 2): handler,
+	})
+	if !errors.Is(err, zeronetwork.ErrRouterRepeated) {
+		t.Fatalf("expected ErrRouterRepeated, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "module: 3, action: 7") {
+		t.Fatalf("error does not identify the conflicting route: %s", err.Error())
+	}
+	for _, action := range []uint8{1, 2} {
+		if _, err := router.Handler(zerodatapack.NewLTDMessage(0, 1, 0, 4, action, nil)); err != zeronetwork.ErrHandlerNotFound {
+			t.Fatalf("route %d should not be registered, err: %v", action, err)
+		}
+	}
+}
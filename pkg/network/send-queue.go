@@ -0,0 +1,249 @@
+package network
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSendQueueFull 发送队列已满，按照 Config.SendOverflowPolicy 丢弃了消息
+var ErrSendQueueFull = errors.New("send queue full")
+
+// sendQueueTimeout SendOverflowBlock 时等待队列空间的时间
+const sendQueueTimeout = 3 * time.Second
+
+// SendQueue 每个会话的发送队列，使用 NewSendQueue 创建
+// 消息优先放入容量为 Config.SendQueueSize 的通道 C，由 sendLoop 读取
+// Config.SendQueueMaxSize 大于 SendQueueSize 时，通道已满之后的消息按顺序放入溢出缓冲区，缓冲区按需翻倍增长，清空之后释放
+// sendLoop 每取出一个消息都需要调用 Refill，将溢出缓冲区中的消息移入通道
+type SendQueue struct {
+	// C sendLoop 从该通道读取消息，Close 时关闭
+	C chan interface{}
+
+	// max 通道与溢出缓冲区中的消息总数上限
+	max int
+
+	// policy 队列已满时的处理策略
+	policy SendOverflowPolicy
+
+	// mutex 保护溢出缓冲区，以及溢出缓冲区不为空时放入通道的过程，保证消息的顺序
+	mutex sync.Mutex
+
+	// overflow 溢出缓冲区，环形，head 为最早放入的消息
+	overflow []interface{}
+	head     int
+
+	// size 溢出缓冲区中的消息数量，修改时持有 mutex
+	size int32
+
+	// closed 通道已经关闭，Refill 不再放入
+	closed bool
+
+	// drained 有消息取出时通知等待者
+	drained chan struct{}
+}
+
+// NewSendQueue 根据 Config.SendQueueSize、SendQueueMaxSize 与 SendOverflowPolicy 创建发送队列
+func NewSendQueue(config *Config) *SendQueue {
+	max := config.SendQueueMaxSize
+	if max < config.SendQueueSize {
+		max = config.SendQueueSize
+	}
+
+	return &SendQueue{
+		C:       make(chan interface{}, config.SendQueueSize),
+		max:     max,
+		policy:  config.SendOverflowPolicy,
+		drained: make(chan struct{}, 1),
+	}
+}
+
+// Put 放入消息，队列已满时按照 Config.SendOverflowPolicy 处理
+// SendOverflowBlock 最多等待 3 秒，超时返回 ErrWriteTimeout；SendOverflowDrop 与 SendOverflowClose 返回 ErrSendQueueFull，关闭连接由调用方完成
+func (q *SendQueue) Put(element interface{}) error {
+	if q.TryPut(element) {
+		return nil
+	}
+
+	if q.policy != SendOverflowBlock {
+		return ErrSendQueueFull
+	}
+
+	return q.PutWait(element, sendQueueTimeout, nil)
+}
+
+// PutWait 放入消息，队列已满时等待，不受 Config.SendOverflowPolicy 影响，用于不能丢弃的消息，比如切换封包工具
+// 超过 timeout 返回 ErrWriteTimeout，timeout <= 0 时与 SendOverflowBlock 相同，最多等待 3 秒
+// closeCh 关闭时不再等待，返回 ErrStopSend，为 nil 时只受 timeout 限制
+func (q *SendQueue) PutWait(element interface{}, timeout time.Duration, closeCh <-chan bool) error {
+	if timeout <= 0 {
+		timeout = sendQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for !q.TryPut(element) {
+		select {
+		case <-q.drained:
+		case <-timer.C:
+			return ErrWriteTimeout
+		case <-closeCh:
+			return ErrStopSend
+		}
+	}
+
+	return nil
+}
+
+// TryPut 尝试放入消息，不会等待，队列已满时返回 false
+func (q *SendQueue) TryPut(element interface{}) bool {
+	// 不会增长的队列只使用通道
+	if q.max <= cap(q.C) {
+		select {
+		case q.C <- element:
+			return true
+		default:
+			return false
+		}
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// 溢出缓冲区中还有消息时，需要排在它们之后
+	if q.size == 0 {
+		select {
+		case q.C <- element:
+			return true
+		default:
+		}
+	}
+
+	if len(q.C)+int(q.size) >= q.max {
+		return false
+	}
+
+	q.push(element)
+	return true
+}
+
+// push 放入溢出缓冲区，已满时翻倍增长，不超过上限，调用时持有 mutex
+func (q *SendQueue) push(element interface{}) {
+	n := int(q.size)
+	if n == len(q.overflow) {
+		capacity := 2 * len(q.overflow)
+		if capacity == 0 {
+			capacity = cap(q.C)
+		}
+		if limit := q.max - cap(q.C); capacity > limit {
+			capacity = limit
+		}
+
+		overflow := make([]interface{}, capacity)
+		for i := 0; i < n; i++ {
+			overflow[i] = q.overflow[(q.head+i)%len(q.overflow)]
+		}
+		q.overflow = overflow
+		q.head = 0
+	}
+
+	q.overflow[(q.head+n)%len(q.overflow)] = element
+	atomic.AddInt32(&q.size, 1)
+}
+
+// Refill sendLoop 每取出一个消息之后调用，将溢出缓冲区中的消息按顺序移入通道，并唤醒等待空间的 Put
+func (q *SendQueue) Refill() {
+	if atomic.LoadInt32(&q.size) > 0 {
+		q.mutex.Lock()
+		q.refill()
+		q.mutex.Unlock()
+	}
+
+	select {
+	case q.drained <- struct{}{}:
+	default:
+	}
+}
+
+// refill 见 Refill，调用时持有 mutex
+func (q *SendQueue) refill() {
+	for q.size > 0 && !q.closed {
+		select {
+		case q.C <- q.overflow[q.head]:
+		default:
+			return
+		}
+
+		q.overflow[q.head] = nil
+		q.head = (q.head + 1) % len(q.overflow)
+		if atomic.AddInt32(&q.size, -1) == 0 {
+			// 突发流量已经平滑，释放溢出缓冲区
+			q.overflow = nil
+			q.head = 0
+		}
+	}
+}
+
+// Len 通道与溢出缓冲区中的消息总数
+func (q *SendQueue) Len() int {
+	return len(q.C) + int(atomic.LoadInt32(&q.size))
+}
+
+// Cap 通道的容量，即 Config.SendQueueSize
+func (q *SendQueue) Cap() int {
+	return cap(q.C)
+}
+
+// Max 消息总数的上限，即 Config.SendQueueSize 与 Config.SendQueueMaxSize 中较大的一个
+func (q *SendQueue) Max() int {
+	return q.max
+}
+
+// Drain 按照放入的顺序取出通道与溢出缓冲区中的所有消息，用于 sendLoop 已经退出时接管剩余的消息
+func (q *SendQueue) Drain() []interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.drain()
+}
+
+// drain 见 Drain，调用时持有 mutex
+func (q *SendQueue) drain() []interface{} {
+	elements := make([]interface{}, 0, q.Len())
+	for element, ok := q.poll(); ok; element, ok = q.poll() {
+		elements = append(elements, element)
+	}
+
+	for ; q.size > 0; atomic.AddInt32(&q.size, -1) {
+		elements = append(elements, q.overflow[q.head])
+		q.overflow[q.head] = nil
+		q.head = (q.head + 1) % len(q.overflow)
+	}
+	q.overflow = nil
+	q.head = 0
+
+	return elements
+}
+
+// poll 不等待地从通道中取出一个消息，通道为空或者已经关闭时返回 false
+func (q *SendQueue) poll() (interface{}, bool) {
+	select {
+	case element, ok := <-q.C:
+		return element, ok
+	default:
+		return nil, false
+	}
+}
+
+// Close 关闭通道，之后不能再放入消息
+// 返回通道与溢出缓冲区中尚未取出的消息，由调用方释放，并响应其中的发送回调
+func (q *SendQueue) Close() []interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	close(q.C)
+
+	return q.drain()
+}
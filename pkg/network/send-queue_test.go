@@ -0,0 +1,183 @@
+package network_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+)
+
+func newSendQueue(size, maxSize int, policy zeronetwork.SendOverflowPolicy) *zeronetwork.SendQueue {
+	config := zeronetwork.DefaultConfig()
+	config.SendQueueSize = size
+	config.SendQueueMaxSize = maxSize
+	config.SendOverflowPolicy = policy
+	return zeronetwork.NewSendQueue(config)
+}
+
+// drainSendQueue 模拟 sendLoop 取出 n 个消息
+func drainSendQueue(t *testing.T, q *zeronetwork.SendQueue, n int) []int {
+	values := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case value := <-q.C:
+			values = append(values, value.(int))
+			q.Refill()
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after %d messages", i)
+		}
+	}
+	return values
+}
+
+func TestSendQueueGrow(t *testing.T) {
+	q := newSendQueue(4, 64, zeronetwork.SendOverflowDrop)
+
+	// 突发的消息超过通道容量但是没有超过上限，不会丢弃
+	for i := 0; i < 50; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatalf("put %d failed: %s", i, err.Error())
+		}
+	}
+	if q.Len() != 50 || q.Cap() != 4 || q.Max() != 64 {
+		t.Fatalf("unexpected len: %d, cap: %d, max: %d", q.Len(), q.Cap(), q.Max())
+	}
+
+	// 取出的顺序与放入的顺序一致，溢出缓冲区中的消息排在通道中的消息之后
+	for i, value := range drainSendQueue(t, q, 30) {
+		if value != i {
+			t.Fatalf("unexpected value: %d, expected: %d", value, i)
+		}
+	}
+
+	// 溢出缓冲区不为空时，新的消息排在溢出缓冲区之后
+	for i := 50; i < 60; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatalf("put %d failed: %s", i, err.Error())
+		}
+	}
+	for i, value := range drainSendQueue(t, q, 30) {
+		if value != 30+i {
+			t.Fatalf("unexpected value: %d, expected: %d", value, 30+i)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("unexpected len: %d", q.Len())
+	}
+}
+
+func TestSendQueueDrain(t *testing.T) {
+	q := newSendQueue(4, 64, zeronetwork.SendOverflowDrop)
+	for i := 0; i < 10; i++ {
+		_ = q.Put(i)
+	}
+
+	// 通道中的消息在前，溢出缓冲区中的消息在后
+	values := q.Drain()
+	if len(values) != 10 || q.Len() != 0 {
+		t.Fatalf("unexpected drained: %v, len: %d", values, q.Len())
+	}
+	for i, value := range values {
+		if value.(int) != i {
+			t.Fatalf("unexpected value: %v, expected: %d", value, i)
+		}
+	}
+
+	// 取出之后仍然可以放入
+	if err := q.Put(10); err != nil || q.Len() != 1 {
+		t.Fatalf("put after drain failed: %v, len: %d", err, q.Len())
+	}
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	for _, policy := range []zeronetwork.SendOverflowPolicy{zeronetwork.SendOverflowDrop, zeronetwork.SendOverflowClose} {
+		// 不增长的队列同样按照策略处理
+		for _, maxSize := range []int{0, 16} {
+			q := newSendQueue(4, maxSize, policy)
+			for i := 0; i < q.Max(); i++ {
+				if err := q.Put(i); err != nil {
+					t.Fatalf("put %d failed: %s", i, err.Error())
+				}
+			}
+
+			if err := q.Put(q.Max()); !errors.Is(err, zeronetwork.ErrSendQueueFull) {
+				t.Fatalf("policy: %d, max size: %d, expected ErrSendQueueFull, got: %v", policy, maxSize, err)
+			}
+			if q.TryPut(q.Max()) {
+				t.Fatalf("policy: %d, max size: %d, try put on full queue succeeded", policy, maxSize)
+			}
+			if q.Len() != q.Max() {
+				t.Fatalf("unexpected len: %d", q.Len())
+			}
+		}
+	}
+}
+
+func TestSendQueueBlock(t *testing.T) {
+	q := newSendQueue(2, 8, zeronetwork.SendOverflowBlock)
+	for i := 0; i < 8; i++ {
+		if err := q.Put(i); err != nil {
+			t.Fatalf("put %d failed: %s", i, err.Error())
+		}
+	}
+
+	// 队列已满时等待，取出一个消息之后放入
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Put(8)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("put on full queue returned: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	values := drainSendQueue(t, q, 1)
+	if err := <-done; err != nil {
+		t.Fatalf("put failed: %s", err.Error())
+	}
+	values = append(values, drainSendQueue(t, q, 8)...)
+	for i, value := range values {
+		if value != i {
+			t.Fatalf("unexpected value: %d, expected: %d", value, i)
+		}
+	}
+
+	// PutWait 超时返回 ErrWriteTimeout
+	for i := 0; i < 8; i++ {
+		_ = q.Put(i)
+	}
+	if err := q.PutWait(8, 20*time.Millisecond, nil); !errors.Is(err, zeronetwork.ErrWriteTimeout) {
+		t.Fatalf("expected ErrWriteTimeout, got: %v", err)
+	}
+
+	// closeCh 关闭时不再等待
+	closeCh := make(chan bool)
+	close(closeCh)
+	if err := q.PutWait(8, time.Minute, closeCh); !errors.Is(err, zeronetwork.ErrStopSend) {
+		t.Fatalf("expected ErrStopSend, got: %v", err)
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := newSendQueue(4, 64, zeronetwork.SendOverflowDrop)
+	for i := 0; i < 10; i++ {
+		_ = q.Put(i)
+	}
+
+	// 关闭时返回通道与溢出缓冲区中剩余的消息，不会丢弃
+	values := q.Close()
+	if len(values) != 10 {
+		t.Fatalf("unexpected remaining: %v", values)
+	}
+	for i, value := range values {
+		if value.(int) != i {
+			t.Fatalf("unexpected value: %v, expected: %d", value, i)
+		}
+	}
+	if _, ok := <-q.C; ok {
+		t.Fatal("expected channel closed")
+	}
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"fmt"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+)
+
+// sessionLogger 为每一行日志加上会话 ID 与远端地址，见 NewSessionLogger
+type sessionLogger struct {
+	zerologger.Logger
+
+	// sessionID 返回当前的会话 ID，会话恢复之后 ID 会变化，所以每次输出时读取
+	sessionID func() SessionID
+
+	// remoteAddress 返回远端地址，客户端会话在连接建立之后才有地址，所以同样每次输出时读取，为空时不输出
+	remoteAddress func() string
+}
+
+// NewSessionLogger 包装 logger，输出的每一行日志以 "session: <id>, address: <addr>, " 开头
+// 会话创建时调用一次，会话内的日志不再需要自行格式化会话 ID
+func NewSessionLogger(logger zerologger.Logger, sessionID func() SessionID, remoteAddress func() string) zerologger.Logger {
+	return &sessionLogger{Logger: logger, sessionID: sessionID, remoteAddress: remoteAddress}
+}
+
+// prefix 日志前缀，只在日志会被输出时计算
+func (l *sessionLogger) prefix() string {
+	address := l.remoteAddress()
+	if address == "" {
+		return fmt.Sprintf("session: %d, ", l.sessionID())
+	}
+
+	return fmt.Sprintf("session: %d, address: %s, ", l.sessionID(), address)
+}
+
+// Debug ..
+func (l *sessionLogger) Debug(v ...interface{}) {
+	if !l.IsDebugAble() {
+		return
+	}
+	l.Logger.Debug(l.prefix() + fmt.Sprint(v...))
+}
+
+// Debugf ..
+func (l *sessionLogger) Debugf(format string, v ...interface{}) {
+	if !l.IsDebugAble() {
+		return
+	}
+	l.Logger.Debug(l.prefix() + fmt.Sprintf(format, v...))
+}
+
+// Info ..
+func (l *sessionLogger) Info(v ...interface{}) {
+	if !l.IsInfoAble() {
+		return
+	}
+	l.Logger.Info(l.prefix() + fmt.Sprint(v...))
+}
+
+// Infof ..
+func (l *sessionLogger) Infof(format string, v ...interface{}) {
+	if !l.IsInfoAble() {
+		return
+	}
+	l.Logger.Info(l.prefix() + fmt.Sprintf(format, v...))
+}
+
+// Warn ..
+func (l *sessionLogger) Warn(v ...interface{}) {
+	if !l.IsWarnAble() {
+		return
+	}
+	l.Logger.Warn(l.prefix() + fmt.Sprint(v...))
+}
+
+// Warnf ..
+func (l *sessionLogger) Warnf(format string, v ...interface{}) {
+	if !l.IsWarnAble() {
+		return
+	}
+	l.Logger.Warn(l.prefix() + fmt.Sprintf(format, v...))
+}
+
+// Error ..
+func (l *sessionLogger) Error(v ...interface{}) {
+	l.Logger.Error(l.prefix() + fmt.Sprint(v...))
+}
+
+// Errorf ..
+func (l *sessionLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Error(l.prefix() + fmt.Sprintf(format, v...))
+}
+
+// Fatal ..
+func (l *sessionLogger) Fatal(v ...interface{}) {
+	l.Logger.Fatal(l.prefix() + fmt.Sprint(v...))
+}
+
+// Fatalf ..
+func (l *sessionLogger) Fatalf(format string, v ...interface{}) {
+	l.Logger.Fatal(l.prefix() + fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,43 @@
+package network_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zeronetworklogger "github.com/zerogo-hub/zero-node/pkg/network/logger"
+)
+
+func TestSessionLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	base := zeronetworklogger.NewSlog(slog.New(slog.NewTextHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	sessionID := zeronetwork.SessionID(1)
+	address := ""
+	logger := zeronetwork.NewSessionLogger(base, func() zeronetwork.SessionID { return sessionID }, func() string { return address })
+
+	// 没有远端地址时只输出会话 ID
+	logger.Infof("read failed: %s", "EOF")
+	if !strings.Contains(buffer.String(), `msg="session: 1, read failed: EOF"`) {
+		t.Fatalf("unexpected log: %s", buffer.String())
+	}
+
+	// 会话 ID 与地址在输出时读取，会话恢复之后使用新的 ID
+	buffer.Reset()
+	sessionID, address = 2, "127.0.0.1:1234"
+	logger.Error("closed")
+	if !strings.Contains(buffer.String(), `msg="session: 2, address: 127.0.0.1:1234, closed"`) {
+		t.Fatalf("unexpected log: %s", buffer.String())
+	}
+
+	// 级别被关闭时不输出
+	buffer.Reset()
+	base.SetLevel(zerologger.INFO)
+	logger.Debugf("dropped: %d", 1)
+	if buffer.Len() != 0 {
+		t.Fatalf("unexpected log: %s", buffer.String())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -16,6 +17,12 @@ type sessionManager struct {
 	// sessions 存储所有连接
 	sessions sync.Map
 
+	// count sessions 中的会话数量，随 sessions 的增删原子更新，Len 不需要遍历
+	count int64
+
+	// parked 断线后等待恢复的会话 ID -> 缓存发给该会话的消息，见 Park
+	parked sync.Map
+
 	// genSessionID 用于生成会话 ID
 	genSessionID SessionID
 }
@@ -32,16 +39,53 @@ func (s *sessionManager) GenSessionID() SessionID {
 
 // Add 添加 Session
 func (s *sessionManager) Add(session Session) {
-	s.sessions.Store(session.ID(), session)
+	s.store(session)
 }
 
-// Del 移除 Session
+// Del 移除 Session 并关闭，返回时收发循环可能尚未退出，需要确认时等待 Session.Closed
 func (s *sessionManager) Del(sessionID SessionID) {
-	session, ok := s.sessions.LoadAndDelete(sessionID)
+	session, ok := s.delete(sessionID)
 	if !ok {
 		return
 	}
-	session.(Session).Close()
+	session.Close()
+}
+
+// Rebind 恢复会话后，session 使用了新的 ID，将其从 oldSessionID 迁移过去，不会关闭会话
+func (s *sessionManager) Rebind(oldSessionID SessionID, session Session) {
+	s.delete(oldSessionID)
+	s.store(session)
+	s.parked.Delete(session.ID())
+}
+
+// Park 会话断线后保留状态期间，Send 发给 sessionID 的消息存入 buffer，同时清理已过期的缓存
+func (s *sessionManager) Park(sessionID SessionID, buffer *ResumeBuffer) {
+	s.parked.Range(func(key any, value any) bool {
+		if value.(*ResumeBuffer).Expired() {
+			s.parked.Delete(key)
+		}
+		return true
+	})
+
+	s.parked.Store(sessionID, buffer)
+}
+
+// store 存储会话，ID 已经存在时替换，不重复计数
+func (s *sessionManager) store(session Session) {
+	if _, loaded := s.sessions.Swap(session.ID(), session); !loaded {
+		atomic.AddInt64(&s.count, 1)
+	}
+}
+
+// delete 移除会话，返回被移除的会话
+func (s *sessionManager) delete(sessionID SessionID) (Session, bool) {
+	session, ok := s.sessions.LoadAndDelete(sessionID)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt64(&s.count, -1)
+
+	return session.(Session), true
 }
 
 // Get(sessionID SessionID) (Session, error)
@@ -54,39 +98,100 @@ func (s *sessionManager) Get(sessionID SessionID) (Session, error) {
 	return session.(Session), nil
 }
 
-// Len 获取当前 Session 数量
+// Len 获取当前 Session 数量，O(1)
 func (s *sessionManager) Len() int {
-	total := 0
+	return int(atomic.LoadInt64(&s.count))
+}
+
+// Range 只读遍历所有 Session，f 返回 false 时停止遍历
+func (s *sessionManager) Range(f func(session Session) bool) {
 	s.sessions.Range(func(key any, value any) bool {
-		total++
-		return true
+		return f(value.(Session))
 	})
-
-	return total
 }
 
 // Close 当前所有连接停止接收客户端消息，不再接收服务端消息，当已接收的服务端消息发送完毕后，断开连接
-// timeout 超时时间，如果超时仍未发送完已接收的服务端消息，也强行关闭连接
-func (s *sessionManager) Close() {
+// timeout 超时时间，如果超时仍未发送完已接收的服务端消息，也强行关闭连接，<= 0 表示一直等待
+// 返回被强行关闭的连接数量
+func (s *sessionManager) Close(timeout time.Duration) int {
+	sessions := []Session{}
 	s.sessions.Range(func(key any, value any) bool {
-		value.(Session).Close()
+		// 只关闭由这里移除的会话，同时被 Del 移除的会话由 Del 关闭
+		if session, ok := s.delete(key.(SessionID)); ok {
+			sessions = append(sessions, session)
+		}
 		return true
 	})
 
-	// 清空
-	s.sessions = sync.Map{}
+	// 同时关闭所有连接，避免一个连接阻塞其它连接的关闭
+	closed := make([]int32, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func(i int, session Session) {
+			defer wg.Done()
+			session.Close()
+			atomic.StoreInt32(&closed[i], 1)
+		}(i, session)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if timeout <= 0 {
+		<-done
+		return 0
+	}
+
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+	}
+
+	// 强行关闭套接字，阻塞中的写入会立即失败
+	forced := 0
+	for i, session := range sessions {
+		if atomic.LoadInt32(&closed[i]) == 1 {
+			continue
+		}
+
+		if conn := session.Conn(); conn != nil {
+			_ = conn.Close()
+		}
+		forced++
+	}
+
+	return forced
 }
 
-// Send 发送消息给客户端
+// Send 发送消息给客户端，会话断线后等待恢复期间缓存该消息，见 Park
 func (s *sessionManager) Send(sessionID SessionID, message Message) error {
 	session, err := s.Get(sessionID)
 	if err != nil {
+		if value, ok := s.parked.Load(sessionID); ok {
+			return s.pushParked(sessionID, value.(*ResumeBuffer), message)
+		}
 		return err
 	}
 
 	return session.Send(message)
 }
 
+// pushParked 缓存发给断线会话的消息，缓存过期时移除并返回 ErrSessionNotFound
+func (s *sessionManager) pushParked(sessionID SessionID, buffer *ResumeBuffer, message Message) error {
+	err := buffer.Push(message)
+	if errors.Is(err, ErrResumeTokenInvalid) {
+		s.parked.CompareAndDelete(sessionID, buffer)
+		return ErrSessionNotFound
+	}
+
+	return err
+}
+
 // SendCallback  发送消息个客户端，发送之后进行回调
 func (s *sessionManager) SendCallback(sessionID SessionID, message Message, callback SendCallbackFunc) error {
 	session, err := s.Get(sessionID)
@@ -97,11 +202,136 @@ func (s *sessionManager) SendCallback(sessionID SessionID, message Message, call
 	return session.SendCallback(message, callback)
 }
 
-// SendAll 给所有客户端发送消息
+// SendResult 发送消息给客户端，写入套接字之后进行回调，写入失败时回调中携带错误
+func (s *sessionManager) SendResult(sessionID SessionID, message Message, callback SendResultFunc) error {
+	session, err := s.Get(sessionID)
+	if err != nil {
+		return err
+	}
+
+	return session.SendResult(message, callback)
+}
+
+// SendReliable 发送需要客户端确认的消息，见 Session.SendReliable
+func (s *sessionManager) SendReliable(sessionID SessionID, message Message, callback SendResultFunc) error {
+	session, err := s.Get(sessionID)
+	if err != nil {
+		return err
+	}
+
+	return session.SendReliable(message, callback)
+}
+
+// Kick 发送 message 通知客户端之后将其踢下线，阻塞直到会话关闭
+func (s *sessionManager) Kick(sessionID SessionID, message Message) error {
+	session, err := s.Get(sessionID)
+	if err != nil {
+		return err
+	}
+
+	return session.SendAndClose(message, CloseReasonKicked)
+}
+
+// Request 发送请求给客户端并等待响应，见 Session.Request
+func (s *sessionManager) Request(sessionID SessionID, message Message, timeout time.Duration) (Message, error) {
+	session, err := s.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.Request(message, timeout)
+}
+
+// SendAll 给所有客户端发送消息，开启 Config.BroadcastReadyOnly 时跳过尚未完成秘钥协商的会话
 // TODO 优化，利用多核发送消息，当前是遍历发送
 func (s *sessionManager) SendAll(message Message) {
 	s.sessions.Range(func(key any, value any) bool {
-		_ = value.(Session).Send(message)
+		session := value.(Session)
+		if !skipBroadcast(session) {
+			_ = session.Send(message)
+		}
+		return true
+	})
+}
+
+// skipBroadcast 开启 Config.BroadcastReadyOnly 时，广播是否跳过该会话
+func skipBroadcast(session Session) bool {
+	return session.Config().BroadcastReadyOnly && !session.Ready()
+}
+
+// sendManyWorkers SendMany 同时发送的 goroutine 数量上限
+const sendManyWorkers = 8
+
+// SendMany 给 ids 中的客户端发送消息，返回发送失败的 ID 及其错误，见 SessionManager.SendMany
+// 先一次性查找所有会话，再由多个 goroutine 并行放入各个会话的发送队列，一个会话的队列已满时不影响其它会话
+func (s *sessionManager) SendMany(ids []SessionID, message Message) map[SessionID]error {
+	defer message.Release()
+
+	var failures map[SessionID]error
+
+	sessions := make([]Session, 0, len(ids))
+	visited := make(map[SessionID]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		session, ok := s.sessions.Load(id)
+		if !ok {
+			if failures == nil {
+				failures = make(map[SessionID]error)
+			}
+			failures[id] = ErrSessionNotFound
+			continue
+		}
+		if skipBroadcast(session.(Session)) {
+			if failures == nil {
+				failures = make(map[SessionID]error)
+			}
+			failures[id] = ErrHandshakeNotReady
+			continue
+		}
+		sessions = append(sessions, session.(Session))
+	}
+
+	workers := sendManyWorkers
+	if len(sessions) < workers {
+		workers = len(sessions)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+
+			for i := w; i < len(sessions); i += workers {
+				clone := message.Clone()
+				if err := sessions[i].Send(clone); err != nil {
+					// 未放入发送队列的副本仍由这里持有
+					clone.Release()
+
+					mutex.Lock()
+					if failures == nil {
+						failures = make(map[SessionID]error)
+					}
+					failures[sessions[i].ID()] = err
+					mutex.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	return failures
+}
+
+// RedirectAll 通知所有客户端连接到新的地址，通知发送完毕之后关闭连接
+func (s *sessionManager) RedirectAll(host string, port int) {
+	s.sessions.Range(func(key any, value any) bool {
+		_ = value.(Session).Redirect(host, port)
 		return true
 	})
 }
@@ -0,0 +1,242 @@
+package network_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+// idSession 只实现 ID 的会话，用于测试会话管理器
+type idSession struct {
+	zeronetwork.Session
+	id zeronetwork.SessionID
+}
+
+func (s *idSession) ID() zeronetwork.SessionID {
+	return s.id
+}
+
+func (s *idSession) Close() {}
+
+func TestSessionManagerRange(t *testing.T) {
+	manager := zeronetwork.NewSessionManager()
+	for i := 0; i < 10; i++ {
+		manager.Add(&idSession{id: manager.GenSessionID()})
+	}
+
+	seen := map[zeronetwork.SessionID]bool{}
+	manager.Range(func(session zeronetwork.Session) bool {
+		seen[session.ID()] = true
+		return true
+	})
+	if len(seen) != 10 {
+		t.Fatalf("expected 10 sessions, got %d", len(seen))
+	}
+
+	count := 0
+	manager.Range(func(session zeronetwork.Session) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Fatalf("expected traversal to stop after 3 sessions, got %d", count)
+	}
+
+	// 遍历过程中可以移除会话
+	manager.Range(func(session zeronetwork.Session) bool {
+		manager.Del(session.ID())
+		return true
+	})
+	if manager.Len() != 0 {
+		t.Fatalf("expected all sessions removed, got %d", manager.Len())
+	}
+}
+
+func TestSessionManagerLen(t *testing.T) {
+	manager := zeronetwork.NewSessionManager()
+
+	// 并发添加、重复添加、移除与迁移同一批 ID，计数始终与实际存储的会话一致
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				id := zeronetwork.SessionID((worker*7+i)%64 + 1)
+				switch i % 4 {
+				case 0, 1:
+					manager.Add(&idSession{id: id})
+				case 2:
+					manager.Del(id)
+				case 3:
+					manager.Rebind(id, &idSession{id: id%64 + 1})
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	count := 0
+	manager.Range(func(zeronetwork.Session) bool {
+		count++
+		return true
+	})
+	if manager.Len() != count {
+		t.Fatalf("Len %d does not match stored sessions %d", manager.Len(), count)
+	}
+
+	manager.Close(0)
+	if manager.Len() != 0 {
+		t.Fatalf("expected no sessions after close, got %d", manager.Len())
+	}
+}
+
+// sendSession 记录收到的消息的会话，closed 时发送失败
+type sendSession struct {
+	idSession
+
+	mutex    sync.Mutex
+	messages []zeronetwork.Message
+	closed   bool
+
+	// config 为 nil 时使用默认配置
+	config *zeronetwork.Config
+
+	// notReady 尚未完成秘钥协商
+	notReady bool
+}
+
+func (s *sendSession) Config() *zeronetwork.Config {
+	if s.config == nil {
+		return zeronetwork.DefaultConfig()
+	}
+	return s.config
+}
+
+func (s *sendSession) Ready() bool {
+	return !s.notReady
+}
+
+func (s *sendSession) Send(message zeronetwork.Message) error {
+	if s.closed {
+		return zeronetwork.ErrStopSend
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// cloneCounter 复制出的消息记录 Release 的调用次数
+type cloneCounter struct {
+	zeronetwork.Message
+	released *int32
+}
+
+func (m *cloneCounter) Clone() zeronetwork.Message {
+	return &releaseCounter{Message: m.Message.Clone(), released: m.released}
+}
+
+func TestSessionManagerSendMany(t *testing.T) {
+	manager := zeronetwork.NewSessionManager()
+
+	sessions := map[zeronetwork.SessionID]*sendSession{}
+	for i := 0; i < 20; i++ {
+		session := &sendSession{idSession: idSession{id: manager.GenSessionID()}}
+		sessions[session.id] = session
+		manager.Add(session)
+	}
+	sessions[3].closed = true
+
+	// 2 重复出现，100 与 101 不存在
+	ids := []zeronetwork.SessionID{1, 2, 2, 3, 100, 5, 101}
+	for id := zeronetwork.SessionID(6); id <= 20; id++ {
+		ids = append(ids, id)
+	}
+
+	var released int32
+	failures := manager.SendMany(ids, &cloneCounter{Message: zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("many")), released: &released})
+	if len(failures) != 3 {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+	for _, id := range []zeronetwork.SessionID{100, 101} {
+		if !errors.Is(failures[id], zeronetwork.ErrSessionNotFound) {
+			t.Errorf("unexpected failure of %d: %v", id, failures[id])
+		}
+	}
+	if !errors.Is(failures[3], zeronetwork.ErrStopSend) {
+		t.Errorf("unexpected failure of 3: %v", failures[3])
+	}
+
+	// 发送失败的副本被释放
+	if n := atomic.LoadInt32(&released); n != 1 {
+		t.Errorf("unexpected released: %d", n)
+	}
+
+	// 每个会话收到一个独立的副本
+	seen := map[zeronetwork.Message]bool{}
+	for id, session := range sessions {
+		expected := 1
+		if id == 3 || id == 4 {
+			expected = 0
+		}
+		if len(session.messages) != expected {
+			t.Fatalf("session %d received %d messages", id, len(session.messages))
+		}
+		for _, message := range session.messages {
+			if string(message.Payload()) != "many" || seen[message] {
+				t.Fatalf("session %d received unexpected message: %s", id, message.String())
+			}
+			seen[message] = true
+		}
+	}
+
+	if failures := manager.SendMany([]zeronetwork.SessionID{1, 2}, zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, nil)); failures != nil {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+}
+
+func TestSessionManagerBroadcastReadyOnly(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.WhetherCrypto = true
+	config.BroadcastReadyOnly = true
+
+	// 会话 2 尚未完成秘钥协商
+	manager := zeronetwork.NewSessionManager()
+	sessions := map[zeronetwork.SessionID]*sendSession{}
+	for i := 0; i < 3; i++ {
+		session := &sendSession{idSession: idSession{id: manager.GenSessionID()}, config: config}
+		sessions[session.id] = session
+		manager.Add(session)
+	}
+	sessions[2].notReady = true
+
+	manager.SendAll(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("all")))
+
+	failures := manager.SendMany([]zeronetwork.SessionID{1, 2, 3}, zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("many")))
+	if len(failures) != 1 || !errors.Is(failures[2], zeronetwork.ErrHandshakeNotReady) {
+		t.Fatalf("unexpected failures: %v", failures)
+	}
+
+	for id, session := range sessions {
+		expected := 2
+		if id == 2 {
+			expected = 0
+		}
+		if len(session.messages) != expected {
+			t.Fatalf("session %d received %d messages", id, len(session.messages))
+		}
+	}
+
+	// 协商完成之后可以收到广播
+	sessions[2].notReady = false
+	manager.SendAll(zerodatapack.NewLTDMessage(0, 0, 0, 1, 1, []byte("all")))
+	if len(sessions[2].messages) != 1 {
+		t.Fatalf("ready session received %d messages", len(sessions[2].messages))
+	}
+}
@@ -0,0 +1,86 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+)
+
+// StatsReporter 按照 Config.StatsInterval 周期性地输出服务的统计日志
+type StatsReporter struct {
+	// done 通知输出协程退出
+	done chan struct{}
+
+	// stopOnce 防止多次关闭
+	stopOnce sync.Once
+
+	// wg 等待输出协程退出
+	wg sync.WaitGroup
+}
+
+// NewStatsReporter 每隔 interval 调用 stats，并使用 logger 输出一行统计日志
+// 速率为两次输出之间的平均值，interval <= 0 时返回 nil
+func NewStatsReporter(logger zerologger.Logger, interval time.Duration, stats func() PeerStats) *StatsReporter {
+	if interval <= 0 {
+		return nil
+	}
+
+	r := &StatsReporter{done: make(chan struct{})}
+
+	r.wg.Add(1)
+	go r.run(logger, interval, stats)
+
+	return r
+}
+
+// Stop 停止输出，等待输出协程退出，可以在 nil 上调用
+func (r *StatsReporter) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.stopOnce.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+// run 输出协程
+func (r *StatsReporter) run(logger zerologger.Logger, interval time.Duration, stats func() PeerStats) {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stats()
+	lastTime := time.Now()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			current := stats()
+			seconds := now.Sub(lastTime).Seconds()
+			rate := func(current, last uint64) float64 {
+				return float64(current-last) / seconds
+			}
+
+			logger.Infof("stats, sessions: %d, accepted: %.1f/s, rejected: %.1f/s, bytes in: %.0f/s, bytes out: %.0f/s, messages: %.1f/s, zero messages: %.1f/s, zero limited: %.1f/s, send queue: %d, recv queue: %d",
+				current.Sessions,
+				rate(current.Accepted, last.Accepted),
+				rate(current.Rejected, last.Rejected),
+				rate(current.BytesIn, last.BytesIn),
+				rate(current.BytesOut, last.BytesOut),
+				rate(current.Messages, last.Messages),
+				rate(current.ZeroMessages, last.ZeroMessages),
+				rate(current.ZeroLimited, last.ZeroLimited),
+				current.SendLen,
+				current.RecvLen,
+			)
+
+			last, lastTime = current, now
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package testutil
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	zerologger "github.com/zerogo-hub/zero-helper/logger"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zerokcp "github.com/zerogo-hub/zero-node/pkg/network/peer/kcp"
+	zerotcp "github.com/zerogo-hub/zero-node/pkg/network/peer/tcp"
+	zerows "github.com/zerogo-hub/zero-node/pkg/network/peer/ws"
+)
+
+var (
+	// ErrUnknownPeerType 不支持的服务类型
+	ErrUnknownPeerType = errors.New("unknown peer type")
+
+	// ErrStartTimeout 服务启动之后，超过 startTimeout 仍未开始监听
+	ErrStartTimeout = errors.New("server start timeout")
+)
+
+// PeerType 服务类型
+type PeerType string
+
+const (
+	// TCP 见 pkg/network/peer/tcp
+	TCP PeerType = "tcp"
+
+	// WS 见 pkg/network/peer/ws，使用二进制消息，不使用 TLS
+	WS PeerType = "ws"
+
+	// KCP 见 pkg/network/peer/kcp
+	KCP PeerType = "kcp"
+)
+
+// startTimeout 等待服务开始监听的时间
+const startTimeout = 2 * time.Second
+
+// leakTimeout 等待 goroutine 退出的时间，会话关闭时需要等待发送队列清空与连接关闭
+const leakTimeout = 5 * time.Second
+
+// EchoHandler 将请求的负载原样作为响应返回，响应的 module 与 action 与请求相同
+func EchoHandler(message zeronetwork.Message) (zeronetwork.Message, error) {
+	payload := append([]byte{}, message.Payload()...)
+	return zerodatapack.Respond(message, message.ActionID(), payload), nil
+}
+
+// StartEchoServer 在本地的空闲端口上启动服务，所有消息都由 EchoHandler 处理
+// 返回监听地址 host:port 与清理函数，清理函数用于关闭服务，ws 服务关闭之后 http 监听仍然保留，见 ws.server.Close
+// opts 在默认选项之后执行，可以覆盖默认选项，比如日志等级
+func StartEchoServer(peerType PeerType, opts ...zeronetwork.Option) (string, func(), error) {
+	var p zeronetwork.Peer
+	var port int
+	var err error
+
+	switch peerType {
+	case TCP:
+		p = zerotcp.NewServer()
+		port, err = freeTCPPort()
+	case WS:
+		p = zerows.NewServer(websocket.BinaryMessage, "", "")
+		port, err = freeTCPPort()
+	case KCP:
+		p = zerokcp.NewServer()
+		port, err = freeUDPPort()
+		// accept 得到的连接共用监听套接字，无法单独设置缓冲区
+		opts = append([]zeronetwork.Option{zeronetwork.WithBufferFailPolicy(zeronetwork.BufferFailIgnore)}, opts...)
+	default:
+		return "", nil, ErrUnknownPeerType
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	opts = append([]zeronetwork.Option{
+		zeronetwork.WithLoggerLevel(zerologger.INFO),
+		zeronetwork.WithHost("127.0.0.1"),
+		zeronetwork.WithPort(port),
+	}, opts...)
+	p.WithOption(opts...)
+	p.Router().SetHandlerFunc(EchoHandler)
+
+	if err := p.Start(); err != nil {
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		_ = p.Close()
+	}
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	// Start 不会等待监听完成，ws 客户端连接失败时会直接退出进程，需要等待监听完成
+	// tcp 客户端连接失败时在 Dial 中重试，kcp 基于 udp，客户端的数据包会重传，都不需要等待
+	if peerType == WS {
+		if err := waitListen(address); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	}
+
+	return address, cleanup, nil
+}
+
+// Dial 连接 StartEchoServer 启动的服务，连接成功之后开始收发消息
+// 收到的消息由 handler 处理，使用完毕之后需要调用 Close 关闭
+func Dial(peerType PeerType, address string, handler zeronetwork.HandlerFunc) (zeronetwork.Client, error) {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var c zeronetwork.Client
+	var network string
+
+	switch peerType {
+	case TCP:
+		c = zerotcp.NewClient(handler, zerotcp.WithClientLoggerLevel(zerologger.INFO))
+		network = "tcp4"
+	case WS:
+		c = zerows.NewClient(websocket.BinaryMessage, false, handler, zerows.WithClientLoggerLevel(zerologger.INFO))
+		network = "ws"
+	case KCP:
+		c = zerokcp.NewClient(handler, zerokcp.WithClientLoggerLevel(zerologger.INFO))
+		network = "udp"
+	default:
+		return nil, ErrUnknownPeerType
+	}
+
+	// tcp 服务可能尚未开始监听，连接失败时重试
+	deadline := time.Now().Add(startTimeout)
+	for {
+		err := c.Connect(network, host, port)
+		if err == nil {
+			break
+		}
+
+		if peerType != TCP || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	go c.Run()
+
+	return c, nil
+}
+
+// waitListen 等待服务开始监听，探测连接不会完成 websocket 握手，不会创建会话
+func waitListen(address string) error {
+	deadline := time.Now().Add(startTimeout)
+
+	for {
+		conn, err := net.DialTimeout("tcp", address, startTimeout)
+		if err == nil {
+			return conn.Close()
+		}
+
+		if time.Now().After(deadline) {
+			return ErrStartTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// freeTCPPort 获取一个本地空闲的 tcp 端口
+func freeTCPPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// freeUDPPort 获取一个本地空闲的 udp 端口
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// CheckGoroutines 记录当前的 goroutine 数量，返回的函数等待 goroutine 数量回落，超时之后报告泄漏并输出所有 goroutine 的调用栈
+// 用法: defer testutil.CheckGoroutines(t)()，服务等常驻的 goroutine 需要在调用之前启动
+func CheckGoroutines(t testing.TB) func() {
+	t.Helper()
+	base := runtime.NumGoroutine()
+
+	return func() {
+		t.Helper()
+
+		deadline := time.Now().Add(leakTimeout)
+		for {
+			n := runtime.NumGoroutine()
+			if n <= base {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				buffer := make([]byte, 1<<20)
+				buffer = buffer[:runtime.Stack(buffer, true)]
+				t.Errorf("goroutine leak, before: %d, after: %d\n%s", base, n, buffer)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package testutil_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	zerozlib "github.com/zerogo-hub/zero-helper/compress/zlib"
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	"github.com/zerogo-hub/zero-node/pkg/network/testutil"
+	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
+)
+
+func TestEcho(t *testing.T) {
+	for _, peerType := range []testutil.PeerType{testutil.TCP, testutil.WS, testutil.KCP} {
+		t.Run(string(peerType), func(t *testing.T) {
+			address, cleanup, err := testutil.StartEchoServer(peerType)
+			if err != nil {
+				t.Fatalf("start failed: %s", err.Error())
+			}
+			defer cleanup()
+
+			responses := make(chan zeronetwork.Message, 1)
+			c, err := testutil.Dial(peerType, address, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+				// 处理函数返回之后消息会被释放，复制一份交给测试
+				responses <- zerodatapack.CopyMessage(message, message.Flag())
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("dial failed: %s", err.Error())
+			}
+			defer c.Close()
+
+			for sn := uint16(1); sn <= 3; sn++ {
+				if err := c.Send(zerodatapack.NewLTDMessage(0, sn, 0, 3, 7, []byte(peerType))); err != nil {
+					t.Fatalf("send failed: %s", err.Error())
+				}
+
+				select {
+				case message := <-responses:
+					if message.SN() != sn || message.ModuleID() != 3 || message.ActionID() != 7 || string(message.Payload()) != string(peerType) {
+						t.Fatalf("unexpected response: %s", message.String())
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for response")
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownPeerType(t *testing.T) {
+	if _, _, err := testutil.StartEchoServer("udp"); err != testutil.ErrUnknownPeerType {
+		t.Fatalf("expected ErrUnknownPeerType, got: %v", err)
+	}
+}
+
+func TestOnConnCloseSend(t *testing.T) {
+	for _, peerType := range []testutil.PeerType{testutil.TCP, testutil.WS, testutil.KCP} {
+		t.Run(string(peerType), func(t *testing.T) {
+			// 会话关闭时仍然可以发送消息，比如下线原因，消息在断开连接之前发送完毕
+			address, cleanup, err := testutil.StartEchoServer(peerType, zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+				if err := session.Send(zerodatapack.NewLTDMessage(0, 0, 0, 9, 9, []byte("bye"))); err != nil {
+					t.Errorf("send in OnConnClose failed: %s", err.Error())
+				}
+			}))
+			if err != nil {
+				t.Fatalf("start failed: %s", err.Error())
+			}
+
+			responses := make(chan zeronetwork.Message, 2)
+			c, err := testutil.Dial(peerType, address, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+				responses <- zerodatapack.CopyMessage(message, message.Flag())
+				return nil, nil
+			})
+			if err != nil {
+				cleanup()
+				t.Fatalf("dial failed: %s", err.Error())
+			}
+			defer c.Close()
+
+			// 收到响应之后服务端的会话已经建立
+			if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+				t.Fatalf("send failed: %s", err.Error())
+			}
+			select {
+			case <-responses:
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for response")
+			}
+
+			cleanup()
+
+			select {
+			case message := <-responses:
+				if message.ModuleID() != 9 || string(message.Payload()) != "bye" {
+					t.Fatalf("unexpected message: %s", message.String())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout waiting for message sent in OnConnClose")
+			}
+		})
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	for _, peerType := range []testutil.PeerType{testutil.TCP, testutil.WS} {
+		for _, abrupt := range []bool{false, true} {
+			name := string(peerType) + "/normal"
+			expected := zeronetwork.CloseReasonRemoteClosed
+			if abrupt {
+				// 直接关闭底层连接，websocket 不会发送关闭帧
+				name = string(peerType) + "/abrupt"
+				if peerType == testutil.WS {
+					expected = zeronetwork.CloseReasonReadError
+				}
+			}
+
+			t.Run(name, func(t *testing.T) {
+				reasons := make(chan zeronetwork.CloseReason, 1)
+				address, cleanup, err := testutil.StartEchoServer(peerType, zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+					reasons <- session.CloseReason()
+				}))
+				if err != nil {
+					t.Fatalf("start failed: %s", err.Error())
+				}
+				defer cleanup()
+
+				responses := make(chan zeronetwork.Message, 1)
+				c, err := testutil.Dial(peerType, address, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+					responses <- zerodatapack.CopyMessage(message, message.Flag())
+					return nil, nil
+				})
+				if err != nil {
+					t.Fatalf("dial failed: %s", err.Error())
+				}
+
+				if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+					t.Fatalf("send failed: %s", err.Error())
+				}
+				select {
+				case <-responses:
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for response")
+				}
+
+				if abrupt {
+					_ = c.Conn().Close()
+				}
+				c.Close()
+
+				select {
+				case reason := <-reasons:
+					if reason != expected {
+						t.Fatalf("expected close reason %s, got %s", expected, reason)
+					}
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for OnConnClose")
+				}
+			})
+		}
+	}
+}
+
+func TestSessionSecurity(t *testing.T) {
+	for _, peerType := range []testutil.PeerType{testutil.TCP, testutil.WS, testutil.KCP} {
+		for _, compress := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/compress=%t", peerType, compress), func(t *testing.T) {
+				sessions := make(chan zeronetwork.Session, 1)
+				opts := []zeronetwork.Option{zeronetwork.WithOnConnected(func(session zeronetwork.Session) {
+					sessions <- session
+				})}
+				if compress {
+					opts = append(opts, zeronetwork.WithWhetherCompress(true), zeronetwork.WithCompress(zerozlib.NewZlib()))
+				}
+				address, cleanup, err := testutil.StartEchoServer(peerType, opts...)
+				if err != nil {
+					t.Fatalf("start failed: %s", err.Error())
+				}
+				defer cleanup()
+
+				c, err := testutil.Dial(peerType, address, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+					return nil, nil
+				})
+				if err != nil {
+					t.Fatalf("dial failed: %s", err.Error())
+				}
+				defer c.Close()
+
+				// kcp 在收到第一个数据包时才会建立会话
+				if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, nil)); err != nil {
+					t.Fatalf("send failed: %s", err.Error())
+				}
+
+				var session zeronetwork.Session
+				select {
+				case session = <-sessions:
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for OnConnected")
+				}
+
+				if session.IsCompressed() != compress {
+					t.Errorf("expected IsCompressed %t", compress)
+				}
+				if c.IsCompressed() {
+					t.Error("client does not compress")
+				}
+
+				if session.IsEncrypted() {
+					t.Fatal("expected not encrypted before SetCrypto")
+				}
+				crypto, err := zerorc4.New([]byte("0123456789abcdef"))
+				if err != nil {
+					t.Fatalf("new crypto failed: %s", err.Error())
+				}
+				session.SetCrypto(crypto)
+				if !session.IsEncrypted() {
+					t.Fatal("expected encrypted after SetCrypto")
+				}
+				session.SetCrypto(nil)
+				if session.IsEncrypted() {
+					t.Fatal("expected not encrypted after SetCrypto(nil)")
+				}
+			})
+		}
+	}
+}
+
+func TestWSReadLimit(t *testing.T) {
+	reasons := make(chan zeronetwork.CloseReason, 1)
+	address, cleanup, err := testutil.StartEchoServer(testutil.WS,
+		zeronetwork.WithMaxMessageSize(1024),
+		zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+			reasons <- session.CloseReason()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("start failed: %s", err.Error())
+	}
+	defer cleanup()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+address+"/", nil)
+	if err != nil {
+		t.Fatalf("dial failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 超过上限的 websocket 消息，服务端读取到上限时即关闭连接，不会缓存整个消息
+	// 服务端可能在消息写完之前就关闭了连接，此时写入失败 (连接被重置、管道断开) 同样符合预期
+	if err := conn.WriteMessage(websocket.BinaryMessage, make([]byte, 1024*1024)); err == nil {
+		// 服务端回复 CloseMessageTooBig 之后关闭连接，未读取的数据可能使客户端只能看到连接被重置
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err = conn.ReadMessage()
+		if err == nil {
+			t.Fatal("expected conn closed")
+		}
+		if e, ok := err.(*websocket.CloseError); ok && e.Code != websocket.CloseMessageTooBig {
+			t.Fatalf("unexpected close code: %d", e.Code)
+		}
+	}
+
+	select {
+	case reason := <-reasons:
+		if reason != zeronetwork.CloseReasonMessageTooBig {
+			t.Fatalf("unexpected close reason: %s", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for OnConnClose")
+	}
+}
+
+func TestGoroutineLeak(t *testing.T) {
+	for _, peerType := range []testutil.PeerType{testutil.TCP, testutil.WS, testutil.KCP} {
+		t.Run(string(peerType), func(t *testing.T) {
+			closed := make(chan bool, 1)
+			opt := zeronetwork.WithOnConnClose(func(session zeronetwork.Session) {
+				go func() {
+					<-session.Closed()
+					closed <- true
+				}()
+			})
+
+			// kcp 没有断开连接的握手，客户端关闭之后服务端只能等待超时，每一轮都启动并关闭服务
+			// ws 服务关闭之后 http 监听仍然保留，所有轮次共用一个服务
+			var address string
+			var cleanup func()
+			start := func() {
+				var err error
+				if address, cleanup, err = testutil.StartEchoServer(peerType, opt); err != nil {
+					t.Fatalf("start failed: %s", err.Error())
+				}
+			}
+			if peerType != testutil.KCP {
+				start()
+				defer cleanup()
+			}
+
+			// 连接、收发消息、断开连接，服务端的会话完全关闭之后结束
+			cycle := func() {
+				if peerType == testutil.KCP {
+					start()
+				}
+
+				responses := make(chan zeronetwork.Message, 1)
+				c, err := testutil.Dial(peerType, address, func(message zeronetwork.Message) (zeronetwork.Message, error) {
+					responses <- zerodatapack.CopyMessage(message, message.Flag())
+					return nil, nil
+				})
+				if err != nil {
+					t.Fatalf("dial failed: %s", err.Error())
+				}
+
+				if err := c.Send(zerodatapack.NewLTDMessage(0, 1, 0, 1, 1, []byte("ping"))); err != nil {
+					t.Fatalf("send failed: %s", err.Error())
+				}
+				select {
+				case <-responses:
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for response")
+				}
+
+				c.Close()
+				if peerType == testutil.KCP {
+					cleanup()
+				}
+				select {
+				case <-closed:
+				case <-time.After(2 * time.Second):
+					t.Fatal("timeout waiting for server session closed")
+				}
+			}
+
+			// 第一轮会启动一些常驻的 goroutine，比如 kcp 的定时更新，不计入泄漏
+			cycle()
+
+			defer testutil.CheckGoroutines(t)()
+			for i := 0; i < 5; i++ {
+				cycle()
+			}
+		})
+	}
+}
@@ -1,25 +1,198 @@
 package network
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+const (
+	// minAcceptDelay Accept 失败后首次等待时间
+	minAcceptDelay = 5 * time.Millisecond
+
+	// maxAcceptDelay Accept 失败后最长等待时间
+	maxAcceptDelay = 1 * time.Second
 )
 
-// IsEOFOrReadError 是否是连接结束或者是读取错误
-// EOF
-// closed by remote
+// IsEOFOrReadError 读取失败是否属于连接正常结束的情况，此时只需要输出调试日志，否则按照错误输出
+// 包括 io.EOF、连接已经被本端关闭 (net.ErrClosed)、读取超时、套接字读取错误 (比如连接被重置)、收到 websocket 关闭帧
+// 关闭原因见 ReadCloseReason
 func IsEOFOrReadError(err error) bool {
 	if err == nil {
 		return false
 	}
 
-	if err == io.EOF || err == io.ErrUnexpectedEOF {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) {
 		return true
 	}
 
-	if e, ok := err.(*net.OpError); ok && e.Op == "read" {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
 		return true
 	}
 
-	return false
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "read" {
+		return true
+	}
+
+	var closeErr *websocket.CloseError
+	return errors.As(err, &closeErr)
+}
+
+// AcceptDelay Accept 失败后下一次的等待时间，参考 net/http 中的 tempDelay
+// delay 为上一次的等待时间，首次为 0
+// 首次等待 5 毫秒，之后每次翻倍，最长 1 秒
+func AcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+
+	delay *= 2
+	if delay > maxAcceptDelay {
+		delay = maxAcceptDelay
+	}
+
+	return delay
+}
+
+// LogSlowHandler 处理函数的耗时 elapsed 超过 Config.SlowHandlerThreshold 时记录警告日志
+func LogSlowHandler(config *Config, message Message, elapsed time.Duration) {
+	if config.SlowHandlerThreshold <= 0 || elapsed <= config.SlowHandlerThreshold {
+		return
+	}
+
+	config.Logger.Warnf("session: %d, slow handler, module: %d, action: %d, sn: %d, duration: %s, threshold: %s",
+		message.SessionID(), message.ModuleID(), message.ActionID(), message.SN(), elapsed, config.SlowHandlerThreshold)
+}
+
+// HandleUnknownMessage 处理函数返回 ErrHandlerNotFound 时调用，记录日志并触发 Config.OnUnknownMessage
+// 开启 Config.StrictRouting 时返回包装了 ErrFatal 的错误，由调用方关闭会话，否则原样返回 err，丢弃该消息
+func HandleUnknownMessage(config *Config, session Session, message Message, err error) error {
+	config.Logger.Infof("session: %d, unknown message, module: %d, action: %d, sn: %d", session.ID(), message.ModuleID(), message.ActionID(), message.SN())
+
+	if config.OnUnknownMessage != nil {
+		config.OnUnknownMessage(session, message)
+	}
+
+	if config.StrictRouting {
+		return fmt.Errorf("%w: %w", ErrFatal, err)
+	}
+
+	return err
+}
+
+// SocketBufferConn 可以设置套接字缓冲区的连接或者监听器，比如 *net.TCPConn、*kcp.UDPSession、*kcp.Listener
+type SocketBufferConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// SetSocketBuffer 按照 Config.SocketReadBuffer、Config.SocketWriteBuffer 设置套接字缓冲区，<= 0 的不设置
+// 设置失败时记录日志，Config.BufferFailPolicy 为 BufferFailClose 时返回错误，由调用方关闭连接
+func SetSocketBuffer(conn SocketBufferConn, config *Config, remoteAddress string) error {
+	if config.SocketReadBuffer > 0 {
+		if err := conn.SetReadBuffer(config.SocketReadBuffer); err != nil {
+			config.Logger.Infof("conn SetReadBuffer failed, remote remoteAddress: %s, err: %s", remoteAddress, err.Error())
+			if config.BufferFailPolicy == BufferFailClose {
+				return err
+			}
+		}
+	}
+
+	if config.SocketWriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(config.SocketWriteBuffer); err != nil {
+			config.Logger.Infof("conn SetWriteBuffer failed, remote remoteAddress: %s, err: %s", remoteAddress, err.Error())
+			if config.BufferFailPolicy == BufferFailClose {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RunAcceptLoops 开启 n 个 goroutine 同时执行 acceptLoop，阻塞直到全部退出，n <= 1 时直接执行
+func RunAcceptLoops(n int, acceptLoop func()) {
+	if n <= 1 {
+		acceptLoop()
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			acceptLoop()
+		}()
+	}
+	wg.Wait()
+}
+
+// ReadAtLeastSliding 与 io.ReadAtLeast 类似，至少读取 min 个字节
+// 不同的是每次读取前都会刷新读超时时间，只要持续有数据到达，就不会超时
+// 用于区分 "没有任何活动" 与 "慢速的大数据传输"
+func ReadAtLeastSliding(conn net.Conn, buffer []byte, min int, timeout time.Duration) (int, error) {
+	if len(buffer) < min {
+		return 0, io.ErrShortBuffer
+	}
+
+	n := 0
+	var err error
+	for n < min && err == nil {
+		if err = conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			break
+		}
+
+		var nn int
+		nn, err = conn.Read(buffer[n:])
+		n += nn
+	}
+
+	if n >= min {
+		err = nil
+	} else if n > 0 && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// slidingReader 每次读取前都会刷新读超时时间
+type slidingReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// NewSlidingReader 包装 conn，每次读取前都会刷新读超时时间，与 ReadAtLeastSliding 相同
+// 可以与 bufio.Reader 配合使用
+func NewSlidingReader(conn net.Conn, timeout time.Duration) io.Reader {
+	return &slidingReader{conn: conn, timeout: timeout}
+}
+
+func (r *slidingReader) Read(p []byte) (int, error) {
+	if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+
+	return r.conn.Read(p)
+}
+
+// PackMessage 会话使用 datapack 封包
+// 开启 Config.CompressNegotiation 时，只有 compress 为 true (会话协商了压缩) 才会压缩消息负载
+// 封包工具未实现 SessionCompressDatapack 时与 Pack 一致
+func PackMessage(config *Config, datapack Datapack, message Message, crypto Crypto, checksumKey []byte, compress bool) ([]byte, error) {
+	if config.CompressNegotiation {
+		if d, ok := datapack.(SessionCompressDatapack); ok {
+			return d.PackCompress(message, crypto, checksumKey, compress)
+		}
+	}
+
+	return datapack.Pack(message, crypto, checksumKey)
 }
@@ -0,0 +1,107 @@
+package network_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+	zeronetworklogger "github.com/zerogo-hub/zero-node/pkg/network/logger"
+)
+
+func TestReadAtLeastSlidingTrickle(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// 每 20 毫秒发送 1 个字节，总耗时超过超时时间，但每次间隔都小于超时时间
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := client.Write([]byte{byte(i)}); err != nil {
+				return
+			}
+		}
+	}()
+
+	buffer := make([]byte, 16)
+	n, err := zeronetwork.ReadAtLeastSliding(server, buffer, 10, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("trickle client timeout: %s", err.Error())
+	}
+	if n != 10 {
+		t.Fatalf("unexpected read size: %d", n)
+	}
+}
+
+func TestReadAtLeastSlidingIdle(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	buffer := make([]byte, 16)
+	_, err := zeronetwork.ReadAtLeastSliding(server, buffer, 10, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("idle client should timeout")
+	}
+
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+// warnRecorder 记录警告日志
+type warnRecorder struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (h *warnRecorder) Enabled(_ context.Context, level slog.Level) bool {
+	return level == slog.LevelWarn
+}
+
+func (h *warnRecorder) Handle(_ context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.messages = append(h.messages, record.Message)
+	return nil
+}
+
+func (h *warnRecorder) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+
+func (h *warnRecorder) WithGroup(name string) slog.Handler { return h }
+
+func (h *warnRecorder) Messages() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]string(nil), h.messages...)
+}
+
+func TestLogSlowHandler(t *testing.T) {
+	recorder := &warnRecorder{}
+	config := zeronetwork.DefaultConfig()
+	config.Logger = zeronetworklogger.NewSlog(slog.New(recorder))
+	message := zerodatapack.NewLTDMessage(0, 9, 0, 3, 7, nil)
+
+	// 未开启
+	zeronetwork.LogSlowHandler(config, message, time.Hour)
+
+	// 恰好等于阈值时不记录
+	config.SlowHandlerThreshold = 50 * time.Millisecond
+	zeronetwork.LogSlowHandler(config, message, 10*time.Millisecond)
+	zeronetwork.LogSlowHandler(config, message, 50*time.Millisecond)
+	if messages := recorder.Messages(); len(messages) != 0 {
+		t.Fatalf("unexpected logs: %v", messages)
+	}
+
+	zeronetwork.LogSlowHandler(config, message, 50*time.Millisecond+1)
+	messages := recorder.Messages()
+	if len(messages) != 1 || !strings.Contains(messages[0], "module: 3, action: 7, sn: 9") {
+		t.Fatalf("unexpected logs: %v", messages)
+	}
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidConfig 配置无效，具体问题见错误信息
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate 检查配置，一次列出所有问题，可以使用 errors.Is(err, ErrInvalidConfig) 判断
+// 服务的 Start、Serve 以及客户端的 Connect 会先检查配置，无效时直接返回错误，不会在运行时才失败
+func (c *Config) Validate() error {
+	var problems []string
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Logger != nil, "Logger is nil")
+	check(c.Port >= 0 && c.Port <= 65535, "Port %d out of range [0, 65535]", c.Port)
+
+	// 缓冲区与队列
+	check(c.RecvBufferSize > 0, "RecvBufferSize %d must be positive", c.RecvBufferSize)
+	check(c.SendBufferSize >= 0, "SendBufferSize %d is negative", c.SendBufferSize)
+	check(c.RecvQueueSize > 0, "RecvQueueSize %d must be positive", c.RecvQueueSize)
+	check(c.SendQueueSize > 0, "SendQueueSize %d must be positive", c.SendQueueSize)
+	check(c.SendQueueMaxSize >= 0, "SendQueueMaxSize %d is negative", c.SendQueueMaxSize)
+	check(c.SendOverflowPolicy >= SendOverflowBlock && c.SendOverflowPolicy <= SendOverflowClose, "unknown SendOverflowPolicy %d", c.SendOverflowPolicy)
+	check(c.RecvQueueMaxBytes >= 0, "RecvQueueMaxBytes %d is negative", c.RecvQueueMaxBytes)
+	check(c.RecvOverflowPolicy == RecvOverflowBlock || c.RecvOverflowPolicy == RecvOverflowClose, "unknown RecvOverflowPolicy %d", c.RecvOverflowPolicy)
+	check(c.SessionMode >= SessionModeDuplex && c.SessionMode <= SessionModeWriteOnly, "unknown SessionMode %d", c.SessionMode)
+	check(c.MaxMessageSize >= 0, "MaxMessageSize %d is negative", c.MaxMessageSize)
+	check(c.SocketReadBuffer >= 0, "SocketReadBuffer %d is negative", c.SocketReadBuffer)
+	check(c.SocketWriteBuffer >= 0, "SocketWriteBuffer %d is negative", c.SocketWriteBuffer)
+	check(c.PayloadPoolMaxSize >= 0, "PayloadPoolMaxSize %d is negative", c.PayloadPoolMaxSize)
+	check(c.SendRateLimit >= 0, "SendRateLimit %d is negative", c.SendRateLimit)
+
+	// 消息确认
+	check(c.AckTimeout > 0, "AckTimeout %s must be positive", c.AckTimeout)
+	check(c.AckRetries >= 0, "AckRetries %d is negative", c.AckRetries)
+	check(c.AckWindow >= 0, "AckWindow %d is negative", c.AckWindow)
+
+	// 秘钥协商
+	check(c.MaxKeyExchanges >= 0, "MaxKeyExchanges %d is negative", c.MaxKeyExchanges)
+	check(c.ZeroMessageRate >= 0, "ZeroMessageRate %d is negative", c.ZeroMessageRate)
+	check(c.MaxExchangeKeyRequests >= 0, "MaxExchangeKeyRequests %d is negative", c.MaxExchangeKeyRequests)
+	check(c.MinCryptoKeySize >= 0, "MinCryptoKeySize %d is negative", c.MinCryptoKeySize)
+
+	// 压缩
+	check(c.CompressThreshold >= 0, "CompressThreshold %d is negative", c.CompressThreshold)
+	check(!c.CompressNegotiation || c.Compress != nil, "CompressNegotiation is true but Compress is nil")
+	if c.WhetherCompress {
+		check(c.Compress != nil, "WhetherCompress is true but Compress is nil")
+		check(c.MaxMessageSize == 0 || c.CompressThreshold <= c.MaxMessageSize,
+			"CompressThreshold %d greater than MaxMessageSize %d, messages are never compressed", c.CompressThreshold, c.MaxMessageSize)
+	}
+
+	if c.Datapack != nil {
+		check(c.RecvBufferSize >= c.Datapack.HeadLen(), "RecvBufferSize %d less than datapack HeadLen %d", c.RecvBufferSize, c.Datapack.HeadLen())
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+}
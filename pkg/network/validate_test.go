@@ -0,0 +1,80 @@
+package network_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+func TestConfigValidate(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.Datapack = zerodatapack.DefaultDatapck(config)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("default config is invalid: %s", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(config *zeronetwork.Config)
+		problems []string
+	}{
+		{
+			name: "queue",
+			modify: func(config *zeronetwork.Config) {
+				config.RecvQueueSize = 0
+				config.SendQueueSize = -1
+			},
+			problems: []string{"RecvQueueSize 0", "SendQueueSize -1"},
+		},
+		{
+			name: "buffer",
+			modify: func(config *zeronetwork.Config) {
+				config.SendBufferSize = -1
+				config.SocketReadBuffer = -1
+				config.RecvBufferSize = 4
+			},
+			problems: []string{"SendBufferSize -1", "SocketReadBuffer -1", "less than datapack HeadLen"},
+		},
+		{
+			name: "compress",
+			modify: func(config *zeronetwork.Config) {
+				config.WhetherCompress = true
+				config.Compress = nil
+				config.CompressThreshold = config.MaxMessageSize + 1
+			},
+			problems: []string{"Compress is nil", "messages are never compressed"},
+		},
+		{
+			name: "ack",
+			modify: func(config *zeronetwork.Config) {
+				config.AckTimeout = 0
+				config.AckWindow = -1
+			},
+			problems: []string{"AckTimeout 0s", "AckWindow -1"},
+		},
+	}
+
+	for _, test := range tests {
+		config := zeronetwork.DefaultConfig()
+		config.Datapack = zerodatapack.DefaultDatapck(config)
+		test.modify(config)
+
+		err := config.Validate()
+		if !errors.Is(err, zeronetwork.ErrInvalidConfig) {
+			t.Fatalf("%s: unexpected err: %v", test.name, err)
+		}
+
+		// 一次列出所有问题
+		for _, problem := range test.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: %q not found in: %s", test.name, problem, err.Error())
+			}
+		}
+		if n := strings.Count(err.Error(), ";") + 1; n != len(test.problems) {
+			t.Errorf("%s: unexpected problems: %s", test.name, err.Error())
+		}
+	}
+}
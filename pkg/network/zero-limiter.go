@@ -0,0 +1,46 @@
+package network
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrZeroMessageLimit 特殊协议消息超过 Config.ZeroMessageRate 或者 Config.MaxExchangeKeyRequests
+var ErrZeroMessageLimit = errors.New("zero message limit exceeded")
+
+// ZeroLimiter 限制单个会话处理的特殊协议消息 (FlagZero)，与路由消息分开计数，零值可以直接使用
+// 不是并发安全的，只在 dispatchLoop 中使用
+type ZeroLimiter struct {
+	// windowStart 当前一秒统计窗口的开始时间
+	windowStart time.Time
+
+	// count 当前窗口内已经处理的特殊协议消息数量
+	count int
+
+	// exchanges 已经处理的秘钥协商请求数量
+	exchanges int
+}
+
+// Allow 处理特殊协议消息之前调用，超过 Config.ZeroMessageRate 或者 Config.MaxExchangeKeyRequests 时返回 ErrZeroMessageLimit
+// 被拒绝的消息不计入数量
+func (l *ZeroLimiter) Allow(config *Config, message Message, now time.Time) error {
+	if config.ZeroMessageRate > 0 {
+		if now.Sub(l.windowStart) >= time.Second {
+			l.windowStart = now
+			l.count = 0
+		}
+		if l.count >= config.ZeroMessageRate {
+			return ErrZeroMessageLimit
+		}
+	}
+
+	if config.MaxExchangeKeyRequests > 0 && message.ActionID() == FlagZeroExchangeKeyRequest {
+		if l.exchanges >= config.MaxExchangeKeyRequests {
+			return ErrZeroMessageLimit
+		}
+		l.exchanges++
+	}
+
+	l.count++
+	return nil
+}
@@ -0,0 +1,54 @@
+package network_test
+
+import (
+	"testing"
+	"time"
+
+	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
+	zerodatapack "github.com/zerogo-hub/zero-node/pkg/network/datapack"
+)
+
+func TestZeroLimiter(t *testing.T) {
+	config := zeronetwork.DefaultConfig()
+	config.ZeroMessageRate = 3
+	config.MaxExchangeKeyRequests = 1
+
+	heartbeat := zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroHeartBeat, nil)
+	exchange := zerodatapack.NewLTDMessage(zeronetwork.FlagZero, 0, 0, 0, zeronetwork.FlagZeroExchangeKeyRequest, nil)
+
+	var l zeronetwork.ZeroLimiter
+	now := time.Now()
+
+	// 秘钥协商请求只处理一次，被拒绝的请求不计入每秒数量
+	if err := l.Allow(config, exchange, now); err != nil {
+		t.Fatalf("first exchange rejected: %v", err)
+	}
+	if err := l.Allow(config, exchange, now); err != zeronetwork.ErrZeroMessageLimit {
+		t.Fatalf("second exchange, unexpected err: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := l.Allow(config, heartbeat, now); err != nil {
+			t.Fatalf("heartbeat %d rejected: %v", i, err)
+		}
+	}
+	if err := l.Allow(config, heartbeat, now.Add(500*time.Millisecond)); err != zeronetwork.ErrZeroMessageLimit {
+		t.Fatalf("heartbeat over rate, unexpected err: %v", err)
+	}
+
+	// 下一秒重新计数，秘钥协商请求的数量不会恢复
+	if err := l.Allow(config, heartbeat, now.Add(time.Second)); err != nil {
+		t.Fatalf("heartbeat in next window rejected: %v", err)
+	}
+	if err := l.Allow(config, exchange, now.Add(time.Second)); err != zeronetwork.ErrZeroMessageLimit {
+		t.Fatalf("exchange in next window, unexpected err: %v", err)
+	}
+
+	// 0 表示不限制
+	var unlimited zeronetwork.ZeroLimiter
+	for i := 0; i < 100; i++ {
+		if err := unlimited.Allow(zeronetwork.DefaultConfig(), exchange, now); err != nil {
+			t.Fatalf("unlimited rejected: %v", err)
+		}
+	}
+}
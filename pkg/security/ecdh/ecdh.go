@@ -1,9 +1,8 @@
 package ecdh
 
 import (
-	"bytes"
+	cryptoRand "crypto/rand"
 	"math/rand"
-	"sync"
 
 	libCurve "golang.org/x/crypto/curve25519"
 )
@@ -14,6 +13,9 @@ type ExchangeRequest struct {
 
 	// R 客户端随机数
 	R string `json:"r"`
+
+	// Compress 客户端是否支持压缩
+	Compress bool `json:"compress,omitempty"`
 }
 
 type ExchageResponse struct {
@@ -22,6 +24,9 @@ type ExchageResponse struct {
 
 	// R 服务器随机数
 	R string `json:"r"`
+
+	// Compress 该会话是否压缩
+	Compress bool `json:"compress,omitempty"`
 }
 
 // GenerateKeys 生成公钥和私钥
@@ -42,23 +47,23 @@ func GenerateShareKey(privateKey, targetPublicKey []byte) ([]byte, error) {
 	return sharedKey, err
 }
 
-func BuildKey(sharedKey, rs, rc []byte) []byte {
-	buffer := bufferPool.Get().(*bytes.Buffer)
-	defer bufferPool.Put(buffer)
-	buffer.Reset()
-
-	buffer.Write(sharedKey)
-	buffer.Write(rs)
-	buffer.Write(rc)
+// GenerateRandomValue 生成秘钥协商使用的随机值，每次返回新的切片
+func GenerateRandomValue(n int) []byte {
+	value := make([]byte, n)
+	if _, err := cryptoRand.Read(value); err != nil {
+		panic("random failed: " + err.Error())
+	}
 
-	return buffer.Bytes()
+	return value
 }
 
-var bufferPool *sync.Pool
+// BuildKey 使用共享秘钥与双方的随机值生成最终的秘钥
+// 每次返回新的切片，同时进行的多个秘钥协商不会共用内存
+func BuildKey(sharedKey, rs, rc []byte) []byte {
+	key := make([]byte, 0, len(sharedKey)+len(rs)+len(rc))
+	key = append(key, sharedKey...)
+	key = append(key, rs...)
+	key = append(key, rc...)
 
-func init() {
-	bufferPool = &sync.Pool{}
-	bufferPool.New = func() interface{} {
-		return &bytes.Buffer{}
-	}
+	return key
 }
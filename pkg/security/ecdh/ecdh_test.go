@@ -5,14 +5,13 @@ import (
 	"reflect"
 	"testing"
 
-	zerorandom "github.com/zerogo-hub/zero-helper/random"
 	zeroecdh "github.com/zerogo-hub/zero-node/pkg/security/ecdh"
 )
 
 func TestExchangeKey(t *testing.T) {
 	// 客户端 --------------------------------------------
 	clientPublicKey, clientPrivateKey := zeroecdh.GenerateKeys()
-	clientRandomValue := zerorandom.Bytes(32)
+	clientRandomValue := zeroecdh.GenerateRandomValue(32)
 
 	request := &zeroecdh.ExchangeRequest{
 		PublicKey: hex.EncodeToString(clientPublicKey),
@@ -25,7 +24,7 @@ func TestExchangeKey(t *testing.T) {
 	peerClientRandomValue, _ := hex.DecodeString(request.R)
 
 	serverPublicKey, serverPrivateKey := zeroecdh.GenerateKeys()
-	serverRandomValue := zerorandom.Bytes(32)
+	serverRandomValue := zeroecdh.GenerateRandomValue(32)
 
 	// 生成共享秘钥
 	serverSharedKey, _ := zeroecdh.GenerateShareKey(serverPrivateKey, peerClientPublicKey)
@@ -54,3 +53,18 @@ func TestExchangeKey(t *testing.T) {
 		t.Errorf("Unexpected key, serverKey: %#v, clientKey: %#v", serverKey, clientKey)
 	}
 }
+
+func TestBuildKeyNotShared(t *testing.T) {
+	sharedKey := make([]byte, 32)
+	first := zeroecdh.BuildKey(sharedKey, zeroecdh.GenerateRandomValue(32), zeroecdh.GenerateRandomValue(32))
+	expected := append([]byte{}, first...)
+
+	// 之后的秘钥协商不能修改已经生成的秘钥
+	second := zeroecdh.BuildKey(sharedKey, zeroecdh.GenerateRandomValue(32), zeroecdh.GenerateRandomValue(32))
+	if !reflect.DeepEqual(first, expected) {
+		t.Fatal("key changed by a later BuildKey")
+	}
+	if len(first) != 96 || reflect.DeepEqual(first, second) {
+		t.Fatalf("unexpected keys, len: %d", len(first))
+	}
+}
@@ -2,10 +2,20 @@ package rc4
 
 import (
 	stdRC4 "crypto/rc4"
+	"errors"
+	"fmt"
 
 	zeronetwork "github.com/zerogo-hub/zero-node/pkg/network"
 )
 
+var (
+	// ErrKeyTooShort 秘钥长度不足
+	ErrKeyTooShort = errors.New("rc4 key too short")
+)
+
+// DefaultMinKeySize 秘钥的默认最小长度，crypto/rc4 要求秘钥长度在 1 ~ 256 之间
+const DefaultMinKeySize = 1
+
 type rc4 struct {
 	// 加密和解密不能使用同一个 Cipher 对象
 	cipherEn *stdRC4.Cipher
@@ -14,6 +24,19 @@ type rc4 struct {
 
 // New 加密和解密要分别创建实例
 func New(key []byte) (zeronetwork.Crypto, error) {
+	return NewWithMinKeySize(key, DefaultMinKeySize)
+}
+
+// NewWithMinKeySize 创建实例，秘钥长度小于 minKeySize 时返回 ErrKeyTooShort
+func NewWithMinKeySize(key []byte, minKeySize int) (zeronetwork.Crypto, error) {
+	if minKeySize < DefaultMinKeySize {
+		minKeySize = DefaultMinKeySize
+	}
+
+	if len(key) < minKeySize {
+		return nil, fmt.Errorf("%w: %d < %d", ErrKeyTooShort, len(key), minKeySize)
+	}
+
 	cipherEn, err := stdRC4.NewCipher(key)
 	if err != nil {
 		return nil, err
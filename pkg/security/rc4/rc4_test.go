@@ -1,6 +1,7 @@
 package rc4_test
 
 import (
+	"errors"
 	"testing"
 
 	zerorc4 "github.com/zerogo-hub/zero-node/pkg/security/rc4"
@@ -22,3 +23,19 @@ func TestRC4(t *testing.T) {
 
 	t.Log(decrypted)
 }
+
+func TestRC4EmptyKey(t *testing.T) {
+	if _, err := zerorc4.New(nil); !errors.Is(err, zerorc4.ErrKeyTooShort) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func TestRC4MinKeySize(t *testing.T) {
+	if _, err := zerorc4.NewWithMinKeySize([]byte("12345678"), 16); !errors.Is(err, zerorc4.ErrKeyTooShort) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if _, err := zerorc4.NewWithMinKeySize([]byte("0123456789abcdef"), 16); err != nil {
+		t.Fatalf("unexpected err: %s", err.Error())
+	}
+}